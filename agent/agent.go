@@ -2,51 +2,132 @@ package agent
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
-	"os"
+	"errors"
+	"fmt"
+	"net/http"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 
+	"github.com/salzr/acert/filewatch"
 	pb "github.com/salzr/acert/proto/agentservice/v1"
 )
 
-// TODO: Create options for the agent client
-// The client will be charged with ensuring that the issued certificate is valid. If the certificate is not valid, the client will attempt to renew it.
-func Run(ctx context.Context) error {
+const (
+	defaultServer            = "server.acert.salzr.localhost:50051"
+	defaultHeartbeatInterval = 5 * time.Second
+	defaultReloadInterval    = 30 * time.Second
+)
+
+// capabilities are the features this agent reports to the server with its heartbeats.
+var capabilities = []string{"inventory", "issue-certificate"}
+
+type Options struct {
+	Server string
+	// ServerName overrides the name the server certificate is verified against.
+	ServerName   string
+	AgentID      string
+	CertFile     string
+	KeyFile      string
+	ServerCAFile string
+
+	HeartbeatInterval time.Duration
+	// ReloadInterval is how often the certificate, key and CA files are checked for rotations.
+	ReloadInterval time.Duration
+	// RenewFraction is the fraction of the certificate lifetime after which it is renewed.
+	RenewFraction float64
+	// MetricsAddress serves prometheus metrics on /metrics when set.
+	MetricsAddress string
+
+	// WatchPaths are the certificate files or globs reported to the server as the host inventory.
+	WatchPaths        []string
+	InventoryInterval time.Duration
+	// PKCS12Password decrypts watched PKCS#12 bundles.
+	PKCS12Password string
+
+	// InstallDirs are the absolute directories IssueCertificate tasks may install keys and
+	// certificates in, including their subdirectories. Tasks are rejected when it is empty.
+	InstallDirs []string
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Server:            defaultServer,
+		CertFile:          filepath.Join(DefaultStateDir, certFileName),
+		KeyFile:           filepath.Join(DefaultStateDir, keyFileName),
+		ServerCAFile:      filepath.Join(DefaultStateDir, serverCAFileName),
+		HeartbeatInterval: defaultHeartbeatInterval,
+		ReloadInterval:    defaultReloadInterval,
+		RenewFraction:     defaultRenewFraction,
+		InventoryInterval: defaultInventoryInterval,
+	}
+}
+
+// Run connects to the server and keeps the agent's client certificate valid by renewing it
+// before it expires. The agent reconnects whenever its stream to the server ends and only
+// returns once ctx is done or it receives SIGINT or SIGTERM.
+func Run(ctx context.Context, options Options) error {
 	log := ctx.Value("logger").(*zap.Logger)
 	log = log.With(zap.String("service", "agent"))
 
-	// TODO: The keypairs would be pulled by config
-	cert, err := tls.LoadX509KeyPair(
-		"/Users/dsalazar/Documents/workspace/github.com/salzr/acert/config/certmanager/agent.crt",
-		"/Users/dsalazar/Documents/workspace/github.com/salzr/acert/config/certmanager/agent.key")
-	if err != nil {
-		log.Fatal("failed to load client cert", zap.Error(err))
+	if options.AgentID == "" {
+		return errors.New("agent id is not set, run `acert agent init` first")
+	}
+	if options.RenewFraction <= 0 || options.RenewFraction >= 1 {
+		return fmt.Errorf("renew fraction must be between 0 and 1, got %v", options.RenewFraction)
+	}
+	for name, interval := range map[string]time.Duration{
+		"heartbeat": options.HeartbeatInterval,
+		"reload":    options.ReloadInterval,
+		"inventory": options.InventoryInterval,
+	} {
+		if interval <= 0 {
+			return fmt.Errorf("%s interval must be positive, got %v", name, interval)
+		}
+	}
+	for _, dir := range options.InstallDirs {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("install directory %q is not absolute", dir)
+		}
 	}
 
-	ca := x509.NewCertPool()
-	caFilePath := "/Users/dsalazar/Documents/workspace/github.com/salzr/acert/config/certmanager/server-ca.crt"
-	caBytes, err := os.ReadFile(caFilePath)
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	creds, err := newCredentialsProvider(options.CertFile, options.KeyFile, options.ServerCAFile)
 	if err != nil {
-		log.Fatal("failed to read ca cert", zap.Error(err))
-	}
-	if ok := ca.AppendCertsFromPEM(caBytes); !ok {
-		log.Fatal("failed to parse", zap.String("filepath", caFilePath))
+		return err
 	}
+	files := []string{options.CertFile, options.KeyFile, options.ServerCAFile}
+	go filewatch.Watch(ctx, options.ReloadInterval, files, func() {
+		if err := creds.Reload(); err != nil {
+			log.Error("failed to reload credentials", zap.Error(err))
+			return
+		}
+		log.Info("reloaded credentials")
+	})
 
-	tlsConfig := &tls.Config{
-		ServerName:   "server.acert.salzr.localhost",
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      ca,
+	serverName, err := resolveServerName(options.Server, options.ServerName)
+	if err != nil {
+		return err
 	}
+	tlsConfig := creds.TLSConfig(serverName)
 
-	conn, err := grpc.NewClient("server.acert.salzr.localhost:50051", grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	dial := func() (*grpc.ClientConn, error) {
+		return grpc.NewClient(options.Server,
+			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+			grpc.WithKeepaliveParams(keepaliveParams),
+			grpc.WithConnectParams(connectParams),
+		)
+	}
+	conn, err := dial()
 	if err != nil {
 		return err
 	}
@@ -54,51 +135,21 @@ func Run(ctx context.Context) error {
 
 	client := pb.NewAgentServiceClient(conn)
 
-	stream, err := client.Poll(ctx)
-	if err != nil {
-		log.Fatal("Failed to poll", zap.Error(err))
-	}
-
-	go func() {
-		for {
-			res, err := stream.Recv()
-			if err != nil {
-				log.Fatal("Failed to receive", zap.Error(err))
-			}
-			if task := res.GetServerTask(); task != nil {
-				log.Info("Received task", zap.String("taskId", task.TaskId), zap.String("command", task.Command))
-			} else if status := res.GetServerStatus(); status != nil {
-				log.Info("Received status", zap.String("message", status.Message))
-			}
-		}
-	}()
-
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, syscall.SIGINT, syscall.SIGTERM)
-
-OUTER:
-	for {
-		select {
-		case <-ticker.C:
-			heartbeat := &pb.AgentRequest{
-				AgentId: "agent-1",
-				Payload: &pb.AgentRequest_Heartbeat{
-					Heartbeat: &pb.AgentHeartbeat{
-						Timestamp: time.Now().Unix(),
-					},
-				},
-			}
-			if err := stream.Send(heartbeat); err != nil {
-				log.Fatal("Failed to send heartbeat", zap.Error(err))
-			}
-		case <-done:
-			stream.CloseSend()
-			break OUTER
-		}
+	reg := prometheus.NewRegistry()
+	go newRenewer(log, client, dial, creds, options, reg).Run(ctx)
+	if options.MetricsAddress != "" {
+		go serveMetrics(log, options.MetricsAddress, reg)
 	}
 
+	newConnection(log, client, options).Run(ctx)
 	return nil
 }
+
+func serveMetrics(log *zap.Logger, address string, gatherer prometheus.Gatherer) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	log.Info("serving metrics", zap.String("address", address))
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Error("failed to serve metrics", zap.Error(err))
+	}
+}
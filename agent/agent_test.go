@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestRunOptions(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "logger", zap.NewNop())
+	for _, tc := range []struct {
+		name   string
+		modify func(o *Options)
+		err    string
+	}{
+		{"renew fraction", func(o *Options) { o.RenewFraction = 1 }, "renew fraction must be between 0 and 1"},
+		{"heartbeat interval", func(o *Options) { o.HeartbeatInterval = 0 }, "heartbeat interval must be positive"},
+		{"reload interval", func(o *Options) { o.ReloadInterval = -1 }, "reload interval must be positive"},
+		{"inventory interval", func(o *Options) { o.InventoryInterval = 0 }, "inventory interval must be positive"},
+		{"install directory", func(o *Options) { o.InstallDirs = []string{"certs"} }, "is not absolute"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options := DefaultOptions()
+			options.AgentID = "1"
+			tc.modify(&options)
+			if err := Run(ctx, options); err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expected %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
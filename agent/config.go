@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	DefaultStateDir = "/var/lib/acert"
+
+	configFileName   = "config.yaml"
+	keyFileName      = "agent.key"
+	certFileName     = "agent.crt"
+	serverCAFileName = "server-ca.crt"
+)
+
+// Config is the agent configuration written by `acert agent init`. Its keys are the flag
+// names of `acert agent` so the file can be passed to it with --config.
+type Config struct {
+	Server       string `json:"server"`
+	ServerName   string `json:"server-name,omitempty"`
+	AgentID      string `json:"agent-id"`
+	CertFile     string `json:"cert"`
+	KeyFile      string `json:"key"`
+	ServerCAFile string `json:"server-ca"`
+}
+
+// ConfigPath returns the path of the agent config inside stateDir.
+func ConfigPath(stateDir string) string {
+	return filepath.Join(stateDir, configFileName)
+}
+
+// Write saves the config to path, readable only by the owner.
+func (c *Config) Write(path string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, b)
+}
+
+// writeFile atomically replaces path with data using 0600 permissions.
+func writeFile(path string, data []byte) error {
+	return writeFileAs(path, data, 0o600, -1, -1)
+}
+
+// writeFileAs atomically replaces path with data using mode and the uid and gid owner, -1 keeps
+// the owner of the process. The directory of path must exist and path must not be a symlink.
+func writeFileAs(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	if info, err := os.Lstat(path); err == nil && !info.Mode().IsRegular() {
+		return fmt.Errorf("refusing to replace %s, it is not a regular file", path)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if uid != -1 || gid != -1 {
+		if err := f.Chown(uid, gid); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to change owner of %s: %w", path, err)
+		}
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/salzr/acert/keytype"
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+const rsaKeySize = 4096
+
+type EnrollOptions struct {
+	Server     string
+	ServerName string
+	// ServerCAFile is used to verify the server until the CA bundle returned by the server is saved.
+	ServerCAFile string
+	Token        string
+	KeyType      string
+	Description  string
+	StateDir     string
+}
+
+// Enroll generates a private key, enrolls with the server using a join token and saves the key,
+// the issued certificate, the server CA bundle and the agent config under the state dir.
+func Enroll(ctx context.Context, opts EnrollOptions) (*Config, error) {
+	log := ctx.Value("logger").(*zap.Logger)
+	log = log.With(zap.String("service", "agent"))
+
+	if opts.Server == "" || opts.Token == "" {
+		return nil, errors.New("server and token are required")
+	}
+	serverName, err := resolveServerName(opts.Server, opts.ServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := generateKey(opts.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hostname: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: hostname},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create csr: %w", err)
+	}
+
+	ca := x509.NewCertPool()
+	caBytes, err := os.ReadFile(opts.ServerCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server ca: %w", err)
+	}
+	if ok := ca.AppendCertsFromPEM(caBytes); !ok {
+		return nil, fmt.Errorf("failed to parse %s", opts.ServerCAFile)
+	}
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		RootCAs:    ca,
+	}
+	conn, err := grpc.NewClient(opts.Server, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	res, err := pb.NewAgentServiceClient(conn).Enroll(ctx, &pb.EnrollRequest{
+		Token:       opts.Token,
+		Csr:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+		Hostname:    hostname,
+		Description: opts.Description,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enroll: %w", err)
+	}
+	log.Info("enrolled", zap.String("agentId", res.GetAgentId()))
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	if err := os.MkdirAll(opts.StateDir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", opts.StateDir, err)
+	}
+	cfg := &Config{
+		Server:       opts.Server,
+		ServerName:   opts.ServerName,
+		AgentID:      res.GetAgentId(),
+		CertFile:     filepath.Join(opts.StateDir, certFileName),
+		KeyFile:      filepath.Join(opts.StateDir, keyFileName),
+		ServerCAFile: filepath.Join(opts.StateDir, serverCAFileName),
+	}
+	files := []struct {
+		path string
+		data []byte
+	}{
+		{cfg.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})},
+		{cfg.CertFile, res.GetCertificateChain()},
+		{cfg.ServerCAFile, res.GetCaBundle()},
+	}
+	for _, f := range files {
+		if err := writeFile(f.path, f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Write(ConfigPath(opts.StateDir)); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolveServerName returns the name to verify the server certificate against,
+// defaulting to the host of the server address.
+func resolveServerName(server, serverName string) (string, error) {
+	if serverName != "" {
+		return serverName, nil
+	}
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return "", fmt.Errorf("invalid server address %q: %w", server, err)
+	}
+	return host, nil
+}
+
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case keytype.RSA:
+		return rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case keytype.ECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+}
@@ -0,0 +1,176 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"sigs.k8s.io/yaml"
+
+	"github.com/salzr/acert/keytype"
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+// enrollServer signs the CSRs of enrolling agents with ca.
+type enrollServer struct {
+	pb.UnimplementedAgentServiceServer
+
+	t  *testing.T
+	ca *testCert
+}
+
+func (s *enrollServer) Enroll(ctx context.Context, req *pb.EnrollRequest) (*pb.EnrollResponse, error) {
+	block, _ := pem.Decode(req.GetCsr())
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		s.t.Error(err)
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		s.t.Error(err)
+		return nil, err
+	}
+	if req.GetToken() != "token" || req.GetHostname() == "" {
+		s.t.Errorf("expected the token and hostname, got %v", req)
+	}
+	issued := newTestCert(s.t, &x509.Certificate{}, s.ca)
+	// The test key stands in for the CSR key, Enroll does not check the certificate.
+	return &pb.EnrollResponse{
+		AgentId:          "7",
+		CertificateChain: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued.cert.Raw}),
+		CaBundle:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.cert.Raw}),
+	}, nil
+}
+
+func TestEnroll(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{IsCA: true, BasicConstraintsValid: true,
+		KeyUsage: x509.KeyUsageCertSign}, nil)
+	serving := newTestCert(t, &x509.Certificate{DNSNames: []string{"server.test"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}, ca)
+	caFile, _ := ca.write(t, dir, "ca")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{serving.tls()}})))
+	pb.RegisterAgentServiceServer(s, &enrollServer{t: t, ca: ca})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	stateDir := filepath.Join(dir, "state")
+	if err := os.Mkdir(stateDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), "logger", zap.NewNop())
+	cfg, err := Enroll(ctx, EnrollOptions{
+		Server:       lis.Addr().String(),
+		ServerName:   "server.test",
+		ServerCAFile: caFile,
+		Token:        "token",
+		KeyType:      keytype.ECDSA,
+		StateDir:     stateDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AgentID != "7" || cfg.ServerName != "server.test" {
+		t.Errorf("expected agent 7 verifying server.test, got %+v", cfg)
+	}
+
+	for _, path := range []string{cfg.KeyFile, cfg.CertFile, cfg.ServerCAFile, ConfigPath(stateDir)} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0o600 {
+			t.Errorf("expected %s to be written with 0600, got %v", path, info.Mode().Perm())
+		}
+	}
+	b, err := os.ReadFile(ConfigPath(stateDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := &Config{}
+	if err := yaml.Unmarshal(b, written); err != nil {
+		t.Fatal(err)
+	}
+	if *written != *cfg {
+		t.Errorf("expected the config file to hold %+v, got %+v", cfg, written)
+	}
+
+	_, err = Enroll(ctx, EnrollOptions{Server: lis.Addr().String(), ServerCAFile: caFile, Token: "token",
+		KeyType: "dsa", StateDir: stateDir})
+	if err == nil {
+		t.Error("expected an unsupported key type to be rejected")
+	}
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := generateKey(keytype.RSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*rsa.PrivateKey); !ok || k.N.BitLen() != rsaKeySize {
+		t.Errorf("expected a %d bit rsa key, got %T", rsaKeySize, key)
+	}
+	key, err = generateKey(keytype.ECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*ecdsa.PrivateKey); !ok || k.Curve.Params().Name != "P-256" {
+		t.Errorf("expected a P-256 ecdsa key, got %T", key)
+	}
+	if _, err := generateKey("dsa"); err == nil {
+		t.Error("expected an unsupported key type to be rejected")
+	}
+}
+
+func TestConfigWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := ConfigPath(dir)
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{Server: "server:8443", AgentID: "1", CertFile: "agent.crt", KeyFile: "agent.key", ServerCAFile: "ca.crt"}
+	if err := cfg.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the config to be replaced with 0600, got %v", info.Mode().Perm())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary file to be left behind, got %v", entries)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &Config{}
+	if err := yaml.Unmarshal(b, got); err != nil {
+		t.Fatal(err)
+	}
+	if *got != *cfg {
+		t.Errorf("expected %+v, got %+v", cfg, got)
+	}
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+const defaultInventoryInterval = time.Hour
+
+// scanInventory parses every certificate file matching the watched paths or globs. Files that
+// fail to parse are skipped and returned as errors so a single bad file does not hide the rest.
+func scanInventory(patterns []string, pkcs12Password string) ([]*pb.CertificateInfo, []error) {
+	var errs []error
+	seen := map[string]bool{}
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid pattern %q: %w", pattern, err))
+			continue
+		}
+		for _, path := range matches {
+			if info, err := os.Stat(path); err != nil || info.IsDir() || seen[path] {
+				continue
+			}
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	certs := make([]*pb.CertificateInfo, 0, len(paths))
+	for _, path := range paths {
+		cert, err := parseCertificateFile(path, pkcs12Password)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse %s: %w", path, err))
+			continue
+		}
+		certs = append(certs, certificateInfo(path, cert))
+	}
+	return certs, errs
+}
+
+// parseCertificateFile returns the leaf certificate of a PEM, DER or PKCS#12 file.
+func parseCertificateFile(path, pkcs12Password string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".p12" || ext == ".pfx":
+		return parsePKCS12(data, pkcs12Password)
+	case bytes.Contains(data, []byte("-----BEGIN")):
+		return parsePEM(data)
+	}
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return cert, nil
+	}
+	return parsePKCS12(data, pkcs12Password)
+}
+
+func parsePEM(data []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
+
+func parsePKCS12(data []byte, password string) (*x509.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate found")
+	}
+	// Bundles carry their chain as well, the leaf is the one that is not a CA.
+	for _, cert := range certs {
+		if !cert.IsCA {
+			return cert, nil
+		}
+	}
+	return certs[0], nil
+}
+
+func certificateInfo(path string, cert *x509.Certificate) *pb.CertificateInfo {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+
+	fingerprint := sha256.Sum256(cert.Raw)
+	return &pb.CertificateInfo{
+		Path:         path,
+		Subject:      cert.Subject.String(),
+		Sans:         sans,
+		Issuer:       cert.Issuer.String(),
+		Serial:       hex.EncodeToString(cert.SerialNumber.Bytes()),
+		NotBefore:    cert.NotBefore.Unix(),
+		NotAfter:     cert.NotAfter.Unix(),
+		KeyAlgorithm: keyAlgorithm(cert),
+		Fingerprint:  hex.EncodeToString(fingerprint[:]),
+	}
+}
+
+func keyAlgorithm(cert *x509.Certificate) string {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", key.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + strings.ReplaceAll(key.Curve.Params().Name, "-", "")
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
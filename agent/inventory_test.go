@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanInventory(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{IsCA: true, BasicConstraintsValid: true}, nil)
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"host.example.com"}}, ca)
+
+	// The PEM file carries the chain after the key, the DER file only the leaf.
+	certFile, keyFile := leaf.write(t, dir, "host")
+	key, _ := os.ReadFile(keyFile)
+	chain, _ := os.ReadFile(certFile)
+	caFile, _ := ca.write(t, t.TempDir(), "ca")
+	caPEM, _ := os.ReadFile(caFile)
+	if err := os.WriteFile(filepath.Join(dir, "bundle.pem"), append(append(key, chain...), caPEM...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "host.der"), leaf.cert.Raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.crt"), []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, errs := scanInventory([]string{filepath.Join(dir, "*.pem"), filepath.Join(dir, "*.der"),
+		filepath.Join(dir, "*.crt")}, "")
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "broken.crt") {
+		t.Errorf("expected only broken.crt to fail, got %v", errs)
+	}
+	if len(certs) != 3 {
+		t.Fatalf("expected 3 certificates, got %d", len(certs))
+	}
+	for _, c := range certs {
+		if c.KeyAlgorithm != "ECDSA-P256" {
+			t.Errorf("%s: expected ECDSA-P256, got %s", c.Path, c.KeyAlgorithm)
+		}
+		if len(c.Sans) != 1 || c.Sans[0] != "DNS:host.example.com" {
+			t.Errorf("%s: unexpected sans %v", c.Path, c.Sans)
+		}
+		if c.NotAfter != leaf.cert.NotAfter.Unix() {
+			t.Errorf("%s: expected not after %d, got %d", c.Path, leaf.cert.NotAfter.Unix(), c.NotAfter)
+		}
+	}
+}
+
+func TestScanInventoryPKCS12(t *testing.T) {
+	// testdata/host.p12 holds a certificate for p12.example.com valid until 2126, encrypted with
+	// the password secret.
+	pattern := filepath.Join("testdata", "*.p12")
+	certs, errs := scanInventory([]string{pattern}, "secret")
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(certs) != 1 || len(certs[0].Sans) != 1 || certs[0].Sans[0] != "DNS:p12.example.com" {
+		t.Errorf("expected the certificate of p12.example.com, got %v", certs)
+	}
+
+	if _, errs := scanInventory([]string{pattern}, "wrong"); len(errs) != 1 {
+		t.Errorf("expected the wrong password to fail, got %v", errs)
+	}
+}
@@ -0,0 +1,257 @@
+package agent
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/salzr/acert/keytype"
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+const (
+	defaultRenewFraction = 2.0 / 3.0
+
+	renewMinBackoff = 30 * time.Second
+	renewMaxBackoff = time.Hour
+	// renewConfirmTimeout is how long the server has to answer a stream opened with a renewed
+	// certificate.
+	renewConfirmTimeout = 30 * time.Second
+)
+
+// renewer renews the agent client certificate once the configured fraction of its lifetime
+// has passed. The previous key and certificate are kept next to the new ones with an .old
+// suffix until a Poll stream authenticated with the renewed certificate is answered by the
+// server, they are restored if the renewed ones cannot be loaded or are not accepted.
+type renewer struct {
+	log      *zap.Logger
+	client   pb.AgentServiceClient
+	creds    *credentialsProvider
+	fraction float64
+	agentID  string
+	// dial opens a new connection to the server, which authenticates with the credentials
+	// loaded at the time of its handshake.
+	dial func() (*grpc.ClientConn, error)
+
+	certFile, keyFile, caFile string
+
+	renewals *prometheus.CounterVec
+}
+
+func newRenewer(log *zap.Logger, client pb.AgentServiceClient, dial func() (*grpc.ClientConn, error), creds *credentialsProvider,
+	options Options, reg prometheus.Registerer) *renewer {
+	r := &renewer{
+		log:      log,
+		client:   client,
+		creds:    creds,
+		fraction: options.RenewFraction,
+		agentID:  options.AgentID,
+		dial:     dial,
+		certFile: options.CertFile,
+		keyFile:  options.KeyFile,
+		caFile:   options.ServerCAFile,
+		renewals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "acert",
+			Subsystem: "agent",
+			Name:      "certificate_renewals_total",
+			Help:      "Number of agent certificate renewal attempts by result.",
+		}, []string{"result"}),
+	}
+	reg.MustRegister(r.renewals, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "acert",
+		Subsystem: "agent",
+		Name:      "certificate_expiry_seconds",
+		Help:      "Seconds until the agent client certificate expires.",
+	}, func() float64 {
+		return time.Until(creds.Leaf().NotAfter).Seconds()
+	}))
+	return r
+}
+
+// renewAt returns when a certificate should be renewed, after fraction of its lifetime.
+func renewAt(cert *x509.Certificate, fraction float64) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+}
+
+// Run renews the certificate whenever it is due until ctx is done. Failed renewals are retried
+// with an exponential backoff. Attempts are at least renewMinBackoff apart so a certificate that
+// is already due when issued, e.g. one capped by an expiring CA, does not renew in a loop.
+func (r *renewer) Run(ctx context.Context) {
+	backoff := time.Duration(0)
+	var lastAttempt time.Time
+	for {
+		leaf := r.creds.Leaf()
+		wait := time.Until(renewAt(leaf, r.fraction))
+		if backoff > 0 {
+			wait = backoff
+		}
+		wait = max(wait, renewMinBackoff-time.Since(lastAttempt))
+		r.log.Info("next certificate renewal", zap.Time("notAfter", leaf.NotAfter), zap.Duration("in", wait))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		lastAttempt = time.Now()
+		if err := r.renew(ctx); err != nil {
+			r.renewals.WithLabelValues("failure").Inc()
+			backoff = min(max(2*backoff, renewMinBackoff), renewMaxBackoff)
+			r.log.Error("failed to renew certificate", zap.Error(err), zap.Duration("retryIn", backoff))
+			continue
+		}
+		r.renewals.WithLabelValues("success").Inc()
+		backoff = 0
+		r.log.Info("renewed certificate", zap.Time("notAfter", r.creds.Leaf().NotAfter))
+	}
+}
+
+func (r *renewer) renew(ctx context.Context) error {
+	current := r.creds.Leaf()
+	key, err := generateKey(keyType(r.creds.PrivateKey()))
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: current.Subject.CommonName},
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create csr: %w", err)
+	}
+	res, err := r.client.Renew(ctx, &pb.RenewRequest{
+		Csr: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to renew: %w", err)
+	}
+
+	block, _ := pem.Decode(res.GetCertificateChain())
+	if block == nil {
+		return errors.New("server returned no certificate")
+	}
+	issued, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse renewed certificate: %w", err)
+	}
+	if pub, ok := issued.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(key.Public()) {
+		return errors.New("renewed certificate does not match the new key")
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	backups, err := r.install(map[string][]byte{
+		r.keyFile:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		r.certFile: res.GetCertificateChain(),
+		r.caFile:   res.GetCaBundle(),
+	})
+	if err != nil {
+		return err
+	}
+	// The renewal only counts once the server accepted the renewed certificate, until then the
+	// backups are kept to roll back to.
+	if err := r.confirm(ctx); err != nil {
+		r.restore(backups)
+		r.removeBackups(backups)
+		return fmt.Errorf("server did not accept the renewed certificate: %w", err)
+	}
+	r.removeBackups(backups)
+	return nil
+}
+
+// install backs up the current files, writes the renewed ones and reloads the credentials.
+// The backups are restored when the renewed credentials fail to load.
+func (r *renewer) install(files map[string][]byte) (map[string][]byte, error) {
+	backups := map[string][]byte{}
+	for path := range files {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		if err := writeFile(path+".old", b); err != nil {
+			return nil, err
+		}
+		backups[path] = b
+	}
+	for path, b := range files {
+		if err := writeFile(path, b); err != nil {
+			r.restore(backups)
+			return nil, err
+		}
+	}
+	if err := r.creds.Reload(); err != nil {
+		r.restore(backups)
+		return nil, err
+	}
+	return backups, nil
+}
+
+// confirm opens a Poll stream on a new connection, whose handshake presents the renewed
+// certificate, and waits for the server to answer its heartbeat. The server replaces the
+// agent's current stream with it, which reconnects once the confirmation stream is closed.
+func (r *renewer) confirm(ctx context.Context) error {
+	conn, err := r.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, renewConfirmTimeout)
+	defer cancel()
+	stream, err := pb.NewAgentServiceClient(conn).Poll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to poll: %w", err)
+	}
+	if err := stream.Send(heartbeatRequest(r.agentID)); err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		return fmt.Errorf("failed to receive: %w", err)
+	}
+	return stream.CloseSend()
+}
+
+func (r *renewer) restore(backups map[string][]byte) {
+	for path, b := range backups {
+		if err := writeFile(path, b); err != nil {
+			r.log.Error("failed to restore previous credentials", zap.String("path", path), zap.Error(err))
+		}
+	}
+	if err := r.creds.Reload(); err != nil {
+		r.log.Error("failed to reload previous credentials", zap.Error(err))
+	}
+}
+
+// removeBackups deletes the .old files of backups once the renewal is confirmed or rolled back.
+func (r *renewer) removeBackups(backups map[string][]byte) {
+	for path := range backups {
+		if err := os.Remove(path + ".old"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			r.log.Warn("failed to remove backup", zap.String("path", path+".old"), zap.Error(err))
+		}
+	}
+}
+
+func keyType(key crypto.PrivateKey) string {
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return keytype.RSA
+	}
+	return keytype.ECDSA
+}
@@ -0,0 +1,202 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"maps"
+	"math/big"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+func TestRenewAt(t *testing.T) {
+	notBefore := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cert := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(90 * 24 * time.Hour)}
+
+	if got, want := renewAt(cert, 2.0/3.0), notBefore.Add(60*24*time.Hour); !got.Equal(want) {
+		t.Errorf("expected renewal at %s, got %s", want, got)
+	}
+	if got, want := renewAt(cert, 0.5), notBefore.Add(45*24*time.Hour); !got.Equal(want) {
+		t.Errorf("expected renewal at %s, got %s", want, got)
+	}
+}
+
+// renewServer signs the CSR of a renewal with ca, or with a fresh key when mismatch is set. Its
+// Poll streams answer heartbeats unless reject is set.
+type renewServer struct {
+	pb.UnimplementedAgentServiceServer
+
+	t        *testing.T
+	ca       *testCert
+	mismatch bool
+	reject   atomic.Bool
+}
+
+func (s *renewServer) Poll(stream pb.AgentService_PollServer) error {
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	if s.reject.Load() {
+		return status.Error(codes.Unauthenticated, "unknown certificate")
+	}
+	return stream.Send(&pb.AgentResponse{
+		Payload: &pb.AgentResponse_ServerStatus{ServerStatus: &pb.ServerStatus{Message: "ok"}},
+	})
+}
+
+func (s *renewServer) Renew(ctx context.Context, req *pb.RenewRequest) (*pb.RenewResponse, error) {
+	block, _ := pem.Decode(req.GetCsr())
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	var raw []byte
+	if s.mismatch {
+		raw = newTestCert(s.t, &x509.Certificate{Subject: csr.Subject}, s.ca).cert.Raw
+	} else {
+		tmpl := &x509.Certificate{Subject: csr.Subject, SerialNumber: big.NewInt(time.Now().UnixNano()),
+			NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+		if raw, err = x509.CreateCertificate(rand.Reader, tmpl, s.ca.cert, csr.PublicKey, s.ca.key); err != nil {
+			return nil, err
+		}
+	}
+	return &pb.RenewResponse{
+		CertificateChain: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}),
+		CaBundle:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.ca.cert.Raw}),
+	}, nil
+}
+
+// newTestRenewer returns a renewer of the agent credentials in dir issued by ca.
+func newTestRenewer(t *testing.T, dir string, ca *testCert) *renewer {
+	t.Helper()
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "1"}}, ca).write(t, dir, "agent")
+	creds, err := newCredentialsProvider(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &renewer{log: zap.NewNop(), creds: creds, certFile: certFile, keyFile: keyFile, caFile: caFile}
+}
+
+// readFiles returns the contents of the files by path.
+func readFiles(t *testing.T, paths ...string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[path] = string(b)
+	}
+	return files
+}
+
+func TestRenewerInstallRestoresBackups(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	r := newTestRenewer(t, dir, ca)
+	leaf := r.creds.Leaf()
+	before := readFiles(t, r.certFile, r.keyFile, r.caFile)
+
+	// The key of one certificate with another one fails to load.
+	certFile, _ := newTestCert(t, &x509.Certificate{}, ca).write(t, t.TempDir(), "renewed")
+	_, keyFile := newTestCert(t, &x509.Certificate{}, ca).write(t, t.TempDir(), "other")
+	renewed := readFiles(t, certFile, keyFile)
+	_, err := r.install(map[string][]byte{r.certFile: []byte(renewed[certFile]), r.keyFile: []byte(renewed[keyFile])})
+	if err == nil {
+		t.Fatal("expected a mismatched key pair to fail to load")
+	}
+	if !r.creds.Leaf().Equal(leaf) {
+		t.Error("expected the previous certificate to stay loaded")
+	}
+	if got := readFiles(t, r.certFile, r.keyFile, r.caFile); !maps.Equal(got, before) {
+		t.Error("expected the previous files to be restored")
+	}
+	for _, path := range []string{r.certFile, r.keyFile} {
+		if got := readFiles(t, path+".old")[path+".old"]; got != before[path] {
+			t.Errorf("expected %s.old to back up the previous file", path)
+		}
+	}
+	if _, err := os.Stat(r.caFile + ".old"); !os.IsNotExist(err) {
+		t.Errorf("expected only the installed files to be backed up, got %v", err)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil)
+	r := newTestRenewer(t, dir, ca)
+	leaf := r.creds.Leaf()
+	before := readFiles(t, r.certFile, r.keyFile, r.caFile)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &renewServer{t: t, ca: ca, mismatch: true}
+	s := grpc.NewServer()
+	pb.RegisterAgentServiceServer(s, fake)
+	go s.Serve(lis)
+	defer s.Stop()
+	r.dial = func() (*grpc.ClientConn, error) {
+		return grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	conn, err := r.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r.client = pb.NewAgentServiceClient(conn)
+	// expectNoBackups checks the .old files are gone once the renewal is decided.
+	expectNoBackups := func() {
+		t.Helper()
+		for _, path := range []string{r.certFile, r.keyFile, r.caFile} {
+			if _, err := os.Stat(path + ".old"); !os.IsNotExist(err) {
+				t.Errorf("expected %s.old to be removed, got %v", path, err)
+			}
+		}
+	}
+
+	if err := r.renew(context.Background()); err == nil {
+		t.Fatal("expected a certificate for another key to be rejected")
+	}
+	if got := readFiles(t, r.certFile, r.keyFile, r.caFile); !maps.Equal(got, before) || !r.creds.Leaf().Equal(leaf) {
+		t.Error("expected the current credentials to be kept")
+	}
+
+	// A renewed certificate the server does not accept on a stream is rolled back.
+	fake.mismatch = false
+	fake.reject.Store(true)
+	if err := r.renew(context.Background()); err == nil {
+		t.Fatal("expected a renewed certificate the server rejects to fail")
+	}
+	if got := readFiles(t, r.certFile, r.keyFile, r.caFile); !maps.Equal(got, before) || !r.creds.Leaf().Equal(leaf) {
+		t.Error("expected the previous credentials to be restored")
+	}
+	expectNoBackups()
+
+	fake.reject.Store(false)
+	if err := r.renew(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	renewed := r.creds.Leaf()
+	if renewed.Equal(leaf) || renewed.Subject.CommonName != leaf.Subject.CommonName {
+		t.Errorf("expected a renewed certificate for %s, got %v", leaf.Subject.CommonName, renewed.Subject)
+	}
+	expectNoBackups()
+}
@@ -0,0 +1,223 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/keepalive"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/version"
+)
+
+const (
+	reconnectMinBackoff = time.Second
+	reconnectMaxBackoff = time.Minute
+)
+
+// keepaliveParams ping the server while the stream is idle so a connection that silently died,
+// e.g. behind a NAT or load balancer, is noticed before the next heartbeat fails.
+var keepaliveParams = keepalive.ClientParameters{
+	Time:                30 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// connectParams keep the redials of the grpc channel in step with the reconnect backoff, Poll
+// fails without dialing while the channel waits for its next attempt.
+var connectParams = grpc.ConnectParams{
+	Backoff: backoff.Config{
+		BaseDelay:  reconnectMinBackoff,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   reconnectMaxBackoff,
+	},
+	MinConnectTimeout: 20 * time.Second,
+}
+
+var errNotConnected = errors.New("not connected to the server")
+
+// connection holds the Poll stream of the agent and opens a new one whenever it ends. The task
+// runner outlives the streams, updates sent while reconnecting fail and are recovered by the
+// server redelivering the unfinished tasks on the next stream.
+type connection struct {
+	log     *zap.Logger
+	client  pb.AgentServiceClient
+	options Options
+	tasks   *taskRunner
+
+	mu     sync.Mutex
+	stream pb.AgentService_PollClient
+}
+
+func newConnection(log *zap.Logger, client pb.AgentServiceClient, options Options) *connection {
+	c := &connection{log: log, client: client, options: options}
+	c.tasks = newTaskRunner(log, options.AgentID, options.InstallDirs, c.send)
+	return c
+}
+
+// send sends req on the current stream. The stream is shared by the heartbeat loop and the
+// task runner.
+func (c *connection) send(req *pb.AgentRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream == nil {
+		return errNotConnected
+	}
+	return c.stream.Send(req)
+}
+
+func (c *connection) setStream(stream pb.AgentService_PollClient) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stream = stream
+}
+
+// Run polls the server until ctx is done. Streams that end are reopened with an exponential
+// backoff and jitter, the backoff starts over once a stream was answered by the server.
+func (c *connection) Run(ctx context.Context) {
+	delay := time.Duration(0)
+	for {
+		established, err := c.poll(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if established {
+			delay = reconnectMinBackoff
+		} else {
+			delay = min(max(2*delay, reconnectMinBackoff), reconnectMaxBackoff)
+		}
+		wait := jitter(delay)
+		c.log.Warn("lost connection to the server", zap.Error(err), zap.Duration("retryIn", wait))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// jitter spreads the reconnects of agents that lost their streams at the same time, e.g. when
+// the server restarted, over the second half of the backoff.
+func jitter(backoff time.Duration) time.Duration {
+	return backoff/2 + rand.N(backoff/2)
+}
+
+// poll opens a stream and serves it until it fails or ctx is done. The server does not keep
+// any state of closed streams, so the heartbeat and inventory are sent again on every stream.
+// It reports whether the server answered on the stream.
+func (c *connection) poll(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := c.client.Poll(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to poll: %w", err)
+	}
+	c.setStream(stream)
+	defer c.setStream(nil)
+
+	var established atomic.Bool
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				errc <- err
+				return
+			}
+			established.Store(true)
+			c.handle(res)
+		}
+	}()
+
+	if err := c.sendHeartbeat(); err != nil {
+		return false, err
+	}
+	if err := c.sendInventory(); err != nil {
+		return false, err
+	}
+	c.log.Info("connected to the server")
+
+	ticker := time.NewTicker(c.options.HeartbeatInterval)
+	defer ticker.Stop()
+	inventoryTicker := time.NewTicker(c.options.InventoryInterval)
+	defer inventoryTicker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err = c.sendHeartbeat()
+		case <-inventoryTicker.C:
+			err = c.sendInventory()
+		case err = <-errc:
+			return established.Load(), fmt.Errorf("failed to receive: %w", err)
+		case <-ctx.Done():
+			stream.CloseSend()
+			return established.Load(), nil
+		}
+		if err != nil {
+			return established.Load(), err
+		}
+	}
+}
+
+func (c *connection) handle(res *pb.AgentResponse) {
+	if task := res.GetServerTask(); task != nil {
+		go c.tasks.HandleTask(task)
+	} else if issued := res.GetIssuedCertificate(); issued != nil {
+		go c.tasks.HandleIssuedCertificate(issued)
+	} else if status := res.GetServerStatus(); status != nil {
+		c.log.Info("Received status", zap.String("message", status.Message))
+	} else if reconnect := res.GetReconnect(); reconnect != nil {
+		c.log.Info("server asked to reconnect", zap.String("reason", reconnect.Reason))
+	}
+}
+
+func heartbeatRequest(agentID string) *pb.AgentRequest {
+	return &pb.AgentRequest{
+		AgentId: agentID,
+		Payload: &pb.AgentRequest_Heartbeat{
+			Heartbeat: &pb.AgentHeartbeat{
+				Timestamp:    time.Now().Unix(),
+				Version:      version.Get(),
+				Capabilities: capabilities,
+			},
+		},
+	}
+}
+
+func (c *connection) sendHeartbeat() error {
+	if err := c.send(heartbeatRequest(c.options.AgentID)); err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	return nil
+}
+
+func (c *connection) sendInventory() error {
+	if len(c.options.WatchPaths) == 0 {
+		return nil
+	}
+	certs, errs := scanInventory(c.options.WatchPaths, c.options.PKCS12Password)
+	for _, err := range errs {
+		c.log.Warn("skipping certificate", zap.Error(err))
+	}
+	inventory := &pb.AgentRequest{
+		AgentId: c.options.AgentID,
+		Payload: &pb.AgentRequest_Inventory{
+			Inventory: &pb.CertificateInventory{Certificates: certs},
+		},
+	}
+	if err := c.send(inventory); err != nil {
+		return fmt.Errorf("failed to send inventory: %w", err)
+	}
+	return nil
+}
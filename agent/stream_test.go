@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+// flakyServer drops the first Poll stream after the heartbeat and keeps later ones open.
+type flakyServer struct {
+	pb.UnimplementedAgentServiceServer
+
+	streams    atomic.Int32
+	heartbeats chan int32
+}
+
+func (s *flakyServer) Poll(stream pb.AgentService_PollServer) error {
+	n := s.streams.Add(1)
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if req.GetHeartbeat() == nil {
+			continue
+		}
+		s.heartbeats <- n
+		if n == 1 {
+			return status.Error(codes.Unavailable, "dropped")
+		}
+		if err := stream.Send(&pb.AgentResponse{
+			Payload: &pb.AgentResponse_ServerStatus{ServerStatus: &pb.ServerStatus{Message: "ok"}},
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestConnectionReconnects(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &flakyServer{heartbeats: make(chan int32, 16)}
+	s := grpc.NewServer()
+	pb.RegisterAgentServiceServer(s, fake)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	options := DefaultOptions()
+	options.AgentID = "1"
+	c := newConnection(zap.NewNop(), pb.NewAgentServiceClient(conn), options)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	for _, want := range []int32{1, 2} {
+		select {
+		case n := <-fake.heartbeats:
+			if n != want {
+				t.Fatalf("expected heartbeat on stream %d, got stream %d", want, n)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for heartbeat on stream %d", want)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to return once the context is done")
+	}
+	if err := c.send(&pb.AgentRequest{}); err != errNotConnected {
+		t.Errorf("expected sends to fail after Run returned, got %v", err)
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for range 100 {
+		if d := jitter(reconnectMinBackoff); d < reconnectMinBackoff/2 || d >= reconnectMinBackoff {
+			t.Fatalf("jitter(%v) = %v, expected it in the second half of the backoff", reconnectMinBackoff, d)
+		}
+	}
+}
@@ -0,0 +1,472 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"go.uber.org/zap"
+
+	"github.com/salzr/acert/certname"
+	"github.com/salzr/acert/keytype"
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+const (
+	// defaultTaskFileMode is the mode of installed private keys, certificates and CAs are
+	// installed world readable with certificateFileMode.
+	defaultTaskFileMode = 0o600
+	certificateFileMode = 0o644
+	// maxFinishedTasks is how many task results are kept to answer redelivered tasks.
+	maxFinishedTasks = 256
+)
+
+// taskRunner executes the tasks sent by the server over the Poll stream. Tasks are delivered at
+// least once, redeliveries are answered with the state of the task instead of running it again.
+type taskRunner struct {
+	log     *zap.Logger
+	agentID string
+	send    func(*pb.AgentRequest) error
+	// installDirs are the directories certificates may be installed in.
+	installDirs []string
+
+	mu sync.Mutex
+	// pending holds the tasks in progress, with the key and CSR of IssueCertificate tasks once
+	// they are generated.
+	pending map[string]*pendingCertificate
+	// finished holds the results of the latest tasks, oldest first in finishedOrder.
+	finished      map[string]*pb.TaskResult
+	finishedOrder []string
+}
+
+type pendingCertificate struct {
+	key  crypto.Signer
+	csr  []byte
+	spec *pb.IssueCertificate
+	// installing is set once the certificate arrived, later copies of it are ignored.
+	installing bool
+}
+
+func newTaskRunner(log *zap.Logger, agentID string, installDirs []string, send func(*pb.AgentRequest) error) *taskRunner {
+	return &taskRunner{
+		log:         log,
+		agentID:     agentID,
+		send:        send,
+		installDirs: installDirs,
+		pending:     map[string]*pendingCertificate{},
+		finished:    map[string]*pb.TaskResult{},
+	}
+}
+
+// HandleTask starts a task sent by the server.
+func (r *taskRunner) HandleTask(task *pb.ServerTask) {
+	log := r.log.With(zap.String("taskId", task.TaskId), zap.Uint32("attempt", task.Attempt))
+	if r.duplicate(log, task) {
+		return
+	}
+
+	switch t := task.Task.(type) {
+	case *pb.ServerTask_IssueCertificate:
+		log.Info("received issue certificate task")
+		r.accept(task.TaskId)
+		if err := r.requestCertificate(task.TaskId, t.IssueCertificate); err != nil {
+			log.Error("failed to request certificate", zap.Error(err))
+			r.result(task.TaskId, nil, err)
+		}
+	default:
+		log.Warn("received unsupported task")
+		r.result(task.TaskId, nil, &taskError{
+			code: pb.TaskErrorCode_TASK_ERROR_CODE_UNSUPPORTED,
+			err:  errors.New("unsupported task"),
+		})
+	}
+}
+
+// duplicate answers a redelivered task with its current state and reports whether it was one.
+// New tasks are registered as in progress so concurrent deliveries of one run it once.
+func (r *taskRunner) duplicate(log *zap.Logger, task *pb.ServerTask) bool {
+	r.mu.Lock()
+	result, finished := r.finished[task.TaskId]
+	pending, running := r.pending[task.TaskId]
+	var csr []byte
+	if running {
+		csr = pending.csr
+	} else if !finished {
+		r.pending[task.TaskId] = &pendingCertificate{}
+	}
+	r.mu.Unlock()
+
+	switch {
+	case finished:
+		log.Info("resending the result of a duplicate task")
+		r.sendOrLog(task.TaskId, &pb.AgentRequest{
+			AgentId: r.agentID,
+			Payload: &pb.AgentRequest_TaskResult{TaskResult: result},
+		})
+	case running:
+		log.Info("duplicate task is already in progress")
+		r.accept(task.TaskId)
+		if csr != nil {
+			r.sendCSR(task.TaskId, csr)
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// taskError is a task failure with the error code reported to the server.
+type taskError struct {
+	code pb.TaskErrorCode
+	err  error
+}
+
+func (e *taskError) Error() string { return e.err.Error() }
+func (e *taskError) Unwrap() error { return e.err }
+
+// requestCertificate generates the key of an IssueCertificate task and sends its CSR.
+func (r *taskRunner) requestCertificate(taskID string, spec *pb.IssueCertificate) error {
+	keyType := spec.KeyType
+	if keyType == "" {
+		keyType = keytype.ECDSA
+	}
+	if !keytype.Valid(keyType) {
+		return &taskError{code: pb.TaskErrorCode_TASK_ERROR_CODE_INVALID, err: fmt.Errorf("unsupported key type %q", keyType)}
+	}
+	names, err := certname.Parse(spec)
+	if err != nil {
+		return &taskError{code: pb.TaskErrorCode_TASK_ERROR_CODE_INVALID, err: err}
+	}
+	if _, _, err := lookupOwner(spec.Owner); err != nil {
+		return &taskError{code: pb.TaskErrorCode_TASK_ERROR_CODE_INVALID, err: err}
+	}
+	if err := checkInstallPaths(r.installDirs, spec); err != nil {
+		return &taskError{code: pb.TaskErrorCode_TASK_ERROR_CODE_INVALID, err: err}
+	}
+	key, err := generateKey(keyType)
+	if err != nil {
+		return &taskError{code: pb.TaskErrorCode_TASK_ERROR_CODE_KEY_GENERATION, err: err}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, names.Request(), key)
+	if err != nil {
+		return &taskError{code: pb.TaskErrorCode_TASK_ERROR_CODE_KEY_GENERATION, err: fmt.Errorf("failed to create csr: %w", err)}
+	}
+	r.progress(taskID, "generated "+keyType+" key, waiting for the certificate")
+
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	r.mu.Lock()
+	r.pending[taskID] = &pendingCertificate{key: key, csr: csrPEM, spec: spec}
+	r.mu.Unlock()
+
+	r.sendCSR(taskID, csrPEM)
+	return nil
+}
+
+// sendCSR sends the CSR of a task. A CSR lost with the stream is sent again when the server
+// redelivers the task.
+func (r *taskRunner) sendCSR(taskID string, csr []byte) {
+	r.sendOrLog(taskID, &pb.AgentRequest{
+		AgentId: r.agentID,
+		Payload: &pb.AgentRequest_Csr{Csr: &pb.CertificateSigningRequest{TaskId: taskID, Csr: csr}},
+	})
+}
+
+// HandleIssuedCertificate installs the certificate issued for a pending task and reports the
+// outcome to the server.
+func (r *taskRunner) HandleIssuedCertificate(res *pb.IssuedCertificate) {
+	log := r.log.With(zap.String("taskId", res.TaskId))
+
+	r.mu.Lock()
+	pending, ok := r.pending[res.TaskId]
+	ok = ok && pending.key != nil && !pending.installing
+	if ok {
+		pending.installing = true
+	}
+	r.mu.Unlock()
+	if !ok {
+		log.Warn("received certificate for unknown task")
+		return
+	}
+
+	if res.Error != "" {
+		// The server already failed the task, the result is only kept for redeliveries.
+		log.Error("server failed to issue certificate", zap.String("error", res.Error))
+		r.finish(&pb.TaskResult{
+			TaskId:    res.TaskId,
+			Status:    pb.TaskStatus_TASK_STATUS_FAILED,
+			Message:   res.Error,
+			ErrorCode: pb.TaskErrorCode_TASK_ERROR_CODE_ISSUANCE,
+		})
+		return
+	}
+	r.progress(res.TaskId, "installing the certificate")
+	artifacts, err := installCertificate(r.installDirs, pending, res)
+	if err != nil {
+		log.Error("failed to install certificate", zap.Error(err))
+		err = &taskError{code: pb.TaskErrorCode_TASK_ERROR_CODE_INSTALL, err: err}
+	} else {
+		log.Info("installed certificate", zap.String("path", pending.spec.CertPath))
+	}
+	r.result(res.TaskId, artifacts, err)
+}
+
+func (r *taskRunner) accept(taskID string) {
+	r.sendOrLog(taskID, &pb.AgentRequest{
+		AgentId: r.agentID,
+		Payload: &pb.AgentRequest_TaskAccepted{TaskAccepted: &pb.TaskAccepted{TaskId: taskID}},
+	})
+}
+
+func (r *taskRunner) progress(taskID, message string) {
+	r.sendOrLog(taskID, &pb.AgentRequest{
+		AgentId: r.agentID,
+		Payload: &pb.AgentRequest_TaskProgress{TaskProgress: &pb.TaskProgress{TaskId: taskID, Message: message}},
+	})
+}
+
+// result reports the outcome of a task, err sets the error code when it is a taskError.
+func (r *taskRunner) result(taskID string, artifacts []*pb.TaskArtifact, err error) {
+	result := &pb.TaskResult{TaskId: taskID, Status: pb.TaskStatus_TASK_STATUS_SUCCEEDED, Artifacts: artifacts}
+	if err != nil {
+		result.Status = pb.TaskStatus_TASK_STATUS_FAILED
+		result.Message = err.Error()
+		var te *taskError
+		if errors.As(err, &te) {
+			result.ErrorCode = te.code
+		}
+	}
+	r.finish(result)
+	r.sendOrLog(taskID, &pb.AgentRequest{
+		AgentId: r.agentID,
+		Payload: &pb.AgentRequest_TaskResult{TaskResult: result},
+	})
+}
+
+// finish moves a task from pending to finished, forgetting the oldest results past
+// maxFinishedTasks.
+func (r *taskRunner) finish(result *pb.TaskResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, result.TaskId)
+	if _, ok := r.finished[result.TaskId]; !ok {
+		r.finishedOrder = append(r.finishedOrder, result.TaskId)
+	}
+	r.finished[result.TaskId] = result
+	for len(r.finishedOrder) > maxFinishedTasks {
+		delete(r.finished, r.finishedOrder[0])
+		r.finishedOrder = r.finishedOrder[1:]
+	}
+}
+
+func (r *taskRunner) sendOrLog(taskID string, req *pb.AgentRequest) {
+	if err := r.send(req); err != nil {
+		r.log.Error("failed to send task update", zap.String("taskId", taskID), zap.Error(err))
+	}
+}
+
+// installCertificate writes the key, certificate chain and CA of a task and returns them as
+// artifacts. The paths are checked against installDirs again as the directories may have
+// changed since the task was accepted. Every file is replaced atomically and the previous files
+// are restored if any of them fails to be written.
+func installCertificate(installDirs []string, pending *pendingCertificate, res *pb.IssuedCertificate) ([]*pb.TaskArtifact, error) {
+	spec := pending.spec
+	if err := checkInstallPaths(installDirs, spec); err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(res.CertificateChain)
+	if block == nil {
+		return nil, errors.New("server returned no certificate")
+	}
+	issued, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse issued certificate: %w", err)
+	}
+	if pub, ok := issued.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(pending.key.Public()) {
+		return nil, errors.New("issued certificate does not match the generated key")
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(pending.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	keyMode := os.FileMode(spec.FileMode)
+	if keyMode == 0 {
+		keyMode = defaultTaskFileMode
+	}
+	uid, gid, err := lookupOwner(spec.Owner)
+	if err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		name string
+		path string
+		mode os.FileMode
+		data []byte
+	}
+	files := []file{
+		{"private_key", spec.KeyPath, keyMode, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})},
+		{"certificate", spec.CertPath, certificateFileMode, res.CertificateChain},
+	}
+	if spec.CaPath != "" && len(res.Ca) > 0 {
+		files = append(files, file{"ca", spec.CaPath, certificateFileMode, res.Ca})
+	}
+
+	// The files are backed up with their mode and owner before any is replaced, so a failed
+	// install restores them as they were.
+	backups := map[string]*fileBackup{}
+	for _, f := range files {
+		b, err := backupFile(f.path)
+		if err == nil {
+			backups[f.path] = b
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to back up %s: %w", f.path, err)
+		}
+	}
+	for i, f := range files {
+		if err := writeFileAs(f.path, f.data, f.mode, uid, gid); err != nil {
+			for _, written := range files[:i] {
+				_ = backups[written.path].restore(written.path)
+			}
+			return nil, err
+		}
+	}
+
+	artifacts := make([]*pb.TaskArtifact, 0, len(files))
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		artifacts = append(artifacts, &pb.TaskArtifact{Name: f.name, Path: f.path, Sha256: hex.EncodeToString(sum[:])})
+	}
+	return artifacts, nil
+}
+
+// checkInstallPaths checks every path of spec with checkInstallPath.
+func checkInstallPaths(installDirs []string, spec *pb.IssueCertificate) error {
+	paths := []string{spec.KeyPath, spec.CertPath}
+	if spec.CaPath != "" {
+		paths = append(paths, spec.CaPath)
+	}
+	for _, path := range paths {
+		if err := checkInstallPath(installDirs, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkInstallPath checks that path is a file in one of installDirs or their subdirectories. The
+// server names the paths, so the agent decides where it lets it write: the directory of path
+// must exist and is resolved through symlinks before it is compared, and path itself must not
+// be a symlink or other non regular file.
+func checkInstallPath(installDirs []string, path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("install path %q must be a clean absolute path", path)
+	}
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to resolve the directory of %s: %w", path, err)
+	}
+	allowed := slices.ContainsFunc(installDirs, func(installDir string) bool {
+		installDir, err := filepath.EvalSymlinks(installDir)
+		if err != nil || !filepath.IsAbs(installDir) {
+			return false
+		}
+		rel, err := filepath.Rel(installDir, dir)
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	})
+	if !allowed {
+		return fmt.Errorf("install path %s is not in an allowed install directory", path)
+	}
+	info, err := os.Lstat(path)
+	if err == nil && !info.Mode().IsRegular() {
+		return fmt.Errorf("install path %s is not a regular file", path)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// fileBackup is the content, mode and owner of a file before it was replaced.
+type fileBackup struct {
+	data     []byte
+	mode     os.FileMode
+	uid, gid int
+}
+
+// backupFile reads path with its mode and owner, failing if it is a symlink.
+func backupFile(path string) (*fileBackup, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	b := &fileBackup{data: data, mode: info.Mode().Perm(), uid: -1, gid: -1}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		b.uid, b.gid = int(st.Uid), int(st.Gid)
+	}
+	return b, nil
+}
+
+// restore writes the backup back to path, a nil backup removes the file that did not exist.
+func (b *fileBackup) restore(path string) error {
+	if b == nil {
+		return os.Remove(path)
+	}
+	return writeFileAs(path, b.data, b.mode, b.uid, b.gid)
+}
+
+// lookupOwner resolves a user or user:group owner, names or numeric ids, to a uid and gid. An
+// empty owner or group returns -1 to keep it unchanged.
+func lookupOwner(owner string) (int, int, error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+	name, group, _ := strings.Cut(owner, ":")
+
+	uid, err := strconv.Atoi(name)
+	if err != nil {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return -1, -1, fmt.Errorf("failed to look up user %q: %w", name, err)
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if group == "" {
+		return uid, -1, nil
+	}
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return -1, -1, fmt.Errorf("failed to look up group %q: %w", group, err)
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,204 @@
+package agent
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+type recorder struct {
+	mu   sync.Mutex
+	reqs []*pb.AgentRequest
+}
+
+func (r *recorder) send(req *pb.AgentRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reqs = append(r.reqs, req)
+	return nil
+}
+
+func (r *recorder) take() []*pb.AgentRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reqs := r.reqs
+	r.reqs = nil
+	return reqs
+}
+
+func TestTaskRunnerIssueCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, &x509.Certificate{IsCA: true, BasicConstraintsValid: true}, nil)
+	rec := &recorder{}
+	runner := newTaskRunner(zap.NewNop(), "1", []string{dir}, rec.send)
+
+	task := &pb.ServerTask{
+		TaskId:  "task",
+		Attempt: 1,
+		Task: &pb.ServerTask_IssueCertificate{IssueCertificate: &pb.IssueCertificate{
+			Subject:  &pb.Subject{CommonName: "web"},
+			DnsNames: []string{"web.example.com"},
+			CertPath: filepath.Join(dir, "web.crt"),
+			KeyPath:  filepath.Join(dir, "web.key"),
+			FileMode: 0o640,
+		}},
+	}
+	runner.HandleTask(task)
+	reqs := rec.take()
+	if len(reqs) != 3 || reqs[0].GetTaskAccepted() == nil || reqs[1].GetTaskProgress() == nil || reqs[2].GetCsr() == nil {
+		t.Fatalf("expected accepted, progress and csr, got %v", reqs)
+	}
+
+	// A redelivery while waiting for the certificate sends the same CSR again.
+	task.Attempt = 2
+	runner.HandleTask(task)
+	dup := rec.take()
+	if len(dup) != 2 || dup[0].GetTaskAccepted() == nil || string(dup[1].GetCsr().GetCsr()) != string(reqs[2].GetCsr().GetCsr()) {
+		t.Fatalf("expected accepted and the same csr, got %v", dup)
+	}
+
+	block, _ := pem.Decode(reqs[2].GetCsr().GetCsr())
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := &pb.IssuedCertificate{TaskId: "task", CertificateChain: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+	runner.HandleIssuedCertificate(issued)
+	runner.HandleIssuedCertificate(issued)
+
+	reqs = rec.take()
+	if len(reqs) != 2 || reqs[1].GetTaskResult().GetStatus() != pb.TaskStatus_TASK_STATUS_SUCCEEDED {
+		t.Fatalf("expected progress and a single successful result, got %v", reqs)
+	}
+	if n := len(reqs[1].GetTaskResult().GetArtifacts()); n != 2 {
+		t.Errorf("expected key and certificate artifacts, got %d", n)
+	}
+	info, err := os.Stat(filepath.Join(dir, "web.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("expected 0640, got %o", info.Mode().Perm())
+	}
+	if info, err = os.Stat(filepath.Join(dir, "web.crt")); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0o644 {
+		t.Errorf("expected the certificate to be readable with 0644, got %o", info.Mode().Perm())
+	}
+
+	// A redelivery of a finished task is answered with its result.
+	runner.HandleTask(task)
+	dup = rec.take()
+	if len(dup) != 1 || dup[0].GetTaskResult().GetStatus() != pb.TaskStatus_TASK_STATUS_SUCCEEDED {
+		t.Fatalf("expected the result again, got %v", dup)
+	}
+}
+
+func TestTaskRunnerRejectsInstallPaths(t *testing.T) {
+	root := t.TempDir()
+	allowed, other := filepath.Join(root, "allowed"), filepath.Join(root, "other")
+	for _, dir := range []string{allowed, filepath.Join(allowed, "sub"), other} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(other, "target"), filepath.Join(allowed, "link.key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(other, filepath.Join(allowed, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		dirs    []string
+		keyPath string
+		wantErr bool
+	}{
+		{name: "allowed", dirs: []string{allowed}, keyPath: filepath.Join(allowed, "web.key")},
+		{name: "subdirectory", dirs: []string{allowed}, keyPath: filepath.Join(allowed, "sub", "web.key")},
+		{name: "no install dirs", keyPath: filepath.Join(allowed, "web.key"), wantErr: true},
+		{name: "outside", dirs: []string{allowed}, keyPath: filepath.Join(other, "web.key"), wantErr: true},
+		{name: "dot dot", dirs: []string{allowed}, keyPath: allowed + "/../other/web.key", wantErr: true},
+		{name: "relative", dirs: []string{allowed}, keyPath: "web.key", wantErr: true},
+		{name: "missing directory", dirs: []string{allowed}, keyPath: filepath.Join(allowed, "missing", "web.key"), wantErr: true},
+		{name: "symlinked file", dirs: []string{allowed}, keyPath: filepath.Join(allowed, "link.key"), wantErr: true},
+		{name: "symlinked directory", dirs: []string{allowed}, keyPath: filepath.Join(allowed, "escape", "web.key"), wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := &recorder{}
+			runner := newTaskRunner(zap.NewNop(), "1", tc.dirs, rec.send)
+			runner.HandleTask(&pb.ServerTask{
+				TaskId: "task",
+				Task: &pb.ServerTask_IssueCertificate{IssueCertificate: &pb.IssueCertificate{
+					DnsNames: []string{"web.example.com"},
+					CertPath: filepath.Join(allowed, "web.crt"),
+					KeyPath:  tc.keyPath,
+				}},
+			})
+			reqs := rec.take()
+			result := reqs[len(reqs)-1].GetTaskResult()
+			if tc.wantErr && result.GetErrorCode() != pb.TaskErrorCode_TASK_ERROR_CODE_INVALID {
+				t.Errorf("expected the task to be rejected as invalid, got %v", reqs)
+			}
+			if !tc.wantErr && result != nil {
+				t.Errorf("expected the task to wait for its certificate, got %v", result)
+			}
+		})
+	}
+}
+
+func TestFileBackupRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.key")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := backupFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAs(path, []byte("new"), 0o644, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	// The backup is restored with the mode it had, not the one of the file replacing it.
+	if err := b.restore(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" || info.Mode().Perm() != 0o600 {
+		t.Errorf("expected old with 0600, got %q with %o", data, info.Mode().Perm())
+	}
+
+	var missing *fileBackup
+	if err := missing.restore(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a file without backup to be removed, got %v", err)
+	}
+}
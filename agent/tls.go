@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// tlsCredentials is the client certificate and server CA pool of the agent.
+type tlsCredentials struct {
+	cert *tls.Certificate
+	cas  *x509.CertPool
+}
+
+// credentialsProvider serves the current credentials to the tls handshake and swaps them atomically on reload.
+type credentialsProvider struct {
+	certFile, keyFile, caFile string
+
+	current atomic.Pointer[tlsCredentials]
+}
+
+func newCredentialsProvider(certFile, keyFile, caFile string) (*credentialsProvider, error) {
+	p := &credentialsProvider{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload reads the certificate, key and CA bundle from disk. The current credentials are kept
+// when any of them fails to load.
+func (p *credentialsProvider) Reload() error {
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client cert: %w", err)
+	}
+	caBytes, err := os.ReadFile(p.caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read ca cert: %w", err)
+	}
+	cas := x509.NewCertPool()
+	if ok := cas.AppendCertsFromPEM(caBytes); !ok {
+		return fmt.Errorf("failed to parse %s", p.caFile)
+	}
+	p.current.Store(&tlsCredentials{cert: &cert, cas: cas})
+	return nil
+}
+
+// Leaf returns the current client certificate.
+func (p *credentialsProvider) Leaf() *x509.Certificate {
+	return p.current.Load().cert.Leaf
+}
+
+// PrivateKey returns the private key of the current client certificate.
+func (p *credentialsProvider) PrivateKey() crypto.PrivateKey {
+	return p.current.Load().cert.PrivateKey
+}
+
+// TLSConfig returns a client tls config that reads the credentials on every handshake.
+func (p *credentialsProvider) TLSConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return p.current.Load().cert, nil
+		},
+		// RootCAs cannot be swapped after the config is handed to grpc, so the server chain is
+		// verified against the current CA pool in VerifyConnection instead.
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			intermediates := x509.NewCertPool()
+			for _, cert := range cs.PeerCertificates[1:] {
+				intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+				DNSName:       serverName,
+				Roots:         p.current.Load().cas,
+				Intermediates: intermediates,
+			})
+			return err
+		},
+	}
+}
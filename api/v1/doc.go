@@ -0,0 +1,8 @@
+// Package v1 contains the acert.salzr.io/v1 custom resources the server keeps its state in when
+// it runs with the kubernetes storage.
+//
+// +k8s:deepcopy-gen=package
+// +groupName=acert.salzr.io
+package v1
+
+//go:generate deepcopy-gen --output-file zz_generated.deepcopy.go --go-header-file /dev/null .
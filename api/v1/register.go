@@ -0,0 +1,28 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// GroupVersion is the group and version of the acert resources.
+var GroupVersion = schema.GroupVersion{Group: "acert.salzr.io", Version: "v1"}
+
+var (
+	// SchemeBuilder adds the acert resources to a scheme, e.g. with k8s.NewClient.
+	SchemeBuilder = runtime.NewSchemeBuilder(addKnownTypes)
+	AddToScheme   = SchemeBuilder.AddToScheme
+)
+
+func addKnownTypes(s *runtime.Scheme) error {
+	s.AddKnownTypes(GroupVersion,
+		&Agent{}, &AgentList{},
+		&InventoryCertificate{}, &InventoryCertificateList{},
+		&JoinToken{}, &JoinTokenList{},
+		&AgentTask{}, &AgentTaskList{},
+		&AuditEvent{}, &AuditEventList{},
+	)
+	metav1.AddToGroupVersion(s, GroupVersion)
+	return nil
+}
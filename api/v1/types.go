@@ -0,0 +1,275 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Labels set on the resources so they can be selected without reading every object.
+const (
+	// LabelAgentID is the id of the agent an AgentTask or InventoryCertificate is for.
+	LabelAgentID = "acert.salzr.io/agent-id"
+	// LabelInventoryGeneration is the inventory report an InventoryCertificate belongs to.
+	LabelInventoryGeneration = "acert.salzr.io/generation"
+	// LabelRevoked is set to true on revoked Agents.
+	LabelRevoked = "acert.salzr.io/revoked"
+	// LabelAuditID is the id of an AuditEvent, so ranges of the log can be selected.
+	LabelAuditID = "acert.salzr.io/id"
+	// LabelTaskStatus is the status of an AgentTask.
+	LabelTaskStatus = "acert.salzr.io/status"
+	// LabelSerialPrefix prefixes the serials of the client certificates of an Agent, each set as a
+	// label key so the Agent holding a certificate can be selected.
+	LabelSerialPrefix = "serial.acert.salzr.io/"
+	// LabelTokenHash is the token hash of a JoinToken, truncated to the length of a label value.
+	LabelTokenHash = "acert.salzr.io/token-hash"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Agent is an enrolled agent, named agent-<id>.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="ID",type=integer,JSONPath=".spec.id"
+// +kubebuilder:printcolumn:name="Hostname",type=string,JSONPath=".spec.hostname"
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=".spec.ip"
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=".status.version"
+// +kubebuilder:printcolumn:name="Last Seen",type=date,JSONPath=".status.lastSeen"
+// +kubebuilder:printcolumn:name="Revoked",type=date,JSONPath=".spec.revoked"
+type Agent struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AgentSpec   `json:"spec"`
+	Status AgentStatus `json:"status,omitempty"`
+}
+
+type AgentSpec struct {
+	ID       int64  `json:"id"`
+	Hostname string `json:"hostname"`
+	IP       string `json:"ip"`
+	// TokenHash is the hash of the join token the agent enrolled with.
+	TokenHash   string      `json:"tokenHash"`
+	Description string      `json:"description,omitempty"`
+	CreatedAt   metav1.Time `json:"createdAt"`
+	// Revoked is when the agent and its client certificates were revoked.
+	Revoked *metav1.Time `json:"revoked,omitempty"`
+}
+
+type AgentStatus struct {
+	// Version and LastSeen are updated from the agent's heartbeats.
+	Version  string       `json:"version,omitempty"`
+	LastSeen *metav1.Time `json:"lastSeen,omitempty"`
+	// ClientCertificates are the client certificates issued to the agent.
+	ClientCertificates []ClientCertificate `json:"clientCertificates,omitempty"`
+	// InventoryGeneration is the inventory report the agent made last, at InventoryReportedAt.
+	// Its certificates are InventoryCertificates, they can outgrow a single object.
+	InventoryGeneration int64        `json:"inventoryGeneration,omitempty"`
+	InventoryReportedAt *metav1.Time `json:"inventoryReportedAt,omitempty"`
+}
+
+// ClientCertificate is a client certificate issued to an agent by the agent CA.
+type ClientCertificate struct {
+	// Serial is the hex encoded serial number.
+	Serial    string       `json:"serial"`
+	NotBefore metav1.Time  `json:"notBefore"`
+	NotAfter  metav1.Time  `json:"notAfter"`
+	CreatedAt metav1.Time  `json:"createdAt"`
+	Revoked   *metav1.Time `json:"revoked,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// InventoryCertificate is a certificate found on the host of an agent, named
+// agent-<id>-<generation>-<index>. Every inventory report is a new generation, only the one in
+// the status of the Agent is current.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Agent",type=integer,JSONPath=".spec.agentId"
+// +kubebuilder:printcolumn:name="Path",type=string,JSONPath=".spec.path"
+// +kubebuilder:printcolumn:name="Subject",type=string,JSONPath=".spec.subject"
+// +kubebuilder:printcolumn:name="Not After",type=date,JSONPath=".spec.notAfter"
+type InventoryCertificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec InventoryCertificateSpec `json:"spec"`
+}
+
+type InventoryCertificateSpec struct {
+	AgentID    int64 `json:"agentId"`
+	Generation int64 `json:"generation"`
+	// Index is the position of the certificate in the report, from 1.
+	Index        int64       `json:"index"`
+	ReportedAt   metav1.Time `json:"reportedAt"`
+	Path         string      `json:"path"`
+	Subject      string      `json:"subject"`
+	SANs         []string    `json:"sans,omitempty"`
+	Issuer       string      `json:"issuer"`
+	Serial       string      `json:"serial"`
+	NotBefore    metav1.Time `json:"notBefore"`
+	NotAfter     metav1.Time `json:"notAfter"`
+	KeyAlgorithm string      `json:"keyAlgorithm"`
+	Fingerprint  string      `json:"fingerprint"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+type InventoryCertificateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InventoryCertificate `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+type AgentList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Agent `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// JoinToken is a single use token for enrolling an agent, named jointoken-<id>. Only the hash
+// of the token is stored.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="ID",type=integer,JSONPath=".spec.id"
+// +kubebuilder:printcolumn:name="Expires",type=date,JSONPath=".spec.expiresAt"
+// +kubebuilder:printcolumn:name="Torn",type=date,JSONPath=".status.torn"
+// +kubebuilder:printcolumn:name="Revoked",type=date,JSONPath=".status.revoked"
+type JoinToken struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   JoinTokenSpec   `json:"spec"`
+	Status JoinTokenStatus `json:"status,omitempty"`
+}
+
+type JoinTokenSpec struct {
+	ID        int64       `json:"id"`
+	TokenHash string      `json:"tokenHash"`
+	CreatedAt metav1.Time `json:"createdAt"`
+	ExpiresAt metav1.Time `json:"expiresAt"`
+}
+
+type JoinTokenStatus struct {
+	// Torn is when the token was used to enroll an agent.
+	Torn *metav1.Time `json:"torn,omitempty"`
+	// Revoked is when the token was revoked before it was used.
+	Revoked *metav1.Time `json:"revoked,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+type JoinTokenList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []JoinToken `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AgentTask is work queued for an agent, named by the task id.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="Agent",type=integer,JSONPath=".spec.agentId"
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=".spec.type"
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=".status.status"
+// +kubebuilder:printcolumn:name="Attempts",type=integer,JSONPath=".status.attempts"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=".spec.createdAt"
+type AgentTask struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AgentTaskSpec   `json:"spec"`
+	Status AgentTaskStatus `json:"status,omitempty"`
+}
+
+type AgentTaskSpec struct {
+	AgentID int64 `json:"agentId"`
+	// Type is the task type, e.g. issue-certificate.
+	Type string `json:"type"`
+	// Payload is the task encoded by the server as JSON.
+	Payload   string      `json:"payload"`
+	CreatedAt metav1.Time `json:"createdAt"`
+}
+
+type AgentTaskStatus struct {
+	// Status is one of pending, dispatched, running, succeeded, failed, timed_out or canceled.
+	// +kubebuilder:validation:Enum=pending;dispatched;running;succeeded;failed;timed_out;canceled
+	Status           string         `json:"status"`
+	Message          string         `json:"message,omitempty"`
+	ErrorCode        string         `json:"errorCode,omitempty"`
+	Artifacts        []TaskArtifact `json:"artifacts,omitempty"`
+	UpdatedAt        metav1.Time    `json:"updatedAt"`
+	DispatchedAt     *metav1.Time   `json:"dispatchedAt,omitempty"`
+	FinishedAt       *metav1.Time   `json:"finishedAt,omitempty"`
+	Attempts         int            `json:"attempts,omitempty"`
+	LastDispatchedAt *metav1.Time   `json:"lastDispatchedAt,omitempty"`
+	// CertificateChain and CA are the PEM encoded certificate issued for the task and its CA.
+	CertificateChain string `json:"certificateChain,omitempty"`
+	CA               string `json:"ca,omitempty"`
+}
+
+// TaskArtifact is a file a task wrote on the host of the agent.
+type TaskArtifact struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+type AgentTaskList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AgentTask `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AuditEvent is an entry of the hash chained audit log, named audit-<id>. Events are never
+// updated.
+//
+// +kubebuilder:object:root=true
+// +kubebuilder:printcolumn:name="ID",type=integer,JSONPath=".spec.id"
+// +kubebuilder:printcolumn:name="Time",type=date,JSONPath=".spec.time"
+// +kubebuilder:printcolumn:name="Actor",type=string,JSONPath=".spec.actor"
+// +kubebuilder:printcolumn:name="Action",type=string,JSONPath=".spec.action"
+// +kubebuilder:printcolumn:name="Target",type=string,JSONPath=".spec.target"
+// +kubebuilder:printcolumn:name="Result",type=string,JSONPath=".spec.result"
+type AuditEvent struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="audit events are immutable"
+	Spec AuditEventSpec `json:"spec"`
+}
+
+type AuditEventSpec struct {
+	ID int64 `json:"id"`
+	// Time is kept at microsecond precision, it is part of the hash.
+	Time        metav1.MicroTime `json:"time"`
+	Actor       string           `json:"actor"`
+	AgentID     int64            `json:"agentId,omitempty"`
+	AgentSerial string           `json:"agentSerial,omitempty"`
+	Action      string           `json:"action"`
+	Target      string           `json:"target"`
+	Result      string           `json:"result"`
+	Detail      string           `json:"detail,omitempty"`
+	PrevHash    string           `json:"prevHash"`
+	Hash        string           `json:"hash"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:object:root=true
+
+type AuditEventList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AuditEvent `json:"items"`
+}
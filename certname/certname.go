@@ -0,0 +1,79 @@
+// Package certname converts the subject and subject alternative names of IssueCertificate tasks
+// to x509 fields. It is shared by the agent building the CSR of a task and the server building
+// the certificate it signs, so both read a task the same way.
+package certname
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/url"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+// Names are the subject and subject alternative names of an IssueCertificate task.
+type Names struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+}
+
+// Parse returns the names of spec, IP addresses and URIs that do not parse are rejected.
+func Parse(spec *pb.IssueCertificate) (*Names, error) {
+	n := &Names{
+		DNSNames:       spec.DnsNames,
+		EmailAddresses: spec.EmailAddresses,
+	}
+	if s := spec.Subject; s != nil {
+		n.Subject = pkix.Name{
+			CommonName:         s.CommonName,
+			Organization:       s.Organizations,
+			OrganizationalUnit: s.OrganizationalUnits,
+			Country:            s.Countries,
+			Locality:           s.Localities,
+			Province:           s.Provinces,
+		}
+	}
+	for _, ip := range spec.IpAddresses {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, fmt.Errorf("invalid ip address %q", ip)
+		}
+		n.IPAddresses = append(n.IPAddresses, parsed)
+	}
+	for _, u := range spec.Uris {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uri %q: %w", u, err)
+		}
+		n.URIs = append(n.URIs, parsed)
+	}
+	return n, nil
+}
+
+// Request returns a certificate request for the names.
+func (n *Names) Request() *x509.CertificateRequest {
+	return &x509.CertificateRequest{
+		Subject:        n.Subject,
+		DNSNames:       n.DNSNames,
+		EmailAddresses: n.EmailAddresses,
+		IPAddresses:    n.IPAddresses,
+		URIs:           n.URIs,
+	}
+}
+
+// Template returns a template of a leaf certificate for the names.
+func (n *Names) Template() *x509.Certificate {
+	return &x509.Certificate{
+		Subject:               n.Subject,
+		DNSNames:              n.DNSNames,
+		EmailAddresses:        n.EmailAddresses,
+		IPAddresses:           n.IPAddresses,
+		URIs:                  n.URIs,
+		BasicConstraintsValid: true,
+	}
+}
@@ -1,20 +1,75 @@
 package agent
 
 import (
+	"fmt"
+	"os"
+	"strings"
+
 	"github.com/salzr/acert/agent"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/salzr/acert/cmd/config"
 )
 
-// TODO: create flags for ca and certificate
+// pkcs12PasswordEnv holds the password of watched PKCS#12 bundles. There is no flag for it so
+// the password does not show up in the process list or shell history.
+const pkcs12PasswordEnv = config.EnvPrefix + "PKCS12_PASSWORD"
 
 func Command() *cobra.Command {
+	opts := agent.DefaultOptions()
+	configPath := agent.ConfigPath(agent.DefaultStateDir)
+	pkcs12PasswordFile := ""
 	cmd := &cobra.Command{
 		Use: "agent",
 		Run: func(cmd *cobra.Command, args []string) {
-			agent.Run(cmd.Context())
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			if err := config.Bind(cmd.Flags(), "config"); err != nil {
+				log.Fatal("failed to load config", zap.Error(err))
+			}
+			password, err := pkcs12Password(pkcs12PasswordFile)
+			if err != nil {
+				log.Fatal("failed to read pkcs12 password", zap.Error(err))
+			}
+			opts.PKCS12Password = password
+			if err := agent.Run(cmd.Context(), opts); err != nil {
+				log.Fatal("agent failed", zap.Error(err))
+			}
 		},
 	}
+	cmd.Flags().StringVar(&configPath, "config", configPath, "config file, flags and ACERT_* environment variables take precedence")
+	cmd.Flags().StringVar(&opts.Server, "server", opts.Server, "server address (host:port)")
+	cmd.Flags().StringVar(&opts.ServerName, "server-name", opts.ServerName, "name to verify the server certificate against, defaults to the server host")
+	cmd.Flags().StringVar(&opts.AgentID, "agent-id", opts.AgentID, "agent id assigned at enrollment")
+	cmd.Flags().StringVar(&opts.CertFile, "cert", opts.CertFile, "client certificate")
+	cmd.Flags().StringVar(&opts.KeyFile, "key", opts.KeyFile, "client private key")
+	cmd.Flags().StringVar(&opts.ServerCAFile, "server-ca", opts.ServerCAFile, "ca bundle to verify the server with")
+	cmd.Flags().DurationVar(&opts.HeartbeatInterval, "heartbeat-interval", opts.HeartbeatInterval, "interval between heartbeats")
+	cmd.Flags().DurationVar(&opts.ReloadInterval, "reload-interval", opts.ReloadInterval, "how often the certificate, key and ca files are checked for rotations")
+	cmd.Flags().Float64Var(&opts.RenewFraction, "renew-fraction", opts.RenewFraction, "fraction of the certificate lifetime after which it is renewed")
+	cmd.Flags().StringVar(&opts.MetricsAddress, "metrics-address", opts.MetricsAddress, "address to serve prometheus metrics on, disabled when empty")
+	cmd.Flags().StringSliceVar(&opts.WatchPaths, "watch", opts.WatchPaths, "certificate files or globs (PEM, DER or PKCS#12) to report as the host inventory")
+	cmd.Flags().DurationVar(&opts.InventoryInterval, "inventory-interval", opts.InventoryInterval, "interval between inventory reports")
+	cmd.Flags().StringVar(&pkcs12PasswordFile, "pkcs12-password-file", pkcs12PasswordFile, "file with the password of watched PKCS#12 bundles, the password can also be set in "+pkcs12PasswordEnv)
+	cmd.Flags().StringSliceVar(&opts.InstallDirs, "install-dir", opts.InstallDirs, "directories issue certificate tasks may install keys and certificates in, tasks are rejected when none is set")
 	cmd.AddCommand(agentInit())
 
 	return cmd
 }
+
+// pkcs12Password reads the password of watched PKCS#12 bundles from file, or from the
+// environment when no file is set. A trailing newline in the file is ignored.
+func pkcs12Password(file string) (string, error) {
+	password, inEnv := os.LookupEnv(pkcs12PasswordEnv)
+	if file == "" {
+		return password, nil
+	}
+	if inEnv {
+		return "", fmt.Errorf("both --pkcs12-password-file and %s are set", pkcs12PasswordEnv)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
@@ -1,16 +1,40 @@
 package agent
 
 import (
-	"fmt"
-
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/salzr/acert/agent"
+	"github.com/salzr/acert/keytype"
 )
 
 func agentInit() *cobra.Command {
-	return &cobra.Command{
-		Use: "init",
+	opts := agent.EnrollOptions{
+		KeyType:  keytype.ECDSA,
+		StateDir: agent.DefaultStateDir,
+	}
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Enrolls the agent with a join token and writes its config and key material",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("init")
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			cfg, err := agent.Enroll(cmd.Context(), opts)
+			if err != nil {
+				log.Fatal("failed to initialize agent", zap.Error(err))
+			}
+			log.Info("agent initialized", zap.String("agentId", cfg.AgentID),
+				zap.String("config", agent.ConfigPath(opts.StateDir)))
 		},
 	}
+	cmd.Flags().StringVar(&opts.Server, "server", opts.Server, "server address to enroll with (host:port)")
+	cmd.Flags().StringVar(&opts.ServerName, "server-name", opts.ServerName, "name to verify the server certificate against, defaults to the server host")
+	cmd.Flags().StringVar(&opts.ServerCAFile, "server-ca", opts.ServerCAFile, "ca bundle to verify the server with during enrollment")
+	cmd.Flags().StringVar(&opts.Token, "token", opts.Token, "join token created with `acert server create-token`")
+	cmd.Flags().StringVar(&opts.KeyType, "key-type", opts.KeyType, "private key type, one of rsa or ecdsa")
+	cmd.Flags().StringVar(&opts.Description, "description", opts.Description, "description of the agent")
+	cmd.Flags().StringVar(&opts.StateDir, "state-dir", opts.StateDir, "directory to write the agent config and key material to")
+	cmd.MarkFlagRequired("server")
+	cmd.MarkFlagRequired("server-ca")
+	cmd.MarkFlagRequired("token")
+	return cmd
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
@@ -25,6 +26,7 @@ import (
 type options struct {
 	acertNamespace          string
 	acertCertificateInstall bool
+	acertCRDInstall         bool
 
 	certManagerInstall     bool
 	certManagerReleaseName string
@@ -47,6 +49,7 @@ func defaultOptions() options {
 
 		acertNamespace:          "acert-system",
 		acertCertificateInstall: true,
+		acertCRDInstall:         true,
 
 		configFlags: configFlags,
 	}
@@ -70,7 +73,7 @@ func Command() *cobra.Command {
 				logger.Fatal("Failed to create rest config", zap.Error(err))
 			}
 
-			k8sClient, err := k8s.NewClient(ctx, cfg, certmanagerv1.SchemeBuilder)
+			k8sClient, err := k8s.NewClient(ctx, cfg, certmanagerv1.SchemeBuilder, apiextensionsv1.SchemeBuilder)
 			if err != nil {
 				logger.Fatal("Failed to create k8s client", zap.Error(err))
 			}
@@ -94,6 +97,13 @@ func Command() *cobra.Command {
 				logger.Info("Chart installed successfully", zap.String("release", rel.Name))
 			}
 
+			if opts.acertCRDInstall {
+				if err := installCRDs(ctx, k8sClient); err != nil {
+					logger.Fatal("Failed to install acert CRDs", zap.Error(err))
+				}
+				logger.Info("CRDs installed successfully")
+			}
+
 			if opts.acertCertificateInstall {
 				ns := &corev1.Namespace{
 					ObjectMeta: metav1.ObjectMeta{
@@ -230,6 +240,7 @@ func Command() *cobra.Command {
 						Subject:    fillOutPrompt(&certmanagerv1.X509Subject{}).(*certmanagerv1.X509Subject),
 						Usages: []certmanagerv1.KeyUsage{
 							certmanagerv1.UsageCertSign,
+							certmanagerv1.UsageCRLSign,
 						},
 						PrivateKey: &certmanagerv1.CertificatePrivateKey{
 							Algorithm: certmanagerv1.RSAKeyAlgorithm,
@@ -256,6 +267,9 @@ func Command() *cobra.Command {
 	cmd.PersistentFlags().StringVar(&opts.certManagerNamespace, "cert-manager-namespace",
 		opts.certManagerNamespace, "cert-manager chart namespace")
 
+	cmd.PersistentFlags().BoolVar(&opts.acertCRDInstall, "install-crds",
+		opts.acertCRDInstall, "install the acert CRDs used by the kubernetes storage of the server")
+
 	opts.configFlags.AddFlags(cmd.PersistentFlags())
 
 	return cmd
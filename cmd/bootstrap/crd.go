@@ -0,0 +1,39 @@
+package bootstrap
+
+import (
+	"context"
+	"fmt"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/salzr/acert/config/crd"
+)
+
+// installCRDs creates the CRDs of the acert resources, or updates them to the embedded
+// manifests when they already exist.
+func installCRDs(ctx context.Context, k8sClient client.Client) error {
+	crds, err := crd.CustomResourceDefinitions()
+	if err != nil {
+		return err
+	}
+	for _, want := range crds {
+		existing := &apiextensionsv1.CustomResourceDefinition{}
+		err := k8sClient.Get(ctx, client.ObjectKey{Name: want.Name}, existing)
+		switch {
+		case errors.IsNotFound(err):
+			if err := k8sClient.Create(ctx, want); err != nil {
+				return fmt.Errorf("failed to create crd %s: %w", want.Name, err)
+			}
+		case err != nil:
+			return fmt.Errorf("failed to get crd %s: %w", want.Name, err)
+		default:
+			existing.Spec = want.Spec
+			if err := k8sClient.Update(ctx, existing); err != nil {
+				return fmt.Errorf("failed to update crd %s: %w", want.Name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package bootstrap
+
+import (
+	"context"
+	"testing"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestInstallCRDs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	ctx := context.Background()
+
+	// Installing again updates the existing CRDs.
+	for range 2 {
+		if err := installCRDs(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := &apiextensionsv1.CustomResourceDefinitionList{}
+	if err := c.List(ctx, list); err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]bool{}
+	for _, crd := range list.Items {
+		kinds[crd.Spec.Names.Kind] = true
+	}
+	for _, kind := range []string{"Agent", "InventoryCertificate", "JoinToken", "AgentTask", "AuditEvent"} {
+		if !kinds[kind] {
+			t.Errorf("expected the %s CRD to be installed, got %v", kind, kinds)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvPrefix is prepended to the upper cased flag name to form its environment variable,
+// e.g. --server-ca is read from ACERT_SERVER_CA.
+const EnvPrefix = "ACERT_"
+
+// EnvName returns the environment variable for the flag name.
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// Bind fills every flag that was not set on the command line, first from its environment
+// variable and then from the YAML config file named by configFlag, whose keys are flag names.
+// A missing config file is only an error when its path was set explicitly.
+func Bind(flags *pflag.FlagSet, configFlag string) error {
+	if err := setFromEnv(flags, flags.Lookup(configFlag)); err != nil {
+		return err
+	}
+	path := flags.Lookup(configFlag).Value.String()
+	values, err := read(path)
+	if errors.Is(err, fs.ErrNotExist) && !flags.Changed(configFlag) {
+		values, err = map[string]any{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	for key := range values {
+		if flags.Lookup(key) == nil || key == configFlag {
+			return fmt.Errorf("unknown key %q in config %s", key, path)
+		}
+	}
+
+	var errs []error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == configFlag {
+			return
+		}
+		if _, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			errs = append(errs, setFromEnv(flags, f))
+			return
+		}
+		if v, ok := values[f.Name]; ok {
+			if err := flags.Set(f.Name, toString(v)); err != nil {
+				errs = append(errs, fmt.Errorf("invalid value for %q in config %s: %w", f.Name, path, err))
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func setFromEnv(flags *pflag.FlagSet, f *pflag.Flag) error {
+	if f.Changed {
+		return nil
+	}
+	v, ok := os.LookupEnv(EnvName(f.Name))
+	if !ok {
+		return nil
+	}
+	if err := flags.Set(f.Name, v); err != nil {
+		return fmt.Errorf("invalid value for %s: %w", EnvName(f.Name), err)
+	}
+	return nil
+}
+
+func read(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	values := map[string]any{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func toString(v any) string {
+	switch v := v.(type) {
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, toString(item))
+		}
+		return strings.Join(items, ",")
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
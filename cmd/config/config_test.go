@@ -0,0 +1,75 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+func newFlags(t *testing.T, config string) (*pflag.FlagSet, *string, *string, *time.Duration) {
+	t.Helper()
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("config", config, "")
+	server := flags.String("server", "default", "")
+	cert := flags.String("cert", "default.crt", "")
+	interval := flags.Duration("heartbeat-interval", time.Second, "")
+	return flags, server, cert, interval
+}
+
+func TestBindPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("server: file\ncert: file.crt\nheartbeat-interval: 10s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	flags, server, cert, interval := newFlags(t, path)
+	if err := flags.Parse([]string{"--server", "flag"}); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ACERT_CERT", "env.crt")
+
+	if err := Bind(flags, "config"); err != nil {
+		t.Fatal(err)
+	}
+	if *server != "flag" {
+		t.Errorf("expected flag to win, got %s", *server)
+	}
+	if *cert != "env.crt" {
+		t.Errorf("expected environment to win over config, got %s", *cert)
+	}
+	if *interval != 10*time.Second {
+		t.Errorf("expected config value, got %s", *interval)
+	}
+}
+
+func TestBindMissingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+	flags, server, _, _ := newFlags(t, path)
+	if err := Bind(flags, "config"); err != nil {
+		t.Fatalf("default config should be optional: %v", err)
+	}
+	if *server != "default" {
+		t.Errorf("expected default, got %s", *server)
+	}
+
+	flags, _, _, _ = newFlags(t, "")
+	if err := flags.Parse([]string{"--config", path}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Bind(flags, "config"); err == nil {
+		t.Error("expected error for explicit missing config")
+	}
+}
+
+func TestBindUnknownKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("sever: typo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	flags, _, _, _ := newFlags(t, path)
+	if err := Bind(flags, "config"); err == nil {
+		t.Error("expected error for unknown key")
+	}
+}
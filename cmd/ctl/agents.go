@@ -0,0 +1,101 @@
+package ctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+)
+
+func agentsCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "agents",
+		Short: "Lists, shows and revokes enrolled agents",
+	}
+	cmd.AddCommand(listAgents(opts))
+	cmd.AddCommand(getAgent(opts))
+	cmd.AddCommand(revokeAgent(opts))
+	return cmd
+}
+
+func listAgents(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "Lists the enrolled agents and their presence",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			res, err := client.ListAgents(ctx, &mpb.ListAgentsRequest{})
+			if err != nil {
+				log.Fatal("failed to list agents", zap.Error(err))
+			}
+			if err := opts.print(res, func(w *tabwriter.Writer) { agentTable(w, res.Agents...) }); err != nil {
+				log.Fatal("failed to print agents", zap.Error(err))
+			}
+		},
+	}
+}
+
+func getAgent(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <agent-id>",
+		Short: "Shows an agent and its connection",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			id, err := parseID(args[0])
+			if err != nil {
+				log.Fatal("invalid agent id", zap.Error(err))
+			}
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			a, err := client.GetAgent(ctx, &mpb.GetAgentRequest{AgentId: id})
+			if err != nil {
+				log.Fatal("failed to get agent", zap.Int64("agentId", id), zap.Error(err))
+			}
+			if err := opts.print(a, func(w *tabwriter.Writer) { agentTable(w, a) }); err != nil {
+				log.Fatal("failed to print agent", zap.Error(err))
+			}
+		},
+	}
+}
+
+func revokeAgent(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke <agent-id>",
+		Short: "Revokes an agent and its certificates and closes its stream",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			id, err := parseID(args[0])
+			if err != nil {
+				log.Fatal("invalid agent id", zap.Error(err))
+			}
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			a, err := client.RevokeAgent(ctx, &mpb.RevokeAgentRequest{AgentId: id})
+			if err != nil {
+				log.Fatal("failed to revoke agent", zap.Int64("agentId", id), zap.Error(err))
+			}
+			if err := opts.print(a, func(w *tabwriter.Writer) { agentTable(w, a) }); err != nil {
+				log.Fatal("failed to print agent", zap.Error(err))
+			}
+		},
+	}
+}
+
+func agentTable(w *tabwriter.Writer, agents ...*mpb.Agent) {
+	fmt.Fprintln(w, "ID\tHOSTNAME\tIP\tPRESENCE\tVERSION\tLAST SEEN\tREVOKED")
+	for _, a := range agents {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", a.Id, a.Hostname, a.Ip, a.Presence, a.Version,
+			formatUnix(a.LastSeen), formatUnix(a.RevokedAt))
+	}
+}
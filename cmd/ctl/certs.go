@@ -0,0 +1,69 @@
+package ctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+)
+
+func certsCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "Lists the certificates the agents report in their inventories",
+	}
+	cmd.AddCommand(listCerts(opts))
+	return cmd
+}
+
+func listCerts(opts *options) *cobra.Command {
+	req := &mpb.ListAllCertificatesRequest{}
+	var within durationValue
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Lists the certificates of every agent inventory, soonest to expire first",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			req.ExpiringWithinSeconds = int64(time.Duration(within).Seconds())
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			res, err := client.ListAllCertificates(ctx, req)
+			if err != nil {
+				log.Fatal("failed to list certificates", zap.Error(err))
+			}
+			err = opts.print(res, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "AGENT\tPATH\tSUBJECT\tSERIAL\tNOT AFTER\tEXPIRES IN")
+				now := time.Now()
+				for _, c := range res.Certificates {
+					fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", c.AgentId, c.Certificate.Path, c.Certificate.Subject,
+						c.Certificate.Serial, formatUnix(c.Certificate.NotAfter), expiresIn(time.Unix(c.Certificate.NotAfter, 0), now))
+				}
+			})
+			if err != nil {
+				log.Fatal("failed to print certificates", zap.Error(err))
+			}
+		},
+	}
+	cmd.Flags().Int64Var(&req.AgentId, "agent-id", 0, "only list the certificates of this agent")
+	cmd.Flags().Var(&within, "expiring-within", "only list the certificates expiring within this duration, e.g. 30d or 12h")
+	return cmd
+}
+
+// expiresIn is the time left until notAfter in whole days, or hours within the last day.
+func expiresIn(notAfter, now time.Time) string {
+	left := notAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return "expired"
+	case left < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(left.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(left.Hours()/24))
+	}
+}
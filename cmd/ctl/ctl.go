@@ -0,0 +1,192 @@
+package ctl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"sigs.k8s.io/yaml"
+
+	"github.com/salzr/acert/cmd/config"
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+)
+
+// Output formats.
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+var outputs = []string{OutputTable, OutputJSON, OutputYAML}
+
+// options are the connection to the server and the output format shared by every command.
+type options struct {
+	server       string
+	serverName   string
+	certFile     string
+	keyFile      string
+	serverCAFile string
+	timeout      time.Duration
+	output       string
+}
+
+func Command() *cobra.Command {
+	opts := &options{timeout: 30 * time.Second, output: OutputTable}
+	configPath := ""
+	if dir, err := os.UserConfigDir(); err == nil {
+		configPath = filepath.Join(dir, "acert", "ctl.yaml")
+	}
+	cmd := &cobra.Command{
+		Use:   "ctl",
+		Short: "Manages a running server through its management api with an operator certificate",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			if err := config.Bind(cmd.Flags(), "config"); err != nil {
+				log.Fatal("failed to load config", zap.Error(err))
+			}
+			if !slices.Contains(outputs, opts.output) {
+				log.Fatal("invalid output", zap.String("output", opts.output), zap.Strings("expected", outputs))
+			}
+		},
+	}
+	cmd.PersistentFlags().StringVar(&configPath, "config", configPath, "config file, flags and ACERT_* environment variables take precedence")
+	cmd.PersistentFlags().StringVar(&opts.server, "server", opts.server, "grpc address of the server (host:port)")
+	cmd.PersistentFlags().StringVar(&opts.serverName, "server-name", opts.serverName, "name to verify the server certificate against, defaults to the server host")
+	cmd.PersistentFlags().StringVar(&opts.certFile, "cert", opts.certFile, "operator client certificate")
+	cmd.PersistentFlags().StringVar(&opts.keyFile, "key", opts.keyFile, "operator client private key")
+	cmd.PersistentFlags().StringVar(&opts.serverCAFile, "server-ca", opts.serverCAFile, "ca bundle to verify the server with")
+	cmd.PersistentFlags().DurationVar(&opts.timeout, "timeout", opts.timeout, "how long to wait for the server to answer")
+	cmd.PersistentFlags().StringVarP(&opts.output, "output", "o", opts.output, "output format, table, json or yaml")
+
+	cmd.AddCommand(agentsCommand(opts))
+	cmd.AddCommand(certsCommand(opts))
+	cmd.AddCommand(tasksCommand(opts))
+	cmd.AddCommand(tokensCommand(opts))
+	return cmd
+}
+
+// connect returns a ManagementService client and a context for a call to it. done closes the
+// connection. Failing to configure the connection is fatal.
+func (o *options) connect(cmd *cobra.Command) (context.Context, mpb.ManagementServiceClient, func()) {
+	log := cmd.Context().Value("logger").(*zap.Logger)
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		log.Fatal("failed to configure tls", zap.Error(err))
+	}
+	conn, err := grpc.NewClient(o.server, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		log.Fatal("failed to connect", zap.String("server", o.server), zap.Error(err))
+	}
+	ctx, cancel := context.WithTimeout(cmd.Context(), o.timeout)
+	return ctx, mpb.NewManagementServiceClient(conn), func() {
+		cancel()
+		conn.Close()
+	}
+}
+
+func (o *options) tlsConfig() (*tls.Config, error) {
+	if o.server == "" {
+		return nil, fmt.Errorf("no server address")
+	}
+	cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load operator certificate: %w", err)
+	}
+	ca, err := os.ReadFile(o.serverCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server ca: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(ca); !ok {
+		return nil, fmt.Errorf("failed to parse %s", o.serverCAFile)
+	}
+	// grpc verifies the server against the host of the address when ServerName is empty.
+	return &tls.Config{
+		ServerName:   o.serverName,
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// print writes msg in the output format, table writes its table form.
+func (o *options) print(msg proto.Message, table func(w *tabwriter.Writer)) error {
+	if o.output == OutputTable {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	}
+	b, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if o.output == OutputYAML {
+		if b, err = yaml.JSONToYAML(b); err != nil {
+			return err
+		}
+	} else {
+		b = append(b, '\n')
+	}
+	_, err = os.Stdout.Write(b)
+	return err
+}
+
+// formatUnix formats unix seconds for tables, zero is unset.
+func formatUnix(sec int64) string {
+	if sec == 0 {
+		return "-"
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339)
+}
+
+// parseID parses the numeric id of an agent or token.
+func parseID(s string) (int64, error) {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	return id, nil
+}
+
+// durationValue is a duration flag that also accepts a number of days, e.g. 30d.
+type durationValue time.Duration
+
+func (d *durationValue) Set(s string) error {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number of days %q", s)
+		}
+		*d = durationValue(time.Duration(n) * 24 * time.Hour)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) String() string {
+	return time.Duration(*d).String()
+}
+
+func (d *durationValue) Type() string {
+	return "duration"
+}
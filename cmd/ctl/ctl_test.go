@@ -0,0 +1,46 @@
+package ctl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationValue(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{in: "30d", want: 30 * 24 * time.Hour},
+		{in: "12h", want: 12 * time.Hour},
+		{in: "1h30m", want: 90 * time.Minute},
+		{in: "xd", err: true},
+		{in: "30", err: true},
+	} {
+		var d durationValue
+		err := d.Set(tc.in)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %v, got %v", tc.in, tc.err, err)
+			continue
+		}
+		if time.Duration(d) != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.in, tc.want, time.Duration(d))
+		}
+	}
+}
+
+func TestExpiresIn(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		notAfter time.Time
+		want     string
+	}{
+		{notAfter: now.Add(-time.Minute), want: "expired"},
+		{notAfter: now.Add(5*time.Hour + time.Minute), want: "5h"},
+		{notAfter: now.Add(30*24*time.Hour + time.Minute), want: "30d"},
+	} {
+		if got := expiresIn(tc.notAfter, now); got != tc.want {
+			t.Errorf("expected %s for %s, got %s", tc.want, tc.notAfter.Sub(now), got)
+		}
+	}
+}
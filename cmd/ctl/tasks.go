@@ -0,0 +1,136 @@
+package ctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	servercmd "github.com/salzr/acert/cmd/server"
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+)
+
+func tasksCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tasks",
+		Short: "Creates, lists, shows and cancels agent tasks",
+	}
+	cmd.AddCommand(createTask(opts))
+	cmd.AddCommand(listTasks(opts))
+	cmd.AddCommand(getTask(opts))
+	cmd.AddCommand(cancelTask(opts))
+	return cmd
+}
+
+func createTask(opts *options) *cobra.Command {
+	spec := &pb.IssueCertificate{}
+	var complete func() error
+	cmd := &cobra.Command{
+		Use:   "create <agent-id>",
+		Short: "Queues a task for an agent to generate a key and install a certificate issued for it",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			id, err := parseID(args[0])
+			if err != nil {
+				log.Fatal("invalid agent id", zap.Error(err))
+			}
+			if err := complete(); err != nil {
+				log.Fatal("invalid task", zap.Error(err))
+			}
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			t, err := client.CreateTask(ctx, &mpb.CreateTaskRequest{
+				AgentId: id,
+				Task:    &mpb.CreateTaskRequest_IssueCertificate{IssueCertificate: spec},
+			})
+			if err != nil {
+				log.Fatal("failed to create task", zap.Int64("agentId", id), zap.Error(err))
+			}
+			if err := opts.print(t, func(w *tabwriter.Writer) { taskTable(w, t) }); err != nil {
+				log.Fatal("failed to print task", zap.Error(err))
+			}
+		},
+	}
+	complete = servercmd.IssueCertificateFlags(cmd, spec)
+	return cmd
+}
+
+func listTasks(opts *options) *cobra.Command {
+	req := &mpb.ListTasksRequest{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Lists agent tasks and their status",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			res, err := client.ListTasks(ctx, req)
+			if err != nil {
+				log.Fatal("failed to list tasks", zap.Error(err))
+			}
+			if err := opts.print(res, func(w *tabwriter.Writer) { taskTable(w, res.Tasks...) }); err != nil {
+				log.Fatal("failed to print tasks", zap.Error(err))
+			}
+		},
+	}
+	cmd.Flags().Int64Var(&req.AgentId, "agent-id", 0, "only list the tasks of this agent")
+	cmd.Flags().StringSliceVar(&req.Statuses, "status", nil, "only list tasks in these statuses")
+	cmd.Flags().Int32Var(&req.Limit, "limit", 0, "maximum number of tasks to list, 0 lists all")
+	return cmd
+}
+
+func getTask(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get <task-id>",
+		Short: "Shows a task, its spec and artifacts are in the json and yaml output",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			t, err := client.GetTask(ctx, &mpb.GetTaskRequest{TaskId: args[0]})
+			if err != nil {
+				log.Fatal("failed to get task", zap.String("taskId", args[0]), zap.Error(err))
+			}
+			if err := opts.print(t, func(w *tabwriter.Writer) { taskTable(w, t) }); err != nil {
+				log.Fatal("failed to print task", zap.Error(err))
+			}
+		},
+	}
+}
+
+func cancelTask(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "cancel <task-id>",
+		Short: "Cancels a task that has not finished, the result of an agent already running it is discarded",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			t, err := client.CancelTask(ctx, &mpb.CancelTaskRequest{TaskId: args[0]})
+			if err != nil {
+				log.Fatal("failed to cancel task", zap.String("taskId", args[0]), zap.Error(err))
+			}
+			if err := opts.print(t, func(w *tabwriter.Writer) { taskTable(w, t) }); err != nil {
+				log.Fatal("failed to print task", zap.Error(err))
+			}
+		},
+	}
+}
+
+func taskTable(w *tabwriter.Writer, tasks ...*mpb.Task) {
+	fmt.Fprintln(w, "ID\tAGENT\tTYPE\tSTATUS\tATTEMPTS\tUPDATED\tMESSAGE")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n", t.Id, t.AgentId, t.Type, t.Status, t.Attempts,
+			formatUnix(t.UpdatedAt), t.Message)
+	}
+}
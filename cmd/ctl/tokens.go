@@ -0,0 +1,105 @@
+package ctl
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+)
+
+func tokensCommand(opts *options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tokens",
+		Short: "Creates, lists and revokes join tokens",
+	}
+	cmd.AddCommand(createToken(opts))
+	cmd.AddCommand(listTokens(opts))
+	cmd.AddCommand(revokeToken(opts))
+	return cmd
+}
+
+func createToken(opts *options) *cobra.Command {
+	var ttl durationValue
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Creates a single use join token for enrolling an agent, it cannot be shown again",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			res, err := client.CreateToken(ctx, &mpb.CreateTokenRequest{TtlSeconds: int64(time.Duration(ttl).Seconds())})
+			if err != nil {
+				log.Fatal("failed to create token", zap.Error(err))
+			}
+			err = opts.print(res, func(w *tabwriter.Writer) {
+				fmt.Fprintln(w, "ID\tEXPIRES\tTOKEN")
+				fmt.Fprintf(w, "%d\t%s\t%s\n", res.TicketId, formatUnix(res.ExpiresAt), res.Token)
+			})
+			if err != nil {
+				log.Fatal("failed to print token", zap.Error(err))
+			}
+		},
+	}
+	cmd.Flags().Var(&ttl, "ttl", "how long the token can be used to enroll an agent, e.g. 2h or 7d, defaults to the server default")
+	return cmd
+}
+
+func listTokens(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "Lists the join tokens and whether they can still be used",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			res, err := client.ListTokens(ctx, &mpb.ListTokensRequest{})
+			if err != nil {
+				log.Fatal("failed to list tokens", zap.Error(err))
+			}
+			if err := opts.print(res, func(w *tabwriter.Writer) { tokenTable(w, res.Tokens...) }); err != nil {
+				log.Fatal("failed to print tokens", zap.Error(err))
+			}
+		},
+	}
+}
+
+func revokeToken(opts *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke <token-id>",
+		Short: "Revokes an unused join token so it can no longer enroll an agent",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			log := cmd.Context().Value("logger").(*zap.Logger)
+			id, err := parseID(args[0])
+			if err != nil {
+				log.Fatal("invalid token id", zap.Error(err))
+			}
+			ctx, client, done := opts.connect(cmd)
+			defer done()
+
+			t, err := client.RevokeToken(ctx, &mpb.RevokeTokenRequest{TicketId: id})
+			if err != nil {
+				log.Fatal("failed to revoke token", zap.Int64("ticketId", id), zap.Error(err))
+			}
+			if err := opts.print(t, func(w *tabwriter.Writer) { tokenTable(w, t) }); err != nil {
+				log.Fatal("failed to print token", zap.Error(err))
+			}
+		},
+	}
+}
+
+func tokenTable(w *tabwriter.Writer, tokens ...*mpb.Token) {
+	fmt.Fprintln(w, "ID\tSTATE\tCREATED\tEXPIRES\tUSED\tREVOKED")
+	for _, t := range tokens {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", t.TicketId, t.State, formatUnix(t.CreatedAt), formatUnix(t.ExpiresAt),
+			formatUnix(t.UsedAt), formatUnix(t.RevokedAt))
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/salzr/acert/cmd/agent"
+	"github.com/salzr/acert/cmd/ctl"
 	"github.com/salzr/acert/cmd/server"
+	"github.com/salzr/acert/version"
 )
 
 var rootCmd = &cobra.Command{
@@ -37,8 +39,10 @@ func init() {
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, "logger", logger)
 
+	rootCmd.Version = version.Get()
 	rootCmd.SetContext(ctx)
 	rootCmd.AddCommand(bootstrap.Command())
 	rootCmd.AddCommand(agent.Command())
 	rootCmd.AddCommand(server.Command())
+	rootCmd.AddCommand(ctl.Command())
 }
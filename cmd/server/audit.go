@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/salzr/acert/server"
+	"github.com/salzr/acert/store"
+)
+
+// auditPageSize is how many events are read at a time when walking the whole audit log.
+const auditPageSize = 500
+
+var auditGroup = &cobra.Group{
+	Title: "Group of commands for the audit log",
+	ID:    "audit",
+}
+
+func audit(opts *server.Options) *cobra.Command {
+	cmd := &cobra.Command{
+		GroupID: auditGroup.ID,
+		Use:     "audit",
+		Short:   "Queries, verifies and exports the hash chained audit log",
+	}
+	cmd.AddCommand(listAuditEvents(opts))
+	cmd.AddCommand(verifyAuditLog(opts))
+	cmd.AddCommand(exportAuditLog(opts))
+	return cmd
+}
+
+func listAuditEvents(opts *server.Options) *cobra.Command {
+	var filter store.AuditFilter
+	var output string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Lists audit events, oldest first",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			log := ctx.Value("logger").(*zap.Logger)
+
+			if output != "table" && output != "jsonl" {
+				log.Fatal("invalid output", zap.String("output", output), zap.Strings("expected", []string{"table", "jsonl"}))
+			}
+
+			st, err := server.OpenStore(ctx, *opts)
+			if err != nil {
+				log.Fatal("failed to open store", zap.Error(err))
+			}
+			defer st.Close()
+
+			events, err := st.ListAuditEvents(ctx, filter)
+			if err != nil {
+				log.Fatal("failed to list audit events", zap.Error(err))
+			}
+			if output == "jsonl" {
+				enc := json.NewEncoder(os.Stdout)
+				for _, e := range events {
+					enc.Encode(e)
+				}
+				return
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tTIME\tACTOR\tACTION\tTARGET\tRESULT\tDETAIL")
+			for _, e := range events {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", e.ID, e.Time.Format(time.RFC3339), e.Actor, e.Action,
+					e.Target, e.Result, e.Detail)
+			}
+			w.Flush()
+		},
+	}
+	cmd.Flags().TimeVar(&filter.Since, "since", time.Time{}, []string{time.RFC3339, time.DateOnly}, "only list events at or after this time")
+	cmd.Flags().TimeVar(&filter.Until, "until", time.Time{}, []string{time.RFC3339, time.DateOnly}, "only list events before this time")
+	cmd.Flags().StringVar(&filter.Actor, "actor", "", "only list the events of this actor, e.g. agent:42 or cli:root")
+	cmd.Flags().StringVar(&filter.Action, "action", "", "only list events of this action, e.g. agent.revoke")
+	cmd.Flags().Int64Var(&filter.AgentID, "agent-id", 0, "only list the events of this agent as actor")
+	cmd.Flags().IntVar(&filter.Limit, "limit", 0, "maximum number of events to list, 0 lists all")
+	cmd.Flags().StringVarP(&output, "output", "o", "table", "output format, table or jsonl")
+	return cmd
+}
+
+func verifyAuditLog(opts *server.Options) *cobra.Command {
+	var head string
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verifies the hash chain of the whole audit log",
+		Long: `Verifies the hash chain of the whole audit log and prints its head.
+
+The chain alone does not detect a log rewritten from the first event. Record the printed head
+outside the store and pass it with --head on the next verification, the log must still
+contain that event unchanged.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			log := ctx.Value("logger").(*zap.Logger)
+
+			var chain store.AuditChain
+			if head != "" {
+				anchor, err := store.ParseAuditHead(head)
+				if err != nil {
+					log.Fatal("invalid head", zap.Error(err))
+				}
+				chain.Anchor = anchor
+			}
+
+			st, err := server.OpenStore(ctx, *opts)
+			if err != nil {
+				log.Fatal("failed to open store", zap.Error(err))
+			}
+			defer st.Close()
+
+			var n int
+			err = walkAuditLog(ctx, st, func(e *store.AuditEvent) error {
+				if err := chain.Verify(e); err != nil {
+					return err
+				}
+				n++
+				return nil
+			})
+			if err == nil {
+				err = chain.Complete()
+			}
+			if err != nil {
+				log.Fatal("audit log verification failed", zap.Int("verified", n), zap.Error(err))
+			}
+			fmt.Printf("%d audit events verified, head %s\n", n, chain.Head())
+		},
+	}
+	cmd.Flags().StringVar(&head, "head", "", "trusted head printed by an earlier verification, as <id>:<hash>")
+	return cmd
+}
+
+func exportAuditLog(opts *server.Options) *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Exports the whole audit log as JSON lines",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			log := ctx.Value("logger").(*zap.Logger)
+
+			st, err := server.OpenStore(ctx, *opts)
+			if err != nil {
+				log.Fatal("failed to open store", zap.Error(err))
+			}
+			defer st.Close()
+
+			var w io.Writer = os.Stdout
+			if file != "" {
+				f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+				if err != nil {
+					log.Fatal("failed to create export file", zap.Error(err))
+				}
+				defer f.Close()
+				w = f
+			}
+			enc := json.NewEncoder(w)
+			if err := walkAuditLog(ctx, st, func(e *store.AuditEvent) error { return enc.Encode(e) }); err != nil {
+				log.Fatal("failed to export audit log", zap.Error(err))
+			}
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "file to write the events to instead of stdout, it must not exist")
+	return cmd
+}
+
+// walkAuditLog calls fn with every audit event in order, reading the log a page at a time.
+func walkAuditLog(ctx context.Context, st store.Repository, fn func(*store.AuditEvent) error) error {
+	var after int64
+	for {
+		events, err := st.ListAuditEvents(ctx, store.AuditFilter{AfterID: after, Limit: auditPageSize})
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if err := fn(e); err != nil {
+				return err
+			}
+			after = e.ID
+		}
+		if len(events) < auditPageSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/server"
+	"github.com/salzr/acert/store"
+)
+
+var taskGroup = &cobra.Group{
+	Title: "Group of commands for agent tasks",
+	ID:    "task",
+}
+
+func issueCertificate(opts *server.Options) *cobra.Command {
+	spec := &pb.IssueCertificate{}
+	var complete func() error
+	cmd := &cobra.Command{
+		GroupID: taskGroup.ID,
+		Use:     "issue-certificate <agent-id>",
+		Short:   "Queues a task for an agent to generate a key and install a certificate issued for it",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			log := ctx.Value("logger").(*zap.Logger)
+
+			agentID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				log.Fatal("invalid agent id", zap.String("agentId", args[0]))
+			}
+			if err := complete(); err != nil {
+				log.Fatal("invalid task", zap.Error(err))
+			}
+
+			st, err := server.OpenStore(ctx, *opts)
+			if err != nil {
+				log.Fatal("failed to open store", zap.Error(err))
+			}
+			defer st.Close()
+
+			task, err := server.CreateIssueCertificateTask(ctx, st, agentID, spec)
+			if err != nil {
+				log.Fatal("failed to create task", zap.Error(err))
+			}
+			if err := server.Audit(ctx, st, server.CLIActor(), server.AuditTaskCreate, server.TaskTarget(task.ID),
+				server.TaskDetail(agentID, task.Type), nil); err != nil {
+				log.Error("failed to record audit event", zap.Error(err))
+			}
+			log.Info("task created", zap.Int64("agentId", agentID))
+			fmt.Println(task.ID)
+		},
+	}
+	complete = IssueCertificateFlags(cmd, spec)
+	return cmd
+}
+
+// IssueCertificateFlags adds the flags of an IssueCertificate task to cmd. The returned function
+// sets the fields of spec that are parsed from the flags, it must be called once they are.
+func IssueCertificateFlags(cmd *cobra.Command, spec *pb.IssueCertificate) func() error {
+	spec.Subject = &pb.Subject{}
+	var duration time.Duration
+	var fileMode string
+	cmd.Flags().StringVar(&spec.Subject.CommonName, "common-name", "", "subject common name")
+	cmd.Flags().StringSliceVar(&spec.Subject.Organizations, "organization", nil, "subject organizations")
+	cmd.Flags().StringSliceVar(&spec.Subject.OrganizationalUnits, "organizational-unit", nil, "subject organizational units")
+	cmd.Flags().StringSliceVar(&spec.Subject.Countries, "country", nil, "subject countries")
+	cmd.Flags().StringSliceVar(&spec.Subject.Localities, "locality", nil, "subject localities")
+	cmd.Flags().StringSliceVar(&spec.Subject.Provinces, "province", nil, "subject provinces")
+	cmd.Flags().StringSliceVar(&spec.DnsNames, "dns-name", nil, "dns subject alternative names")
+	cmd.Flags().StringSliceVar(&spec.IpAddresses, "ip-address", nil, "ip subject alternative names")
+	cmd.Flags().StringSliceVar(&spec.Uris, "uri", nil, "uri subject alternative names")
+	cmd.Flags().StringSliceVar(&spec.EmailAddresses, "email-address", nil, "email subject alternative names")
+	cmd.Flags().StringVar(&spec.KeyType, "key-type", "ecdsa", "private key type, rsa or ecdsa")
+	cmd.Flags().StringSliceVar(&spec.Usages, "usage", nil, "key usages, e.g. \"digital signature\" or \"server auth\", defaults to digital signature and key encipherment")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "certificate lifetime, defaults to 90 days")
+	cmd.Flags().StringVar(&spec.CertPath, "cert-path", "", "absolute path the agent installs the certificate chain to")
+	cmd.Flags().StringVar(&spec.KeyPath, "key-path", "", "absolute path the agent installs the private key to")
+	cmd.Flags().StringVar(&spec.CaPath, "ca-path", "", "absolute path the agent installs the issuer ca to, not installed when empty")
+	cmd.Flags().StringVar(&fileMode, "file-mode", "0600", "octal permissions of the installed files")
+	cmd.Flags().StringVar(&spec.Owner, "owner", "", "owner of the installed files as user or user:group")
+	cmd.MarkFlagRequired("cert-path")
+	cmd.MarkFlagRequired("key-path")
+	return func() error {
+		mode, err := strconv.ParseUint(fileMode, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid file mode %q", fileMode)
+		}
+		spec.FileMode = uint32(mode)
+		spec.DurationSeconds = int64(duration.Seconds())
+		return nil
+	}
+}
+
+func listTasks(opts *server.Options) *cobra.Command {
+	var filter store.TaskFilter
+	cmd := &cobra.Command{
+		GroupID: taskGroup.ID,
+		Use:     "list-tasks",
+		Short:   "Lists agent tasks and their status",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			log := ctx.Value("logger").(*zap.Logger)
+
+			for _, status := range filter.Statuses {
+				if !slices.Contains(store.TaskStatuses, status) {
+					log.Fatal("invalid status", zap.String("status", status), zap.Strings("expected", store.TaskStatuses))
+				}
+			}
+
+			st, err := server.OpenStore(ctx, *opts)
+			if err != nil {
+				log.Fatal("failed to open store", zap.Error(err))
+			}
+			defer st.Close()
+
+			tasks, err := st.ListTasks(ctx, filter)
+			if err != nil {
+				log.Fatal("failed to list tasks", zap.Error(err))
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tAGENT\tTYPE\tSTATUS\tUPDATED\tMESSAGE")
+			for _, t := range tasks {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", t.ID, t.AgentID, t.Type, t.Status,
+					t.UpdatedAt.Format(time.RFC3339), t.Message)
+			}
+			w.Flush()
+		},
+	}
+	cmd.Flags().Int64Var(&filter.AgentID, "agent-id", 0, "only list the tasks of this agent")
+	cmd.Flags().StringSliceVar(&filter.Statuses, "status", nil, "only list tasks in these statuses")
+	cmd.Flags().IntVar(&filter.Limit, "limit", 0, "maximum number of tasks to list, 0 lists all")
+	return cmd
+}
+
+func getTask(opts *server.Options) *cobra.Command {
+	return &cobra.Command{
+		GroupID: taskGroup.ID,
+		Use:     "get-task <task-id>",
+		Short:   "Prints a task with its spec, status and artifacts",
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			log := ctx.Value("logger").(*zap.Logger)
+
+			st, err := server.OpenStore(ctx, *opts)
+			if err != nil {
+				log.Fatal("failed to open store", zap.Error(err))
+			}
+			defer st.Close()
+
+			t, err := st.GetTask(ctx, args[0])
+			if err != nil {
+				log.Fatal("failed to get task", zap.Error(err))
+			}
+			b, err := yaml.Marshal(map[string]any{
+				"id":           t.ID,
+				"agentId":      t.AgentID,
+				"type":         t.Type,
+				"spec":         json.RawMessage(t.Spec),
+				"status":       t.Status,
+				"message":      t.Message,
+				"errorCode":    t.ErrorCode,
+				"artifacts":    t.Artifacts,
+				"createdAt":    t.CreatedAt,
+				"updatedAt":    t.UpdatedAt,
+				"dispatchedAt": t.DispatchedAt,
+				"finishedAt":   t.FinishedAt,
+			})
+			if err != nil {
+				log.Fatal("failed to encode task", zap.Error(err))
+			}
+			os.Stdout.Write(b)
+		},
+	}
+}
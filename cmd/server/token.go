@@ -4,6 +4,9 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/salzr/acert/server"
 )
 
 var authGroup = &cobra.Group{
@@ -11,12 +14,34 @@ var authGroup = &cobra.Group{
 	ID:    "auth",
 }
 
-func createToken() *cobra.Command {
-	return &cobra.Command{
+func createToken(opts *server.Options) *cobra.Command {
+	ttl := server.DefaultTokenTTL
+	cmd := &cobra.Command{
 		GroupID: authGroup.ID,
 		Use:     "create-token",
+		Short:   "Creates a single use join token for enrolling an agent",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("create")
+			ctx := cmd.Context()
+			log := ctx.Value("logger").(*zap.Logger)
+
+			st, err := server.OpenStore(ctx, *opts)
+			if err != nil {
+				log.Fatal("failed to open store", zap.Error(err))
+			}
+			defer st.Close()
+
+			token, ticket, err := server.CreateToken(ctx, st, ttl)
+			if err != nil {
+				log.Fatal("failed to create token", zap.Error(err))
+			}
+			if err := server.Audit(ctx, st, server.CLIActor(), server.AuditTokenCreate, server.TicketTarget(ticket),
+				server.TicketDetail(ticket), nil); err != nil {
+				log.Error("failed to record audit event", zap.Error(err))
+			}
+			log.Info("token created", zap.Int64("ticketId", ticket.ID), zap.Time("expiresAt", ticket.ExpiresAt))
+			fmt.Println(token)
 		},
 	}
+	cmd.Flags().DurationVar(&ttl, "ttl", ttl, "how long the token can be used to enroll an agent")
+	return cmd
 }
@@ -0,0 +1,38 @@
+// Package crd embeds the CustomResourceDefinitions of the acert.salzr.io resources in api/v1.
+// The manifests are generated from the types and their kubebuilder markers.
+package crd
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+//go:generate controller-gen crd paths=../../api/v1/... output:crd:dir=.
+
+//go:embed *.yaml
+var manifests embed.FS
+
+// CustomResourceDefinitions returns the CRDs of the acert resources.
+func CustomResourceDefinitions() ([]*apiextensionsv1.CustomResourceDefinition, error) {
+	files, err := fs.Glob(manifests, "*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	var crds []*apiextensionsv1.CustomResourceDefinition
+	for _, f := range files {
+		b, err := manifests.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.UnmarshalStrict(b, crd); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", f, err)
+		}
+		crds = append(crds, crd)
+	}
+	return crds, nil
+}
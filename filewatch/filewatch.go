@@ -0,0 +1,45 @@
+package filewatch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+// Watch calls onChange whenever the content of any of the files changes, checking every
+// interval until ctx is done. Polling the content instead of relying on file events also
+// catches the symlink swaps used by Kubernetes volume mounts.
+func Watch(ctx context.Context, interval time.Duration, files []string, onChange func()) {
+	last, _ := sum(files)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			current, err := sum(files)
+			// A file that cannot be read is most likely being replaced, check again on the next tick.
+			if err != nil || bytes.Equal(current, last) {
+				continue
+			}
+			last = current
+			onChange()
+		}
+	}
+}
+
+func sum(files []string) ([]byte, error) {
+	h := sha256.New()
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		h.Write(b)
+	}
+	return h.Sum(nil), nil
+}
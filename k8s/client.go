@@ -18,8 +18,8 @@ type k8sObject interface {
 	client.Object
 }
 
-// NewClient creates a new k8s client.
-func NewClient(ctx context.Context, config *rest.Config, builder ...runtime.SchemeBuilder) (client.Client, error) {
+// NewClient creates a new k8s client that can also watch objects.
+func NewClient(ctx context.Context, config *rest.Config, builder ...runtime.SchemeBuilder) (client.WithWatch, error) {
 	logger := ctx.Value("logger").(*zap.Logger)
 	log.SetLogger(zapr.NewLogger(logger))
 
@@ -32,7 +32,7 @@ func NewClient(ctx context.Context, config *rest.Config, builder ...runtime.Sche
 			return nil, fmt.Errorf("failed to add scheme: %w", err)
 		}
 	}
-	k8sClient, err := client.New(config, client.Options{Scheme: s})
+	k8sClient, err := client.NewWithWatch(config, client.Options{Scheme: s})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create k8s client: %w", err)
 	}
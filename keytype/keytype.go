@@ -0,0 +1,13 @@
+// Package keytype names the private key types agents generate. It is shared by the agent and
+// the server validating the tasks it queues for agents.
+package keytype
+
+const (
+	RSA   = "rsa"
+	ECDSA = "ecdsa"
+)
+
+// Valid reports whether t is a key type agents can generate.
+func Valid(t string) bool {
+	return t == RSA || t == ECDSA
+}
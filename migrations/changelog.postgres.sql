@@ -0,0 +1,133 @@
+--liquibase formatted sql
+
+--changeset david.salazar:1
+CREATE TABLE ticket (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    token TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    torn TIMESTAMPTZ
+);
+--rollback DROP TABLE ticket;
+
+--changeset david.salazar:2
+CREATE TABLE agent (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    hostname TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    token TEXT UNIQUE NOT NULL,
+    description TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    revoked TIMESTAMPTZ
+);
+--rollback DROP TABLE agent;
+
+--changeset david.salazar:3
+ALTER TABLE ticket ADD COLUMN expires_at TIMESTAMPTZ;
+--rollback ALTER TABLE ticket DROP COLUMN expires_at;
+
+--changeset david.salazar:4
+CREATE UNIQUE INDEX ticket_token_idx ON ticket (token);
+--rollback DROP INDEX ticket_token_idx;
+
+--changeset david.salazar:5
+CREATE TABLE certificate (
+    id BIGSERIAL PRIMARY KEY NOT NULL,
+    agent_id BIGINT NOT NULL REFERENCES agent (id),
+    path TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    sans TEXT NOT NULL,
+    issuer TEXT NOT NULL,
+    serial TEXT NOT NULL,
+    not_before TIMESTAMPTZ NOT NULL,
+    not_after TIMESTAMPTZ NOT NULL,
+    key_algorithm TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    reported_at TIMESTAMPTZ NOT NULL,
+    UNIQUE (agent_id, path)
+);
+--rollback DROP TABLE certificate;
+
+--changeset david.salazar:6
+CREATE TABLE task (
+    id TEXT PRIMARY KEY NOT NULL,
+    agent_id BIGINT NOT NULL REFERENCES agent (id),
+    type TEXT NOT NULL,
+    spec TEXT NOT NULL,
+    status TEXT NOT NULL,
+    message TEXT,
+    created_at TIMESTAMPTZ NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX task_agent_status_idx ON task (agent_id, status);
+--rollback DROP TABLE task;
+
+--changeset david.salazar:7
+ALTER TABLE task ADD COLUMN error_code TEXT;
+ALTER TABLE task ADD COLUMN artifacts TEXT;
+ALTER TABLE task ADD COLUMN dispatched_at TIMESTAMPTZ;
+ALTER TABLE task ADD COLUMN finished_at TIMESTAMPTZ;
+--rollback ALTER TABLE task DROP COLUMN finished_at;
+--rollback ALTER TABLE task DROP COLUMN dispatched_at;
+--rollback ALTER TABLE task DROP COLUMN artifacts;
+--rollback ALTER TABLE task DROP COLUMN error_code;
+
+--changeset david.salazar:8
+ALTER TABLE task ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE task ADD COLUMN last_dispatched_at TIMESTAMPTZ;
+--rollback ALTER TABLE task DROP COLUMN last_dispatched_at;
+--rollback ALTER TABLE task DROP COLUMN attempts;
+
+--changeset david.salazar:9
+ALTER TABLE agent ADD COLUMN version TEXT;
+ALTER TABLE agent ADD COLUMN last_seen TIMESTAMPTZ;
+--rollback ALTER TABLE agent DROP COLUMN last_seen;
+--rollback ALTER TABLE agent DROP COLUMN version;
+
+--changeset david.salazar:10
+CREATE TABLE agent_certificate (
+    serial TEXT PRIMARY KEY NOT NULL,
+    agent_id BIGINT NOT NULL REFERENCES agent (id),
+    not_before TIMESTAMPTZ NOT NULL,
+    not_after TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    revoked TIMESTAMPTZ
+);
+CREATE INDEX agent_certificate_agent_idx ON agent_certificate (agent_id);
+--rollback DROP TABLE agent_certificate;
+
+--changeset david.salazar:11
+CREATE TABLE crl (
+    id INTEGER PRIMARY KEY NOT NULL CHECK (id = 1),
+    number BIGINT NOT NULL,
+    der BYTEA NOT NULL,
+    this_update TIMESTAMPTZ NOT NULL,
+    next_update TIMESTAMPTZ NOT NULL
+);
+--rollback DROP TABLE crl;
+
+--changeset david.salazar:12
+CREATE TABLE audit_event (
+    id BIGINT PRIMARY KEY NOT NULL,
+    time TIMESTAMPTZ NOT NULL,
+    actor TEXT NOT NULL,
+    agent_id BIGINT NOT NULL DEFAULT 0,
+    agent_serial TEXT NOT NULL DEFAULT '',
+    action TEXT NOT NULL,
+    target TEXT NOT NULL,
+    result TEXT NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+CREATE INDEX audit_event_time_idx ON audit_event (time);
+--rollback DROP TABLE audit_event;
+
+--changeset david.salazar:13
+ALTER TABLE ticket ADD COLUMN revoked TIMESTAMPTZ;
+--rollback ALTER TABLE ticket DROP COLUMN revoked;
+
+--changeset david.salazar:14
+ALTER TABLE task ADD COLUMN certificate_chain TEXT;
+ALTER TABLE task ADD COLUMN ca TEXT;
+--rollback ALTER TABLE task DROP COLUMN ca;
+--rollback ALTER TABLE task DROP COLUMN certificate_chain;
@@ -0,0 +1,16 @@
+// Package migrations embeds the Liquibase changelogs of the server database. The store applies
+// their changesets on open, so Liquibase is only needed to manage the schema by hand. Both
+// changelogs have the same changesets, changes must be made to both.
+package migrations
+
+import _ "embed"
+
+// Changelog is the sqlite changelog in Liquibase formatted SQL.
+//
+//go:embed changelog.sql
+var Changelog string
+
+// PostgresChangelog is the PostgreSQL changelog in Liquibase formatted SQL.
+//
+//go:embed changelog.postgres.sql
+var PostgresChangelog string
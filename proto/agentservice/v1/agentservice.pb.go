@@ -21,12 +21,129 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type TaskStatus int32
+
+const (
+	TaskStatus_TASK_STATUS_UNSPECIFIED TaskStatus = 0
+	TaskStatus_TASK_STATUS_SUCCEEDED   TaskStatus = 1
+	TaskStatus_TASK_STATUS_FAILED      TaskStatus = 2
+)
+
+// Enum value maps for TaskStatus.
+var (
+	TaskStatus_name = map[int32]string{
+		0: "TASK_STATUS_UNSPECIFIED",
+		1: "TASK_STATUS_SUCCEEDED",
+		2: "TASK_STATUS_FAILED",
+	}
+	TaskStatus_value = map[string]int32{
+		"TASK_STATUS_UNSPECIFIED": 0,
+		"TASK_STATUS_SUCCEEDED":   1,
+		"TASK_STATUS_FAILED":      2,
+	}
+)
+
+func (x TaskStatus) Enum() *TaskStatus {
+	p := new(TaskStatus)
+	*p = x
+	return p
+}
+
+func (x TaskStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TaskStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_agentservice_proto_enumTypes[0].Descriptor()
+}
+
+func (TaskStatus) Type() protoreflect.EnumType {
+	return &file_agentservice_proto_enumTypes[0]
+}
+
+func (x TaskStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TaskStatus.Descriptor instead.
+func (TaskStatus) EnumDescriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{0}
+}
+
+type TaskErrorCode int32
+
+const (
+	TaskErrorCode_TASK_ERROR_CODE_UNSPECIFIED TaskErrorCode = 0
+	// The agent does not know the task type.
+	TaskErrorCode_TASK_ERROR_CODE_UNSUPPORTED TaskErrorCode = 1
+	// The task spec cannot be executed as given.
+	TaskErrorCode_TASK_ERROR_CODE_INVALID TaskErrorCode = 2
+	// The key or CSR could not be generated.
+	TaskErrorCode_TASK_ERROR_CODE_KEY_GENERATION TaskErrorCode = 3
+	// The server failed to issue the certificate.
+	TaskErrorCode_TASK_ERROR_CODE_ISSUANCE TaskErrorCode = 4
+	// The issued files could not be installed.
+	TaskErrorCode_TASK_ERROR_CODE_INSTALL TaskErrorCode = 5
+)
+
+// Enum value maps for TaskErrorCode.
+var (
+	TaskErrorCode_name = map[int32]string{
+		0: "TASK_ERROR_CODE_UNSPECIFIED",
+		1: "TASK_ERROR_CODE_UNSUPPORTED",
+		2: "TASK_ERROR_CODE_INVALID",
+		3: "TASK_ERROR_CODE_KEY_GENERATION",
+		4: "TASK_ERROR_CODE_ISSUANCE",
+		5: "TASK_ERROR_CODE_INSTALL",
+	}
+	TaskErrorCode_value = map[string]int32{
+		"TASK_ERROR_CODE_UNSPECIFIED":    0,
+		"TASK_ERROR_CODE_UNSUPPORTED":    1,
+		"TASK_ERROR_CODE_INVALID":        2,
+		"TASK_ERROR_CODE_KEY_GENERATION": 3,
+		"TASK_ERROR_CODE_ISSUANCE":       4,
+		"TASK_ERROR_CODE_INSTALL":        5,
+	}
+)
+
+func (x TaskErrorCode) Enum() *TaskErrorCode {
+	p := new(TaskErrorCode)
+	*p = x
+	return p
+}
+
+func (x TaskErrorCode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TaskErrorCode) Descriptor() protoreflect.EnumDescriptor {
+	return file_agentservice_proto_enumTypes[1].Descriptor()
+}
+
+func (TaskErrorCode) Type() protoreflect.EnumType {
+	return &file_agentservice_proto_enumTypes[1]
+}
+
+func (x TaskErrorCode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TaskErrorCode.Descriptor instead.
+func (TaskErrorCode) EnumDescriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{1}
+}
+
 type AgentRequest struct {
 	state   protoimpl.MessageState `protogen:"open.v1"`
 	AgentId string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
 	// Types that are valid to be assigned to Payload:
 	//
 	//	*AgentRequest_Heartbeat
+	//	*AgentRequest_Inventory
+	//	*AgentRequest_Csr
+	//	*AgentRequest_TaskResult
+	//	*AgentRequest_TaskAccepted
+	//	*AgentRequest_TaskProgress
 	Payload       isAgentRequest_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -85,6 +202,51 @@ func (x *AgentRequest) GetHeartbeat() *AgentHeartbeat {
 	return nil
 }
 
+func (x *AgentRequest) GetInventory() *CertificateInventory {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentRequest_Inventory); ok {
+			return x.Inventory
+		}
+	}
+	return nil
+}
+
+func (x *AgentRequest) GetCsr() *CertificateSigningRequest {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentRequest_Csr); ok {
+			return x.Csr
+		}
+	}
+	return nil
+}
+
+func (x *AgentRequest) GetTaskResult() *TaskResult {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentRequest_TaskResult); ok {
+			return x.TaskResult
+		}
+	}
+	return nil
+}
+
+func (x *AgentRequest) GetTaskAccepted() *TaskAccepted {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentRequest_TaskAccepted); ok {
+			return x.TaskAccepted
+		}
+	}
+	return nil
+}
+
+func (x *AgentRequest) GetTaskProgress() *TaskProgress {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentRequest_TaskProgress); ok {
+			return x.TaskProgress
+		}
+	}
+	return nil
+}
+
 type isAgentRequest_Payload interface {
 	isAgentRequest_Payload()
 }
@@ -93,14 +255,46 @@ type AgentRequest_Heartbeat struct {
 	Heartbeat *AgentHeartbeat `protobuf:"bytes,2,opt,name=heartbeat,proto3,oneof"`
 }
 
+type AgentRequest_Inventory struct {
+	Inventory *CertificateInventory `protobuf:"bytes,3,opt,name=inventory,proto3,oneof"`
+}
+
+type AgentRequest_Csr struct {
+	Csr *CertificateSigningRequest `protobuf:"bytes,4,opt,name=csr,proto3,oneof"`
+}
+
+type AgentRequest_TaskResult struct {
+	TaskResult *TaskResult `protobuf:"bytes,5,opt,name=task_result,json=taskResult,proto3,oneof"`
+}
+
+type AgentRequest_TaskAccepted struct {
+	TaskAccepted *TaskAccepted `protobuf:"bytes,6,opt,name=task_accepted,json=taskAccepted,proto3,oneof"`
+}
+
+type AgentRequest_TaskProgress struct {
+	TaskProgress *TaskProgress `protobuf:"bytes,7,opt,name=task_progress,json=taskProgress,proto3,oneof"`
+}
+
 func (*AgentRequest_Heartbeat) isAgentRequest_Payload() {}
 
+func (*AgentRequest_Inventory) isAgentRequest_Payload() {}
+
+func (*AgentRequest_Csr) isAgentRequest_Payload() {}
+
+func (*AgentRequest_TaskResult) isAgentRequest_Payload() {}
+
+func (*AgentRequest_TaskAccepted) isAgentRequest_Payload() {}
+
+func (*AgentRequest_TaskProgress) isAgentRequest_Payload() {}
+
 type AgentResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// Types that are valid to be assigned to Payload:
 	//
 	//	*AgentResponse_ServerTask
 	//	*AgentResponse_ServerStatus
+	//	*AgentResponse_IssuedCertificate
+	//	*AgentResponse_Reconnect
 	Payload       isAgentResponse_Payload `protobuf_oneof:"payload"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -161,6 +355,24 @@ func (x *AgentResponse) GetServerStatus() *ServerStatus {
 	return nil
 }
 
+func (x *AgentResponse) GetIssuedCertificate() *IssuedCertificate {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentResponse_IssuedCertificate); ok {
+			return x.IssuedCertificate
+		}
+	}
+	return nil
+}
+
+func (x *AgentResponse) GetReconnect() *Reconnect {
+	if x != nil {
+		if x, ok := x.Payload.(*AgentResponse_Reconnect); ok {
+			return x.Reconnect
+		}
+	}
+	return nil
+}
+
 type isAgentResponse_Payload interface {
 	isAgentResponse_Payload()
 }
@@ -173,13 +385,29 @@ type AgentResponse_ServerStatus struct {
 	ServerStatus *ServerStatus `protobuf:"bytes,2,opt,name=server_status,json=serverStatus,proto3,oneof"`
 }
 
+type AgentResponse_IssuedCertificate struct {
+	IssuedCertificate *IssuedCertificate `protobuf:"bytes,3,opt,name=issued_certificate,json=issuedCertificate,proto3,oneof"`
+}
+
+type AgentResponse_Reconnect struct {
+	Reconnect *Reconnect `protobuf:"bytes,4,opt,name=reconnect,proto3,oneof"`
+}
+
 func (*AgentResponse_ServerTask) isAgentResponse_Payload() {}
 
 func (*AgentResponse_ServerStatus) isAgentResponse_Payload() {}
 
+func (*AgentResponse_IssuedCertificate) isAgentResponse_Payload() {}
+
+func (*AgentResponse_Reconnect) isAgentResponse_Payload() {}
+
+// AgentHeartbeat is sent when the agent connects and every heartbeat interval after that.
 type AgentHeartbeat struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Timestamp     int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Timestamp int64                  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Version   string                 `protobuf:"bytes,2,opt,name=version,proto3" json:"version,omitempty"`
+	// Features the agent supports, e.g. inventory or issue-certificate.
+	Capabilities  []string `protobuf:"bytes,3,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -221,28 +449,43 @@ func (x *AgentHeartbeat) GetTimestamp() int64 {
 	return 0
 }
 
-type ServerTask struct {
+func (x *AgentHeartbeat) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *AgentHeartbeat) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+// CertificateInventory is the full set of certificates found on the agent host, it replaces
+// any inventory reported before.
+type CertificateInventory struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
-	Command       string                 `protobuf:"bytes,2,opt,name=command,proto3" json:"command,omitempty"`
+	Certificates  []*CertificateInfo     `protobuf:"bytes,1,rep,name=certificates,proto3" json:"certificates,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *ServerTask) Reset() {
-	*x = ServerTask{}
+func (x *CertificateInventory) Reset() {
+	*x = CertificateInventory{}
 	mi := &file_agentservice_proto_msgTypes[3]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *ServerTask) String() string {
+func (x *CertificateInventory) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ServerTask) ProtoMessage() {}
+func (*CertificateInventory) ProtoMessage() {}
 
-func (x *ServerTask) ProtoReflect() protoreflect.Message {
+func (x *CertificateInventory) ProtoReflect() protoreflect.Message {
 	mi := &file_agentservice_proto_msgTypes[3]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -254,46 +497,51 @@ func (x *ServerTask) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ServerTask.ProtoReflect.Descriptor instead.
-func (*ServerTask) Descriptor() ([]byte, []int) {
+// Deprecated: Use CertificateInventory.ProtoReflect.Descriptor instead.
+func (*CertificateInventory) Descriptor() ([]byte, []int) {
 	return file_agentservice_proto_rawDescGZIP(), []int{3}
 }
 
-func (x *ServerTask) GetTaskId() string {
-	if x != nil {
-		return x.TaskId
-	}
-	return ""
-}
-
-func (x *ServerTask) GetCommand() string {
+func (x *CertificateInventory) GetCertificates() []*CertificateInfo {
 	if x != nil {
-		return x.Command
+		return x.Certificates
 	}
-	return ""
+	return nil
 }
 
-type ServerStatus struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+type CertificateInfo struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Path    string                 `protobuf:"bytes,1,opt,name=path,proto3" json:"path,omitempty"`
+	Subject string                 `protobuf:"bytes,2,opt,name=subject,proto3" json:"subject,omitempty"`
+	// Subject alternative names prefixed by their type, e.g. DNS:example.com or IP:10.0.0.1.
+	Sans   []string `protobuf:"bytes,3,rep,name=sans,proto3" json:"sans,omitempty"`
+	Issuer string   `protobuf:"bytes,4,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	// Hex encoded serial number.
+	Serial    string `protobuf:"bytes,5,opt,name=serial,proto3" json:"serial,omitempty"`
+	NotBefore int64  `protobuf:"varint,6,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter  int64  `protobuf:"varint,7,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	// Public key algorithm and size, e.g. RSA-2048 or ECDSA-P256.
+	KeyAlgorithm string `protobuf:"bytes,8,opt,name=key_algorithm,json=keyAlgorithm,proto3" json:"key_algorithm,omitempty"`
+	// Hex encoded SHA-256 fingerprint of the DER certificate.
+	Fingerprint   string `protobuf:"bytes,9,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *ServerStatus) Reset() {
-	*x = ServerStatus{}
+func (x *CertificateInfo) Reset() {
+	*x = CertificateInfo{}
 	mi := &file_agentservice_proto_msgTypes[4]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *ServerStatus) String() string {
+func (x *CertificateInfo) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ServerStatus) ProtoMessage() {}
+func (*CertificateInfo) ProtoMessage() {}
 
-func (x *ServerStatus) ProtoReflect() protoreflect.Message {
+func (x *CertificateInfo) ProtoReflect() protoreflect.Message {
 	mi := &file_agentservice_proto_msgTypes[4]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -305,100 +553,1305 @@ func (x *ServerStatus) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ServerStatus.ProtoReflect.Descriptor instead.
-func (*ServerStatus) Descriptor() ([]byte, []int) {
+// Deprecated: Use CertificateInfo.ProtoReflect.Descriptor instead.
+func (*CertificateInfo) Descriptor() ([]byte, []int) {
 	return file_agentservice_proto_rawDescGZIP(), []int{4}
 }
 
-func (x *ServerStatus) GetMessage() string {
+func (x *CertificateInfo) GetPath() string {
 	if x != nil {
-		return x.Message
+		return x.Path
 	}
 	return ""
 }
 
-var File_agentservice_proto protoreflect.FileDescriptor
+func (x *CertificateInfo) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
 
-const file_agentservice_proto_rawDesc = "" +
-	"\n" +
-	"\x12agentservice.proto\x12\x02v1\"h\n" +
-	"\fAgentRequest\x12\x19\n" +
-	"\bagent_id\x18\x01 \x01(\tR\aagentId\x122\n" +
-	"\theartbeat\x18\x02 \x01(\v2\x12.v1.AgentHeartbeatH\x00R\theartbeatB\t\n" +
-	"\apayload\"\x86\x01\n" +
-	"\rAgentResponse\x121\n" +
-	"\vserver_task\x18\x01 \x01(\v2\x0e.v1.ServerTaskH\x00R\n" +
-	"serverTask\x127\n" +
-	"\rserver_status\x18\x02 \x01(\v2\x10.v1.ServerStatusH\x00R\fserverStatusB\t\n" +
-	"\apayload\".\n" +
-	"\x0eAgentHeartbeat\x12\x1c\n" +
-	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\"?\n" +
-	"\n" +
-	"ServerTask\x12\x17\n" +
-	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x18\n" +
-	"\acommand\x18\x02 \x01(\tR\acommand\"(\n" +
-	"\fServerStatus\x12\x18\n" +
-	"\amessage\x18\x01 \x01(\tR\amessage2?\n" +
-	"\fAgentService\x12/\n" +
-	"\x04Poll\x12\x10.v1.AgentRequest\x1a\x11.v1.AgentResponse(\x010\x01B(Z&github.com/salzr/proto/agentservice/v1b\x06proto3"
+func (x *CertificateInfo) GetSans() []string {
+	if x != nil {
+		return x.Sans
+	}
+	return nil
+}
 
-var (
-	file_agentservice_proto_rawDescOnce sync.Once
-	file_agentservice_proto_rawDescData []byte
-)
+func (x *CertificateInfo) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
 
-func file_agentservice_proto_rawDescGZIP() []byte {
-	file_agentservice_proto_rawDescOnce.Do(func() {
-		file_agentservice_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_agentservice_proto_rawDesc), len(file_agentservice_proto_rawDesc)))
-	})
-	return file_agentservice_proto_rawDescData
+func (x *CertificateInfo) GetSerial() string {
+	if x != nil {
+		return x.Serial
+	}
+	return ""
 }
 
-var file_agentservice_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
-var file_agentservice_proto_goTypes = []any{
-	(*AgentRequest)(nil),   // 0: v1.AgentRequest
-	(*AgentResponse)(nil),  // 1: v1.AgentResponse
-	(*AgentHeartbeat)(nil), // 2: v1.AgentHeartbeat
-	(*ServerTask)(nil),     // 3: v1.ServerTask
-	(*ServerStatus)(nil),   // 4: v1.ServerStatus
+func (x *CertificateInfo) GetNotBefore() int64 {
+	if x != nil {
+		return x.NotBefore
+	}
+	return 0
 }
-var file_agentservice_proto_depIdxs = []int32{
-	2, // 0: v1.AgentRequest.heartbeat:type_name -> v1.AgentHeartbeat
-	3, // 1: v1.AgentResponse.server_task:type_name -> v1.ServerTask
-	4, // 2: v1.AgentResponse.server_status:type_name -> v1.ServerStatus
-	0, // 3: v1.AgentService.Poll:input_type -> v1.AgentRequest
-	1, // 4: v1.AgentService.Poll:output_type -> v1.AgentResponse
-	4, // [4:5] is the sub-list for method output_type
-	3, // [3:4] is the sub-list for method input_type
-	3, // [3:3] is the sub-list for extension type_name
-	3, // [3:3] is the sub-list for extension extendee
-	0, // [0:3] is the sub-list for field type_name
+
+func (x *CertificateInfo) GetNotAfter() int64 {
+	if x != nil {
+		return x.NotAfter
+	}
+	return 0
 }
 
-func init() { file_agentservice_proto_init() }
-func file_agentservice_proto_init() {
-	if File_agentservice_proto != nil {
-		return
+func (x *CertificateInfo) GetKeyAlgorithm() string {
+	if x != nil {
+		return x.KeyAlgorithm
 	}
-	file_agentservice_proto_msgTypes[0].OneofWrappers = []any{
-		(*AgentRequest_Heartbeat)(nil),
+	return ""
+}
+
+func (x *CertificateInfo) GetFingerprint() string {
+	if x != nil {
+		return x.Fingerprint
 	}
-	file_agentservice_proto_msgTypes[1].OneofWrappers = []any{
-		(*AgentResponse_ServerTask)(nil),
-		(*AgentResponse_ServerStatus)(nil),
+	return ""
+}
+
+// ServerTask is delivered at least once, a task is redelivered until the agent accepts it and
+// again when the agent reconnects before reporting its result. Agents detect duplicates by
+// task_id and answer them with the state they already have.
+type ServerTask struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	TaskId string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	// Types that are valid to be assigned to Task:
+	//
+	//	*ServerTask_IssueCertificate
+	Task isServerTask_Task `protobuf_oneof:"task"`
+	// Delivery attempt, starting at 1.
+	Attempt       uint32 `protobuf:"varint,4,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerTask) Reset() {
+	*x = ServerTask{}
+	mi := &file_agentservice_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerTask) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerTask) ProtoMessage() {}
+
+func (x *ServerTask) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerTask.ProtoReflect.Descriptor instead.
+func (*ServerTask) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ServerTask) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *ServerTask) GetTask() isServerTask_Task {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *ServerTask) GetIssueCertificate() *IssueCertificate {
+	if x != nil {
+		if x, ok := x.Task.(*ServerTask_IssueCertificate); ok {
+			return x.IssueCertificate
+		}
+	}
+	return nil
+}
+
+func (x *ServerTask) GetAttempt() uint32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+type isServerTask_Task interface {
+	isServerTask_Task()
+}
+
+type ServerTask_IssueCertificate struct {
+	IssueCertificate *IssueCertificate `protobuf:"bytes,3,opt,name=issue_certificate,json=issueCertificate,proto3,oneof"`
+}
+
+func (*ServerTask_IssueCertificate) isServerTask_Task() {}
+
+// IssueCertificate asks the agent to generate a key, request a certificate for it with a
+// CertificateSigningRequest and install both once the IssuedCertificate arrives.
+type IssueCertificate struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Subject        *Subject               `protobuf:"bytes,1,opt,name=subject,proto3" json:"subject,omitempty"`
+	DnsNames       []string               `protobuf:"bytes,2,rep,name=dns_names,json=dnsNames,proto3" json:"dns_names,omitempty"`
+	IpAddresses    []string               `protobuf:"bytes,3,rep,name=ip_addresses,json=ipAddresses,proto3" json:"ip_addresses,omitempty"`
+	Uris           []string               `protobuf:"bytes,4,rep,name=uris,proto3" json:"uris,omitempty"`
+	EmailAddresses []string               `protobuf:"bytes,5,rep,name=email_addresses,json=emailAddresses,proto3" json:"email_addresses,omitempty"`
+	// Either rsa or ecdsa.
+	KeyType string `protobuf:"bytes,6,opt,name=key_type,json=keyType,proto3" json:"key_type,omitempty"`
+	// Key usages named like cert-manager ones, e.g. digital signature or server auth.
+	Usages          []string `protobuf:"bytes,7,rep,name=usages,proto3" json:"usages,omitempty"`
+	DurationSeconds int64    `protobuf:"varint,8,opt,name=duration_seconds,json=durationSeconds,proto3" json:"duration_seconds,omitempty"`
+	// Absolute destination paths, ca_path is optional.
+	CertPath string `protobuf:"bytes,9,opt,name=cert_path,json=certPath,proto3" json:"cert_path,omitempty"`
+	KeyPath  string `protobuf:"bytes,10,opt,name=key_path,json=keyPath,proto3" json:"key_path,omitempty"`
+	CaPath   string `protobuf:"bytes,11,opt,name=ca_path,json=caPath,proto3" json:"ca_path,omitempty"`
+	// Permissions of the installed files, 0600 when unset.
+	FileMode uint32 `protobuf:"varint,12,opt,name=file_mode,json=fileMode,proto3" json:"file_mode,omitempty"`
+	// Owner of the installed files as user or user:group, unchanged when empty.
+	Owner         string `protobuf:"bytes,13,opt,name=owner,proto3" json:"owner,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IssueCertificate) Reset() {
+	*x = IssueCertificate{}
+	mi := &file_agentservice_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IssueCertificate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IssueCertificate) ProtoMessage() {}
+
+func (x *IssueCertificate) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IssueCertificate.ProtoReflect.Descriptor instead.
+func (*IssueCertificate) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *IssueCertificate) GetSubject() *Subject {
+	if x != nil {
+		return x.Subject
+	}
+	return nil
+}
+
+func (x *IssueCertificate) GetDnsNames() []string {
+	if x != nil {
+		return x.DnsNames
+	}
+	return nil
+}
+
+func (x *IssueCertificate) GetIpAddresses() []string {
+	if x != nil {
+		return x.IpAddresses
+	}
+	return nil
+}
+
+func (x *IssueCertificate) GetUris() []string {
+	if x != nil {
+		return x.Uris
+	}
+	return nil
+}
+
+func (x *IssueCertificate) GetEmailAddresses() []string {
+	if x != nil {
+		return x.EmailAddresses
+	}
+	return nil
+}
+
+func (x *IssueCertificate) GetKeyType() string {
+	if x != nil {
+		return x.KeyType
+	}
+	return ""
+}
+
+func (x *IssueCertificate) GetUsages() []string {
+	if x != nil {
+		return x.Usages
+	}
+	return nil
+}
+
+func (x *IssueCertificate) GetDurationSeconds() int64 {
+	if x != nil {
+		return x.DurationSeconds
+	}
+	return 0
+}
+
+func (x *IssueCertificate) GetCertPath() string {
+	if x != nil {
+		return x.CertPath
+	}
+	return ""
+}
+
+func (x *IssueCertificate) GetKeyPath() string {
+	if x != nil {
+		return x.KeyPath
+	}
+	return ""
+}
+
+func (x *IssueCertificate) GetCaPath() string {
+	if x != nil {
+		return x.CaPath
+	}
+	return ""
+}
+
+func (x *IssueCertificate) GetFileMode() uint32 {
+	if x != nil {
+		return x.FileMode
+	}
+	return 0
+}
+
+func (x *IssueCertificate) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+type Subject struct {
+	state               protoimpl.MessageState `protogen:"open.v1"`
+	CommonName          string                 `protobuf:"bytes,1,opt,name=common_name,json=commonName,proto3" json:"common_name,omitempty"`
+	Organizations       []string               `protobuf:"bytes,2,rep,name=organizations,proto3" json:"organizations,omitempty"`
+	OrganizationalUnits []string               `protobuf:"bytes,3,rep,name=organizational_units,json=organizationalUnits,proto3" json:"organizational_units,omitempty"`
+	Countries           []string               `protobuf:"bytes,4,rep,name=countries,proto3" json:"countries,omitempty"`
+	Localities          []string               `protobuf:"bytes,5,rep,name=localities,proto3" json:"localities,omitempty"`
+	Provinces           []string               `protobuf:"bytes,6,rep,name=provinces,proto3" json:"provinces,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *Subject) Reset() {
+	*x = Subject{}
+	mi := &file_agentservice_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Subject) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Subject) ProtoMessage() {}
+
+func (x *Subject) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Subject.ProtoReflect.Descriptor instead.
+func (*Subject) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Subject) GetCommonName() string {
+	if x != nil {
+		return x.CommonName
+	}
+	return ""
+}
+
+func (x *Subject) GetOrganizations() []string {
+	if x != nil {
+		return x.Organizations
+	}
+	return nil
+}
+
+func (x *Subject) GetOrganizationalUnits() []string {
+	if x != nil {
+		return x.OrganizationalUnits
+	}
+	return nil
+}
+
+func (x *Subject) GetCountries() []string {
+	if x != nil {
+		return x.Countries
+	}
+	return nil
+}
+
+func (x *Subject) GetLocalities() []string {
+	if x != nil {
+		return x.Localities
+	}
+	return nil
+}
+
+func (x *Subject) GetProvinces() []string {
+	if x != nil {
+		return x.Provinces
+	}
+	return nil
+}
+
+type CertificateSigningRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	TaskId string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	// PEM encoded certificate signing request for the key generated for the task.
+	Csr           []byte `protobuf:"bytes,2,opt,name=csr,proto3" json:"csr,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CertificateSigningRequest) Reset() {
+	*x = CertificateSigningRequest{}
+	mi := &file_agentservice_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CertificateSigningRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CertificateSigningRequest) ProtoMessage() {}
+
+func (x *CertificateSigningRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CertificateSigningRequest.ProtoReflect.Descriptor instead.
+func (*CertificateSigningRequest) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CertificateSigningRequest) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *CertificateSigningRequest) GetCsr() []byte {
+	if x != nil {
+		return x.Csr
+	}
+	return nil
+}
+
+type IssuedCertificate struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	TaskId string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	// PEM encoded certificate followed by its chain, empty when error is set.
+	CertificateChain []byte `protobuf:"bytes,2,opt,name=certificate_chain,json=certificateChain,proto3" json:"certificate_chain,omitempty"`
+	// PEM encoded CA of the issuer.
+	Ca            []byte `protobuf:"bytes,3,opt,name=ca,proto3" json:"ca,omitempty"`
+	Error         string `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IssuedCertificate) Reset() {
+	*x = IssuedCertificate{}
+	mi := &file_agentservice_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IssuedCertificate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IssuedCertificate) ProtoMessage() {}
+
+func (x *IssuedCertificate) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IssuedCertificate.ProtoReflect.Descriptor instead.
+func (*IssuedCertificate) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *IssuedCertificate) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *IssuedCertificate) GetCertificateChain() []byte {
+	if x != nil {
+		return x.CertificateChain
+	}
+	return nil
+}
+
+func (x *IssuedCertificate) GetCa() []byte {
+	if x != nil {
+		return x.Ca
+	}
+	return nil
+}
+
+func (x *IssuedCertificate) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// TaskAccepted is sent once the agent starts working on a task, it moves the task to running.
+type TaskAccepted struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskAccepted) Reset() {
+	*x = TaskAccepted{}
+	mi := &file_agentservice_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskAccepted) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskAccepted) ProtoMessage() {}
+
+func (x *TaskAccepted) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskAccepted.ProtoReflect.Descriptor instead.
+func (*TaskAccepted) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *TaskAccepted) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+type TaskProgress struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskProgress) Reset() {
+	*x = TaskProgress{}
+	mi := &file_agentservice_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskProgress) ProtoMessage() {}
+
+func (x *TaskProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskProgress.ProtoReflect.Descriptor instead.
+func (*TaskProgress) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *TaskProgress) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *TaskProgress) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// TaskResult is the final outcome of a task.
+type TaskResult struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	TaskId  string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	Status  TaskStatus             `protobuf:"varint,2,opt,name=status,proto3,enum=v1.TaskStatus" json:"status,omitempty"`
+	Message string                 `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+	// Set when status is failed.
+	ErrorCode     TaskErrorCode   `protobuf:"varint,4,opt,name=error_code,json=errorCode,proto3,enum=v1.TaskErrorCode" json:"error_code,omitempty"`
+	Artifacts     []*TaskArtifact `protobuf:"bytes,5,rep,name=artifacts,proto3" json:"artifacts,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskResult) Reset() {
+	*x = TaskResult{}
+	mi := &file_agentservice_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskResult) ProtoMessage() {}
+
+func (x *TaskResult) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskResult.ProtoReflect.Descriptor instead.
+func (*TaskResult) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *TaskResult) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *TaskResult) GetStatus() TaskStatus {
+	if x != nil {
+		return x.Status
+	}
+	return TaskStatus_TASK_STATUS_UNSPECIFIED
+}
+
+func (x *TaskResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *TaskResult) GetErrorCode() TaskErrorCode {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return TaskErrorCode_TASK_ERROR_CODE_UNSPECIFIED
+}
+
+func (x *TaskResult) GetArtifacts() []*TaskArtifact {
+	if x != nil {
+		return x.Artifacts
+	}
+	return nil
+}
+
+// TaskArtifact is a file produced by a task.
+type TaskArtifact struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// What the file holds, e.g. certificate, private_key or ca.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Path string `protobuf:"bytes,2,opt,name=path,proto3" json:"path,omitempty"`
+	// Hex encoded SHA-256 of the file contents.
+	Sha256        string `protobuf:"bytes,3,opt,name=sha256,proto3" json:"sha256,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskArtifact) Reset() {
+	*x = TaskArtifact{}
+	mi := &file_agentservice_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskArtifact) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskArtifact) ProtoMessage() {}
+
+func (x *TaskArtifact) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskArtifact.ProtoReflect.Descriptor instead.
+func (*TaskArtifact) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *TaskArtifact) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TaskArtifact) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *TaskArtifact) GetSha256() string {
+	if x != nil {
+		return x.Sha256
+	}
+	return ""
+}
+
+type ServerStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Message       string                 `protobuf:"bytes,1,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ServerStatus) Reset() {
+	*x = ServerStatus{}
+	mi := &file_agentservice_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ServerStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ServerStatus) ProtoMessage() {}
+
+func (x *ServerStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ServerStatus.ProtoReflect.Descriptor instead.
+func (*ServerStatus) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ServerStatus) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+// Reconnect is sent before the server closes the stream, e.g. when it shuts down, so the agent
+// reconnects right away, possibly to another server.
+type Reconnect struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Reason        string                 `protobuf:"bytes,1,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Reconnect) Reset() {
+	*x = Reconnect{}
+	mi := &file_agentservice_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Reconnect) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Reconnect) ProtoMessage() {}
+
+func (x *Reconnect) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Reconnect.ProtoReflect.Descriptor instead.
+func (*Reconnect) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *Reconnect) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+type EnrollRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Token string                 `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	// PEM encoded certificate signing request generated by the agent.
+	Csr           []byte `protobuf:"bytes,2,opt,name=csr,proto3" json:"csr,omitempty"`
+	Hostname      string `protobuf:"bytes,3,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Description   string `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrollRequest) Reset() {
+	*x = EnrollRequest{}
+	mi := &file_agentservice_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrollRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollRequest) ProtoMessage() {}
+
+func (x *EnrollRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollRequest.ProtoReflect.Descriptor instead.
+func (*EnrollRequest) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *EnrollRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *EnrollRequest) GetCsr() []byte {
+	if x != nil {
+		return x.Csr
+	}
+	return nil
+}
+
+func (x *EnrollRequest) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *EnrollRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+type EnrollResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	AgentId string                 `protobuf:"bytes,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// PEM encoded client certificate followed by the agent CA.
+	CertificateChain []byte `protobuf:"bytes,2,opt,name=certificate_chain,json=certificateChain,proto3" json:"certificate_chain,omitempty"`
+	// PEM encoded CA bundle to verify the server with.
+	CaBundle      []byte `protobuf:"bytes,3,opt,name=ca_bundle,json=caBundle,proto3" json:"ca_bundle,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EnrollResponse) Reset() {
+	*x = EnrollResponse{}
+	mi := &file_agentservice_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EnrollResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EnrollResponse) ProtoMessage() {}
+
+func (x *EnrollResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EnrollResponse.ProtoReflect.Descriptor instead.
+func (*EnrollResponse) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *EnrollResponse) GetAgentId() string {
+	if x != nil {
+		return x.AgentId
+	}
+	return ""
+}
+
+func (x *EnrollResponse) GetCertificateChain() []byte {
+	if x != nil {
+		return x.CertificateChain
+	}
+	return nil
+}
+
+func (x *EnrollResponse) GetCaBundle() []byte {
+	if x != nil {
+		return x.CaBundle
+	}
+	return nil
+}
+
+type RenewRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// PEM encoded certificate signing request for the new key.
+	Csr           []byte `protobuf:"bytes,1,opt,name=csr,proto3" json:"csr,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenewRequest) Reset() {
+	*x = RenewRequest{}
+	mi := &file_agentservice_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewRequest) ProtoMessage() {}
+
+func (x *RenewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewRequest.ProtoReflect.Descriptor instead.
+func (*RenewRequest) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *RenewRequest) GetCsr() []byte {
+	if x != nil {
+		return x.Csr
+	}
+	return nil
+}
+
+type RenewResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// PEM encoded client certificate followed by the agent CA.
+	CertificateChain []byte `protobuf:"bytes,1,opt,name=certificate_chain,json=certificateChain,proto3" json:"certificate_chain,omitempty"`
+	// PEM encoded CA bundle to verify the server with.
+	CaBundle      []byte `protobuf:"bytes,2,opt,name=ca_bundle,json=caBundle,proto3" json:"ca_bundle,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenewResponse) Reset() {
+	*x = RenewResponse{}
+	mi := &file_agentservice_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenewResponse) ProtoMessage() {}
+
+func (x *RenewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_agentservice_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenewResponse.ProtoReflect.Descriptor instead.
+func (*RenewResponse) Descriptor() ([]byte, []int) {
+	return file_agentservice_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *RenewResponse) GetCertificateChain() []byte {
+	if x != nil {
+		return x.CertificateChain
+	}
+	return nil
+}
+
+func (x *RenewResponse) GetCaBundle() []byte {
+	if x != nil {
+		return x.CaBundle
+	}
+	return nil
+}
+
+var File_agentservice_proto protoreflect.FileDescriptor
+
+const file_agentservice_proto_rawDesc = "" +
+	"\n" +
+	"\x12agentservice.proto\x12\x02v1\"\xfa\x02\n" +
+	"\fAgentRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x122\n" +
+	"\theartbeat\x18\x02 \x01(\v2\x12.v1.AgentHeartbeatH\x00R\theartbeat\x128\n" +
+	"\tinventory\x18\x03 \x01(\v2\x18.v1.CertificateInventoryH\x00R\tinventory\x121\n" +
+	"\x03csr\x18\x04 \x01(\v2\x1d.v1.CertificateSigningRequestH\x00R\x03csr\x121\n" +
+	"\vtask_result\x18\x05 \x01(\v2\x0e.v1.TaskResultH\x00R\n" +
+	"taskResult\x127\n" +
+	"\rtask_accepted\x18\x06 \x01(\v2\x10.v1.TaskAcceptedH\x00R\ftaskAccepted\x127\n" +
+	"\rtask_progress\x18\a \x01(\v2\x10.v1.TaskProgressH\x00R\ftaskProgressB\t\n" +
+	"\apayload\"\xfd\x01\n" +
+	"\rAgentResponse\x121\n" +
+	"\vserver_task\x18\x01 \x01(\v2\x0e.v1.ServerTaskH\x00R\n" +
+	"serverTask\x127\n" +
+	"\rserver_status\x18\x02 \x01(\v2\x10.v1.ServerStatusH\x00R\fserverStatus\x12F\n" +
+	"\x12issued_certificate\x18\x03 \x01(\v2\x15.v1.IssuedCertificateH\x00R\x11issuedCertificate\x12-\n" +
+	"\treconnect\x18\x04 \x01(\v2\r.v1.ReconnectH\x00R\treconnectB\t\n" +
+	"\apayload\"l\n" +
+	"\x0eAgentHeartbeat\x12\x1c\n" +
+	"\ttimestamp\x18\x01 \x01(\x03R\ttimestamp\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\tR\aversion\x12\"\n" +
+	"\fcapabilities\x18\x03 \x03(\tR\fcapabilities\"O\n" +
+	"\x14CertificateInventory\x127\n" +
+	"\fcertificates\x18\x01 \x03(\v2\x13.v1.CertificateInfoR\fcertificates\"\x86\x02\n" +
+	"\x0fCertificateInfo\x12\x12\n" +
+	"\x04path\x18\x01 \x01(\tR\x04path\x12\x18\n" +
+	"\asubject\x18\x02 \x01(\tR\asubject\x12\x12\n" +
+	"\x04sans\x18\x03 \x03(\tR\x04sans\x12\x16\n" +
+	"\x06issuer\x18\x04 \x01(\tR\x06issuer\x12\x16\n" +
+	"\x06serial\x18\x05 \x01(\tR\x06serial\x12\x1d\n" +
+	"\n" +
+	"not_before\x18\x06 \x01(\x03R\tnotBefore\x12\x1b\n" +
+	"\tnot_after\x18\a \x01(\x03R\bnotAfter\x12#\n" +
+	"\rkey_algorithm\x18\b \x01(\tR\fkeyAlgorithm\x12 \n" +
+	"\vfingerprint\x18\t \x01(\tR\vfingerprint\"\x9b\x01\n" +
+	"\n" +
+	"ServerTask\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12C\n" +
+	"\x11issue_certificate\x18\x03 \x01(\v2\x14.v1.IssueCertificateH\x00R\x10issueCertificate\x12\x18\n" +
+	"\aattempt\x18\x04 \x01(\rR\aattemptB\x06\n" +
+	"\x04taskJ\x04\b\x02\x10\x03R\acommand\"\x98\x03\n" +
+	"\x10IssueCertificate\x12%\n" +
+	"\asubject\x18\x01 \x01(\v2\v.v1.SubjectR\asubject\x12\x1b\n" +
+	"\tdns_names\x18\x02 \x03(\tR\bdnsNames\x12!\n" +
+	"\fip_addresses\x18\x03 \x03(\tR\vipAddresses\x12\x12\n" +
+	"\x04uris\x18\x04 \x03(\tR\x04uris\x12'\n" +
+	"\x0femail_addresses\x18\x05 \x03(\tR\x0eemailAddresses\x12\x19\n" +
+	"\bkey_type\x18\x06 \x01(\tR\akeyType\x12\x16\n" +
+	"\x06usages\x18\a \x03(\tR\x06usages\x12)\n" +
+	"\x10duration_seconds\x18\b \x01(\x03R\x0fdurationSeconds\x12\x1b\n" +
+	"\tcert_path\x18\t \x01(\tR\bcertPath\x12\x19\n" +
+	"\bkey_path\x18\n" +
+	" \x01(\tR\akeyPath\x12\x17\n" +
+	"\aca_path\x18\v \x01(\tR\x06caPath\x12\x1b\n" +
+	"\tfile_mode\x18\f \x01(\rR\bfileMode\x12\x14\n" +
+	"\x05owner\x18\r \x01(\tR\x05owner\"\xdf\x01\n" +
+	"\aSubject\x12\x1f\n" +
+	"\vcommon_name\x18\x01 \x01(\tR\n" +
+	"commonName\x12$\n" +
+	"\rorganizations\x18\x02 \x03(\tR\rorganizations\x121\n" +
+	"\x14organizational_units\x18\x03 \x03(\tR\x13organizationalUnits\x12\x1c\n" +
+	"\tcountries\x18\x04 \x03(\tR\tcountries\x12\x1e\n" +
+	"\n" +
+	"localities\x18\x05 \x03(\tR\n" +
+	"localities\x12\x1c\n" +
+	"\tprovinces\x18\x06 \x03(\tR\tprovinces\"F\n" +
+	"\x19CertificateSigningRequest\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x10\n" +
+	"\x03csr\x18\x02 \x01(\fR\x03csr\"\x7f\n" +
+	"\x11IssuedCertificate\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12+\n" +
+	"\x11certificate_chain\x18\x02 \x01(\fR\x10certificateChain\x12\x0e\n" +
+	"\x02ca\x18\x03 \x01(\fR\x02ca\x12\x14\n" +
+	"\x05error\x18\x04 \x01(\tR\x05error\"'\n" +
+	"\fTaskAccepted\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\"A\n" +
+	"\fTaskProgress\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"\xc9\x01\n" +
+	"\n" +
+	"TaskResult\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12&\n" +
+	"\x06status\x18\x02 \x01(\x0e2\x0e.v1.TaskStatusR\x06status\x12\x18\n" +
+	"\amessage\x18\x03 \x01(\tR\amessage\x120\n" +
+	"\n" +
+	"error_code\x18\x04 \x01(\x0e2\x11.v1.TaskErrorCodeR\terrorCode\x12.\n" +
+	"\tartifacts\x18\x05 \x03(\v2\x10.v1.TaskArtifactR\tartifacts\"N\n" +
+	"\fTaskArtifact\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04path\x18\x02 \x01(\tR\x04path\x12\x16\n" +
+	"\x06sha256\x18\x03 \x01(\tR\x06sha256\"(\n" +
+	"\fServerStatus\x12\x18\n" +
+	"\amessage\x18\x01 \x01(\tR\amessage\"#\n" +
+	"\tReconnect\x12\x16\n" +
+	"\x06reason\x18\x01 \x01(\tR\x06reason\"u\n" +
+	"\rEnrollRequest\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\x12\x10\n" +
+	"\x03csr\x18\x02 \x01(\fR\x03csr\x12\x1a\n" +
+	"\bhostname\x18\x03 \x01(\tR\bhostname\x12 \n" +
+	"\vdescription\x18\x04 \x01(\tR\vdescription\"u\n" +
+	"\x0eEnrollResponse\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\tR\aagentId\x12+\n" +
+	"\x11certificate_chain\x18\x02 \x01(\fR\x10certificateChain\x12\x1b\n" +
+	"\tca_bundle\x18\x03 \x01(\fR\bcaBundle\" \n" +
+	"\fRenewRequest\x12\x10\n" +
+	"\x03csr\x18\x01 \x01(\fR\x03csr\"Y\n" +
+	"\rRenewResponse\x12+\n" +
+	"\x11certificate_chain\x18\x01 \x01(\fR\x10certificateChain\x12\x1b\n" +
+	"\tca_bundle\x18\x02 \x01(\fR\bcaBundle*\\\n" +
+	"\n" +
+	"TaskStatus\x12\x1b\n" +
+	"\x17TASK_STATUS_UNSPECIFIED\x10\x00\x12\x19\n" +
+	"\x15TASK_STATUS_SUCCEEDED\x10\x01\x12\x16\n" +
+	"\x12TASK_STATUS_FAILED\x10\x02*\xcd\x01\n" +
+	"\rTaskErrorCode\x12\x1f\n" +
+	"\x1bTASK_ERROR_CODE_UNSPECIFIED\x10\x00\x12\x1f\n" +
+	"\x1bTASK_ERROR_CODE_UNSUPPORTED\x10\x01\x12\x1b\n" +
+	"\x17TASK_ERROR_CODE_INVALID\x10\x02\x12\"\n" +
+	"\x1eTASK_ERROR_CODE_KEY_GENERATION\x10\x03\x12\x1c\n" +
+	"\x18TASK_ERROR_CODE_ISSUANCE\x10\x04\x12\x1b\n" +
+	"\x17TASK_ERROR_CODE_INSTALL\x10\x052\x9e\x01\n" +
+	"\fAgentService\x12/\n" +
+	"\x04Poll\x12\x10.v1.AgentRequest\x1a\x11.v1.AgentResponse(\x010\x01\x12/\n" +
+	"\x06Enroll\x12\x11.v1.EnrollRequest\x1a\x12.v1.EnrollResponse\x12,\n" +
+	"\x05Renew\x12\x10.v1.RenewRequest\x1a\x11.v1.RenewResponseB(Z&github.com/salzr/proto/agentservice/v1b\x06proto3"
+
+var (
+	file_agentservice_proto_rawDescOnce sync.Once
+	file_agentservice_proto_rawDescData []byte
+)
+
+func file_agentservice_proto_rawDescGZIP() []byte {
+	file_agentservice_proto_rawDescOnce.Do(func() {
+		file_agentservice_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_agentservice_proto_rawDesc), len(file_agentservice_proto_rawDesc)))
+	})
+	return file_agentservice_proto_rawDescData
+}
+
+var file_agentservice_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_agentservice_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
+var file_agentservice_proto_goTypes = []any{
+	(TaskStatus)(0),                   // 0: v1.TaskStatus
+	(TaskErrorCode)(0),                // 1: v1.TaskErrorCode
+	(*AgentRequest)(nil),              // 2: v1.AgentRequest
+	(*AgentResponse)(nil),             // 3: v1.AgentResponse
+	(*AgentHeartbeat)(nil),            // 4: v1.AgentHeartbeat
+	(*CertificateInventory)(nil),      // 5: v1.CertificateInventory
+	(*CertificateInfo)(nil),           // 6: v1.CertificateInfo
+	(*ServerTask)(nil),                // 7: v1.ServerTask
+	(*IssueCertificate)(nil),          // 8: v1.IssueCertificate
+	(*Subject)(nil),                   // 9: v1.Subject
+	(*CertificateSigningRequest)(nil), // 10: v1.CertificateSigningRequest
+	(*IssuedCertificate)(nil),         // 11: v1.IssuedCertificate
+	(*TaskAccepted)(nil),              // 12: v1.TaskAccepted
+	(*TaskProgress)(nil),              // 13: v1.TaskProgress
+	(*TaskResult)(nil),                // 14: v1.TaskResult
+	(*TaskArtifact)(nil),              // 15: v1.TaskArtifact
+	(*ServerStatus)(nil),              // 16: v1.ServerStatus
+	(*Reconnect)(nil),                 // 17: v1.Reconnect
+	(*EnrollRequest)(nil),             // 18: v1.EnrollRequest
+	(*EnrollResponse)(nil),            // 19: v1.EnrollResponse
+	(*RenewRequest)(nil),              // 20: v1.RenewRequest
+	(*RenewResponse)(nil),             // 21: v1.RenewResponse
+}
+var file_agentservice_proto_depIdxs = []int32{
+	4,  // 0: v1.AgentRequest.heartbeat:type_name -> v1.AgentHeartbeat
+	5,  // 1: v1.AgentRequest.inventory:type_name -> v1.CertificateInventory
+	10, // 2: v1.AgentRequest.csr:type_name -> v1.CertificateSigningRequest
+	14, // 3: v1.AgentRequest.task_result:type_name -> v1.TaskResult
+	12, // 4: v1.AgentRequest.task_accepted:type_name -> v1.TaskAccepted
+	13, // 5: v1.AgentRequest.task_progress:type_name -> v1.TaskProgress
+	7,  // 6: v1.AgentResponse.server_task:type_name -> v1.ServerTask
+	16, // 7: v1.AgentResponse.server_status:type_name -> v1.ServerStatus
+	11, // 8: v1.AgentResponse.issued_certificate:type_name -> v1.IssuedCertificate
+	17, // 9: v1.AgentResponse.reconnect:type_name -> v1.Reconnect
+	6,  // 10: v1.CertificateInventory.certificates:type_name -> v1.CertificateInfo
+	8,  // 11: v1.ServerTask.issue_certificate:type_name -> v1.IssueCertificate
+	9,  // 12: v1.IssueCertificate.subject:type_name -> v1.Subject
+	0,  // 13: v1.TaskResult.status:type_name -> v1.TaskStatus
+	1,  // 14: v1.TaskResult.error_code:type_name -> v1.TaskErrorCode
+	15, // 15: v1.TaskResult.artifacts:type_name -> v1.TaskArtifact
+	2,  // 16: v1.AgentService.Poll:input_type -> v1.AgentRequest
+	18, // 17: v1.AgentService.Enroll:input_type -> v1.EnrollRequest
+	20, // 18: v1.AgentService.Renew:input_type -> v1.RenewRequest
+	3,  // 19: v1.AgentService.Poll:output_type -> v1.AgentResponse
+	19, // 20: v1.AgentService.Enroll:output_type -> v1.EnrollResponse
+	21, // 21: v1.AgentService.Renew:output_type -> v1.RenewResponse
+	19, // [19:22] is the sub-list for method output_type
+	16, // [16:19] is the sub-list for method input_type
+	16, // [16:16] is the sub-list for extension type_name
+	16, // [16:16] is the sub-list for extension extendee
+	0,  // [0:16] is the sub-list for field type_name
+}
+
+func init() { file_agentservice_proto_init() }
+func file_agentservice_proto_init() {
+	if File_agentservice_proto != nil {
+		return
+	}
+	file_agentservice_proto_msgTypes[0].OneofWrappers = []any{
+		(*AgentRequest_Heartbeat)(nil),
+		(*AgentRequest_Inventory)(nil),
+		(*AgentRequest_Csr)(nil),
+		(*AgentRequest_TaskResult)(nil),
+		(*AgentRequest_TaskAccepted)(nil),
+		(*AgentRequest_TaskProgress)(nil),
+	}
+	file_agentservice_proto_msgTypes[1].OneofWrappers = []any{
+		(*AgentResponse_ServerTask)(nil),
+		(*AgentResponse_ServerStatus)(nil),
+		(*AgentResponse_IssuedCertificate)(nil),
+		(*AgentResponse_Reconnect)(nil),
+	}
+	file_agentservice_proto_msgTypes[5].OneofWrappers = []any{
+		(*ServerTask_IssueCertificate)(nil),
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_agentservice_proto_rawDesc), len(file_agentservice_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   5,
+			NumEnums:      2,
+			NumMessages:   20,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_agentservice_proto_goTypes,
 		DependencyIndexes: file_agentservice_proto_depIdxs,
+		EnumInfos:         file_agentservice_proto_enumTypes,
 		MessageInfos:      file_agentservice_proto_msgTypes,
 	}.Build()
 	File_agentservice_proto = out.File
@@ -0,0 +1,1642 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        v6.31.1
+// source: managementservice.proto
+
+package v1
+
+import (
+	v1 "github.com/salzr/acert/proto/agentservice/v1"
+	_ "google.golang.org/genproto/googleapis/api/annotations"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Agent is an enrolled agent. Times are unix seconds, zero when unset.
+type Agent struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Hostname    string                 `protobuf:"bytes,2,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Ip          string                 `protobuf:"bytes,3,opt,name=ip,proto3" json:"ip,omitempty"`
+	Description string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	CreatedAt   int64                  `protobuf:"varint,5,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	RevokedAt   int64                  `protobuf:"varint,6,opt,name=revoked_at,json=revokedAt,proto3" json:"revoked_at,omitempty"`
+	Version     string                 `protobuf:"bytes,7,opt,name=version,proto3" json:"version,omitempty"`
+	LastSeen    int64                  `protobuf:"varint,8,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	// Either online, stale or offline.
+	Presence string `protobuf:"bytes,9,opt,name=presence,proto3" json:"presence,omitempty"`
+	// Set while the agent holds a Poll stream.
+	Connection    *AgentConnection `protobuf:"bytes,10,opt,name=connection,proto3" json:"connection,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Agent) Reset() {
+	*x = Agent{}
+	mi := &file_managementservice_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Agent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Agent) ProtoMessage() {}
+
+func (x *Agent) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Agent.ProtoReflect.Descriptor instead.
+func (*Agent) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Agent) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Agent) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Agent) GetIp() string {
+	if x != nil {
+		return x.Ip
+	}
+	return ""
+}
+
+func (x *Agent) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Agent) GetCreatedAt() int64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+func (x *Agent) GetRevokedAt() int64 {
+	if x != nil {
+		return x.RevokedAt
+	}
+	return 0
+}
+
+func (x *Agent) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Agent) GetLastSeen() int64 {
+	if x != nil {
+		return x.LastSeen
+	}
+	return 0
+}
+
+func (x *Agent) GetPresence() string {
+	if x != nil {
+		return x.Presence
+	}
+	return ""
+}
+
+func (x *Agent) GetConnection() *AgentConnection {
+	if x != nil {
+		return x.Connection
+	}
+	return nil
+}
+
+type AgentConnection struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	RemoteAddr    string                 `protobuf:"bytes,1,opt,name=remote_addr,json=remoteAddr,proto3" json:"remote_addr,omitempty"`
+	ConnectedAt   int64                  `protobuf:"varint,2,opt,name=connected_at,json=connectedAt,proto3" json:"connected_at,omitempty"`
+	LastHeartbeat int64                  `protobuf:"varint,3,opt,name=last_heartbeat,json=lastHeartbeat,proto3" json:"last_heartbeat,omitempty"`
+	Capabilities  []string               `protobuf:"bytes,4,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	// Hex encoded serial number of the client certificate the agent connected with.
+	CertificateSerial   string `protobuf:"bytes,5,opt,name=certificate_serial,json=certificateSerial,proto3" json:"certificate_serial,omitempty"`
+	CertificateNotAfter int64  `protobuf:"varint,6,opt,name=certificate_not_after,json=certificateNotAfter,proto3" json:"certificate_not_after,omitempty"`
+	unknownFields       protoimpl.UnknownFields
+	sizeCache           protoimpl.SizeCache
+}
+
+func (x *AgentConnection) Reset() {
+	*x = AgentConnection{}
+	mi := &file_managementservice_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentConnection) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentConnection) ProtoMessage() {}
+
+func (x *AgentConnection) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentConnection.ProtoReflect.Descriptor instead.
+func (*AgentConnection) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AgentConnection) GetRemoteAddr() string {
+	if x != nil {
+		return x.RemoteAddr
+	}
+	return ""
+}
+
+func (x *AgentConnection) GetConnectedAt() int64 {
+	if x != nil {
+		return x.ConnectedAt
+	}
+	return 0
+}
+
+func (x *AgentConnection) GetLastHeartbeat() int64 {
+	if x != nil {
+		return x.LastHeartbeat
+	}
+	return 0
+}
+
+func (x *AgentConnection) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *AgentConnection) GetCertificateSerial() string {
+	if x != nil {
+		return x.CertificateSerial
+	}
+	return ""
+}
+
+func (x *AgentConnection) GetCertificateNotAfter() int64 {
+	if x != nil {
+		return x.CertificateNotAfter
+	}
+	return 0
+}
+
+type ListAgentsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAgentsRequest) Reset() {
+	*x = ListAgentsRequest{}
+	mi := &file_managementservice_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAgentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAgentsRequest) ProtoMessage() {}
+
+func (x *ListAgentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAgentsRequest.ProtoReflect.Descriptor instead.
+func (*ListAgentsRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{2}
+}
+
+type ListAgentsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Agents        []*Agent               `protobuf:"bytes,1,rep,name=agents,proto3" json:"agents,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAgentsResponse) Reset() {
+	*x = ListAgentsResponse{}
+	mi := &file_managementservice_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAgentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAgentsResponse) ProtoMessage() {}
+
+func (x *ListAgentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAgentsResponse.ProtoReflect.Descriptor instead.
+func (*ListAgentsResponse) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListAgentsResponse) GetAgents() []*Agent {
+	if x != nil {
+		return x.Agents
+	}
+	return nil
+}
+
+type GetAgentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       int64                  `protobuf:"varint,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetAgentRequest) Reset() {
+	*x = GetAgentRequest{}
+	mi := &file_managementservice_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetAgentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetAgentRequest) ProtoMessage() {}
+
+func (x *GetAgentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetAgentRequest.ProtoReflect.Descriptor instead.
+func (*GetAgentRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetAgentRequest) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+type RevokeAgentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       int64                  `protobuf:"varint,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeAgentRequest) Reset() {
+	*x = RevokeAgentRequest{}
+	mi := &file_managementservice_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeAgentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeAgentRequest) ProtoMessage() {}
+
+func (x *RevokeAgentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeAgentRequest.ProtoReflect.Descriptor instead.
+func (*RevokeAgentRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RevokeAgentRequest) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+type ListCertificatesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       int64                  `protobuf:"varint,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCertificatesRequest) Reset() {
+	*x = ListCertificatesRequest{}
+	mi := &file_managementservice_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCertificatesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCertificatesRequest) ProtoMessage() {}
+
+func (x *ListCertificatesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCertificatesRequest.ProtoReflect.Descriptor instead.
+func (*ListCertificatesRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListCertificatesRequest) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+type ListCertificatesResponse struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	Certificates []*v1.CertificateInfo  `protobuf:"bytes,1,rep,name=certificates,proto3" json:"certificates,omitempty"`
+	// When the inventory was reported, zero when the agent has not reported one.
+	ReportedAt    int64 `protobuf:"varint,2,opt,name=reported_at,json=reportedAt,proto3" json:"reported_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCertificatesResponse) Reset() {
+	*x = ListCertificatesResponse{}
+	mi := &file_managementservice_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCertificatesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCertificatesResponse) ProtoMessage() {}
+
+func (x *ListCertificatesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCertificatesResponse.ProtoReflect.Descriptor instead.
+func (*ListCertificatesResponse) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListCertificatesResponse) GetCertificates() []*v1.CertificateInfo {
+	if x != nil {
+		return x.Certificates
+	}
+	return nil
+}
+
+func (x *ListCertificatesResponse) GetReportedAt() int64 {
+	if x != nil {
+		return x.ReportedAt
+	}
+	return 0
+}
+
+type ListAllCertificatesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Only list the certificates of this agent, zero lists those of every agent.
+	AgentId int64 `protobuf:"varint,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// Only list the certificates expiring within this many seconds, zero lists all.
+	ExpiringWithinSeconds int64 `protobuf:"varint,2,opt,name=expiring_within_seconds,json=expiringWithinSeconds,proto3" json:"expiring_within_seconds,omitempty"`
+	unknownFields         protoimpl.UnknownFields
+	sizeCache             protoimpl.SizeCache
+}
+
+func (x *ListAllCertificatesRequest) Reset() {
+	*x = ListAllCertificatesRequest{}
+	mi := &file_managementservice_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAllCertificatesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAllCertificatesRequest) ProtoMessage() {}
+
+func (x *ListAllCertificatesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAllCertificatesRequest.ProtoReflect.Descriptor instead.
+func (*ListAllCertificatesRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListAllCertificatesRequest) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+func (x *ListAllCertificatesRequest) GetExpiringWithinSeconds() int64 {
+	if x != nil {
+		return x.ExpiringWithinSeconds
+	}
+	return 0
+}
+
+type ListAllCertificatesResponse struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Certificates  []*InventoryCertificate `protobuf:"bytes,1,rep,name=certificates,proto3" json:"certificates,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAllCertificatesResponse) Reset() {
+	*x = ListAllCertificatesResponse{}
+	mi := &file_managementservice_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAllCertificatesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAllCertificatesResponse) ProtoMessage() {}
+
+func (x *ListAllCertificatesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAllCertificatesResponse.ProtoReflect.Descriptor instead.
+func (*ListAllCertificatesResponse) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ListAllCertificatesResponse) GetCertificates() []*InventoryCertificate {
+	if x != nil {
+		return x.Certificates
+	}
+	return nil
+}
+
+// InventoryCertificate is a certificate in the inventory of an agent.
+type InventoryCertificate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       int64                  `protobuf:"varint,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Certificate   *v1.CertificateInfo    `protobuf:"bytes,2,opt,name=certificate,proto3" json:"certificate,omitempty"`
+	ReportedAt    int64                  `protobuf:"varint,3,opt,name=reported_at,json=reportedAt,proto3" json:"reported_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InventoryCertificate) Reset() {
+	*x = InventoryCertificate{}
+	mi := &file_managementservice_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InventoryCertificate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InventoryCertificate) ProtoMessage() {}
+
+func (x *InventoryCertificate) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InventoryCertificate.ProtoReflect.Descriptor instead.
+func (*InventoryCertificate) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *InventoryCertificate) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+func (x *InventoryCertificate) GetCertificate() *v1.CertificateInfo {
+	if x != nil {
+		return x.Certificate
+	}
+	return nil
+}
+
+func (x *InventoryCertificate) GetReportedAt() int64 {
+	if x != nil {
+		return x.ReportedAt
+	}
+	return 0
+}
+
+type CreateTokenRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// How long the token can be used, the server default when unset.
+	TtlSeconds    int64 `protobuf:"varint,1,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateTokenRequest) Reset() {
+	*x = CreateTokenRequest{}
+	mi := &file_managementservice_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateTokenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateTokenRequest) ProtoMessage() {}
+
+func (x *CreateTokenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateTokenRequest.ProtoReflect.Descriptor instead.
+func (*CreateTokenRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *CreateTokenRequest) GetTtlSeconds() int64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+type CreateTokenResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The token is only returned here and cannot be recovered later.
+	Token         string `protobuf:"bytes,1,opt,name=token,proto3" json:"token,omitempty"`
+	TicketId      int64  `protobuf:"varint,2,opt,name=ticket_id,json=ticketId,proto3" json:"ticket_id,omitempty"`
+	ExpiresAt     int64  `protobuf:"varint,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateTokenResponse) Reset() {
+	*x = CreateTokenResponse{}
+	mi := &file_managementservice_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateTokenResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateTokenResponse) ProtoMessage() {}
+
+func (x *CreateTokenResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateTokenResponse.ProtoReflect.Descriptor instead.
+func (*CreateTokenResponse) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *CreateTokenResponse) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *CreateTokenResponse) GetTicketId() int64 {
+	if x != nil {
+		return x.TicketId
+	}
+	return 0
+}
+
+func (x *CreateTokenResponse) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+// Token is a join token without the token itself. Times are unix seconds, zero when unset.
+type Token struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	TicketId  int64                  `protobuf:"varint,1,opt,name=ticket_id,json=ticketId,proto3" json:"ticket_id,omitempty"`
+	CreatedAt int64                  `protobuf:"varint,2,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	ExpiresAt int64                  `protobuf:"varint,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	UsedAt    int64                  `protobuf:"varint,4,opt,name=used_at,json=usedAt,proto3" json:"used_at,omitempty"`
+	RevokedAt int64                  `protobuf:"varint,5,opt,name=revoked_at,json=revokedAt,proto3" json:"revoked_at,omitempty"`
+	// One of valid, used, revoked or expired.
+	State         string `protobuf:"bytes,6,opt,name=state,proto3" json:"state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Token) Reset() {
+	*x = Token{}
+	mi := &file_managementservice_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Token) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Token) ProtoMessage() {}
+
+func (x *Token) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Token.ProtoReflect.Descriptor instead.
+func (*Token) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *Token) GetTicketId() int64 {
+	if x != nil {
+		return x.TicketId
+	}
+	return 0
+}
+
+func (x *Token) GetCreatedAt() int64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+func (x *Token) GetExpiresAt() int64 {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return 0
+}
+
+func (x *Token) GetUsedAt() int64 {
+	if x != nil {
+		return x.UsedAt
+	}
+	return 0
+}
+
+func (x *Token) GetRevokedAt() int64 {
+	if x != nil {
+		return x.RevokedAt
+	}
+	return 0
+}
+
+func (x *Token) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+type ListTokensRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTokensRequest) Reset() {
+	*x = ListTokensRequest{}
+	mi := &file_managementservice_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTokensRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTokensRequest) ProtoMessage() {}
+
+func (x *ListTokensRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTokensRequest.ProtoReflect.Descriptor instead.
+func (*ListTokensRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{14}
+}
+
+type ListTokensResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tokens        []*Token               `protobuf:"bytes,1,rep,name=tokens,proto3" json:"tokens,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTokensResponse) Reset() {
+	*x = ListTokensResponse{}
+	mi := &file_managementservice_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTokensResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTokensResponse) ProtoMessage() {}
+
+func (x *ListTokensResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTokensResponse.ProtoReflect.Descriptor instead.
+func (*ListTokensResponse) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ListTokensResponse) GetTokens() []*Token {
+	if x != nil {
+		return x.Tokens
+	}
+	return nil
+}
+
+type RevokeTokenRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TicketId      int64                  `protobuf:"varint,1,opt,name=ticket_id,json=ticketId,proto3" json:"ticket_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RevokeTokenRequest) Reset() {
+	*x = RevokeTokenRequest{}
+	mi := &file_managementservice_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RevokeTokenRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RevokeTokenRequest) ProtoMessage() {}
+
+func (x *RevokeTokenRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RevokeTokenRequest.ProtoReflect.Descriptor instead.
+func (*RevokeTokenRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *RevokeTokenRequest) GetTicketId() int64 {
+	if x != nil {
+		return x.TicketId
+	}
+	return 0
+}
+
+type CreateTaskRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	AgentId int64                  `protobuf:"varint,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// Types that are valid to be assigned to Task:
+	//
+	//	*CreateTaskRequest_IssueCertificate
+	Task          isCreateTaskRequest_Task `protobuf_oneof:"task"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateTaskRequest) Reset() {
+	*x = CreateTaskRequest{}
+	mi := &file_managementservice_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateTaskRequest) ProtoMessage() {}
+
+func (x *CreateTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateTaskRequest.ProtoReflect.Descriptor instead.
+func (*CreateTaskRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *CreateTaskRequest) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+func (x *CreateTaskRequest) GetTask() isCreateTaskRequest_Task {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+func (x *CreateTaskRequest) GetIssueCertificate() *v1.IssueCertificate {
+	if x != nil {
+		if x, ok := x.Task.(*CreateTaskRequest_IssueCertificate); ok {
+			return x.IssueCertificate
+		}
+	}
+	return nil
+}
+
+type isCreateTaskRequest_Task interface {
+	isCreateTaskRequest_Task()
+}
+
+type CreateTaskRequest_IssueCertificate struct {
+	IssueCertificate *v1.IssueCertificate `protobuf:"bytes,2,opt,name=issue_certificate,json=issueCertificate,proto3,oneof"`
+}
+
+func (*CreateTaskRequest_IssueCertificate) isCreateTaskRequest_Task() {}
+
+// Task is work queued for an agent. Times are unix seconds, zero when unset.
+type Task struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Id      string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	AgentId int64                  `protobuf:"varint,2,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	// The task type, e.g. issue-certificate.
+	Type string `protobuf:"bytes,3,opt,name=type,proto3" json:"type,omitempty"`
+	// One of pending, dispatched, running, succeeded, failed, timed_out or canceled.
+	Status       string             `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	Message      string             `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+	ErrorCode    string             `protobuf:"bytes,6,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	Artifacts    []*v1.TaskArtifact `protobuf:"bytes,7,rep,name=artifacts,proto3" json:"artifacts,omitempty"`
+	CreatedAt    int64              `protobuf:"varint,8,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt    int64              `protobuf:"varint,9,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	DispatchedAt int64              `protobuf:"varint,10,opt,name=dispatched_at,json=dispatchedAt,proto3" json:"dispatched_at,omitempty"`
+	FinishedAt   int64              `protobuf:"varint,11,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	Attempts     int32              `protobuf:"varint,12,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	// Types that are valid to be assigned to Spec:
+	//
+	//	*Task_IssueCertificate
+	Spec          isTask_Spec `protobuf_oneof:"spec"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Task) Reset() {
+	*x = Task{}
+	mi := &file_managementservice_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Task) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Task) ProtoMessage() {}
+
+func (x *Task) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Task.ProtoReflect.Descriptor instead.
+func (*Task) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *Task) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Task) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+func (x *Task) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Task) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Task) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *Task) GetErrorCode() string {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return ""
+}
+
+func (x *Task) GetArtifacts() []*v1.TaskArtifact {
+	if x != nil {
+		return x.Artifacts
+	}
+	return nil
+}
+
+func (x *Task) GetCreatedAt() int64 {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return 0
+}
+
+func (x *Task) GetUpdatedAt() int64 {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return 0
+}
+
+func (x *Task) GetDispatchedAt() int64 {
+	if x != nil {
+		return x.DispatchedAt
+	}
+	return 0
+}
+
+func (x *Task) GetFinishedAt() int64 {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return 0
+}
+
+func (x *Task) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
+}
+
+func (x *Task) GetSpec() isTask_Spec {
+	if x != nil {
+		return x.Spec
+	}
+	return nil
+}
+
+func (x *Task) GetIssueCertificate() *v1.IssueCertificate {
+	if x != nil {
+		if x, ok := x.Spec.(*Task_IssueCertificate); ok {
+			return x.IssueCertificate
+		}
+	}
+	return nil
+}
+
+type isTask_Spec interface {
+	isTask_Spec()
+}
+
+type Task_IssueCertificate struct {
+	IssueCertificate *v1.IssueCertificate `protobuf:"bytes,13,opt,name=issue_certificate,json=issueCertificate,proto3,oneof"`
+}
+
+func (*Task_IssueCertificate) isTask_Spec() {}
+
+type ListTasksRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AgentId       int64                  `protobuf:"varint,1,opt,name=agent_id,json=agentId,proto3" json:"agent_id,omitempty"`
+	Statuses      []string               `protobuf:"bytes,2,rep,name=statuses,proto3" json:"statuses,omitempty"`
+	Limit         int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksRequest) Reset() {
+	*x = ListTasksRequest{}
+	mi := &file_managementservice_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksRequest) ProtoMessage() {}
+
+func (x *ListTasksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksRequest.ProtoReflect.Descriptor instead.
+func (*ListTasksRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ListTasksRequest) GetAgentId() int64 {
+	if x != nil {
+		return x.AgentId
+	}
+	return 0
+}
+
+func (x *ListTasksRequest) GetStatuses() []string {
+	if x != nil {
+		return x.Statuses
+	}
+	return nil
+}
+
+func (x *ListTasksRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ListTasksResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Tasks         []*Task                `protobuf:"bytes,1,rep,name=tasks,proto3" json:"tasks,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListTasksResponse) Reset() {
+	*x = ListTasksResponse{}
+	mi := &file_managementservice_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListTasksResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListTasksResponse) ProtoMessage() {}
+
+func (x *ListTasksResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListTasksResponse.ProtoReflect.Descriptor instead.
+func (*ListTasksResponse) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *ListTasksResponse) GetTasks() []*Task {
+	if x != nil {
+		return x.Tasks
+	}
+	return nil
+}
+
+type GetTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTaskRequest) Reset() {
+	*x = GetTaskRequest{}
+	mi := &file_managementservice_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTaskRequest) ProtoMessage() {}
+
+func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTaskRequest.ProtoReflect.Descriptor instead.
+func (*GetTaskRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *GetTaskRequest) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+type CancelTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CancelTaskRequest) Reset() {
+	*x = CancelTaskRequest{}
+	mi := &file_managementservice_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CancelTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CancelTaskRequest) ProtoMessage() {}
+
+func (x *CancelTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_managementservice_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CancelTaskRequest.ProtoReflect.Descriptor instead.
+func (*CancelTaskRequest) Descriptor() ([]byte, []int) {
+	return file_managementservice_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *CancelTaskRequest) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+var File_managementservice_proto protoreflect.FileDescriptor
+
+const file_managementservice_proto_rawDesc = "" +
+	"\n" +
+	"\x17managementservice.proto\x12\x02v1\x1a\x12agentservice.proto\x1a\x1cgoogle/api/annotations.proto\"\xab\x02\n" +
+	"\x05Agent\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1a\n" +
+	"\bhostname\x18\x02 \x01(\tR\bhostname\x12\x0e\n" +
+	"\x02ip\x18\x03 \x01(\tR\x02ip\x12 \n" +
+	"\vdescription\x18\x04 \x01(\tR\vdescription\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\x05 \x01(\x03R\tcreatedAt\x12\x1d\n" +
+	"\n" +
+	"revoked_at\x18\x06 \x01(\x03R\trevokedAt\x12\x18\n" +
+	"\aversion\x18\a \x01(\tR\aversion\x12\x1b\n" +
+	"\tlast_seen\x18\b \x01(\x03R\blastSeen\x12\x1a\n" +
+	"\bpresence\x18\t \x01(\tR\bpresence\x123\n" +
+	"\n" +
+	"connection\x18\n" +
+	" \x01(\v2\x13.v1.AgentConnectionR\n" +
+	"connection\"\x83\x02\n" +
+	"\x0fAgentConnection\x12\x1f\n" +
+	"\vremote_addr\x18\x01 \x01(\tR\n" +
+	"remoteAddr\x12!\n" +
+	"\fconnected_at\x18\x02 \x01(\x03R\vconnectedAt\x12%\n" +
+	"\x0elast_heartbeat\x18\x03 \x01(\x03R\rlastHeartbeat\x12\"\n" +
+	"\fcapabilities\x18\x04 \x03(\tR\fcapabilities\x12-\n" +
+	"\x12certificate_serial\x18\x05 \x01(\tR\x11certificateSerial\x122\n" +
+	"\x15certificate_not_after\x18\x06 \x01(\x03R\x13certificateNotAfter\"\x13\n" +
+	"\x11ListAgentsRequest\"7\n" +
+	"\x12ListAgentsResponse\x12!\n" +
+	"\x06agents\x18\x01 \x03(\v2\t.v1.AgentR\x06agents\",\n" +
+	"\x0fGetAgentRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\x03R\aagentId\"/\n" +
+	"\x12RevokeAgentRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\x03R\aagentId\"4\n" +
+	"\x17ListCertificatesRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\x03R\aagentId\"t\n" +
+	"\x18ListCertificatesResponse\x127\n" +
+	"\fcertificates\x18\x01 \x03(\v2\x13.v1.CertificateInfoR\fcertificates\x12\x1f\n" +
+	"\vreported_at\x18\x02 \x01(\x03R\n" +
+	"reportedAt\"o\n" +
+	"\x1aListAllCertificatesRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\x03R\aagentId\x126\n" +
+	"\x17expiring_within_seconds\x18\x02 \x01(\x03R\x15expiringWithinSeconds\"[\n" +
+	"\x1bListAllCertificatesResponse\x12<\n" +
+	"\fcertificates\x18\x01 \x03(\v2\x18.v1.InventoryCertificateR\fcertificates\"\x89\x01\n" +
+	"\x14InventoryCertificate\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\x03R\aagentId\x125\n" +
+	"\vcertificate\x18\x02 \x01(\v2\x13.v1.CertificateInfoR\vcertificate\x12\x1f\n" +
+	"\vreported_at\x18\x03 \x01(\x03R\n" +
+	"reportedAt\"5\n" +
+	"\x12CreateTokenRequest\x12\x1f\n" +
+	"\vttl_seconds\x18\x01 \x01(\x03R\n" +
+	"ttlSeconds\"g\n" +
+	"\x13CreateTokenResponse\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\tR\x05token\x12\x1b\n" +
+	"\tticket_id\x18\x02 \x01(\x03R\bticketId\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\x03R\texpiresAt\"\xb0\x01\n" +
+	"\x05Token\x12\x1b\n" +
+	"\tticket_id\x18\x01 \x01(\x03R\bticketId\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\x02 \x01(\x03R\tcreatedAt\x12\x1d\n" +
+	"\n" +
+	"expires_at\x18\x03 \x01(\x03R\texpiresAt\x12\x17\n" +
+	"\aused_at\x18\x04 \x01(\x03R\x06usedAt\x12\x1d\n" +
+	"\n" +
+	"revoked_at\x18\x05 \x01(\x03R\trevokedAt\x12\x14\n" +
+	"\x05state\x18\x06 \x01(\tR\x05state\"\x13\n" +
+	"\x11ListTokensRequest\"7\n" +
+	"\x12ListTokensResponse\x12!\n" +
+	"\x06tokens\x18\x01 \x03(\v2\t.v1.TokenR\x06tokens\"1\n" +
+	"\x12RevokeTokenRequest\x12\x1b\n" +
+	"\tticket_id\x18\x01 \x01(\x03R\bticketId\"{\n" +
+	"\x11CreateTaskRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\x03R\aagentId\x12C\n" +
+	"\x11issue_certificate\x18\x02 \x01(\v2\x14.v1.IssueCertificateH\x00R\x10issueCertificateB\x06\n" +
+	"\x04task\"\xb3\x03\n" +
+	"\x04Task\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x19\n" +
+	"\bagent_id\x18\x02 \x01(\x03R\aagentId\x12\x12\n" +
+	"\x04type\x18\x03 \x01(\tR\x04type\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x18\n" +
+	"\amessage\x18\x05 \x01(\tR\amessage\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x06 \x01(\tR\terrorCode\x12.\n" +
+	"\tartifacts\x18\a \x03(\v2\x10.v1.TaskArtifactR\tartifacts\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\b \x01(\x03R\tcreatedAt\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\t \x01(\x03R\tupdatedAt\x12#\n" +
+	"\rdispatched_at\x18\n" +
+	" \x01(\x03R\fdispatchedAt\x12\x1f\n" +
+	"\vfinished_at\x18\v \x01(\x03R\n" +
+	"finishedAt\x12\x1a\n" +
+	"\battempts\x18\f \x01(\x05R\battempts\x12C\n" +
+	"\x11issue_certificate\x18\r \x01(\v2\x14.v1.IssueCertificateH\x00R\x10issueCertificateB\x06\n" +
+	"\x04spec\"_\n" +
+	"\x10ListTasksRequest\x12\x19\n" +
+	"\bagent_id\x18\x01 \x01(\x03R\aagentId\x12\x1a\n" +
+	"\bstatuses\x18\x02 \x03(\tR\bstatuses\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\"3\n" +
+	"\x11ListTasksResponse\x12\x1e\n" +
+	"\x05tasks\x18\x01 \x03(\v2\b.v1.TaskR\x05tasks\")\n" +
+	"\x0eGetTaskRequest\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\",\n" +
+	"\x11CancelTaskRequest\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId2\xb2\b\n" +
+	"\x11ManagementService\x12O\n" +
+	"\n" +
+	"ListAgents\x12\x15.v1.ListAgentsRequest\x1a\x16.v1.ListAgentsResponse\"\x12\x82\xd3\xe4\x93\x02\f\x12\n" +
+	"/v1/agents\x12I\n" +
+	"\bGetAgent\x12\x13.v1.GetAgentRequest\x1a\t.v1.Agent\"\x1d\x82\xd3\xe4\x93\x02\x17\x12\x15/v1/agents/{agent_id}\x12V\n" +
+	"\vRevokeAgent\x12\x16.v1.RevokeAgentRequest\x1a\t.v1.Agent\"$\x82\xd3\xe4\x93\x02\x1e\"\x1c/v1/agents/{agent_id}:revoke\x12y\n" +
+	"\x10ListCertificates\x12\x1b.v1.ListCertificatesRequest\x1a\x1c.v1.ListCertificatesResponse\"*\x82\xd3\xe4\x93\x02$\x12\"/v1/agents/{agent_id}/certificates\x12p\n" +
+	"\x13ListAllCertificates\x12\x1e.v1.ListAllCertificatesRequest\x1a\x1f.v1.ListAllCertificatesResponse\"\x18\x82\xd3\xe4\x93\x02\x12\x12\x10/v1/certificates\x12U\n" +
+	"\vCreateToken\x12\x16.v1.CreateTokenRequest\x1a\x17.v1.CreateTokenResponse\"\x15\x82\xd3\xe4\x93\x02\x0f:\x01*\"\n" +
+	"/v1/tokens\x12O\n" +
+	"\n" +
+	"ListTokens\x12\x15.v1.ListTokensRequest\x1a\x16.v1.ListTokensResponse\"\x12\x82\xd3\xe4\x93\x02\f\x12\n" +
+	"/v1/tokens\x12W\n" +
+	"\vRevokeToken\x12\x16.v1.RevokeTokenRequest\x1a\t.v1.Token\"%\x82\xd3\xe4\x93\x02\x1f\"\x1d/v1/tokens/{ticket_id}:revoke\x12U\n" +
+	"\n" +
+	"CreateTask\x12\x15.v1.CreateTaskRequest\x1a\b.v1.Task\"&\x82\xd3\xe4\x93\x02 :\x01*\"\x1b/v1/agents/{agent_id}/tasks\x12K\n" +
+	"\tListTasks\x12\x14.v1.ListTasksRequest\x1a\x15.v1.ListTasksResponse\"\x11\x82\xd3\xe4\x93\x02\v\x12\t/v1/tasks\x12D\n" +
+	"\aGetTask\x12\x12.v1.GetTaskRequest\x1a\b.v1.Task\"\x1b\x82\xd3\xe4\x93\x02\x15\x12\x13/v1/tasks/{task_id}\x12Q\n" +
+	"\n" +
+	"CancelTask\x12\x15.v1.CancelTaskRequest\x1a\b.v1.Task\"\"\x82\xd3\xe4\x93\x02\x1c\"\x1a/v1/tasks/{task_id}:cancelB3Z1github.com/salzr/acert/proto/managementservice/v1b\x06proto3"
+
+var (
+	file_managementservice_proto_rawDescOnce sync.Once
+	file_managementservice_proto_rawDescData []byte
+)
+
+func file_managementservice_proto_rawDescGZIP() []byte {
+	file_managementservice_proto_rawDescOnce.Do(func() {
+		file_managementservice_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_managementservice_proto_rawDesc), len(file_managementservice_proto_rawDesc)))
+	})
+	return file_managementservice_proto_rawDescData
+}
+
+var file_managementservice_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
+var file_managementservice_proto_goTypes = []any{
+	(*Agent)(nil),                       // 0: v1.Agent
+	(*AgentConnection)(nil),             // 1: v1.AgentConnection
+	(*ListAgentsRequest)(nil),           // 2: v1.ListAgentsRequest
+	(*ListAgentsResponse)(nil),          // 3: v1.ListAgentsResponse
+	(*GetAgentRequest)(nil),             // 4: v1.GetAgentRequest
+	(*RevokeAgentRequest)(nil),          // 5: v1.RevokeAgentRequest
+	(*ListCertificatesRequest)(nil),     // 6: v1.ListCertificatesRequest
+	(*ListCertificatesResponse)(nil),    // 7: v1.ListCertificatesResponse
+	(*ListAllCertificatesRequest)(nil),  // 8: v1.ListAllCertificatesRequest
+	(*ListAllCertificatesResponse)(nil), // 9: v1.ListAllCertificatesResponse
+	(*InventoryCertificate)(nil),        // 10: v1.InventoryCertificate
+	(*CreateTokenRequest)(nil),          // 11: v1.CreateTokenRequest
+	(*CreateTokenResponse)(nil),         // 12: v1.CreateTokenResponse
+	(*Token)(nil),                       // 13: v1.Token
+	(*ListTokensRequest)(nil),           // 14: v1.ListTokensRequest
+	(*ListTokensResponse)(nil),          // 15: v1.ListTokensResponse
+	(*RevokeTokenRequest)(nil),          // 16: v1.RevokeTokenRequest
+	(*CreateTaskRequest)(nil),           // 17: v1.CreateTaskRequest
+	(*Task)(nil),                        // 18: v1.Task
+	(*ListTasksRequest)(nil),            // 19: v1.ListTasksRequest
+	(*ListTasksResponse)(nil),           // 20: v1.ListTasksResponse
+	(*GetTaskRequest)(nil),              // 21: v1.GetTaskRequest
+	(*CancelTaskRequest)(nil),           // 22: v1.CancelTaskRequest
+	(*v1.CertificateInfo)(nil),          // 23: v1.CertificateInfo
+	(*v1.IssueCertificate)(nil),         // 24: v1.IssueCertificate
+	(*v1.TaskArtifact)(nil),             // 25: v1.TaskArtifact
+}
+var file_managementservice_proto_depIdxs = []int32{
+	1,  // 0: v1.Agent.connection:type_name -> v1.AgentConnection
+	0,  // 1: v1.ListAgentsResponse.agents:type_name -> v1.Agent
+	23, // 2: v1.ListCertificatesResponse.certificates:type_name -> v1.CertificateInfo
+	10, // 3: v1.ListAllCertificatesResponse.certificates:type_name -> v1.InventoryCertificate
+	23, // 4: v1.InventoryCertificate.certificate:type_name -> v1.CertificateInfo
+	13, // 5: v1.ListTokensResponse.tokens:type_name -> v1.Token
+	24, // 6: v1.CreateTaskRequest.issue_certificate:type_name -> v1.IssueCertificate
+	25, // 7: v1.Task.artifacts:type_name -> v1.TaskArtifact
+	24, // 8: v1.Task.issue_certificate:type_name -> v1.IssueCertificate
+	18, // 9: v1.ListTasksResponse.tasks:type_name -> v1.Task
+	2,  // 10: v1.ManagementService.ListAgents:input_type -> v1.ListAgentsRequest
+	4,  // 11: v1.ManagementService.GetAgent:input_type -> v1.GetAgentRequest
+	5,  // 12: v1.ManagementService.RevokeAgent:input_type -> v1.RevokeAgentRequest
+	6,  // 13: v1.ManagementService.ListCertificates:input_type -> v1.ListCertificatesRequest
+	8,  // 14: v1.ManagementService.ListAllCertificates:input_type -> v1.ListAllCertificatesRequest
+	11, // 15: v1.ManagementService.CreateToken:input_type -> v1.CreateTokenRequest
+	14, // 16: v1.ManagementService.ListTokens:input_type -> v1.ListTokensRequest
+	16, // 17: v1.ManagementService.RevokeToken:input_type -> v1.RevokeTokenRequest
+	17, // 18: v1.ManagementService.CreateTask:input_type -> v1.CreateTaskRequest
+	19, // 19: v1.ManagementService.ListTasks:input_type -> v1.ListTasksRequest
+	21, // 20: v1.ManagementService.GetTask:input_type -> v1.GetTaskRequest
+	22, // 21: v1.ManagementService.CancelTask:input_type -> v1.CancelTaskRequest
+	3,  // 22: v1.ManagementService.ListAgents:output_type -> v1.ListAgentsResponse
+	0,  // 23: v1.ManagementService.GetAgent:output_type -> v1.Agent
+	0,  // 24: v1.ManagementService.RevokeAgent:output_type -> v1.Agent
+	7,  // 25: v1.ManagementService.ListCertificates:output_type -> v1.ListCertificatesResponse
+	9,  // 26: v1.ManagementService.ListAllCertificates:output_type -> v1.ListAllCertificatesResponse
+	12, // 27: v1.ManagementService.CreateToken:output_type -> v1.CreateTokenResponse
+	15, // 28: v1.ManagementService.ListTokens:output_type -> v1.ListTokensResponse
+	13, // 29: v1.ManagementService.RevokeToken:output_type -> v1.Token
+	18, // 30: v1.ManagementService.CreateTask:output_type -> v1.Task
+	20, // 31: v1.ManagementService.ListTasks:output_type -> v1.ListTasksResponse
+	18, // 32: v1.ManagementService.GetTask:output_type -> v1.Task
+	18, // 33: v1.ManagementService.CancelTask:output_type -> v1.Task
+	22, // [22:34] is the sub-list for method output_type
+	10, // [10:22] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
+}
+
+func init() { file_managementservice_proto_init() }
+func file_managementservice_proto_init() {
+	if File_managementservice_proto != nil {
+		return
+	}
+	file_managementservice_proto_msgTypes[17].OneofWrappers = []any{
+		(*CreateTaskRequest_IssueCertificate)(nil),
+	}
+	file_managementservice_proto_msgTypes[18].OneofWrappers = []any{
+		(*Task_IssueCertificate)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_managementservice_proto_rawDesc), len(file_managementservice_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   23,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_managementservice_proto_goTypes,
+		DependencyIndexes: file_managementservice_proto_depIdxs,
+		MessageInfos:      file_managementservice_proto_msgTypes,
+	}.Build()
+	File_managementservice_proto = out.File
+	file_managementservice_proto_goTypes = nil
+	file_managementservice_proto_depIdxs = nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"os/user"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/salzr/acert/store"
+)
+
+// Audited actions. Targets name what was acted on as kind:id, e.g. agent:42 or task:<uuid>.
+const (
+	AuditTokenCreate      = "token.create"
+	AuditTokenRevoke      = "token.revoke"
+	AuditAgentEnroll      = "agent.enroll"
+	AuditAgentRenew       = "agent.renew"
+	AuditAgentRevoke      = "agent.revoke"
+	AuditCertificateIssue = "certificate.issue"
+	AuditTaskCreate       = "task.create"
+	AuditTaskCancel       = "task.cancel"
+	AuditTaskFinish       = "task.finish"
+	AuditTaskTimeOut      = "task.time_out"
+)
+
+// Actor is who performs an audited action.
+type Actor struct {
+	Name string
+	// AgentID and AgentSerial identify an agent by its mTLS client certificate.
+	AgentID     int64
+	AgentSerial string
+}
+
+// serverActor performs the actions of the server itself, such as timing out tasks.
+var serverActor = Actor{Name: "server"}
+
+// CLIActor is the local user running an acert server command against the store.
+func CLIActor() Actor {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return Actor{Name: "cli:" + name}
+}
+
+// agentActor is the agent calling with cert.
+func agentActor(agentID int64, cert *x509.Certificate) Actor {
+	a := Actor{Name: AgentTarget(agentID), AgentID: agentID}
+	if cert != nil {
+		a.AgentSerial = certificateSerial(cert)
+	}
+	return a
+}
+
+// managementActor is the caller of the ManagementService with the bearer token of tokenHash.
+// The hash is shortened, it only needs to tell the tokens of the token file apart.
+func managementActor(tokenHash string) Actor {
+	return Actor{Name: "management:" + tokenHash[:min(len(tokenHash), 12)]}
+}
+
+// Audit appends an event for the action of actor on target to the audit log of st. The action
+// failed when err is not nil, its message is recorded after the detail.
+func Audit(ctx context.Context, st store.Repository, actor Actor, action, target, detail string, err error) error {
+	e := &store.AuditEvent{
+		Time:        time.Now(),
+		Actor:       actor.Name,
+		AgentID:     actor.AgentID,
+		AgentSerial: actor.AgentSerial,
+		Action:      action,
+		Target:      target,
+		Result:      store.AuditSuccess,
+		Detail:      detail,
+	}
+	if err != nil {
+		e.Result = store.AuditFailure
+		e.Detail = err.Error()
+		if detail != "" {
+			e.Detail = detail + ": " + err.Error()
+		}
+	}
+	return st.AppendAuditEvent(ctx, e)
+}
+
+// audit records an action in the audit log. The action already happened, failing to record it
+// is logged rather than failing the call.
+func (s *server) audit(ctx context.Context, actor Actor, action, target, detail string, err error) {
+	// The event is recorded even when the call was canceled after the action.
+	ctx = context.WithoutCancel(ctx)
+	if err := Audit(ctx, s.store, actor, action, target, detail, err); err != nil {
+		s.logger.Error("failed to record audit event", zap.String("action", action), zap.String("target", target),
+			zap.Error(err))
+	}
+}
+
+// AgentTarget, TicketTarget and TaskTarget name the targets of audit events.
+func AgentTarget(id int64) string {
+	return "agent:" + strconv.FormatInt(id, 10)
+}
+
+func TicketTarget(t *store.Ticket) string {
+	return "ticket:" + strconv.FormatInt(t.ID, 10)
+}
+
+func TaskTarget(id string) string {
+	return "task:" + id
+}
+
+// TicketDetail and TaskDetail describe created tickets and tasks in audit events.
+func TicketDetail(t *store.Ticket) string {
+	return "expires " + t.ExpiresAt.UTC().Format(time.RFC3339)
+}
+
+func TaskDetail(agentID int64, taskType string) string {
+	return taskType + " for " + AgentTarget(agentID)
+}
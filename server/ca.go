@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/salzr/acert/store"
+)
+
+// agentIdentityURI is the URI SAN placed in every agent certificate.
+const agentIdentityURI = "spiffe://acert/agent/%s"
+
+// certificateAuthority signs agent client certificates.
+type certificateAuthority struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+func loadCertificateAuthority(certFile, keyFile string) (*certificateAuthority, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load ca keypair: %w", err)
+	}
+	return newCertificateAuthority(pair)
+}
+
+func newCertificateAuthority(pair tls.Certificate) (*certificateAuthority, error) {
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ca certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a ca", cert.Subject.CommonName)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("ca private key cannot sign")
+	}
+	return &certificateAuthority{cert: cert, key: key}, nil
+}
+
+// PEM returns the PEM encoded CA certificate.
+func (ca *certificateAuthority) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+// parseCSR decodes a PEM encoded certificate request and verifies its signature.
+func parseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("csr is not a PEM encoded certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid csr signature: %w", err)
+	}
+	return csr, nil
+}
+
+// SignAgent issues a client certificate for agentID and returns it along with its PEM encoding.
+// The subject and extensions requested in the CSR are ignored, only its public key is used.
+// revocationURL is the base URL the CRL and OCSP responder of the CA are served at, the
+// certificate points to them unless it is empty.
+func (ca *certificateAuthority) SignAgent(csr *x509.CertificateRequest, agentID string, ttl time.Duration, revocationURL string) (*x509.Certificate, []byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+	uri, err := url.Parse(fmt.Sprintf(agentIdentityURI, agentID))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(ttl)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: agentID},
+		URIs:                  []*url.URL{uri},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	if revocationURL != "" {
+		base := strings.TrimSuffix(revocationURL, "/")
+		tmpl.CRLDistributionPoints = []string{base + crlPath}
+		tmpl.OCSPServer = []string{base + ocspPath}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// CRL returns a DER encoded CRL listing the revoked certs, valid from now until nextUpdate.
+// The number orders CRLs of the same CA and must grow with every CRL.
+func (ca *certificateAuthority) CRL(revoked []*store.AgentCertificate, number int64, now, nextUpdate time.Time) ([]byte, error) {
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, c := range revoked {
+		serial, ok := new(big.Int).SetString(c.Serial, 16)
+		if !ok || c.Revoked == nil {
+			return nil, fmt.Errorf("invalid revoked certificate %q", c.Serial)
+		}
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: *c.Revoked})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(number),
+		ThisUpdate:                now,
+		NextUpdate:                nextUpdate,
+	}, ca.cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign crl: %w", err)
+	}
+	return der, nil
+}
+
+// OCSP answers an OCSP request for a certificate of the CA. status is ocsp.Good, ocsp.Revoked
+// or ocsp.Unknown, revokedAt is only used for revoked certificates. Requests for another
+// issuer are answered with ocsp.UnauthorizedErrorResponse.
+func (ca *certificateAuthority) OCSP(req *ocsp.Request, status int, revokedAt, now, nextUpdate time.Time) ([]byte, error) {
+	if !ca.issued(req) {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+	res, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		SerialNumber: req.SerialNumber,
+		Status:       status,
+		RevokedAt:    revokedAt,
+		ThisUpdate:   now,
+		NextUpdate:   nextUpdate,
+	}, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign ocsp response: %w", err)
+	}
+	return res, nil
+}
+
+// issued reports whether req names the CA as the issuer by its name and key hashes.
+func (ca *certificateAuthority) issued(req *ocsp.Request) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(ca.cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	nameHash := req.HashAlgorithm.New()
+	nameHash.Write(ca.cert.RawSubject)
+	keyHash := req.HashAlgorithm.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+	return bytes.Equal(nameHash.Sum(nil), req.IssuerNameHash) && bytes.Equal(keyHash.Sum(nil), req.IssuerKeyHash)
+}
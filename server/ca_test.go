@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T) *certificateAuthority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "acert-agent-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &certificateAuthority{cert: cert, key: key}
+}
+
+func newTestCSR(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "ignored"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+func TestSignAgent(t *testing.T) {
+	ca := newTestCA(t)
+	csr, err := parseCSR(newTestCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _, err := ca.SignAgent(csr, "42", 48*time.Hour, "https://acert.example.com:8080/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.CRLDistributionPoints) != 1 || cert.CRLDistributionPoints[0] != "https://acert.example.com:8080/agent-ca.crl" {
+		t.Errorf("unexpected crl distribution points %v", cert.CRLDistributionPoints)
+	}
+	if len(cert.OCSPServer) != 1 || cert.OCSPServer[0] != "https://acert.example.com:8080/ocsp" {
+		t.Errorf("unexpected ocsp servers %v", cert.OCSPServer)
+	}
+	if cert.Subject.CommonName != "42" {
+		t.Errorf("expected common name 42, got %s", cert.Subject.CommonName)
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != "spiffe://acert/agent/42" {
+		t.Errorf("unexpected uris %v", cert.URIs)
+	}
+	if cert.NotAfter.After(ca.cert.NotAfter) {
+		t.Errorf("certificate outlives ca: %s > %s", cert.NotAfter, ca.cert.NotAfter)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Errorf("failed to verify agent certificate: %v", err)
+	}
+}
+
+func TestParseCSRRejectsGarbage(t *testing.T) {
+	if _, err := parseCSR([]byte("not a csr")); err == nil {
+		t.Error("expected error")
+	}
+}
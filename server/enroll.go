@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+// Enroll redeems a join token and signs the agent's CSR with the agent CA. Every attempt is
+// audited, including the rejected ones.
+func (s *server) Enroll(ctx context.Context, req *pb.EnrollRequest) (*pb.EnrollResponse, error) {
+	log := s.logger.With(zap.String("hostname", req.GetHostname()))
+
+	// Enrolling hosts have no client certificate yet, they are identified by their address.
+	actor := Actor{Name: "host:" + peerIP(ctx)}
+	host := "hostname:" + req.GetHostname()
+
+	if req.GetToken() == "" || len(req.GetCsr()) == 0 || req.GetHostname() == "" {
+		err := status.Error(codes.InvalidArgument, "token, csr and hostname are required")
+		s.audit(ctx, actor, AuditAgentEnroll, host, "", err)
+		return nil, err
+	}
+	csr, err := parseCSR(req.GetCsr())
+	if err != nil {
+		s.audit(ctx, actor, AuditAgentEnroll, host, "", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	a := &store.Agent{
+		Hostname:    req.GetHostname(),
+		IP:          peerIP(ctx),
+		Description: req.GetDescription(),
+		CreatedAt:   time.Now(),
+	}
+	m := s.material.Load()
+	var issued *x509.Certificate
+	var certPEM []byte
+	// The ticket is only used up once the agent and its certificate are stored, a host whose
+	// enrollment failed can retry with the same token.
+	ticket, err := s.store.EnrollAgent(ctx, hashToken(req.GetToken()), a, time.Now(),
+		func(a *store.Agent) (*store.AgentCertificate, error) {
+			cert, pem, err := m.agentCA.SignAgent(csr, strconv.FormatInt(a.ID, 10), s.agentCertTTL, s.revocationURL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to sign csr: %w", err)
+			}
+			issued, certPEM = cert, pem
+			return agentCertificate(a.ID, cert), nil
+		})
+	if errors.Is(err, store.ErrTicketNotFound) || errors.Is(err, store.ErrTicketTorn) || errors.Is(err, store.ErrTicketExpired) ||
+		errors.Is(err, store.ErrTicketRevoked) {
+		log.Warn("rejected enrollment", zap.Error(err))
+		s.audit(ctx, actor, AuditAgentEnroll, host, "", err)
+		return nil, status.Error(codes.PermissionDenied, "invalid join token")
+	}
+	if err != nil {
+		log.Error("failed to enroll agent", zap.Error(err))
+		// A certificate signed before the enrollment failed is never handed out, the serial
+		// identifies it in the log.
+		detail := ""
+		if issued != nil {
+			detail = "certificate " + certificateSerial(issued)
+		}
+		s.audit(ctx, actor, AuditAgentEnroll, host, detail, err)
+		return nil, status.Error(codes.Internal, "failed to enroll agent")
+	}
+	agentId := strconv.FormatInt(a.ID, 10)
+
+	s.audit(ctx, actor, AuditAgentEnroll, AgentTarget(a.ID),
+		fmt.Sprintf("%s with %s, certificate %s", host, TicketTarget(ticket), certificateSerial(issued)), nil)
+	log.Info("agent enrolled", zap.String("agentId", agentId), zap.String("ip", a.IP))
+	return &pb.EnrollResponse{
+		AgentId:          agentId,
+		CertificateChain: append(certPEM, m.agentCA.PEM()...),
+		CaBundle:         m.serverCA,
+	}, nil
+}
+
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+// failingEnrollStore fails enrollments after the agent certificate was signed.
+type failingEnrollStore struct {
+	store.Repository
+}
+
+func (f failingEnrollStore) EnrollAgent(ctx context.Context, token string, a *store.Agent, now time.Time,
+	sign func(a *store.Agent) (*store.AgentCertificate, error)) (*store.Ticket, error) {
+	return f.Repository.EnrollAgent(ctx, token, a, now, func(a *store.Agent) (*store.AgentCertificate, error) {
+		if _, err := sign(a); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("disk full")
+	})
+}
+
+func TestEnrollAndRenewAudit(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "acert.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	s := &server{logger: zap.NewNop(), store: st, agentCertTTL: time.Hour}
+	s.material.Store(&tlsMaterial{agentCA: newTestCA(t)})
+	token, _, err := CreateToken(ctx, st, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// expectAudit checks the latest event of action.
+	expectAudit := func(action, target, result, detail string) {
+		t.Helper()
+		events, err := st.ListAuditEvents(ctx, store.AuditFilter{Action: action})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 {
+			t.Fatalf("expected %s to be audited", action)
+		}
+		e := events[len(events)-1]
+		if e.Target != target || e.Result != result || !strings.HasPrefix(e.Detail, detail) {
+			t.Errorf("expected %s of %s to be audited as %s with %q, got %+v", action, target, result, detail, e)
+		}
+	}
+
+	req := &pb.EnrollRequest{Hostname: "host", Csr: newTestCSR(t)}
+	if _, err := s.Enroll(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected %s without token, got %v", codes.InvalidArgument, err)
+	}
+	expectAudit(AuditAgentEnroll, "hostname:host", store.AuditFailure, "")
+
+	req.Token = "unknown"
+	if _, err := s.Enroll(ctx, req); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected %s for an unknown token, got %v", codes.PermissionDenied, err)
+	}
+	expectAudit(AuditAgentEnroll, "hostname:host", store.AuditFailure, "")
+
+	// The certificate signed for a failed enrollment is audited and the token can be used again.
+	req.Token = token
+	s.store = failingEnrollStore{st}
+	if _, err := s.Enroll(ctx, req); status.Code(err) != codes.Internal {
+		t.Errorf("expected %s when the enrollment is not stored, got %v", codes.Internal, err)
+	}
+	expectAudit(AuditAgentEnroll, "hostname:host", store.AuditFailure, "certificate ")
+
+	s.store = st
+	res, err := s.Enroll(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectAudit(AuditAgentEnroll, "agent:"+res.AgentId, store.AuditSuccess, "hostname:host with ticket:")
+
+	if _, err := s.Renew(ctx, &pb.RenewRequest{Csr: newTestCSR(t)}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected %s without a client certificate, got %v", codes.Unauthenticated, err)
+	}
+	expectAudit(AuditAgentRenew, "agent", store.AuditFailure, "")
+}
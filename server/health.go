@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const readyTimeout = 2 * time.Second
+
+// ready returns an error unless the server can serve agents, its store must be reachable and
+// its TLS material loaded.
+func (s *server) ready(ctx context.Context) error {
+	if s.material.Load() == nil {
+		return errors.New("tls material not loaded")
+	}
+	return s.store.Ping(ctx)
+}
+
+// serveHealthz reports that the process is alive.
+func (s *server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// serveReadyz reports whether the server is ready to serve agents.
+func (s *server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	if err := s.ready(ctx); err != nil {
+		s.logger.Warn("not ready", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
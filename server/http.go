@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// httpHandler serves the http port, health checks, metrics, the CRL and OCSP responder of the
+// agent CA and, with gateway, the REST gateway of the ManagementService when it is enabled.
+func (s *server) httpHandler(ctx context.Context, gateway bool) (http.Handler, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.serveHealthz)
+	mux.HandleFunc("GET /readyz", s.serveReadyz)
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.metrics.reg, promhttp.HandlerOpts{}))
+	mux.HandleFunc("GET "+crlPath, s.serveCRL)
+	mux.HandleFunc("POST "+ocspPath, s.serveOCSP)
+	mux.HandleFunc("GET "+ocspPath+"/{request...}", s.serveOCSP)
+	if s.management != nil && gateway {
+		h, err := s.management.handler(ctx)
+		if err != nil {
+			return nil, err
+		}
+		mux.Handle("/v1/", h)
+	}
+	return mux, nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/salzr/acert/store"
+)
+
+// agentIdentity returns the id of the agent a client certificate was issued to. It is taken from
+// the spiffe://acert/agent/<id> URI SAN and must match the CN, which is all certificates without
+// the URI SAN carry.
+func agentIdentity(cert *x509.Certificate) (int64, error) {
+	var fromURI string
+	prefix := fmt.Sprintf(agentIdentityURI, "")
+	for _, u := range cert.URIs {
+		if id, ok := strings.CutPrefix(u.String(), prefix); ok {
+			fromURI = id
+			break
+		}
+	}
+	cn := cert.Subject.CommonName
+	if fromURI != "" && cn != "" && fromURI != cn {
+		return 0, fmt.Errorf("certificate uri %s does not match common name %q", prefix+fromURI, cn)
+	}
+	agentID := fromURI
+	if agentID == "" {
+		agentID = cn
+	}
+	id, err := strconv.ParseInt(agentID, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("certificate does not identify an agent")
+	}
+	return id, nil
+}
+
+// certificateSerial is the form serial numbers of agent certificates are stored in.
+func certificateSerial(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
+
+// authenticateAgent resolves the verified client certificate of the caller to an enrolled agent
+// that is not revoked. Certificates recorded for another agent are rejected, as are certificates
+// recorded as revoked, and operator certificates. The returned errors are grpc statuses.
+func (s *server) authenticateAgent(ctx context.Context) (*store.Agent, *x509.Certificate, error) {
+	cert := verifiedPeerCertificate(ctx)
+	if cert == nil {
+		return nil, nil, status.Error(codes.Unauthenticated, "client certificate required")
+	}
+	log := s.logger.With(zap.String("serial", certificateSerial(cert)))
+	if s.material.Load().operator(cert) {
+		log.Warn("rejected operator certificate as agent")
+		return nil, nil, status.Error(codes.PermissionDenied, "unknown agent")
+	}
+
+	id, err := agentIdentity(cert)
+	if err != nil {
+		log.Warn("rejected client certificate", zap.Error(err))
+		return nil, nil, status.Error(codes.PermissionDenied, "unknown agent")
+	}
+	log = log.With(zap.Int64("agentId", id))
+
+	// Certificates issued before serials were recorded are not found and identified by their
+	// subject alone.
+	issued, err := s.store.GetAgentCertificate(ctx, certificateSerial(cert))
+	switch {
+	case errors.Is(err, store.ErrAgentCertificateNotFound):
+	case err != nil:
+		log.Error("failed to get agent certificate", zap.Error(err))
+		return nil, nil, status.Error(codes.Internal, "failed to get agent certificate")
+	case issued.AgentID != id:
+		log.Warn("rejected certificate issued to another agent", zap.Int64("issuedTo", issued.AgentID))
+		return nil, nil, status.Error(codes.PermissionDenied, "unknown agent")
+	case issued.Revoked != nil:
+		log.Warn("rejected revoked certificate")
+		return nil, nil, status.Error(codes.PermissionDenied, "certificate revoked")
+	}
+
+	a, err := s.store.GetAgent(ctx, id)
+	if errors.Is(err, store.ErrAgentNotFound) {
+		log.Warn("rejected certificate of unknown agent")
+		return nil, nil, status.Error(codes.PermissionDenied, "unknown agent")
+	}
+	if err != nil {
+		log.Error("failed to get agent", zap.Error(err))
+		return nil, nil, status.Error(codes.Internal, "failed to get agent")
+	}
+	if a.Revoked != nil {
+		log.Warn("rejected revoked agent")
+		return nil, nil, status.Error(codes.PermissionDenied, "agent revoked")
+	}
+	return a, cert, nil
+}
+
+// recordAgentCertificate stores the serial of a certificate issued to an agent so later
+// connections with it can be tied to the agent and revoked.
+func (s *server) recordAgentCertificate(ctx context.Context, agentID int64, cert *x509.Certificate) error {
+	return s.store.CreateAgentCertificate(ctx, agentCertificate(agentID, cert))
+}
+
+func agentCertificate(agentID int64, cert *x509.Certificate) *store.AgentCertificate {
+	return &store.AgentCertificate{
+		Serial:    certificateSerial(cert),
+		AgentID:   agentID,
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		CreatedAt: time.Now(),
+	}
+}
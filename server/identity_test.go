@@ -0,0 +1,47 @@
+package server
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAgentIdentity(t *testing.T) {
+	csr, err := parseCSR(newTestCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, _, err := newTestCA(t).SignAgent(csr, "42", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := url.Parse("spiffe://acert/agent/7")
+
+	for _, tc := range []struct {
+		name    string
+		cert    *x509.Certificate
+		want    int64
+		wantErr bool
+	}{
+		{name: "signed", cert: signed, want: 42},
+		{name: "common name only", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "3"}}, want: 3},
+		{name: "uri only", cert: &x509.Certificate{URIs: []*url.URL{other}}, want: 7},
+		{name: "mismatch", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "3"}, URIs: []*url.URL{other}}, wantErr: true},
+		{name: "not an agent", cert: &x509.Certificate{Subject: pkix.Name{CommonName: "server.acert.salzr.localhost"}}, wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := agentIdentity(tc.cert)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got agent %d", got)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Errorf("expected agent %d, got %d (%v)", tc.want, got, err)
+			}
+		})
+	}
+}
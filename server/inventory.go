@@ -0,0 +1,28 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+// recordInventory replaces the stored certificate inventory of the agent with the reported one.
+func (s *server) recordInventory(ctx context.Context, agentID int64, inventory *pb.CertificateInventory) error {
+	certs := make([]*store.Certificate, 0, len(inventory.Certificates))
+	for _, c := range inventory.Certificates {
+		certs = append(certs, &store.Certificate{
+			Path:         c.Path,
+			Subject:      c.Subject,
+			SANs:         c.Sans,
+			Issuer:       c.Issuer,
+			Serial:       c.Serial,
+			NotBefore:    time.Unix(c.NotBefore, 0),
+			NotAfter:     time.Unix(c.NotAfter, 0),
+			KeyAlgorithm: c.KeyAlgorithm,
+			Fingerprint:  c.Fingerprint,
+		})
+	}
+	return s.store.ReplaceCertificates(ctx, agentID, certs, time.Now())
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	certmanagerv1 "github.com/cert-manager/cert-manager/pkg/apis/certmanager/v1"
+	certmanagermetav1 "github.com/cert-manager/cert-manager/pkg/apis/meta/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/salzr/acert/certname"
+	"github.com/salzr/acert/k8s"
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+const (
+	// IssuerCA signs host certificates with a local CA key pair, IssuerCertManager through a
+	// cert-manager CertificateRequest.
+	IssuerCA          = "ca"
+	IssuerCertManager = "cert-manager"
+
+	defaultIssuerName = "acert-cluster-certificate-issuer"
+	defaultIssuerKind = "Issuer"
+
+	defaultCertificateDuration = 90 * 24 * time.Hour
+	certificateRequestPoll     = 2 * time.Second
+	certificateRequestTimeout  = 2 * time.Minute
+	taskIDLabel                = "acert.salzr.io/task-id"
+)
+
+// issuer signs the certificates requested by IssueCertificate tasks.
+type issuer interface {
+	// Issue returns the PEM encoded certificate chain and CA for a CSR matching spec.
+	Issue(ctx context.Context, taskID string, csrPEM []byte, spec *pb.IssueCertificate) (chain, ca []byte, err error)
+}
+
+func newIssuer(ctx context.Context, options Options) (issuer, error) {
+	switch options.Issuer {
+	case "":
+		return nil, nil
+	case IssuerCA:
+		ca, err := loadCertificateAuthority(options.IssuerCertFile, options.IssuerKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &caIssuer{ca: ca}, nil
+	case IssuerCertManager:
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rest config: %w", err)
+		}
+		c, err := k8s.NewClient(ctx, cfg, certmanagerv1.SchemeBuilder)
+		if err != nil {
+			return nil, err
+		}
+		return &certManagerIssuer{
+			client:    c,
+			namespace: options.Namespace,
+			ref: certmanagermetav1.IssuerReference{
+				Name:  options.IssuerName,
+				Kind:  options.IssuerKind,
+				Group: "cert-manager.io",
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown issuer %q, expected %s or %s", options.Issuer, IssuerCA, IssuerCertManager)
+}
+
+var keyUsages = map[string]x509.KeyUsage{
+	string(certmanagerv1.UsageDigitalSignature):  x509.KeyUsageDigitalSignature,
+	string(certmanagerv1.UsageContentCommitment): x509.KeyUsageContentCommitment,
+	string(certmanagerv1.UsageKeyEncipherment):   x509.KeyUsageKeyEncipherment,
+	string(certmanagerv1.UsageKeyAgreement):      x509.KeyUsageKeyAgreement,
+	string(certmanagerv1.UsageDataEncipherment):  x509.KeyUsageDataEncipherment,
+}
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	string(certmanagerv1.UsageServerAuth):      x509.ExtKeyUsageServerAuth,
+	string(certmanagerv1.UsageClientAuth):      x509.ExtKeyUsageClientAuth,
+	string(certmanagerv1.UsageCodeSigning):     x509.ExtKeyUsageCodeSigning,
+	string(certmanagerv1.UsageEmailProtection): x509.ExtKeyUsageEmailProtection,
+}
+
+// usages returns the requested usages, or the cert-manager defaults when none are set.
+func usages(spec *pb.IssueCertificate) []string {
+	if len(spec.Usages) == 0 {
+		return []string{string(certmanagerv1.UsageDigitalSignature), string(certmanagerv1.UsageKeyEncipherment)}
+	}
+	return spec.Usages
+}
+
+func duration(spec *pb.IssueCertificate) time.Duration {
+	if spec.DurationSeconds == 0 {
+		return defaultCertificateDuration
+	}
+	return time.Duration(spec.DurationSeconds) * time.Second
+}
+
+// caIssuer signs with a local CA, the subject, SANs and usages come from the task spec.
+type caIssuer struct {
+	ca *certificateAuthority
+}
+
+func (i *caIssuer) Issue(_ context.Context, _ string, csrPEM []byte, spec *pb.IssueCertificate) ([]byte, []byte, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	names, err := certname.Parse(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := names.Template()
+	if tmpl.KeyUsage, tmpl.ExtKeyUsage, err = certificateUsages(spec); err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+	now := time.Now()
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = now.Add(-time.Minute)
+	tmpl.NotAfter = now.Add(duration(spec))
+	if tmpl.NotAfter.After(i.ca.cert.NotAfter) {
+		tmpl.NotAfter = i.ca.cert.NotAfter
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, i.ca.cert, csr.PublicKey, i.ca.key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, i.ca.PEM()...), i.ca.PEM(), nil
+}
+
+// certificateUsages returns the key usages and extended key usages of spec.
+func certificateUsages(spec *pb.IssueCertificate) (x509.KeyUsage, []x509.ExtKeyUsage, error) {
+	var keyUsage x509.KeyUsage
+	var extKeyUsage []x509.ExtKeyUsage
+	for _, usage := range usages(spec) {
+		if ku, ok := keyUsages[usage]; ok {
+			keyUsage |= ku
+		} else if eku, ok := extKeyUsages[usage]; ok {
+			extKeyUsage = append(extKeyUsage, eku)
+		} else {
+			return 0, nil, fmt.Errorf("unsupported usage %q", usage)
+		}
+	}
+	return keyUsage, extKeyUsage, nil
+}
+
+// certManagerIssuer creates a cert-manager CertificateRequest and waits for it to be signed.
+// cert-manager takes the subject and SANs from the CSR, the server checks them against the spec
+// before the request is created. The request is named after the task, issuing for a task again
+// waits for the request already created for it.
+type certManagerIssuer struct {
+	client    client.Client
+	namespace string
+	ref       certmanagermetav1.IssuerReference
+}
+
+func (i *certManagerIssuer) Issue(ctx context.Context, taskID string, csrPEM []byte, spec *pb.IssueCertificate) ([]byte, []byte, error) {
+	cr := &certmanagerv1.CertificateRequest{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "acert-task-" + taskID,
+			Namespace: i.namespace,
+			Labels:    map[string]string{taskIDLabel: taskID},
+		},
+		Spec: certmanagerv1.CertificateRequestSpec{
+			Request:   csrPEM,
+			Duration:  &metav1.Duration{Duration: duration(spec)},
+			IssuerRef: i.ref,
+		},
+	}
+	for _, usage := range usages(spec) {
+		cr.Spec.Usages = append(cr.Spec.Usages, certmanagerv1.KeyUsage(usage))
+	}
+	err := i.client.Create(ctx, cr)
+	if apierrors.IsAlreadyExists(err) {
+		if err := i.client.Get(ctx, client.ObjectKeyFromObject(cr), cr); err != nil {
+			return nil, nil, fmt.Errorf("failed to get certificate request %s: %w", cr.Name, err)
+		}
+		if !bytes.Equal(cr.Spec.Request, csrPEM) {
+			return nil, nil, fmt.Errorf("certificate request %s was created for another csr", cr.Name)
+		}
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, certificateRequestTimeout)
+	defer cancel()
+	ticker := time.NewTicker(certificateRequestPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("certificate request %s was not signed: %w", cr.Name, ctx.Err())
+		case <-ticker.C:
+		}
+		if err := i.client.Get(ctx, client.ObjectKeyFromObject(cr), cr); err != nil {
+			return nil, nil, fmt.Errorf("failed to get certificate request %s: %w", cr.Name, err)
+		}
+		for _, cond := range cr.Status.Conditions {
+			switch {
+			case cond.Type == certmanagerv1.CertificateRequestConditionDenied && cond.Status == certmanagermetav1.ConditionTrue:
+				return nil, nil, fmt.Errorf("certificate request %s was denied: %s", cr.Name, cond.Message)
+			case cond.Type == certmanagerv1.CertificateRequestConditionReady && cond.Status == certmanagermetav1.ConditionTrue:
+				return cr.Status.Certificate, cr.Status.CA, nil
+			case cond.Type == certmanagerv1.CertificateRequestConditionReady && cond.Reason == certmanagerv1.CertificateRequestReasonFailed:
+				return nil, nil, fmt.Errorf("certificate request %s failed: %s", cr.Name, cond.Message)
+			}
+		}
+	}
+}
+
+// checkCSR verifies the CSR generated by the agent asks for exactly what the task specified.
+func checkCSR(csrPEM []byte, spec *pb.IssueCertificate) error {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return err
+	}
+	names, err := certname.Parse(spec)
+	if err != nil {
+		return err
+	}
+	if csr.Subject.String() != names.Subject.String() {
+		return fmt.Errorf("csr subject %q does not match %q", csr.Subject, names.Subject)
+	}
+	var csrIPs, specIPs []string
+	for _, ip := range csr.IPAddresses {
+		csrIPs = append(csrIPs, ip.String())
+	}
+	for _, ip := range names.IPAddresses {
+		specIPs = append(specIPs, ip.String())
+	}
+	var csrURIs []string
+	for _, u := range csr.URIs {
+		csrURIs = append(csrURIs, u.String())
+	}
+	if !sameSet(csr.DNSNames, spec.DnsNames) || !sameSet(csrIPs, specIPs) || !sameSet(csrURIs, spec.Uris) ||
+		!sameSet(csr.EmailAddresses, spec.EmailAddresses) {
+		return errors.New("csr subject alternative names do not match the task")
+	}
+	return nil
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]int, len(a))
+	for _, v := range a {
+		seen[v]++
+	}
+	for _, v := range b {
+		if seen[v] == 0 {
+			return false
+		}
+		seen[v]--
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+func TestCAIssuer(t *testing.T) {
+	spec := &pb.IssueCertificate{
+		Subject:     &pb.Subject{CommonName: "web"},
+		DnsNames:    []string{"web.example.com"},
+		IpAddresses: []string{"10.0.0.1"},
+		Usages:      []string{"digital signature", "server auth"},
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: "web"},
+		DNSNames:    []string{"web.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("10.0.0.1")},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+
+	if err := checkCSR(csr, spec); err != nil {
+		t.Fatalf("expected csr to match the spec: %v", err)
+	}
+	if err := checkCSR(newTestCSR(t), spec); err == nil {
+		t.Error("expected a csr for another subject to be rejected")
+	}
+
+	chain, _, err := (&caIssuer{ca: newTestCA(t)}).Issue(context.Background(), "task", csr, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(chain)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "web" || len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 1 {
+		t.Errorf("unexpected subject or sans: %s %v %v", cert.Subject, cert.DNSNames, cert.IPAddresses)
+	}
+	if cert.KeyUsage != x509.KeyUsageDigitalSignature || len(cert.ExtKeyUsage) != 1 || cert.ExtKeyUsage[0] != x509.ExtKeyUsageServerAuth {
+		t.Errorf("unexpected usages: %v %v", cert.KeyUsage, cert.ExtKeyUsage)
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+// Servers sharing a store elect a leader with a Lease in the namespace. A new leader takes
+// over within leaseDuration of the previous one stopping to renew the Lease.
+const (
+	defaultLeaderElectionID = "acert-server"
+
+	leaseDuration      = 15 * time.Second
+	leaseRenewDeadline = 10 * time.Second
+	leaseRetryPeriod   = 2 * time.Second
+)
+
+// runSingletons runs the jobs only one of the servers sharing the store may run, timing out
+// tasks and publishing the CRL, until ctx is done.
+func (s *server) runSingletons(ctx context.Context, taskTimeout time.Duration) {
+	s.leading.Store(true)
+	s.metrics.leader.Set(1)
+	defer func() {
+		s.leading.Store(false)
+		s.metrics.leader.Set(0)
+	}()
+
+	var wg sync.WaitGroup
+	wg.Go(func() { s.expireTasks(ctx, taskTimeout) })
+	wg.Go(func() { s.publishCRLs(ctx) })
+	wg.Wait()
+}
+
+// newLeaderElector returns an elector for the Lease named options.LeaderElectionID that runs
+// the singleton jobs while this server holds it. The Lease is released on shutdown so another
+// server takes over right away.
+func (s *server) newLeaderElector(options Options) (*leaderelection.LeaderElector, error) {
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rest config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create k8s clientset: %w", err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	// Replicas may share a hostname outside of Kubernetes, the suffix keeps identities unique.
+	return s.leaderElector(options, clientset.CoordinationV1(), hostname+"_"+uuid.NewString())
+}
+
+// leaderElector returns an elector competing for the Lease in leases as identity.
+func (s *server) leaderElector(options Options, leases coordinationv1client.LeasesGetter, identity string) (*leaderelection.LeaderElector, error) {
+	log := s.logger.With(zap.String("lease", options.LeaderElectionID), zap.String("identity", identity))
+	return leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Namespace: options.Namespace, Name: options.LeaderElectionID},
+			Client:     leases,
+			LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+		},
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   leaseRenewDeadline,
+		RetryPeriod:     leaseRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            options.LeaderElectionID,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Info("started leading")
+				s.runSingletons(ctx, options.TaskTimeout)
+			},
+			OnStoppedLeading: func() {
+				log.Info("stopped leading")
+			},
+			OnNewLeader: func(leader string) {
+				log.Info("leader elected", zap.String("leader", leader))
+			},
+		},
+	})
+}
+
+// campaign runs the elector until ctx is done, standing for election again whenever the
+// leadership is lost.
+func campaign(ctx context.Context, elector *leaderelection.LeaderElector) {
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coordinationv1client "k8s.io/client-go/kubernetes/typed/coordination/v1"
+
+	"github.com/salzr/acert/store"
+)
+
+// fakeLeases keeps Leases in memory, updates of a stale Lease conflict like on the API server.
+type fakeLeases struct {
+	coordinationv1client.LeaseInterface
+
+	mu     sync.Mutex
+	leases map[string]*coordinationv1.Lease
+}
+
+func (f *fakeLeases) Leases(string) coordinationv1client.LeaseInterface {
+	return f
+}
+
+func (f *fakeLeases) Get(_ context.Context, name string, _ metav1.GetOptions) (*coordinationv1.Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	l, ok := f.leases[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(coordinationv1.Resource("leases"), name)
+	}
+	return l.DeepCopy(), nil
+}
+
+func (f *fakeLeases) Create(_ context.Context, l *coordinationv1.Lease, _ metav1.CreateOptions) (*coordinationv1.Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.leases[l.Name]; ok {
+		return nil, apierrors.NewAlreadyExists(coordinationv1.Resource("leases"), l.Name)
+	}
+	l = l.DeepCopy()
+	l.ResourceVersion = "1"
+	f.leases[l.Name] = l
+	return l.DeepCopy(), nil
+}
+
+func (f *fakeLeases) Update(_ context.Context, l *coordinationv1.Lease, _ metav1.UpdateOptions) (*coordinationv1.Lease, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, ok := f.leases[l.Name]
+	if !ok {
+		return nil, apierrors.NewNotFound(coordinationv1.Resource("leases"), l.Name)
+	}
+	if current.ResourceVersion != l.ResourceVersion {
+		return nil, apierrors.NewConflict(coordinationv1.Resource("leases"), l.Name, nil)
+	}
+	version, _ := strconv.Atoi(current.ResourceVersion)
+	l = l.DeepCopy()
+	l.ResourceVersion = strconv.Itoa(version + 1)
+	f.leases[l.Name] = l
+	return l.DeepCopy(), nil
+}
+
+func TestLeaderRunsSingletons(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "acert.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	a := &store.Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: time.Now()}
+	if err := st.CreateAgent(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultOptions()
+	options.TaskTimeout = time.Minute
+	leases := &fakeLeases{leases: map[string]*coordinationv1.Lease{}}
+	material := &tlsMaterial{agentCA: newTestCA(t)}
+	servers := make([]*server, 2)
+	cancels := make([]context.CancelFunc, 2)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := range servers {
+		s := &server{logger: zap.NewNop(), store: st, taskAckTimeout: 50 * time.Millisecond, taskMaxAttempts: 1}
+		s.metrics = newMetrics(s)
+		s.material.Store(material)
+		elector, err := s.leaderElector(options, leases, strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var serverCtx context.Context
+		serverCtx, cancels[i] = context.WithCancel(ctx)
+		defer cancels[i]()
+		wg.Go(func() { campaign(serverCtx, elector) })
+		servers[i] = s
+	}
+
+	// leader waits for exactly one of the running servers to lead.
+	leader := func(running ...*server) *server {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			var leading []*server
+			for _, s := range running {
+				if s.leading.Load() {
+					leading = append(leading, s)
+				}
+			}
+			if len(leading) > 1 {
+				t.Fatal("expected a single leader")
+			}
+			if len(leading) == 1 {
+				return leading[0]
+			}
+		}
+		t.Fatal("no server was elected")
+		return nil
+	}
+	first := leader(servers...)
+
+	// The singleton jobs of the leader publish the CRL and time out the unacknowledged task.
+	if err := st.CreateTask(ctx, &store.Task{ID: "task", AgentID: a.ID, Type: TaskTypeIssueCertificate, Spec: []byte("{}"),
+		CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.DispatchTask(ctx, "task", unfinishedTaskStatuses, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		task, err := st.GetTask(ctx, "task")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task.Status == store.TaskTimedOut {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the leader to time out the task, got %s", task.Status)
+		}
+	}
+	if _, err := st.GetCRL(ctx); err != nil {
+		t.Errorf("expected the leader to publish the crl, got %v", err)
+	}
+
+	// The other server takes over once the leader stops.
+	for i, s := range servers {
+		if s == first {
+			cancels[i]()
+		}
+	}
+	var follower *server
+	for _, s := range servers {
+		if s != first {
+			follower = s
+		}
+	}
+	if leader(follower) != follower {
+		t.Error("expected the remaining server to take over")
+	}
+	if first.leading.Load() {
+		t.Error("expected the stopped server to no longer lead")
+	}
+}
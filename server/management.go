@@ -0,0 +1,483 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+// managementServer implements the ManagementService. Callers authenticate with an operator
+// client certificate or one of the bearer tokens of the management token file, only the hashes
+// of the tokens are kept.
+type managementServer struct {
+	mpb.UnimplementedManagementServiceServer
+
+	s      *server
+	tokens []string
+}
+
+// newManagementServer reads the bearer tokens from path, one per line. Empty lines and lines
+// starting with # are ignored. Without a path only operator certificates are accepted.
+func newManagementServer(s *server, path string) (*managementServer, error) {
+	m := &managementServer{s: s}
+	if path == "" {
+		return m, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open management tokens: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		m.tokens = append(m.tokens, hashToken(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read management tokens: %w", err)
+	}
+	if len(m.tokens) == 0 {
+		return nil, fmt.Errorf("no management tokens in %s", path)
+	}
+	return m, nil
+}
+
+// authorize checks the operator certificate or bearer token of the caller and returns the caller
+// as the actor of audit events. REST callers send the token in the Authorization header which the
+// gateway forwards as metadata.
+func (m *managementServer) authorize(ctx context.Context) (Actor, error) {
+	if cert := verifiedPeerCertificate(ctx); cert != nil && m.s.material.Load().operator(cert) {
+		return Actor{Name: "operator:" + cert.Subject.CommonName}, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(v, "Bearer ")
+		if !ok {
+			continue
+		}
+		hash := hashToken(token)
+		for _, t := range m.tokens {
+			if subtle.ConstantTimeCompare([]byte(hash), []byte(t)) == 1 {
+				return managementActor(t), nil
+			}
+		}
+	}
+	return Actor{}, status.Error(codes.Unauthenticated, "operator certificate or valid bearer token required")
+}
+
+// handler serves the ManagementService as REST/JSON.
+func (m *managementServer) handler(ctx context.Context) (http.Handler, error) {
+	mux := runtime.NewServeMux()
+	if err := mpb.RegisterManagementServiceHandlerServer(ctx, mux, m); err != nil {
+		return nil, fmt.Errorf("failed to register management handler: %w", err)
+	}
+	return mux, nil
+}
+
+func (m *managementServer) ListAgents(ctx context.Context, _ *mpb.ListAgentsRequest) (*mpb.ListAgentsResponse, error) {
+	if _, err := m.authorize(ctx); err != nil {
+		return nil, err
+	}
+	agents, err := m.s.store.ListAgents(ctx)
+	if err != nil {
+		return nil, m.internal("failed to list agents", err)
+	}
+	res := &mpb.ListAgentsResponse{}
+	for _, a := range agents {
+		res.Agents = append(res.Agents, m.agent(a))
+	}
+	return res, nil
+}
+
+func (m *managementServer) GetAgent(ctx context.Context, req *mpb.GetAgentRequest) (*mpb.Agent, error) {
+	if _, err := m.authorize(ctx); err != nil {
+		return nil, err
+	}
+	a, err := m.getAgent(ctx, req.AgentId)
+	if err != nil {
+		return nil, err
+	}
+	return m.agent(a), nil
+}
+
+func (m *managementServer) RevokeAgent(ctx context.Context, req *mpb.RevokeAgentRequest) (*mpb.Agent, error) {
+	actor, err := m.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = revokeAgent(ctx, m.s.store, req.AgentId)
+	m.s.audit(ctx, actor, AuditAgentRevoke, AgentTarget(req.AgentId), "", err)
+	if errors.Is(err, store.ErrAgentNotFound) {
+		return nil, status.Errorf(codes.NotFound, "agent %d not found", req.AgentId)
+	} else if err != nil {
+		return nil, m.internal("failed to revoke agent", err)
+	}
+	m.s.logger.Info("agent revoked", zap.Int64("agentId", req.AgentId))
+	// Close the stream and, on the leader, publish the CRL now rather than on the next refresh.
+	if m.s.leading.Load() {
+		if err := m.s.publishCRL(ctx, time.Now()); err != nil {
+			m.s.logger.Error("failed to publish crl", zap.Error(err))
+		}
+	}
+	if err := m.s.refreshRevocations(ctx, time.Now()); err != nil {
+		m.s.logger.Error("failed to refresh revocations", zap.Error(err))
+	}
+	a, err := m.getAgent(ctx, req.AgentId)
+	if err != nil {
+		return nil, err
+	}
+	return m.agent(a), nil
+}
+
+func (m *managementServer) ListCertificates(ctx context.Context, req *mpb.ListCertificatesRequest) (*mpb.ListCertificatesResponse, error) {
+	if _, err := m.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := m.getAgent(ctx, req.AgentId); err != nil {
+		return nil, err
+	}
+	certs, err := m.s.store.ListCertificates(ctx, req.AgentId)
+	if err != nil {
+		return nil, m.internal("failed to list certificates", err)
+	}
+	res := &mpb.ListCertificatesResponse{}
+	for _, c := range certs {
+		res.Certificates = append(res.Certificates, certificateInfo(c))
+		res.ReportedAt = c.ReportedAt.Unix()
+	}
+	return res, nil
+}
+
+func (m *managementServer) ListAllCertificates(ctx context.Context, req *mpb.ListAllCertificatesRequest) (*mpb.ListAllCertificatesResponse, error) {
+	if _, err := m.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if req.ExpiringWithinSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "expiring within must not be negative")
+	}
+	filter := store.CertificateFilter{AgentID: req.AgentId}
+	if req.ExpiringWithinSeconds > 0 {
+		filter.ExpiresBefore = time.Now().Add(time.Duration(req.ExpiringWithinSeconds) * time.Second)
+	}
+	certs, err := m.s.store.ListAllCertificates(ctx, filter)
+	if err != nil {
+		return nil, m.internal("failed to list certificates", err)
+	}
+	res := &mpb.ListAllCertificatesResponse{}
+	for _, c := range certs {
+		res.Certificates = append(res.Certificates, &mpb.InventoryCertificate{
+			AgentId:     c.AgentID,
+			Certificate: certificateInfo(c),
+			ReportedAt:  c.ReportedAt.Unix(),
+		})
+	}
+	return res, nil
+}
+
+func (m *managementServer) CreateToken(ctx context.Context, req *mpb.CreateTokenRequest) (*mpb.CreateTokenResponse, error) {
+	actor, err := m.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ttl := DefaultTokenTTL
+	if req.TtlSeconds != 0 {
+		ttl = time.Duration(req.TtlSeconds) * time.Second
+	}
+	if ttl <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl must be positive")
+	}
+	token, ticket, err := CreateToken(ctx, m.s.store, ttl)
+	if err != nil {
+		m.s.audit(ctx, actor, AuditTokenCreate, "ticket", "", err)
+		return nil, m.internal("failed to create token", err)
+	}
+	m.s.audit(ctx, actor, AuditTokenCreate, TicketTarget(ticket), TicketDetail(ticket), nil)
+	m.s.logger.Info("token created", zap.Int64("ticketId", ticket.ID), zap.Time("expiresAt", ticket.ExpiresAt))
+	return &mpb.CreateTokenResponse{
+		Token:     token,
+		TicketId:  ticket.ID,
+		ExpiresAt: ticket.ExpiresAt.Unix(),
+	}, nil
+}
+
+func (m *managementServer) ListTokens(ctx context.Context, _ *mpb.ListTokensRequest) (*mpb.ListTokensResponse, error) {
+	if _, err := m.authorize(ctx); err != nil {
+		return nil, err
+	}
+	tickets, err := m.s.store.ListTickets(ctx)
+	if err != nil {
+		return nil, m.internal("failed to list tokens", err)
+	}
+	res := &mpb.ListTokensResponse{}
+	now := time.Now()
+	for _, t := range tickets {
+		res.Tokens = append(res.Tokens, token(t, now))
+	}
+	return res, nil
+}
+
+func (m *managementServer) RevokeToken(ctx context.Context, req *mpb.RevokeTokenRequest) (*mpb.Token, error) {
+	actor, err := m.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t, err := m.s.store.RevokeTicket(ctx, req.TicketId, time.Now())
+	m.s.audit(ctx, actor, AuditTokenRevoke, "ticket:"+strconv.FormatInt(req.TicketId, 10), "", err)
+	switch {
+	case errors.Is(err, store.ErrTicketNotFound):
+		return nil, status.Errorf(codes.NotFound, "token %d not found", req.TicketId)
+	case errors.Is(err, store.ErrTicketTorn):
+		return nil, status.Errorf(codes.FailedPrecondition, "token %d was already used", req.TicketId)
+	case err != nil:
+		return nil, m.internal("failed to revoke token", err)
+	}
+	m.s.logger.Info("token revoked", zap.Int64("ticketId", t.ID))
+	return token(t, time.Now()), nil
+}
+
+func (m *managementServer) CreateTask(ctx context.Context, req *mpb.CreateTaskRequest) (*mpb.Task, error) {
+	actor, err := m.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	spec := req.GetIssueCertificate()
+	if spec == nil {
+		return nil, status.Error(codes.InvalidArgument, "task required")
+	}
+	if err := validateIssueCertificate(spec); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	t, err := CreateIssueCertificateTask(ctx, m.s.store, req.AgentId, spec)
+	if err != nil {
+		m.s.audit(ctx, actor, AuditTaskCreate, "task", TaskDetail(req.AgentId, TaskTypeIssueCertificate), err)
+	} else {
+		m.s.audit(ctx, actor, AuditTaskCreate, TaskTarget(t.ID), TaskDetail(req.AgentId, t.Type), nil)
+	}
+	if errors.Is(err, store.ErrAgentNotFound) {
+		return nil, status.Errorf(codes.NotFound, "agent %d not found", req.AgentId)
+	}
+	if err != nil {
+		return nil, m.internal("failed to create task", err)
+	}
+	m.s.logger.Info("task created", zap.Int64("agentId", req.AgentId), zap.String("taskId", t.ID))
+	return m.task(t)
+}
+
+func (m *managementServer) ListTasks(ctx context.Context, req *mpb.ListTasksRequest) (*mpb.ListTasksResponse, error) {
+	if _, err := m.authorize(ctx); err != nil {
+		return nil, err
+	}
+	for _, s := range req.Statuses {
+		if !slices.Contains(store.TaskStatuses, s) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid status %q", s)
+		}
+	}
+	tasks, err := m.s.store.ListTasks(ctx, store.TaskFilter{
+		AgentID:  req.AgentId,
+		Statuses: req.Statuses,
+		Limit:    int(req.Limit),
+	})
+	if err != nil {
+		return nil, m.internal("failed to list tasks", err)
+	}
+	res := &mpb.ListTasksResponse{}
+	for _, t := range tasks {
+		task, err := m.task(t)
+		if err != nil {
+			return nil, err
+		}
+		res.Tasks = append(res.Tasks, task)
+	}
+	return res, nil
+}
+
+func (m *managementServer) GetTask(ctx context.Context, req *mpb.GetTaskRequest) (*mpb.Task, error) {
+	if _, err := m.authorize(ctx); err != nil {
+		return nil, err
+	}
+	t, err := m.s.store.GetTask(ctx, req.TaskId)
+	if errors.Is(err, store.ErrTaskNotFound) {
+		return nil, status.Errorf(codes.NotFound, "task %s not found", req.TaskId)
+	}
+	if err != nil {
+		return nil, m.internal("failed to get task", err)
+	}
+	return m.task(t)
+}
+
+func (m *managementServer) CancelTask(ctx context.Context, req *mpb.CancelTaskRequest) (*mpb.Task, error) {
+	actor, err := m.authorize(ctx)
+	if err != nil {
+		return nil, err
+	}
+	target := TaskTarget(req.TaskId)
+	from := []string{store.TaskPending, store.TaskDispatched, store.TaskRunning}
+	update := store.TaskUpdate{Status: store.TaskCanceled, Message: "canceled by " + actor.Name}
+	ok, err := m.s.store.UpdateTask(ctx, req.TaskId, from, update, time.Now())
+	if err != nil {
+		m.s.audit(ctx, actor, AuditTaskCancel, target, "", err)
+		return nil, m.internal("failed to cancel task", err)
+	}
+	t, err := m.s.store.GetTask(ctx, req.TaskId)
+	if errors.Is(err, store.ErrTaskNotFound) {
+		m.s.audit(ctx, actor, AuditTaskCancel, target, "", err)
+		return nil, status.Errorf(codes.NotFound, "task %s not found", req.TaskId)
+	}
+	if err != nil {
+		m.s.audit(ctx, actor, AuditTaskCancel, target, "", err)
+		return nil, m.internal("failed to get task", err)
+	}
+	if !ok {
+		err := status.Errorf(codes.FailedPrecondition, "task %s already %s", req.TaskId, t.Status)
+		m.s.audit(ctx, actor, AuditTaskCancel, target, TaskDetail(t.AgentID, t.Type), err)
+		return nil, err
+	}
+	m.s.audit(ctx, actor, AuditTaskCancel, target, TaskDetail(t.AgentID, t.Type), nil)
+	m.s.logger.Info("task canceled", zap.String("taskId", t.ID), zap.Int64("agentId", t.AgentID))
+	return m.task(t)
+}
+
+func (m *managementServer) getAgent(ctx context.Context, id int64) (*store.Agent, error) {
+	a, err := m.s.store.GetAgent(ctx, id)
+	if errors.Is(err, store.ErrAgentNotFound) {
+		return nil, status.Errorf(codes.NotFound, "agent %d not found", id)
+	}
+	if err != nil {
+		return nil, m.internal("failed to get agent", err)
+	}
+	return a, nil
+}
+
+// internal logs err and returns a grpc status that does not leak it.
+func (m *managementServer) internal(msg string, err error) error {
+	m.s.logger.Error(msg, zap.Error(err))
+	return status.Error(codes.Internal, msg)
+}
+
+// agent combines the stored agent with its connection in the registry.
+func (m *managementServer) agent(a *store.Agent) *mpb.Agent {
+	res := &mpb.Agent{
+		Id:          a.ID,
+		Hostname:    a.Hostname,
+		Ip:          a.IP,
+		Description: a.Description,
+		CreatedAt:   a.CreatedAt.Unix(),
+		RevokedAt:   unix(a.Revoked),
+		Version:     a.Version,
+		LastSeen:    unix(a.LastSeen),
+		Presence:    PresenceOffline,
+	}
+	if c, ok := m.s.registry.Get(a.ID); ok && c.Presence != PresenceOffline {
+		res.Presence = c.Presence
+		res.Connection = &mpb.AgentConnection{
+			RemoteAddr:          c.RemoteAddr,
+			ConnectedAt:         c.ConnectedAt.Unix(),
+			LastHeartbeat:       c.LastHeartbeat.Unix(),
+			Capabilities:        c.Capabilities,
+			CertificateSerial:   c.CertSerial,
+			CertificateNotAfter: c.CertNotAfter.Unix(),
+		}
+	}
+	return res
+}
+
+func (m *managementServer) task(t *store.Task) (*mpb.Task, error) {
+	res := &mpb.Task{
+		Id:           t.ID,
+		AgentId:      t.AgentID,
+		Type:         t.Type,
+		Status:       t.Status,
+		Message:      t.Message,
+		ErrorCode:    t.ErrorCode,
+		CreatedAt:    t.CreatedAt.Unix(),
+		UpdatedAt:    t.UpdatedAt.Unix(),
+		DispatchedAt: unix(t.DispatchedAt),
+		FinishedAt:   unix(t.FinishedAt),
+		Attempts:     int32(t.Attempts),
+	}
+	for _, a := range t.Artifacts {
+		res.Artifacts = append(res.Artifacts, &pb.TaskArtifact{Name: a.Name, Path: a.Path, Sha256: a.SHA256})
+	}
+	if t.Type == TaskTypeIssueCertificate {
+		spec := &pb.IssueCertificate{}
+		if err := protojson.Unmarshal(t.Spec, spec); err != nil {
+			return nil, m.internal("failed to decode task spec", err)
+		}
+		res.Spec = &mpb.Task_IssueCertificate{IssueCertificate: spec}
+	}
+	return res, nil
+}
+
+func certificateInfo(c *store.Certificate) *pb.CertificateInfo {
+	return &pb.CertificateInfo{
+		Path:         c.Path,
+		Subject:      c.Subject,
+		Sans:         c.SANs,
+		Issuer:       c.Issuer,
+		Serial:       c.Serial,
+		NotBefore:    c.NotBefore.Unix(),
+		NotAfter:     c.NotAfter.Unix(),
+		KeyAlgorithm: c.KeyAlgorithm,
+		Fingerprint:  c.Fingerprint,
+	}
+}
+
+// Token states.
+const (
+	TokenValid   = "valid"
+	TokenUsed    = "used"
+	TokenRevoked = "revoked"
+	TokenExpired = "expired"
+)
+
+func token(t *store.Ticket, now time.Time) *mpb.Token {
+	res := &mpb.Token{
+		TicketId:  t.ID,
+		CreatedAt: t.CreatedAt.Unix(),
+		ExpiresAt: t.ExpiresAt.Unix(),
+		UsedAt:    unix(t.Torn),
+		RevokedAt: unix(t.Revoked),
+	}
+	switch {
+	case t.Torn != nil:
+		res.State = TokenUsed
+	case t.Revoked != nil:
+		res.State = TokenRevoked
+	case !now.Before(t.ExpiresAt):
+		res.State = TokenExpired
+	default:
+		res.State = TokenValid
+	}
+	return res
+}
+
+// unix returns t as unix seconds, zero when t is nil.
+func unix(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+func TestManagementAuthorize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# operators\nfirst\n\n  second  \n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := newManagementServer(&server{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		header []string
+		want   codes.Code
+	}{
+		{name: "first", header: []string{"Bearer first"}, want: codes.OK},
+		{name: "second", header: []string{"Bearer second"}, want: codes.OK},
+		{name: "unknown", header: []string{"Bearer third"}, want: codes.Unauthenticated},
+		{name: "comment", header: []string{"Bearer # operators"}, want: codes.Unauthenticated},
+		{name: "not bearer", header: []string{"first"}, want: codes.Unauthenticated},
+		{name: "missing", want: codes.Unauthenticated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.header != nil {
+				ctx = metadata.NewIncomingContext(ctx, metadata.MD{"authorization": tc.header})
+			}
+			_, err := m.authorize(ctx)
+			if got := status.Code(err); got != tc.want {
+				t.Errorf("expected %s, got %s", tc.want, got)
+			}
+		})
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(empty, []byte("# none\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newManagementServer(&server{}, empty); err == nil {
+		t.Error("expected a token file without tokens to be rejected")
+	}
+}
+
+func TestManagementOperatorCertificate(t *testing.T) {
+	agentCA, operatorCA := newTestCA(t), newTestCA(t)
+	if _, err := newTLSMaterial(tls.Certificate{}, agentCA.PEM(), agentCA, nil, agentCA.PEM()); err == nil {
+		t.Error("expected an operator ca trusting the agent ca to be rejected")
+	}
+	material, err := newTLSMaterial(tls.Certificate{}, agentCA.PEM(), agentCA, nil, operatorCA.PEM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{}
+	s.material.Store(material)
+	// Without a token file only operator certificates are accepted.
+	m, err := newManagementServer(s, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csr, err := parseCSR(newTestCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	operator, _, err := operatorCA.SignAgent(csr, "alice", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, _, err := agentCA.SignAgent(csr, "42", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		cert *x509.Certificate
+		want codes.Code
+	}{
+		{name: "operator", cert: operator, want: codes.OK},
+		{name: "agent", cert: agent, want: codes.Unauthenticated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{
+				State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tc.cert}}},
+			}})
+			actor, err := m.authorize(ctx)
+			if got := status.Code(err); got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+			if err == nil && actor.Name != "operator:alice" {
+				t.Errorf("expected actor operator:alice, got %s", actor.Name)
+			}
+		})
+	}
+	if !material.operator(operator) || material.operator(agent) {
+		t.Error("expected only the operator certificate to be an operator")
+	}
+}
+
+func TestManagementCancelTaskAudit(t *testing.T) {
+	s, _, task := newTaskTestServer(t)
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("operator\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := newManagementServer(s, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.MD{"authorization": {"Bearer operator"}})
+	// expectAudit checks the latest task cancellation event.
+	expectAudit := func(target, result string) {
+		t.Helper()
+		events, err := s.store.ListAuditEvents(ctx, store.AuditFilter{Action: AuditTaskCancel})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 0 {
+			t.Fatal("expected the cancellation to be audited")
+		}
+		if e := events[len(events)-1]; e.Target != target || e.Result != result {
+			t.Errorf("expected the cancellation of %s to be audited as %s, got %+v", target, result, e)
+		}
+	}
+
+	if _, err := m.CancelTask(ctx, &mpb.CancelTaskRequest{TaskId: "missing"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected %s for an unknown task, got %v", codes.NotFound, err)
+	}
+	expectAudit("task:missing", store.AuditFailure)
+
+	if _, err := m.CancelTask(ctx, &mpb.CancelTaskRequest{TaskId: task.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectAudit(TaskTarget(task.ID), store.AuditSuccess)
+
+	if _, err := m.CancelTask(ctx, &mpb.CancelTaskRequest{TaskId: task.ID}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected %s canceling a task twice, got %v", codes.FailedPrecondition, err)
+	}
+	expectAudit(TaskTarget(task.ID), store.AuditFailure)
+}
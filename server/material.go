@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/salzr/acert/filewatch"
+	"github.com/salzr/acert/k8s"
+)
+
+// tlsMaterial is the key material the grpc listener serves, verifies agents and signs with.
+// It is replaced as a whole whenever its source changes.
+type tlsMaterial struct {
+	serving   tls.Certificate
+	clientCAs *x509.CertPool
+	agentCA   *certificateAuthority
+	// serverCA is the CA bundle handed to agents to verify the server.
+	serverCA []byte
+	// operatorCAs verifies the client certificates of operators calling the ManagementService,
+	// nil when operator certificates are not accepted.
+	operatorCAs *x509.CertPool
+}
+
+// loadFileMaterial reads the TLS material from the files named in the options.
+func loadFileMaterial(options Options) (*tlsMaterial, error) {
+	serving, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load keypair: %w", err)
+	}
+	clientCA, err := os.ReadFile(options.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client ca: %w", err)
+	}
+	agentCA, err := loadCertificateAuthority(options.AgentCACertFile, options.AgentCAKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	serverCA, err := os.ReadFile(options.ServerCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server ca: %w", err)
+	}
+	operatorCA, err := readOperatorCA(options)
+	if err != nil {
+		return nil, err
+	}
+	return newTLSMaterial(serving, clientCA, agentCA, serverCA, operatorCA)
+}
+
+// readOperatorCA reads the operator CA bundle, it is nil when none is configured.
+func readOperatorCA(options Options) ([]byte, error) {
+	if options.OperatorCAFile == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(options.OperatorCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operator ca: %w", err)
+	}
+	return b, nil
+}
+
+// newTLSMaterial builds the material, the operator CAs are also trusted for client certificates.
+// They must not trust the agent CA, agents would otherwise be operators.
+func newTLSMaterial(serving tls.Certificate, clientCA []byte, agentCA *certificateAuthority, serverCA, operatorCA []byte) (*tlsMaterial, error) {
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(clientCA); !ok {
+		return nil, fmt.Errorf("failed to parse client ca")
+	}
+	m := &tlsMaterial{
+		serving:   serving,
+		clientCAs: pool,
+		agentCA:   agentCA,
+		serverCA:  serverCA,
+	}
+	if operatorCA == nil {
+		return m, nil
+	}
+	m.operatorCAs = x509.NewCertPool()
+	if ok := m.operatorCAs.AppendCertsFromPEM(operatorCA); !ok {
+		return nil, fmt.Errorf("failed to parse operator ca")
+	}
+	if _, err := agentCA.cert.Verify(x509.VerifyOptions{Roots: m.operatorCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err == nil {
+		return nil, fmt.Errorf("operator ca must not trust the agent ca")
+	}
+	pool.AppendCertsFromPEM(operatorCA)
+	return m, nil
+}
+
+// operator reports whether cert is an operator certificate, issued by one of the operator CAs.
+func (m *tlsMaterial) operator(cert *x509.Certificate) bool {
+	if m == nil || m.operatorCAs == nil {
+		return false
+	}
+	_, err := cert.Verify(x509.VerifyOptions{Roots: m.operatorCAs, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	return err == nil
+}
+
+// watchMaterial loads the TLS material from the configured source and keeps watching the
+// files or Secrets for rotations until ctx is done. Material that fails to load is logged
+// and the previous material is kept.
+func (s *server) watchMaterial(ctx context.Context, options Options) error {
+	switch options.TLSSource {
+	case TLSSourceFile:
+		m, err := loadFileMaterial(options)
+		if err != nil {
+			return err
+		}
+		s.material.Store(m)
+		files := []string{options.CertFile, options.KeyFile, options.ClientCAFile,
+			options.AgentCACertFile, options.AgentCAKeyFile, options.ServerCAFile}
+		if options.OperatorCAFile != "" {
+			files = append(files, options.OperatorCAFile)
+		}
+		go filewatch.Watch(ctx, options.ReloadInterval, files, func() {
+			m, err := loadFileMaterial(options)
+			if err != nil {
+				s.logger.Error("failed to reload tls material", zap.Error(err))
+				return
+			}
+			s.material.Store(m)
+			s.logger.Info("reloaded tls material")
+		})
+		return nil
+	case TLSSourceSecret:
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return fmt.Errorf("failed to create rest config: %w", err)
+		}
+		c, err := k8s.NewClient(ctx, cfg)
+		if err != nil {
+			return err
+		}
+		m, err := loadSecretMaterial(ctx, c, options)
+		if err != nil {
+			return err
+		}
+		s.material.Store(m)
+		go watchSecrets(ctx, s.logger, c, options, func() {
+			m, err := loadSecretMaterial(ctx, c, options)
+			if err != nil {
+				s.logger.Error("failed to reload tls material", zap.Error(err))
+				return
+			}
+			s.material.Store(m)
+			s.logger.Info("reloaded tls material")
+		})
+		return nil
+	default:
+		return fmt.Errorf("unknown tls source %q", options.TLSSource)
+	}
+}
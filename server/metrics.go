@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+
+	"github.com/salzr/acert/store"
+)
+
+const metricsCollectTimeout = 5 * time.Second
+
+// certificateExpiryBuckets groups the inventory certificates by the days until they expire, a
+// certificate is counted in the first bucket it expires within.
+var certificateExpiryBuckets = []struct {
+	label  string
+	within time.Duration
+}{
+	{"expired", 0},
+	{"0-7", 7 * 24 * time.Hour},
+	{"7-30", 30 * 24 * time.Hour},
+	{"30-90", 90 * 24 * time.Hour},
+	{"90+", math.MaxInt64},
+}
+
+// metrics are the prometheus metrics of the server. Counters are updated as things happen, the
+// agent, task and certificate gauges are read from the registry and store on every scrape.
+type metrics struct {
+	reg *prometheus.Registry
+
+	heartbeats   prometheus.Counter
+	streamErrors prometheus.Counter
+	issuance     *prometheus.HistogramVec
+	leader       prometheus.Gauge
+}
+
+func newMetrics(s *server) *metrics {
+	m := &metrics{
+		reg: prometheus.NewRegistry(),
+		heartbeats: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "acert",
+			Subsystem: "server",
+			Name:      "heartbeats_total",
+			Help:      "Number of heartbeats received from agents.",
+		}),
+		streamErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "acert",
+			Subsystem: "server",
+			Name:      "stream_errors_total",
+			Help:      "Number of agent Poll streams that ended with an error.",
+		}),
+		issuance: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "acert",
+			Subsystem: "server",
+			Name:      "certificate_issuance_duration_seconds",
+			Help:      "Time taken to issue the certificates of IssueCertificate tasks by result.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 15),
+		}, []string{"result"}),
+		leader: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "acert",
+			Subsystem: "server",
+			Name:      "leader",
+			Help:      "Whether this server runs the singleton jobs, 1 while it leads.",
+		}),
+	}
+	m.reg.MustRegister(m.heartbeats, m.streamErrors, m.issuance, m.leader, &stateCollector{s: s})
+	return m
+}
+
+// observeIssuance records how long issuing a certificate took since start.
+func (m *metrics) observeIssuance(start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.issuance.WithLabelValues(result).Observe(time.Since(start).Seconds())
+}
+
+var (
+	connectedAgentsDesc = prometheus.NewDesc("acert_server_connected_agents",
+		"Number of agents holding a Poll stream by presence.", []string{"presence"}, nil)
+	tasksDesc = prometheus.NewDesc("acert_server_tasks",
+		"Number of tasks by status.", []string{"status"}, nil)
+	certificatesDesc = prometheus.NewDesc("acert_server_certificates",
+		"Number of certificates in the agent inventories by days until they expire.", []string{"expires_in_days"}, nil)
+)
+
+// stateCollector collects the gauges of the registry and the store.
+type stateCollector struct {
+	s *server
+}
+
+func (c *stateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectedAgentsDesc
+	ch <- tasksDesc
+	ch <- certificatesDesc
+}
+
+func (c *stateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsCollectTimeout)
+	defer cancel()
+
+	presence := map[string]int{PresenceOnline: 0, PresenceStale: 0}
+	for _, conn := range c.s.registry.List() {
+		if _, ok := presence[conn.Presence]; ok {
+			presence[conn.Presence]++
+		}
+	}
+	for p, n := range presence {
+		ch <- prometheus.MustNewConstMetric(connectedAgentsDesc, prometheus.GaugeValue, float64(n), p)
+	}
+
+	if counts, err := c.s.store.CountTasks(ctx); err != nil {
+		c.s.logger.Error("failed to collect task metrics", zap.Error(err))
+	} else {
+		for _, status := range store.TaskStatuses {
+			ch <- prometheus.MustNewConstMetric(tasksDesc, prometheus.GaugeValue, float64(counts[status]), status)
+		}
+	}
+
+	if expiries, err := c.s.store.ListCertificateExpiries(ctx); err != nil {
+		c.s.logger.Error("failed to collect certificate metrics", zap.Error(err))
+	} else {
+		counts := make([]int, len(certificateExpiryBuckets))
+		now := time.Now()
+		for _, notAfter := range expiries {
+			counts[expiryBucket(notAfter.Sub(now))]++
+		}
+		for i, b := range certificateExpiryBuckets {
+			ch <- prometheus.MustNewConstMetric(certificatesDesc, prometheus.GaugeValue, float64(counts[i]), b.label)
+		}
+	}
+}
+
+// expiryBucket returns the index in certificateExpiryBuckets for a certificate expiring in d.
+func expiryBucket(d time.Duration) int {
+	for i, b := range certificateExpiryBuckets {
+		if d <= b.within {
+			return i
+		}
+	}
+	return len(certificateExpiryBuckets) - 1
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiryBucket(t *testing.T) {
+	day := 24 * time.Hour
+	for _, tc := range []struct {
+		in   time.Duration
+		want string
+	}{
+		{-time.Hour, "expired"},
+		{0, "expired"},
+		{time.Hour, "0-7"},
+		{7 * day, "0-7"},
+		{8 * day, "7-30"},
+		{60 * day, "30-90"},
+		{365 * day, "90+"},
+	} {
+		if got := certificateExpiryBuckets[expiryBucket(tc.in)].label; got != tc.want {
+			t.Errorf("expected %s to be in %s, got %s", tc.in, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/x509"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/salzr/acert/store"
+)
+
+// Agent presence in the registry. An agent is stale once it misses heartbeats for the stale
+// timeout and offline once it misses them for the offline timeout or disconnects.
+const (
+	PresenceOnline  = "online"
+	PresenceStale   = "stale"
+	PresenceOffline = "offline"
+
+	defaultAgentStaleAfter   = 30 * time.Second
+	defaultAgentOfflineAfter = 2 * time.Minute
+	registrySweepInterval    = 10 * time.Second
+)
+
+// AgentConnection is a snapshot of an agent in the registry.
+type AgentConnection struct {
+	AgentID     int64
+	Hostname    string
+	RemoteAddr  string
+	ConnectedAt time.Time
+	// CertSubject and CertSerial identify the client certificate of the stream.
+	CertSubject   string
+	CertSerial    string
+	CertNotAfter  time.Time
+	LastHeartbeat time.Time
+	Version       string
+	Capabilities  []string
+	Presence      string
+}
+
+type registryEntry struct {
+	AgentConnection
+	// sess is the Poll stream of the agent, nil once it disconnected.
+	sess *session
+}
+
+// presenceStore persists what the registry learns about agents.
+type presenceStore interface {
+	UpdateAgentPresence(ctx context.Context, id int64, version string, lastSeen time.Time) error
+}
+
+// registry tracks the agents connected to this server and their presence. It is shared by every
+// Poll stream and safe for concurrent use.
+type registry struct {
+	logger       *zap.Logger
+	store        presenceStore
+	staleAfter   time.Duration
+	offlineAfter time.Duration
+
+	mu     sync.RWMutex
+	agents map[int64]*registryEntry
+	// streams are the Poll streams that have not ended, a replaced stream is closed but may
+	// still be ending.
+	streams map[*session]struct{}
+}
+
+func newRegistry(logger *zap.Logger, st presenceStore, staleAfter, offlineAfter time.Duration) *registry {
+	return &registry{
+		logger:       logger,
+		store:        st,
+		staleAfter:   staleAfter,
+		offlineAfter: offlineAfter,
+		agents:       map[int64]*registryEntry{},
+		streams:      map[*session]struct{}{},
+	}
+}
+
+// Connect registers the stream of an agent. A previous stream of the agent is closed, so its
+// tasks are only dispatched on the newest one.
+func (r *registry) Connect(sess *session, agent *store.Agent, remoteAddr string, cert *x509.Certificate, now time.Time) {
+	conn := AgentConnection{
+		AgentID:       agent.ID,
+		Hostname:      agent.Hostname,
+		RemoteAddr:    remoteAddr,
+		ConnectedAt:   now,
+		LastHeartbeat: now,
+		Version:       agent.Version,
+		Presence:      PresenceOnline,
+	}
+	if cert != nil {
+		conn.CertSubject = cert.Subject.String()
+		conn.CertSerial = cert.SerialNumber.Text(16)
+		conn.CertNotAfter = cert.NotAfter
+	}
+
+	r.mu.Lock()
+	var prev *session
+	if e, ok := r.agents[agent.ID]; ok && e.sess != sess {
+		prev = e.sess
+	}
+	r.agents[agent.ID] = &registryEntry{AgentConnection: conn, sess: sess}
+	r.streams[sess] = struct{}{}
+	r.mu.Unlock()
+	if prev != nil {
+		r.logger.Info("replaced agent stream", zap.Int64("agentId", agent.ID))
+		prev.close(status.Error(codes.AlreadyExists, "replaced by a newer stream"))
+	}
+	r.persist(agent.ID, conn.Version, now)
+}
+
+// Heartbeat records a heartbeat of the agent along with the version and capabilities it reports.
+func (r *registry) Heartbeat(sess *session, version string, capabilities []string, now time.Time) {
+	r.mu.Lock()
+	e, ok := r.agents[sess.agentID]
+	if !ok || e.sess != sess {
+		r.mu.Unlock()
+		return
+	}
+	e.LastHeartbeat = now
+	e.Presence = PresenceOnline
+	versionChanged := version != "" && version != e.Version
+	if version != "" {
+		e.Version = version
+	}
+	e.Capabilities = slices.Clone(capabilities)
+	r.mu.Unlock()
+
+	if versionChanged {
+		r.persist(sess.agentID, version, now)
+	}
+}
+
+// Disconnect marks the agent offline if sess is still its current stream. The entry is kept so
+// the agent's last connection stays visible.
+func (r *registry) Disconnect(sess *session, now time.Time) {
+	r.mu.Lock()
+	delete(r.streams, sess)
+	e, ok := r.agents[sess.agentID]
+	if !ok || e.sess != sess {
+		r.mu.Unlock()
+		return
+	}
+	e.sess = nil
+	e.Presence = PresenceOffline
+	version := e.Version
+	r.mu.Unlock()
+	r.persist(sess.agentID, version, now)
+}
+
+// Get returns the agent's connection.
+func (r *registry) Get(agentID int64) (AgentConnection, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.agents[agentID]
+	if !ok {
+		return AgentConnection{}, false
+	}
+	return e.snapshot(), true
+}
+
+// List returns every agent seen since the server started ordered by id.
+func (r *registry) List() []AgentConnection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	conns := make([]AgentConnection, 0, len(r.agents))
+	for _, e := range r.agents {
+		conns = append(conns, e.snapshot())
+	}
+	slices.SortFunc(conns, func(a, b AgentConnection) int { return cmp.Compare(a.AgentID, b.AgentID) })
+	return conns
+}
+
+// Session returns the current Poll stream of a connected agent.
+func (r *registry) Session(agentID int64) (*session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	e, ok := r.agents[agentID]
+	if !ok || e.sess == nil {
+		return nil, false
+	}
+	return e.sess, true
+}
+
+// Sessions returns every Poll stream that has not ended, of the agent with id agentID when it
+// is not zero.
+func (r *registry) Sessions(agentID int64) []*session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var sessions []*session
+	for sess := range r.streams {
+		if agentID == 0 || sess.agentID == agentID {
+			sessions = append(sessions, sess)
+		}
+	}
+	return sessions
+}
+
+func (e *registryEntry) snapshot() AgentConnection {
+	c := e.AgentConnection
+	c.Capabilities = slices.Clone(c.Capabilities)
+	return c
+}
+
+// Run updates the presence of the agents until ctx is done.
+func (r *registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(min(registrySweepInterval, r.staleAfter))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.sweep(now)
+		}
+	}
+}
+
+// sweep moves the agents that stopped sending heartbeats to stale or offline and records when
+// the online ones were last seen.
+func (r *registry) sweep(now time.Time) {
+	type seen struct {
+		id       int64
+		version  string
+		lastSeen time.Time
+	}
+	var online []seen
+
+	r.mu.Lock()
+	for id, e := range r.agents {
+		if e.sess == nil {
+			continue
+		}
+		presence := PresenceOnline
+		switch silence := now.Sub(e.LastHeartbeat); {
+		case silence >= r.offlineAfter:
+			presence = PresenceOffline
+		case silence >= r.staleAfter:
+			presence = PresenceStale
+		}
+		if presence != e.Presence {
+			r.logger.Warn("agent presence changed", zap.Int64("agentId", id),
+				zap.String("from", e.Presence), zap.String("to", presence), zap.Time("lastHeartbeat", e.LastHeartbeat))
+			e.Presence = presence
+		}
+		if presence == PresenceOnline {
+			online = append(online, seen{id, e.Version, e.LastHeartbeat})
+		}
+	}
+	r.mu.Unlock()
+
+	for _, s := range online {
+		r.persist(s.id, s.version, s.lastSeen)
+	}
+}
+
+// persist records the version and last seen time of an agent in the agent table.
+func (r *registry) persist(agentID int64, version string, lastSeen time.Time) {
+	if err := r.store.UpdateAgentPresence(context.Background(), agentID, version, lastSeen); err != nil {
+		r.logger.Error("failed to update agent presence", zap.Int64("agentId", agentID), zap.Error(err))
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/salzr/acert/store"
+)
+
+type fakePresenceStore struct {
+	mu       sync.Mutex
+	versions map[int64]string
+}
+
+func (f *fakePresenceStore) UpdateAgentPresence(_ context.Context, id int64, version string, _ time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.versions[id] = version
+	return nil
+}
+
+func TestRegistryPresence(t *testing.T) {
+	st := &fakePresenceStore{versions: map[int64]string{}}
+	r := newRegistry(zap.NewNop(), st, 30*time.Second, 2*time.Minute)
+	now := time.Now()
+
+	first := &session{agentID: 1}
+	r.Connect(first, &store.Agent{ID: 1, Hostname: "host"}, "10.0.0.1:1234", nil, now)
+	r.Heartbeat(first, "v1.0.0", []string{"inventory"}, now)
+
+	conn, ok := r.Get(1)
+	if !ok || conn.Presence != PresenceOnline || conn.Version != "v1.0.0" || st.versions[1] != "v1.0.0" {
+		t.Fatalf("expected online agent running v1.0.0, got %+v", conn)
+	}
+
+	for _, tc := range []struct {
+		after time.Duration
+		want  string
+	}{
+		{10 * time.Second, PresenceOnline},
+		{45 * time.Second, PresenceStale},
+		{3 * time.Minute, PresenceOffline},
+	} {
+		r.sweep(now.Add(tc.after))
+		if conn, _ := r.Get(1); conn.Presence != tc.want {
+			t.Errorf("after %s expected %s, got %s", tc.after, tc.want, conn.Presence)
+		}
+	}
+
+	// A reconnect replaces and closes the stream, the old one ending must not mark the agent
+	// offline.
+	var closed error
+	first.cancel = func(err error) { closed = err }
+	second := &session{agentID: 1}
+	r.Connect(second, &store.Agent{ID: 1, Hostname: "host"}, "10.0.0.1:5678", nil, now)
+	if status.Code(closed) != codes.AlreadyExists {
+		t.Errorf("expected the first stream to be closed as %s, got %v", codes.AlreadyExists, closed)
+	}
+	r.Disconnect(first, now)
+	if s, ok := r.Session(1); !ok || s != second {
+		t.Fatal("expected the second stream to stay registered")
+	}
+	r.Disconnect(second, now)
+	if _, ok := r.Session(1); ok {
+		t.Error("expected no stream after disconnect")
+	}
+	if conn, _ := r.Get(1); conn.Presence != PresenceOffline || conn.RemoteAddr != "10.0.0.1:5678" {
+		t.Errorf("expected the last connection to be kept offline, got %+v", conn)
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+)
+
+// Renew signs a new CSR for the agent identified by its current client certificate. Every
+// attempt is audited, including the rejected ones.
+func (s *server) Renew(ctx context.Context, req *pb.RenewRequest) (*pb.RenewResponse, error) {
+	a, cert, err := s.authenticateAgent(ctx)
+	if err != nil {
+		// The caller is not a known agent, it is identified by its address.
+		s.audit(ctx, Actor{Name: "host:" + peerIP(ctx)}, AuditAgentRenew, "agent", "", err)
+		return nil, err
+	}
+	agentId := strconv.FormatInt(a.ID, 10)
+	log := s.logger.With(zap.String("agentId", agentId))
+	actor := agentActor(a.ID, cert)
+
+	csr, err := parseCSR(req.GetCsr())
+	if err != nil {
+		s.audit(ctx, actor, AuditAgentRenew, AgentTarget(a.ID), "", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	m := s.material.Load()
+	issued, certPEM, err := m.agentCA.SignAgent(csr, agentId, s.agentCertTTL, s.revocationURL)
+	if err != nil {
+		log.Error("failed to sign csr", zap.Error(err))
+		s.audit(ctx, actor, AuditAgentRenew, AgentTarget(a.ID), "", err)
+		return nil, status.Error(codes.Internal, "failed to sign csr")
+	}
+	if err := s.recordAgentCertificate(ctx, a.ID, issued); err != nil {
+		// The certificate is signed but never handed out, the serial identifies it in the log.
+		log.Error("failed to record certificate", zap.Error(err))
+		s.audit(ctx, actor, AuditAgentRenew, AgentTarget(a.ID), "certificate "+certificateSerial(issued), err)
+		return nil, status.Error(codes.Internal, "failed to record certificate")
+	}
+
+	s.audit(ctx, actor, AuditAgentRenew, AgentTarget(a.ID), "certificate "+certificateSerial(issued), nil)
+	log.Info("agent certificate renewed", zap.Time("notAfter", issued.NotAfter))
+	return &pb.RenewResponse{
+		CertificateChain: append(certPEM, m.agentCA.PEM()...),
+		CaBundle:         m.serverCA,
+	}, nil
+}
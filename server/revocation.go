@@ -0,0 +1,251 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/salzr/acert/store"
+)
+
+const (
+	// revocationRefreshInterval is how often servers reload the revoked agents and certificates.
+	revocationRefreshInterval = 10 * time.Second
+	// crlPath and ocspPath are where the HTTP port serves the CRL and OCSP responder.
+	crlPath  = "/agent-ca.crl"
+	ocspPath = "/ocsp"
+	// crlValidity is how long a published CRL is valid, it is signed again after half of it.
+	crlValidity  = 24 * time.Hour
+	ocspValidity = time.Hour
+
+	maxOCSPRequestSize = 4096
+)
+
+// revokeAgent revokes the agent and every certificate issued to it. Running servers close the
+// agent's Poll stream and publish its certificates in the CRL on their next refresh.
+func revokeAgent(ctx context.Context, st store.Repository, agentID int64) error {
+	return st.RevokeAgent(ctx, agentID, time.Now())
+}
+
+// revocations is the revocation state of the agent CA as of its last refresh.
+type revocations struct {
+	agents  map[int64]bool
+	serials map[string]bool
+
+	// crl is the DER encoded CRL published at crlUpdated, nil until one is published.
+	crl        []byte
+	crlUpdated time.Time
+}
+
+// check returns an error if cert was revoked or issued to a revoked agent. The agent is checked
+// as well as the serial so certificates issued before serials were recorded are rejected too.
+func (r *revocations) check(cert *x509.Certificate) error {
+	if serial := certificateSerial(cert); r.serials[serial] {
+		return fmt.Errorf("certificate %s is revoked", serial)
+	}
+	if id, err := agentIdentity(cert); err == nil && r.agents[id] {
+		return fmt.Errorf("agent %d is revoked", id)
+	}
+	return nil
+}
+
+// watchRevocations refreshes the revocations until ctx is done.
+func (s *server) watchRevocations(ctx context.Context) {
+	ticker := time.NewTicker(revocationRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.refreshRevocations(ctx, now); err != nil && ctx.Err() == nil {
+				s.logger.Error("failed to refresh revocations", zap.Error(err))
+			}
+		}
+	}
+}
+
+// refreshRevocations loads the revoked agents and certificates and the published CRL from the
+// store and closes the Poll streams of revoked agents.
+func (s *server) refreshRevocations(ctx context.Context, now time.Time) error {
+	agents, err := s.store.ListAgents(ctx)
+	if err != nil {
+		return err
+	}
+	certs, err := s.store.ListRevokedAgentCertificates(ctx, now)
+	if err != nil {
+		return err
+	}
+	crl, err := s.store.GetCRL(ctx)
+	if err != nil && !errors.Is(err, store.ErrCRLNotFound) {
+		return err
+	}
+	r := &revocations{agents: map[int64]bool{}, serials: map[string]bool{}}
+	for _, a := range agents {
+		if a.Revoked != nil {
+			r.agents[a.ID] = true
+		}
+	}
+	for _, c := range certs {
+		r.serials[c.Serial] = true
+	}
+	if crl != nil {
+		r.crl, r.crlUpdated = crl.DER, crl.ThisUpdate
+	}
+	s.revocations.Store(r)
+
+	for id := range r.agents {
+		for _, sess := range s.registry.Sessions(id) {
+			s.logger.Warn("closing stream of revoked agent", zap.Int64("agentId", id))
+			sess.close(status.Error(codes.PermissionDenied, "agent revoked"))
+		}
+	}
+	return nil
+}
+
+// publishCRLs publishes the CRL until ctx is done. Only the leader runs it, the other servers
+// serve the CRL it stores.
+func (s *server) publishCRLs(ctx context.Context) {
+	ticker := time.NewTicker(revocationRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.publishCRL(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			s.logger.Error("failed to publish crl", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishCRL signs and stores the CRL when the revoked certificates or the agent CA changed
+// since the published one, or when half of its validity has passed. CRL numbers keep
+// increasing across leaders.
+func (s *server) publishCRL(ctx context.Context, now time.Time) error {
+	certs, err := s.store.ListRevokedAgentCertificates(ctx, now)
+	if err != nil {
+		return err
+	}
+	prev, err := s.store.GetCRL(ctx)
+	if err != nil && !errors.Is(err, store.ErrCRLNotFound) {
+		return err
+	}
+	ca := s.material.Load().agentCA
+	if prev != nil && now.Sub(prev.ThisUpdate) < crlValidity/2 && crlCurrent(prev.DER, ca, certs) {
+		return nil
+	}
+
+	number := now.UnixMilli()
+	if prev != nil {
+		number = max(number, prev.Number+1)
+	}
+	der, err := ca.CRL(certs, number, now, now.Add(crlValidity))
+	if err != nil {
+		return err
+	}
+	if err := s.store.PutCRL(ctx, &store.CRL{Number: number, DER: der, ThisUpdate: now, NextUpdate: now.Add(crlValidity)}); err != nil {
+		return err
+	}
+	s.logger.Info("published crl", zap.Int("revoked", len(certs)), zap.Int64("number", number))
+	return nil
+}
+
+// crlCurrent reports whether the DER encoded CRL was signed by ca and lists exactly the
+// serials of certs.
+func crlCurrent(der []byte, ca *certificateAuthority, certs []*store.AgentCertificate) bool {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil || crl.CheckSignatureFrom(ca.cert) != nil || len(crl.RevokedCertificateEntries) != len(certs) {
+		return false
+	}
+	serials := map[string]bool{}
+	for _, c := range certs {
+		serials[c.Serial] = true
+	}
+	for _, e := range crl.RevokedCertificateEntries {
+		if !serials[e.SerialNumber.Text(16)] {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyPeerCertificate rejects client certificates that are revoked during the handshake.
+// Connections without a client certificate are left to the handlers.
+func (s *server) verifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	r := s.revocations.Load()
+	if r == nil || len(verifiedChains) == 0 {
+		return nil
+	}
+	if err := r.check(verifiedChains[0][0]); err != nil {
+		s.logger.Warn("rejected client certificate", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// serveCRL serves the CRL of the agent CA.
+func (s *server) serveCRL(w http.ResponseWriter, r *http.Request) {
+	rev := s.revocations.Load()
+	if rev == nil || rev.crl == nil {
+		http.Error(w, "crl unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Last-Modified", rev.crlUpdated.UTC().Format(http.TimeFormat))
+	w.Write(rev.crl)
+}
+
+// serveOCSP answers OCSP requests for agent certificates, sent either as the body of a POST
+// or base64 encoded in the path of a GET as described in RFC 6960 appendix A.
+func (s *server) serveOCSP(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	var err error
+	if r.Method == http.MethodGet {
+		der, err = base64.StdEncoding.DecodeString(r.PathValue("request"))
+	} else {
+		der, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	}
+	var res []byte
+	if err == nil {
+		res, err = s.ocspResponse(r.Context(), der, time.Now())
+	}
+	if err != nil {
+		s.logger.Error("failed to answer ocsp request", zap.Error(err))
+		res = ocsp.InternalErrorErrorResponse
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(res)
+}
+
+// ocspResponse answers the DER encoded OCSP request from the store so revocations are
+// reflected without waiting for a refresh. Certificates that were not recorded are unknown.
+func (s *server) ocspResponse(ctx context.Context, der []byte, now time.Time) ([]byte, error) {
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	certStatus, revokedAt := ocsp.Unknown, time.Time{}
+	c, err := s.store.GetAgentCertificate(ctx, req.SerialNumber.Text(16))
+	switch {
+	case errors.Is(err, store.ErrAgentCertificateNotFound):
+	case err != nil:
+		return nil, err
+	case c.Revoked != nil:
+		certStatus, revokedAt = ocsp.Revoked, *c.Revoked
+	default:
+		certStatus = ocsp.Good
+	}
+	return s.material.Load().agentCA.OCSP(req, certStatus, revokedAt, now, now.Add(ocspValidity))
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+func TestRevocation(t *testing.T) {
+	ca := newTestCA(t)
+	csr, err := parseCSR(newTestCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _, err := ca.SignAgent(csr, "42", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, _, err := ca.SignAgent(csr, "43", time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Truncate(time.Second)
+
+	t.Run("crl", func(t *testing.T) {
+		der, err := ca.CRL([]*store.AgentCertificate{{Serial: certificateSerial(revoked), Revoked: &now}}, 1, now, now.Add(crlValidity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := crl.CheckSignatureFrom(ca.cert); err != nil {
+			t.Fatal(err)
+		}
+		if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(revoked.SerialNumber) != 0 {
+			t.Errorf("unexpected crl entries %v", crl.RevokedCertificateEntries)
+		}
+	})
+
+	t.Run("ocsp", func(t *testing.T) {
+		der, err := ocsp.CreateRequest(revoked, ca.cert, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := ocsp.ParseRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ca.OCSP(req, ocsp.Revoked, now, now, now.Add(ocspValidity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := ocsp.ParseResponseForCert(b, revoked, ca.cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != ocsp.Revoked || !res.RevokedAt.Equal(now) {
+			t.Errorf("expected revoked at %s, got status %d at %s", now, res.Status, res.RevokedAt)
+		}
+
+		b, err = newTestCA(t).OCSP(req, ocsp.Good, time.Time{}, now, now.Add(ocspValidity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, ocsp.UnauthorizedErrorResponse) {
+			t.Error("expected another ca to answer unauthorized")
+		}
+		// A request naming the CA with the key hash of another one is refused as well.
+		other, err := ocsp.CreateRequest(revoked, newTestCA(t).cert, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged, err := ocsp.ParseRequest(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged.IssuerNameHash = req.IssuerNameHash
+		b, err = ca.OCSP(forged, ocsp.Good, time.Time{}, now, now.Add(ocspValidity))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, ocsp.UnauthorizedErrorResponse) {
+			t.Error("expected a request for another issuer key to be answered unauthorized")
+		}
+	})
+
+	t.Run("check", func(t *testing.T) {
+		r := &revocations{agents: map[int64]bool{}, serials: map[string]bool{certificateSerial(revoked): true}}
+		if err := r.check(revoked); err == nil {
+			t.Error("expected the revoked serial to be rejected")
+		}
+		if err := r.check(good); err != nil {
+			t.Errorf("expected a certificate of another agent to pass, got %v", err)
+		}
+		r.agents[43] = true
+		if err := r.check(good); err == nil {
+			t.Error("expected the certificate of a revoked agent to be rejected")
+		}
+	})
+}
+
+func TestPublishCRL(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, newTestDatabase(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	// Two servers share the store, only the leader publishes the CRL.
+	leader := &server{logger: zap.NewNop(), store: st}
+	leader.material.Store(&tlsMaterial{agentCA: newTestCA(t)})
+	follower := &server{logger: zap.NewNop(), store: st, registry: newRegistry(zap.NewNop(), st, time.Minute, time.Minute)}
+
+	now := time.Now().Truncate(time.Second)
+	if err := leader.publishCRL(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	first, err := st.GetCRL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.publishCRL(ctx, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if crl, _ := st.GetCRL(ctx); crl.Number != first.Number {
+		t.Errorf("expected the current crl to be kept, got number %d after %d", crl.Number, first.Number)
+	}
+
+	a := &store.Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: now}
+	if err := st.CreateAgent(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateAgentCertificate(ctx, &store.AgentCertificate{Serial: "1a", AgentID: a.ID,
+		NotBefore: now, NotAfter: now.Add(time.Hour), CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RevokeAgent(ctx, a.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.publishCRL(ctx, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := follower.refreshRevocations(ctx, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(follower.revocations.Load().crl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crl.Number.Int64() <= first.Number {
+		t.Errorf("expected the crl number to increase from %d, got %d", first.Number, crl.Number)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Text(16) != "1a" {
+		t.Errorf("expected the follower to serve a crl revoking 1a, got %v", crl.RevokedCertificateEntries)
+	}
+}
+
+func TestRevokedAgentStreams(t *testing.T) {
+	ctx := context.Background()
+	s, sess, task := newTaskTestServer(t)
+	s.registry = newRegistry(zap.NewNop(), s.store, defaultAgentStaleAfter, defaultAgentOfflineAfter)
+	s.issuer = &caIssuer{ca: newTestCA(t)}
+
+	// The replaced stream of the agent may still be running when the agent is revoked.
+	closed := map[*session]error{}
+	replaced := &session{agentID: sess.agentID}
+	for _, stream := range []*session{replaced, sess} {
+		s.registry.Connect(stream, &store.Agent{ID: sess.agentID}, "10.0.0.1:1234", nil, time.Now())
+		stream.cancel = func(err error) { closed[stream] = err }
+	}
+	if err := revokeAgent(ctx, s.store, sess.agentID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.refreshRevocations(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range []*session{replaced, sess} {
+		if status.Code(closed[stream]) != codes.PermissionDenied {
+			t.Errorf("expected every stream of the revoked agent to be closed, got %v", closed[stream])
+		}
+	}
+
+	if _, _, _, err := s.issueCertificate(ctx, sess.agentID, &pb.CertificateSigningRequest{TaskId: task.ID,
+		Csr: newTestCSR(t)}); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("expected the csr of a revoked agent to be rejected, got %v", err)
+	}
+}
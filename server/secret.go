@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	TLSSourceFile   = "file"
+	TLSSourceSecret = "secret"
+
+	defaultNamespace     = "acert-system"
+	defaultServingSecret = "acert-agent-ca-cert"
+	defaultAgentCASecret = "acert-agent-ca"
+
+	secretRewatchDelay = 5 * time.Second
+)
+
+// loadSecretMaterial reads the TLS material from the cert-manager Secrets created by bootstrap.
+// The serving Secret provides the grpc key pair and, through ca.crt, the server CA handed to
+// agents. The agent CA Secret provides both the client CA bundle and the signing key pair. The
+// operator CA is read from its file.
+func loadSecretMaterial(ctx context.Context, c client.Client, options Options) (*tlsMaterial, error) {
+	serving, err := getSecret(ctx, c, options.Namespace, options.ServingSecret)
+	if err != nil {
+		return nil, err
+	}
+	servingPair, err := tls.X509KeyPair(serving.Data[corev1.TLSCertKey], serving.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keypair in secret %s: %w", serving.Name, err)
+	}
+
+	agent, err := getSecret(ctx, c, options.Namespace, options.AgentCASecret)
+	if err != nil {
+		return nil, err
+	}
+	agentPair, err := tls.X509KeyPair(agent.Data[corev1.TLSCertKey], agent.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse keypair in secret %s: %w", agent.Name, err)
+	}
+	agentCA, err := newCertificateAuthority(agentPair)
+	if err != nil {
+		return nil, err
+	}
+
+	operatorCA, err := readOperatorCA(options)
+	if err != nil {
+		return nil, err
+	}
+	return newTLSMaterial(servingPair, agent.Data[corev1.TLSCertKey], agentCA, serving.Data["ca.crt"], operatorCA)
+}
+
+func getSecret(ctx context.Context, c client.Client, namespace, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", namespace, name, err)
+	}
+	return secret, nil
+}
+
+// watchSecrets calls reload whenever one of the TLS Secrets changes until ctx is done.
+// The watch is re-established when the api server closes it.
+func watchSecrets(ctx context.Context, log *zap.Logger, c client.WithWatch, options Options, reload func()) {
+	names := map[string]bool{options.ServingSecret: true, options.AgentCASecret: true}
+	for {
+		w, err := c.Watch(ctx, &corev1.SecretList{}, client.InNamespace(options.Namespace))
+		if err != nil {
+			log.Error("failed to watch secrets", zap.Error(err))
+		} else {
+			for ev := range w.ResultChan() {
+				secret, ok := ev.Object.(*corev1.Secret)
+				if !ok || !names[secret.Name] || ev.Type == watch.Deleted {
+					continue
+				}
+				log.Info("tls secret changed", zap.String("secret", secret.Name), zap.String("event", string(ev.Type)))
+				reload()
+			}
+			w.Stop()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(secretRewatchDelay):
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testSecret(t *testing.T, name string, ca *certificateAuthority, caBundle []byte) *corev1.Secret {
+	t.Helper()
+	key, err := x509.MarshalPKCS8PrivateKey(ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: defaultNamespace},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       ca.PEM(),
+			corev1.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+			"ca.crt":                caBundle,
+		},
+	}
+}
+
+func TestLoadSecretMaterial(t *testing.T) {
+	serving, agentCA, serverCA := newTestCA(t), newTestCA(t), newTestCA(t)
+	c := fake.NewClientBuilder().WithObjects(
+		testSecret(t, defaultServingSecret, serving, serverCA.PEM()),
+		testSecret(t, defaultAgentCASecret, agentCA, agentCA.PEM()),
+	).Build()
+
+	m, err := loadSecretMaterial(context.Background(), c, DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(m.serverCA) != string(serverCA.PEM()) {
+		t.Error("expected server ca from the serving secret ca.crt")
+	}
+	if !m.agentCA.cert.Equal(agentCA.cert) {
+		t.Error("expected agent ca from the agent ca secret")
+	}
+	if !m.clientCAs.Equal(func() *x509.CertPool {
+		p := x509.NewCertPool()
+		p.AddCert(agentCA.cert)
+		return p
+	}()) {
+		t.Error("expected client cas to contain the agent ca")
+	}
+
+	opts := DefaultOptions()
+	opts.AgentCASecret = "missing"
+	if _, err := loadSecretMaterial(context.Background(), c, opts); err == nil {
+		t.Error("expected error for missing secret")
+	}
+}
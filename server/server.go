@@ -4,126 +4,556 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
+	"net/http"
+	"net/url"
 	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"k8s.io/client-go/tools/leaderelection"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/k8s"
 	pb "github.com/salzr/acert/proto/agentservice/v1"
+	mpb "github.com/salzr/acert/proto/managementservice/v1"
+	"github.com/salzr/acert/store"
+	"github.com/salzr/acert/store/kube"
 )
 
-// TODO: create a ticket in the database and share that with the agent
-// With that ticket the agent will be able to init a configuration which will contain
-// * client certs for mtls
-// -- the client certs will be generated by the agent
-// -- agent will pull in runtime the client ca's to sign the certificate
+// Agents enroll with a join token created by `acert server create-token`. The agent generates
+// its own key and sends a CSR along with the token, the server signs it with the agent CA and
+// returns the client certificate used for mtls on Poll together with the server CA bundle.
 const (
-	defaultGRPCPort = 50051
-	defaultPort     = 8080
+	// StorageSQL keeps the server state in a sqlite or PostgreSQL database, StorageKubernetes
+	// as custom resources.
+	StorageSQL        = "sql"
+	StorageKubernetes = "kubernetes"
+
+	DefaultConfigFile = "/etc/acert/server.yaml"
+
+	defaultGRPCPort        = 50051
+	defaultPort            = 8080
+	defaultDatabase        = "db/acert.db"
+	defaultCertFile        = "config/certmanager/grpc.crt"
+	defaultKeyFile         = "config/certmanager/grpc.key"
+	defaultMinTLSVersion   = "1.2"
+	defaultReloadInterval  = 30 * time.Second
+	defaultAgentCACertFile = "config/certmanager/agent-ca.crt"
+	defaultAgentCAKeyFile  = "config/certmanager/agent-ca.key"
+	defaultServerCAFile    = "config/certmanager/server-ca.crt"
+	defaultAgentCertTTL    = 90 * 24 * time.Hour
+	defaultTaskTimeout     = 10 * time.Minute
+	defaultTaskAckTimeout  = 30 * time.Second
+	defaultTaskMaxAttempts = 5
+	defaultShutdownTimeout = 30 * time.Second
+
+	// httpReadHeaderTimeout bounds how long clients of the http port can take to send the
+	// request headers.
+	httpReadHeaderTimeout = 10 * time.Second
+)
+
+var (
+	// keepaliveParams close connections of agents that stopped answering pings, their streams
+	// would otherwise stay registered until the next send fails.
+	keepaliveParams = keepalive.ServerParameters{
+		Time:    time.Minute,
+		Timeout: 20 * time.Second,
+	}
+	// keepaliveEnforcement allows the pings agents send every 30 seconds, the grpc default
+	// closes connections pinging more often than every 5 minutes.
+	keepaliveEnforcement = keepalive.EnforcementPolicy{
+		MinTime:             15 * time.Second,
+		PermitWithoutStream: true,
+	}
 )
 
 type Options struct {
-	GRPCPort int
-	Port     int
+	// GRPCBindAddress and BindAddress are the interfaces the grpc and http ports bind to,
+	// empty binds every interface.
+	GRPCBindAddress string
+	GRPCPort        int
+	BindAddress     string
+	Port            int
+	// HTTPTLS serves the http port with TLS using the serving certificate of the grpc port.
+	// Without it the REST gateway of the ManagementService is only served on a loopback
+	// BindAddress, as its bearer tokens would cross the network in the clear.
+	HTTPTLS bool
+	// Storage is either StorageSQL to keep the server state in the database below or
+	// StorageKubernetes to keep it as acert.salzr.io resources in Namespace.
+	Storage string
+	// Database is the path of the sqlite database, used unless DatabaseURL is set.
+	Database string
+	// DatabaseURL selects the database by url, postgres:// for a PostgreSQL database several
+	// servers can share.
+	DatabaseURL string
+
+	// CertFile and KeyFile are the serving key pair of the grpc listener.
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is the CA bundle agent client certificates are verified against.
+	ClientCAFile string
+	// MinTLSVersion is either 1.2 or 1.3.
+	MinTLSVersion string
+	// CipherSuites restricts the TLS 1.2 cipher suites by name, empty keeps the crypto/tls defaults.
+	CipherSuites []string
+	// ReloadInterval is how often the TLS files are checked for rotations.
+	ReloadInterval time.Duration
+
+	// AgentCACertFile and AgentCAKeyFile are the key pair used to sign agent client certificates.
+	AgentCACertFile string
+	AgentCAKeyFile  string
+	// ServerCAFile is the CA bundle handed to agents to verify the server.
+	ServerCAFile string
+	AgentCertTTL time.Duration
+	// RevocationURL is the base URL the HTTP port is reachable at by the relying parties of agent
+	// certificates, e.g. https://acert.example.com:8080. Agent certificates carry its CRL and OCSP
+	// URLs, empty leaves them out.
+	RevocationURL string
+
+	// TLSSource is either TLSSourceFile to read the TLS material from the files above or
+	// TLSSourceSecret to read it from the Secrets bootstrap creates in Namespace.
+	TLSSource     string
+	Namespace     string
+	ServingSecret string
+	AgentCASecret string
+
+	// Issuer signs the certificates of IssueCertificate tasks, either IssuerCA with the
+	// IssuerCertFile and IssuerKeyFile key pair or IssuerCertManager with the cert-manager
+	// IssuerName of IssuerKind in Namespace. Empty fails such tasks.
+	Issuer         string
+	IssuerCertFile string
+	IssuerKeyFile  string
+	IssuerName     string
+	IssuerKind     string
+
+	// TaskTimeout is how long a dispatched task has to finish before it is timed out.
+	TaskTimeout time.Duration
+	// TaskAckTimeout is how long the agent has to accept a task before it is redelivered, up to
+	// TaskMaxAttempts deliveries.
+	TaskAckTimeout  time.Duration
+	TaskMaxAttempts int
+
+	// LeaderElection elects the server running the singleton jobs, timing out tasks and
+	// publishing the CRL, with the Lease LeaderElectionID in Namespace. It is needed when
+	// several servers share the store.
+	LeaderElection   bool
+	LeaderElectionID string
+
+	// AgentStaleAfter and AgentOfflineAfter are how long an agent can miss heartbeats before it
+	// is considered stale and offline.
+	AgentStaleAfter   time.Duration
+	AgentOfflineAfter time.Duration
+	// ManagementTokenFile holds the bearer tokens of the ManagementService, one per line. The
+	// service is served on the grpc port and as REST/JSON on the http port, see HTTPTLS.
+	ManagementTokenFile string
+	// OperatorCAFile is the CA bundle operator client certificates are verified against, they
+	// authenticate to the ManagementService on the grpc port. It must not trust agent
+	// certificates. The service is disabled when neither it nor ManagementTokenFile is set.
+	OperatorCAFile string
+
+	// ShutdownTimeout is how long in flight calls are drained for when the server stops.
+	ShutdownTimeout time.Duration
 }
 
 func DefaultOptions() Options {
 	return Options{
-		GRPCPort: defaultGRPCPort,
-		Port:     defaultPort,
+		GRPCPort:        defaultGRPCPort,
+		Port:            defaultPort,
+		Storage:         StorageSQL,
+		Database:        defaultDatabase,
+		CertFile:        defaultCertFile,
+		KeyFile:         defaultKeyFile,
+		ClientCAFile:    defaultAgentCACertFile,
+		MinTLSVersion:   defaultMinTLSVersion,
+		ReloadInterval:  defaultReloadInterval,
+		AgentCACertFile: defaultAgentCACertFile,
+		AgentCAKeyFile:  defaultAgentCAKeyFile,
+		ServerCAFile:    defaultServerCAFile,
+		AgentCertTTL:    defaultAgentCertTTL,
+		TLSSource:       TLSSourceFile,
+		Namespace:       defaultNamespace,
+		ServingSecret:   defaultServingSecret,
+		AgentCASecret:   defaultAgentCASecret,
+		IssuerName:      defaultIssuerName,
+		IssuerKind:      defaultIssuerKind,
+		TaskTimeout:     defaultTaskTimeout,
+		TaskAckTimeout:  defaultTaskAckTimeout,
+		TaskMaxAttempts: defaultTaskMaxAttempts,
+
+		LeaderElectionID: defaultLeaderElectionID,
+
+		AgentStaleAfter:   defaultAgentStaleAfter,
+		AgentOfflineAfter: defaultAgentOfflineAfter,
+		ShutdownTimeout:   defaultShutdownTimeout,
 	}
 }
 
 type server struct {
 	pb.UnimplementedAgentServiceServer
 
-	logger *zap.Logger
+	logger       *zap.Logger
+	store        store.Repository
+	material     atomic.Pointer[tlsMaterial]
+	agentCertTTL time.Duration
+	// revocationURL is the base URL agent certificates point to for the CRL and OCSP.
+	revocationURL string
+	issuer        issuer
+
+	taskAckTimeout  time.Duration
+	taskMaxAttempts int
+
+	registry    *registry
+	revocations atomic.Pointer[revocations]
+	// leading is set while the server runs the singleton jobs.
+	leading    atomic.Bool
+	management *managementServer
+	metrics    *metrics
+	health     *health.Server
+}
+
+// verifiedPeerCertificate returns the client certificate of the caller if it was verified
+// against the agent CA, nil otherwise.
+func verifiedPeerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 {
+		return nil
+	}
+	return tlsInfo.State.VerifiedChains[0][0]
 }
 
 func (s *server) Poll(stream pb.AgentService_PollServer) error {
 	log := s.logger
 
+	// The agent is identified by its client certificate, every message must carry the same id.
+	agent, cert, err := s.authenticateAgent(stream.Context())
+	if err != nil {
+		return err
+	}
+	id := agent.ID
+	agentId := strconv.FormatInt(id, 10)
+
 	req, err := stream.Recv()
 	if err != nil {
 		return err
 	}
+	var remoteAddr string
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		remoteAddr = p.Addr.String()
+	}
+	log.Info("agent connected", zap.String("agentId", agentId), zap.String("remoteAddr", remoteAddr))
 
-	agentId := req.GetAgentId()
-	log.Info("agent connected", zap.String("agentId", agentId))
+	ctx, cancel := context.WithCancelCause(stream.Context())
+	defer cancel(nil)
+	sess := &session{agentID: id, cert: cert, stream: stream, cancel: cancel, wake: make(chan struct{}, 1)}
+	s.registry.Connect(sess, agent, remoteAddr, cert, time.Now())
+	defer s.registry.Disconnect(sess, time.Now())
+	go s.dispatchTasks(ctx, sess)
 
-	for {
-		req, err = stream.Recv()
-		if err == io.EOF {
-			log.Info("agent disconnected", zap.String("agentId", agentId))
-			return nil
-		}
-		if err != nil {
-			log.Error("error receiving from agent", zap.String("agentId", agentId), zap.Error(err))
+	// The stream is received from in the background so it can be closed by the server, e.g.
+	// when the agent is revoked. Returning ends the stream and the pending Recv with it.
+	errc := make(chan error, 1)
+	go func() { errc <- s.receive(ctx, sess, req) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		if err := context.Cause(ctx); status.Code(err) != codes.Unknown {
+			log.Info("closed agent stream", zap.String("agentId", agentId), zap.Error(err))
 			return err
 		}
+		return ctx.Err()
+	}
+}
+
+// receive handles the messages of the agent, starting with req, until the stream ends.
+func (s *server) receive(ctx context.Context, sess *session, req *pb.AgentRequest) error {
+	log := s.logger
+	id := sess.agentID
+	agentId := strconv.FormatInt(id, 10)
+	for {
+		if req.GetAgentId() != agentId {
+			log.Warn("rejected message for another agent", zap.String("agentId", agentId),
+				zap.String("messageAgentId", req.GetAgentId()))
+			s.metrics.streamErrors.Inc()
+			return status.Errorf(codes.PermissionDenied, "agent id %q does not match the client certificate", req.GetAgentId())
+		}
 
 		if heartbeat := req.GetHeartbeat(); heartbeat != nil {
 			log.Info("agent heartbeat", zap.String("agentId", agentId))
-			stream.Send(&pb.AgentResponse{
+			s.registry.Heartbeat(sess, heartbeat.Version, heartbeat.Capabilities, time.Now())
+			s.metrics.heartbeats.Inc()
+			sess.send(&pb.AgentResponse{
 				Payload: &pb.AgentResponse_ServerStatus{
 					ServerStatus: &pb.ServerStatus{
 						Message: fmt.Sprintf("Acknowledged heartbeat for %s", agentId),
 					},
 				},
 			})
+		} else if inventory := req.GetInventory(); inventory != nil {
+			if err := s.recordInventory(ctx, id, inventory); err != nil {
+				log.Error("failed to record inventory", zap.String("agentId", agentId), zap.Error(err))
+			} else {
+				log.Info("agent inventory", zap.String("agentId", agentId),
+					zap.Int("certificates", len(inventory.Certificates)))
+			}
+		} else if csr := req.GetCsr(); csr != nil {
+			go s.handleCSR(ctx, sess, csr)
+		} else if accepted := req.GetTaskAccepted(); accepted != nil {
+			if err := s.handleTaskAccepted(ctx, sess, accepted); err != nil {
+				log.Error("failed to accept task", zap.String("agentId", agentId),
+					zap.String("taskId", accepted.TaskId), zap.Error(err))
+			}
+		} else if progress := req.GetTaskProgress(); progress != nil {
+			if err := s.handleTaskProgress(ctx, sess, progress); err != nil {
+				log.Error("failed to record task progress", zap.String("agentId", agentId),
+					zap.String("taskId", progress.TaskId), zap.Error(err))
+			}
+		} else if result := req.GetTaskResult(); result != nil {
+			if err := s.handleTaskResult(ctx, sess, result); err != nil {
+				log.Error("failed to record task result", zap.String("agentId", agentId),
+					zap.String("taskId", result.TaskId), zap.Error(err))
+			} else {
+				log.Info("task finished", zap.String("agentId", agentId), zap.String("taskId", result.TaskId),
+					zap.Stringer("status", result.Status))
+			}
+		}
+
+		var err error
+		req, err = sess.stream.Recv()
+		if err == io.EOF {
+			log.Info("agent disconnected", zap.String("agentId", agentId))
+			return nil
+		}
+		if err != nil {
+			log.Error("error receiving from agent", zap.String("agentId", agentId), zap.Error(err))
+			s.metrics.streamErrors.Inc()
+			return err
 		}
 	}
 }
 
+// OpenStore opens the storage of the options. Databases have their migrations applied, the
+// Kubernetes storage needs the CRDs bootstrap installs.
+func OpenStore(ctx context.Context, options Options) (store.Repository, error) {
+	switch options.Storage {
+	case StorageSQL:
+		if options.DatabaseURL != "" {
+			return store.OpenURL(ctx, options.DatabaseURL)
+		}
+		return store.Open(ctx, options.Database)
+	case StorageKubernetes:
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create rest config: %w", err)
+		}
+		c, err := k8s.NewClient(ctx, cfg, acertv1.SchemeBuilder)
+		if err != nil {
+			return nil, err
+		}
+		return kube.Open(ctx, c, options.Namespace)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", options.Storage)
+	}
+}
+
+// loopback reports whether the bind address only accepts local connections.
+func loopback(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Run serves the agent and management services until ctx is done or SIGINT or SIGTERM is
+// received, then shuts down gracefully. Errors starting or serving are returned.
 func Run(ctx context.Context, options Options) error {
 	log := ctx.Value("logger").(*zap.Logger)
 	log = log.With(zap.String("service", "agentservice"))
 
-	shutdown := make(chan os.Signal, 1)
-	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		cert, err := tls.LoadX509KeyPair(
-			"/Users/dsalazar/Documents/workspace/github.com/salzr/acert/config/certmanager/grpc.crt",
-			"/Users/dsalazar/Documents/workspace/github.com/salzr/acert/config/certmanager/grpc.key")
-		if err != nil {
-			log.Fatal("failed to load keypair", zap.Error(err))
+	if options.RevocationURL != "" {
+		if u, err := url.Parse(options.RevocationURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid revocation url %q", options.RevocationURL)
 		}
-		ca := x509.NewCertPool()
-		caFilePath := "/Users/dsalazar/Documents/workspace/github.com/salzr/acert/config/certmanager/agent-ca.crt"
-		caBytes, err := os.ReadFile(caFilePath)
-		if err != nil {
-			log.Fatal("failed to read ca cert", zap.Error(err))
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	st, err := OpenStore(ctx, options)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// Background loops get their own context so they stop before the store is closed, whether
+	// Run returns on shutdown or on an error.
+	bgCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	srv := &server{
+		logger:        log,
+		store:         st,
+		agentCertTTL:  options.AgentCertTTL,
+		revocationURL: options.RevocationURL,
+
+		taskAckTimeout:  options.TaskAckTimeout,
+		taskMaxAttempts: options.TaskMaxAttempts,
+		registry:        newRegistry(log, st, options.AgentStaleAfter, options.AgentOfflineAfter),
+	}
+	srv.metrics = newMetrics(srv)
+	srv.health = health.NewServer()
+	if srv.issuer, err = newIssuer(ctx, options); err != nil {
+		return fmt.Errorf("failed to configure issuer: %w", err)
+	}
+	if err := srv.watchMaterial(bgCtx, options); err != nil {
+		return fmt.Errorf("failed to load tls material: %w", err)
+	}
+	var elector *leaderelection.LeaderElector
+	if options.LeaderElection {
+		if elector, err = srv.newLeaderElector(options); err != nil {
+			return fmt.Errorf("failed to configure leader election: %w", err)
 		}
-		if ok := ca.AppendCertsFromPEM(caBytes); !ok {
-			log.Fatal("failed to parse", zap.String("filepath", caFilePath))
+	} else if err := srv.publishCRL(ctx, time.Now()); err != nil {
+		// Without other servers the CRL is published before serving, it is retried by the
+		// singleton jobs.
+		log.Error("failed to publish crl", zap.Error(err))
+	}
+	if err := srv.refreshRevocations(ctx, time.Now()); err != nil {
+		return fmt.Errorf("failed to load revocations: %w", err)
+	}
+	tlsConfig, err := serverTLSConfig(options, srv.material.Load, srv.verifyPeerCertificate)
+	if err != nil {
+		return fmt.Errorf("failed to configure tls: %w", err)
+	}
+	if options.ManagementTokenFile != "" || options.OperatorCAFile != "" {
+		if srv.management, err = newManagementServer(srv, options.ManagementTokenFile); err != nil {
+			return fmt.Errorf("failed to configure management service: %w", err)
 		}
+	}
+	gateway := options.HTTPTLS || loopback(options.BindAddress)
+	if srv.management != nil && !gateway {
+		log.Warn("not serving the management gateway over plain http on a non loopback address, enable http tls to serve it")
+	}
+	handler, err := srv.httpHandler(bgCtx, gateway)
+	if err != nil {
+		return err
+	}
 
-		tlsConfig := &tls.Config{
-			ClientAuth:   tls.RequireAndVerifyClientCert,
-			Certificates: []tls.Certificate{cert},
-			ClientCAs:    ca,
-		}
+	grpcLis, err := net.Listen("tcp", net.JoinHostPort(options.GRPCBindAddress, strconv.Itoa(options.GRPCPort)))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	httpLis, err := net.Listen("tcp", net.JoinHostPort(options.BindAddress, strconv.Itoa(options.Port)))
+	if err != nil {
+		grpcLis.Close()
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	if options.HTTPTLS {
+		httpLis = tls.NewListener(httpLis, tlsConfig)
+	}
 
-		lis, err := net.Listen("tcp", fmt.Sprintf(":%d", options.GRPCPort))
-		if err != nil {
-			log.Fatal("failed to listen", zap.Error(err))
+	wg.Go(func() { srv.registry.Run(bgCtx) })
+	wg.Go(func() { srv.watchRevocations(bgCtx) })
+	wg.Go(func() { srv.watchTasks(bgCtx) })
+	if elector != nil {
+		wg.Go(func() { campaign(bgCtx, elector) })
+	} else {
+		wg.Go(func() { srv.runSingletons(bgCtx, options.TaskTimeout) })
+	}
+
+	s := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.KeepaliveParams(keepaliveParams),
+		grpc.KeepaliveEnforcementPolicy(keepaliveEnforcement),
+	)
+	pb.RegisterAgentServiceServer(s, srv)
+	if srv.management != nil {
+		mpb.RegisterManagementServiceServer(s, srv.management)
+	}
+	healthpb.RegisterHealthServer(s, srv.health)
+	srv.health.SetServingStatus(pb.AgentService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	httpServer := &http.Server{Handler: handler, ReadHeaderTimeout: httpReadHeaderTimeout}
+
+	errc := make(chan error, 2)
+	go func() {
+		log.Info("server listening", zap.String("address", grpcLis.Addr().String()))
+		if err := s.Serve(grpcLis); err != nil {
+			errc <- fmt.Errorf("failed to serve: %w", err)
 		}
-		s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
-		pb.RegisterAgentServiceServer(s, &server{logger: log})
-		log.Info("server listening", zap.String("address", lis.Addr().String()))
-		if err := s.Serve(lis); err != nil {
-			log.Fatal("failed to serve", zap.Error(err))
+	}()
+	go func() {
+		log.Info("serving http", zap.String("address", httpLis.Addr().String()))
+		if err := httpServer.Serve(httpLis); !errors.Is(err, http.ErrServerClosed) {
+			errc <- fmt.Errorf("failed to serve http: %w", err)
 		}
 	}()
 
-	<-shutdown
-	log.Info("shutting down")
-	return nil
+	select {
+	case <-ctx.Done():
+		log.Info("shutting down")
+	case err = <-errc:
+		log.Error("shutting down after error", zap.Error(err))
+	}
+	srv.shutdown(s, httpServer, options.ShutdownTimeout)
+	return err
+}
+
+// shutdown stops serving within timeout. Health checks report not serving right away and
+// connected agents are asked to reconnect before their streams are closed, then in flight
+// calls are drained. Whatever is still running after the timeout is cut off.
+func (s *server) shutdown(grpcServer *grpc.Server, httpServer *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	s.health.Shutdown()
+	for _, sess := range s.registry.Sessions(0) {
+		err := sess.send(&pb.AgentResponse{
+			Payload: &pb.AgentResponse_Reconnect{Reconnect: &pb.Reconnect{Reason: "server shutting down"}},
+		})
+		if err != nil {
+			s.logger.Warn("failed to notify agent", zap.Int64("agentId", sess.agentID), zap.Error(err))
+		}
+		sess.close(status.Error(codes.Unavailable, "server shutting down"))
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.logger.Warn("drain timed out, closing remaining connections")
+		grpcServer.Stop()
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		s.logger.Warn("failed to shut down http server", zap.Error(err))
+		httpServer.Close()
+	}
+	s.logger.Info("server stopped")
 }
@@ -0,0 +1,302 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+// newTestDatabase creates a sqlite database with the changesets of the changelog applied.
+func newTestDatabase(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acert.db")
+	st, err := store.Open(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// testServer is a server started by Run with the TLS material in config/certmanager.
+type testServer struct {
+	options Options
+	cancel  context.CancelFunc
+	done    chan error
+}
+
+func startTestServer(t *testing.T, configure ...func(*Options)) *testServer {
+	t.Helper()
+	options := DefaultOptions()
+	options.Database = newTestDatabase(t)
+	options.GRPCBindAddress = "127.0.0.1"
+	options.GRPCPort = freePort(t)
+	options.BindAddress = "127.0.0.1"
+	options.Port = freePort(t)
+	for _, f := range []*string{&options.CertFile, &options.KeyFile, &options.ClientCAFile,
+		&options.AgentCACertFile, &options.AgentCAKeyFile, &options.ServerCAFile} {
+		*f = filepath.Join("..", *f)
+	}
+	options.ShutdownTimeout = 5 * time.Second
+	for _, c := range configure {
+		c(&options)
+	}
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "logger", zap.NewNop()))
+	ts := &testServer{options: options, cancel: cancel, done: make(chan error, 1)}
+	go func() { ts.done <- Run(ctx, options) }()
+	t.Cleanup(func() {
+		if err := ts.stop(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	readyz := ts.url(t, "/readyz")
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		if res, err := ts.httpClient(t).Get(readyz); err == nil {
+			res.Body.Close()
+			if res.StatusCode == http.StatusOK {
+				return ts
+			}
+		}
+		select {
+		case err := <-ts.done:
+			t.Fatalf("server stopped: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server not ready")
+		}
+	}
+}
+
+// url returns the url of path on the http port.
+func (ts *testServer) url(t *testing.T, path string) string {
+	t.Helper()
+	scheme := "http"
+	if ts.options.HTTPTLS {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://127.0.0.1:%d%s", scheme, ts.options.Port, path)
+}
+
+// httpClient returns a client of the http port trusting the server CA.
+func (ts *testServer) httpClient(t *testing.T) *http.Client {
+	t.Helper()
+	serverCA, err := os.ReadFile(ts.options.ServerCAFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCA)
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    roots,
+		ServerName: "server.acert.salzr.localhost",
+	}}}
+}
+
+// stop cancels the server and returns what Run returned.
+func (ts *testServer) stop() error {
+	ts.cancel()
+	select {
+	case err := <-ts.done:
+		ts.done <- err
+		return err
+	case <-time.After(2 * ts.options.ShutdownTimeout):
+		return errors.New("server did not stop")
+	}
+}
+
+// dialAgent enrolls an agent directly in the store and connects with a certificate issued to it.
+func (ts *testServer) dialAgent(t *testing.T) (*store.Agent, *grpc.ClientConn) {
+	t.Helper()
+	ctx := context.Background()
+	st, err := store.Open(ctx, ts.options.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	a := &store.Agent{Hostname: "test", IP: "127.0.0.1", Token: "test", CreatedAt: time.Now()}
+	if err := st.CreateAgent(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	ca, err := loadCertificateAuthority(ts.options.AgentCACertFile, ts.options.AgentCAKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := ca.SignAgent(&x509.CertificateRequest{PublicKey: key.Public()}, strconv.FormatInt(a.ID, 10), time.Hour, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCA, err := os.ReadFile(ts.options.ServerCAFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(serverCA)
+	conn, err := grpc.NewClient(net.JoinHostPort(ts.options.GRPCBindAddress, strconv.Itoa(ts.options.GRPCPort)),
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}},
+			RootCAs:      roots,
+			ServerName:   "server.acert.salzr.localhost",
+		})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return a, conn
+}
+
+func TestRunShutdown(t *testing.T) {
+	ts := startTestServer(t)
+	a, conn := ts.dialAgent(t)
+
+	stream, err := pb.NewAgentServiceClient(conn).Poll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&pb.AgentRequest{
+		AgentId: strconv.FormatInt(a.ID, 10),
+		Payload: &pb.AgentRequest_Heartbeat{Heartbeat: &pb.AgentHeartbeat{Timestamp: time.Now().Unix()}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := stream.Recv(); err != nil || res.GetServerStatus() == nil {
+		t.Fatalf("expected a heartbeat status, got %v (%v)", res, err)
+	}
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- ts.stop() }()
+
+	res, err := stream.Recv()
+	if err != nil || res.GetReconnect() == nil {
+		t.Fatalf("expected to be asked to reconnect, got %v (%v)", res, err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("expected the stream to end as unavailable, got %v", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestPollReplacesStream(t *testing.T) {
+	ts := startTestServer(t)
+	a, conn := ts.dialAgent(t)
+
+	// poll opens a stream and waits until the server registered it.
+	poll := func() pb.AgentService_PollClient {
+		t.Helper()
+		stream, err := pb.NewAgentServiceClient(conn).Poll(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(&pb.AgentRequest{
+			AgentId: strconv.FormatInt(a.ID, 10),
+			Payload: &pb.AgentRequest_Heartbeat{Heartbeat: &pb.AgentHeartbeat{Timestamp: time.Now().Unix()}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if res, err := stream.Recv(); err != nil || res.GetServerStatus() == nil {
+			t.Fatalf("expected a heartbeat status, got %v (%v)", res, err)
+		}
+		return stream
+	}
+	first := poll()
+	poll()
+	if _, err := first.Recv(); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("expected the first stream to end as replaced, got %v", err)
+	}
+}
+
+func TestHTTPGateway(t *testing.T) {
+	for address, want := range map[string]bool{"127.0.0.1": true, "::1": true, "localhost": true, "": false,
+		"0.0.0.0": false, "10.0.0.1": false} {
+		if got := loopback(address); got != want {
+			t.Errorf("expected loopback(%q) to be %v, got %v", address, want, got)
+		}
+	}
+
+	tokens := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(tokens, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ts := startTestServer(t, func(o *Options) {
+		o.HTTPTLS = true
+		o.ManagementTokenFile = tokens
+	})
+	req, err := http.NewRequest(http.MethodGet, ts.url(t, "/v1/agents"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := ts.httpClient(t).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the gateway to be served over tls, got %s", res.Status)
+	}
+	if res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/readyz", ts.options.Port)); err == nil {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			t.Error("expected plain http to be refused")
+		}
+	}
+
+	m, err := newManagementServer(&server{}, tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{management: m, metrics: &metrics{reg: prometheus.NewRegistry()}}
+	handler, err := s.httpHandler(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected the gateway not to be mounted, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,471 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/salzr/acert/certname"
+	"github.com/salzr/acert/keytype"
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+const (
+	TaskTypeIssueCertificate = "issue-certificate"
+
+	taskDispatchInterval = 5 * time.Second
+	taskExpiryInterval   = 30 * time.Second
+	taskWatchRetryDelay  = 5 * time.Second
+)
+
+// CreateIssueCertificateTask validates spec and queues it for the agent.
+func CreateIssueCertificateTask(ctx context.Context, st store.Repository, agentID int64, spec *pb.IssueCertificate) (*store.Task, error) {
+	if err := validateIssueCertificate(spec); err != nil {
+		return nil, err
+	}
+	if _, err := st.GetAgent(ctx, agentID); err != nil {
+		return nil, err
+	}
+	b, err := protojson.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	t := &store.Task{
+		ID:        uuid.NewString(),
+		AgentID:   agentID,
+		Type:      TaskTypeIssueCertificate,
+		Spec:      b,
+		CreatedAt: time.Now(),
+	}
+	if err := st.CreateTask(ctx, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func validateIssueCertificate(spec *pb.IssueCertificate) error {
+	if spec.GetSubject().GetCommonName() == "" && len(spec.DnsNames)+len(spec.IpAddresses)+len(spec.Uris)+len(spec.EmailAddresses) == 0 {
+		return errors.New("a common name or at least one subject alternative name is required")
+	}
+	if _, err := certname.Parse(spec); err != nil {
+		return err
+	}
+	if _, _, err := certificateUsages(spec); err != nil {
+		return err
+	}
+	if spec.KeyType != "" && !keytype.Valid(spec.KeyType) {
+		return fmt.Errorf("unsupported key type %q", spec.KeyType)
+	}
+	if spec.DurationSeconds < 0 {
+		return errors.New("duration must not be negative")
+	}
+	if !filepath.IsAbs(spec.CertPath) || !filepath.IsAbs(spec.KeyPath) {
+		return errors.New("cert and key paths must be absolute")
+	}
+	if spec.CaPath != "" && !filepath.IsAbs(spec.CaPath) {
+		return errors.New("ca path must be absolute")
+	}
+	if spec.FileMode > 0o777 {
+		return fmt.Errorf("invalid file mode %o", spec.FileMode)
+	}
+	return nil
+}
+
+// session is the Poll stream of a connected agent. grpc streams are not safe for concurrent
+// sends, every response goes through send.
+type session struct {
+	agentID int64
+	// cert is the client certificate the agent connected with.
+	cert   *x509.Certificate
+	stream pb.AgentService_PollServer
+	mu     sync.Mutex
+	// cancel ends the stream with the error it is given.
+	cancel context.CancelCauseFunc
+	// wake has a value when tasks were queued for the agent since they were last dispatched.
+	wake chan struct{}
+}
+
+// close ends the stream, Poll returns err to the agent.
+func (s *session) close(err error) {
+	if s.cancel != nil {
+		s.cancel(err)
+	}
+}
+
+// notify wakes the dispatch of the session's tasks.
+func (s *session) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *session) send(res *pb.AgentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.Send(res)
+}
+
+// dispatchTasks delivers the tasks of the agent until ctx is done. When the agent connects every
+// unfinished task is delivered, as the agent may have lost the ones delivered on a previous
+// stream. After that pending tasks are delivered as they are queued, by any server sharing the
+// store, and dispatched tasks are redelivered when the agent does not accept them within the
+// ack timeout.
+func (s *server) dispatchTasks(ctx context.Context, sess *session) {
+	log := s.logger.With(zap.Int64("agentId", sess.agentID))
+
+	tasks, err := s.store.ListTasks(ctx, store.TaskFilter{AgentID: sess.agentID, Statuses: unfinishedTaskStatuses})
+	if err != nil && ctx.Err() == nil {
+		log.Error("failed to list tasks", zap.Error(err))
+	}
+	ticker := time.NewTicker(min(taskDispatchInterval, s.taskAckTimeout))
+	defer ticker.Stop()
+	for {
+		for _, t := range tasks {
+			if err := s.dispatchTask(ctx, sess, t); err != nil {
+				log.Error("failed to dispatch task", zap.String("taskId", t.ID), zap.Error(err))
+				continue
+			}
+			log.Info("dispatched task", zap.String("taskId", t.ID), zap.String("type", t.Type),
+				zap.Int("attempt", t.Attempts+1))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-sess.wake:
+		}
+		tasks, err = s.dueTasks(ctx, sess.agentID)
+		if err != nil && ctx.Err() == nil {
+			log.Error("failed to list tasks", zap.Error(err))
+		}
+	}
+}
+
+var unfinishedTaskStatuses = []string{store.TaskPending, store.TaskDispatched, store.TaskRunning}
+
+// dueTasks returns the pending tasks of an agent and its dispatched tasks past the ack timeout.
+// Tasks delivered the maximum number of times are left for expireTasks to time out.
+func (s *server) dueTasks(ctx context.Context, agentID int64) ([]*store.Task, error) {
+	pending, err := s.store.ListTasks(ctx, store.TaskFilter{AgentID: agentID, Statuses: []string{store.TaskPending}})
+	if err != nil {
+		return nil, err
+	}
+	unacked, err := s.store.ListTasks(ctx, store.TaskFilter{
+		AgentID:          agentID,
+		Statuses:         []string{store.TaskDispatched},
+		DispatchedBefore: time.Now().Add(-s.taskAckTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range unacked {
+		if t.Attempts < s.taskMaxAttempts {
+			pending = append(pending, t)
+		}
+	}
+	return pending, nil
+}
+
+func (s *server) dispatchTask(ctx context.Context, sess *session, t *store.Task) error {
+	task := &pb.ServerTask{TaskId: t.ID, Attempt: uint32(t.Attempts + 1)}
+	switch t.Type {
+	case TaskTypeIssueCertificate:
+		spec := &pb.IssueCertificate{}
+		if err := protojson.Unmarshal(t.Spec, spec); err != nil {
+			return fmt.Errorf("failed to decode task spec: %w", err)
+		}
+		task.Task = &pb.ServerTask_IssueCertificate{IssueCertificate: spec}
+	default:
+		_, err := s.store.UpdateTask(ctx, t.ID, unfinishedTaskStatuses, store.TaskUpdate{
+			Status:    store.TaskFailed,
+			Message:   fmt.Sprintf("unknown task type %q", t.Type),
+			ErrorCode: errorCode(pb.TaskErrorCode_TASK_ERROR_CODE_UNSUPPORTED),
+		}, time.Now())
+		return err
+	}
+
+	// The delivery is recorded first so the agent's reply never finds the task pending. A failed
+	// send is retried once the ack timeout passes.
+	ok, err := s.store.DispatchTask(ctx, t.ID, unfinishedTaskStatuses, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	return sess.send(&pb.AgentResponse{Payload: &pb.AgentResponse_ServerTask{ServerTask: task}})
+}
+
+// handleCSR signs the CSR the agent generated for an IssueCertificate task and sends back the
+// certificate, or the error that failed the task. A CSR redelivered for a task that already has a
+// certificate is answered with that certificate.
+func (s *server) handleCSR(ctx context.Context, sess *session, req *pb.CertificateSigningRequest) {
+	log := s.logger.With(zap.Int64("agentId", sess.agentID), zap.String("taskId", req.TaskId))
+
+	start := time.Now()
+	chain, ca, issued, err := s.issueCertificate(ctx, sess.agentID, req)
+	if issued || err != nil {
+		s.metrics.observeIssuance(start, err)
+		s.audit(ctx, agentActor(sess.agentID, sess.cert), AuditCertificateIssue, TaskTarget(req.TaskId), issuedDetail(chain), err)
+	}
+	res := &pb.IssuedCertificate{TaskId: req.TaskId, CertificateChain: chain, Ca: ca}
+	switch {
+	case err != nil:
+		log.Error("failed to issue certificate", zap.Error(err))
+		res.Error = err.Error()
+		if _, err := s.store.UpdateTask(ctx, req.TaskId, []string{store.TaskDispatched, store.TaskRunning}, store.TaskUpdate{
+			Status:    store.TaskFailed,
+			Message:   res.Error,
+			ErrorCode: errorCode(pb.TaskErrorCode_TASK_ERROR_CODE_ISSUANCE),
+		}, time.Now()); err != nil {
+			log.Error("failed to update task", zap.Error(err))
+		}
+	case issued:
+		log.Info("issued certificate")
+	default:
+		log.Info("resending the certificate issued for a duplicate csr")
+	}
+	if err := sess.send(&pb.AgentResponse{Payload: &pb.AgentResponse_IssuedCertificate{IssuedCertificate: res}}); err != nil {
+		log.Error("failed to send certificate", zap.Error(err))
+	}
+}
+
+// issueCertificate returns the certificate for the CSR of a task and whether it was issued now.
+// The certificate is recorded on the task so redelivered CSRs get it back, a CSR for another key
+// than the one certified is rejected, as are the CSRs of revoked agents.
+func (s *server) issueCertificate(ctx context.Context, agentID int64, req *pb.CertificateSigningRequest) ([]byte, []byte, bool, error) {
+	if r := s.revocations.Load(); r != nil && r.agents[agentID] {
+		return nil, nil, false, fmt.Errorf("agent %d is revoked", agentID)
+	}
+	t, err := s.store.GetTask(ctx, req.TaskId)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if t.AgentID != agentID || t.Type != TaskTypeIssueCertificate {
+		return nil, nil, false, store.ErrTaskNotFound
+	}
+	if t.Status != store.TaskDispatched && t.Status != store.TaskRunning {
+		return nil, nil, false, fmt.Errorf("task is %s", t.Status)
+	}
+	if t.CertificateChain != nil {
+		chain, ca, err := issuedFor(t, req.Csr)
+		return chain, ca, false, err
+	}
+	if s.issuer == nil {
+		return nil, nil, false, errors.New("no issuer is configured")
+	}
+	spec := &pb.IssueCertificate{}
+	if err := protojson.Unmarshal(t.Spec, spec); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to decode task spec: %w", err)
+	}
+	if err := checkCSR(req.Csr, spec); err != nil {
+		return nil, nil, false, err
+	}
+	chain, ca, err := s.issuer.Issue(ctx, t.ID, req.Csr, spec)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	ok, err := s.store.SetTaskCertificate(ctx, t.ID, chain, ca, time.Now())
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if !ok {
+		// A concurrent delivery of the CSR recorded its certificate first.
+		if t, err = s.store.GetTask(ctx, t.ID); err != nil {
+			return nil, nil, false, err
+		}
+		if t.CertificateChain == nil {
+			return nil, nil, false, fmt.Errorf("task is %s", t.Status)
+		}
+		chain, ca, err := issuedFor(t, req.Csr)
+		return chain, ca, false, err
+	}
+	return chain, ca, true, nil
+}
+
+// issuedFor returns the certificate recorded on a task if it certifies the key of the CSR.
+func issuedFor(t *store.Task, csrPEM []byte) ([]byte, []byte, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, _ := pem.Decode(t.CertificateChain)
+	if block == nil {
+		return nil, nil, errors.New("failed to decode the certificate of the task")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the certificate of the task: %w", err)
+	}
+	if pub, ok := cert.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(csr.PublicKey) {
+		return nil, nil, errors.New("task already has a certificate for another key")
+	}
+	return t.CertificateChain, t.CA, nil
+}
+
+// agentTask returns a task of the session's agent.
+func (s *server) agentTask(ctx context.Context, sess *session, id string) (*store.Task, error) {
+	t, err := s.store.GetTask(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.AgentID != sess.agentID {
+		return nil, store.ErrTaskNotFound
+	}
+	return t, nil
+}
+
+// handleTaskAccepted moves a dispatched task to running, accepting a running task again is a
+// duplicate and ignored.
+func (s *server) handleTaskAccepted(ctx context.Context, sess *session, req *pb.TaskAccepted) error {
+	t, err := s.agentTask(ctx, sess, req.TaskId)
+	if err != nil {
+		return err
+	}
+	if t.Status == store.TaskRunning {
+		return nil
+	}
+	ok, err := s.store.UpdateTask(ctx, t.ID, []string{store.TaskDispatched}, store.TaskUpdate{Status: store.TaskRunning}, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("task is %s", t.Status)
+	}
+	return nil
+}
+
+// handleTaskProgress records the latest progress message of a running task.
+func (s *server) handleTaskProgress(ctx context.Context, sess *session, req *pb.TaskProgress) error {
+	t, err := s.agentTask(ctx, sess, req.TaskId)
+	if err != nil {
+		return err
+	}
+	ok, err := s.store.UpdateTask(ctx, t.ID, []string{store.TaskRunning}, store.TaskUpdate{
+		Status:  store.TaskRunning,
+		Message: req.Message,
+	}, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("task is %s", t.Status)
+	}
+	return nil
+}
+
+// handleTaskResult records the outcome the agent reported for a task. Results are accepted for
+// timed out tasks as well since the agent may finish after the server gave up on it, a result
+// for a task that already succeeded or failed is a duplicate and ignored.
+func (s *server) handleTaskResult(ctx context.Context, sess *session, res *pb.TaskResult) error {
+	t, err := s.agentTask(ctx, sess, res.TaskId)
+	if err != nil {
+		return err
+	}
+
+	update := store.TaskUpdate{Message: res.Message}
+	switch res.Status {
+	case pb.TaskStatus_TASK_STATUS_SUCCEEDED:
+		update.Status = store.TaskSucceeded
+	case pb.TaskStatus_TASK_STATUS_FAILED:
+		update.Status = store.TaskFailed
+		update.ErrorCode = errorCode(res.ErrorCode)
+	default:
+		return fmt.Errorf("unexpected task status %s", res.Status)
+	}
+	for _, a := range res.Artifacts {
+		update.Artifacts = append(update.Artifacts, store.TaskArtifact{Name: a.Name, Path: a.Path, SHA256: a.Sha256})
+	}
+	from := []string{store.TaskDispatched, store.TaskRunning, store.TaskTimedOut}
+	ok, err := s.store.UpdateTask(ctx, t.ID, from, update, time.Now())
+	if err != nil || !ok {
+		return err
+	}
+	var failure error
+	if update.Status == store.TaskFailed {
+		failure = fmt.Errorf("%s: %s", cmp.Or(update.ErrorCode, "failed"), update.Message)
+	}
+	s.audit(ctx, agentActor(sess.agentID, sess.cert), AuditTaskFinish, TaskTarget(t.ID), t.Type, failure)
+	return nil
+}
+
+// watchTasks wakes the dispatch of the agents connected to this server when tasks are queued
+// for them until ctx is done. The stores notify the tasks created by every server sharing them,
+// so tasks are delivered right away whichever server holds the agent's stream.
+func (s *server) watchTasks(ctx context.Context) {
+	for {
+		err := s.store.WatchTasks(ctx, func(agentID int64) {
+			if sess, ok := s.registry.Session(agentID); ok {
+				sess.notify()
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			s.logger.Error("failed to watch tasks", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(taskWatchRetryDelay):
+		}
+	}
+}
+
+// expireTasks times out the tasks that did not finish within timeout of being first dispatched
+// and the ones the agent did not accept after the maximum number of deliveries.
+func (s *server) expireTasks(ctx context.Context, timeout time.Duration) {
+	ticker := time.NewTicker(min(taskExpiryInterval, timeout, s.taskAckTimeout))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		ids, err := s.store.TimeOutTasks(ctx, now.Add(-timeout), now.Add(-s.taskAckTimeout), s.taskMaxAttempts, now)
+		if err != nil {
+			s.logger.Error("failed to time out tasks", zap.Error(err))
+		}
+		// The tasks timed out before an error are audited as well.
+		for _, id := range ids {
+			s.logger.Warn("task timed out", zap.String("taskId", id))
+			s.audit(ctx, serverActor, AuditTaskTimeOut, TaskTarget(id), "task did not finish in time", nil)
+		}
+	}
+}
+
+// issuedDetail names the certificate at the start of a PEM encoded chain for the audit log.
+func issuedDetail(chain []byte) string {
+	block, _ := pem.Decode(chain)
+	if block == nil {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("certificate %s for %s", certificateSerial(cert), cert.Subject)
+}
+
+// errorCode is the name a task error code is stored with, e.g. install.
+func errorCode(code pb.TaskErrorCode) string {
+	if code == pb.TaskErrorCode_TASK_ERROR_CODE_UNSPECIFIED {
+		return ""
+	}
+	return strings.ToLower(strings.TrimPrefix(code.String(), "TASK_ERROR_CODE_"))
+}
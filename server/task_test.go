@@ -0,0 +1,332 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+// newTaskTestServer returns a server on a sqlite store with an agent and a task dispatched to it.
+func newTaskTestServer(t *testing.T) (*server, *session, *store.Task) {
+	t.Helper()
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "acert.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	a := &store.Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: time.Now()}
+	if err := st.CreateAgent(ctx, a); err != nil {
+		t.Fatal(err)
+	}
+	task := &store.Task{ID: "task", AgentID: a.ID, Type: TaskTypeIssueCertificate, Spec: []byte("{}"), CreatedAt: time.Now()}
+	if err := st.CreateTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.DispatchTask(ctx, task.ID, unfinishedTaskStatuses, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return &server{logger: zap.NewNop(), store: st}, &session{agentID: a.ID}, task
+}
+
+func expectTaskStatus(t *testing.T, s *server, id, status string) *store.Task {
+	t.Helper()
+	got, err := s.store.GetTask(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != status {
+		t.Errorf("expected task %s to be %s, got %s", id, status, got.Status)
+	}
+	return got
+}
+
+func TestHandleTaskAcceptedAndProgress(t *testing.T) {
+	ctx := context.Background()
+	s, sess, task := newTaskTestServer(t)
+
+	if err := s.handleTaskProgress(ctx, sess, &pb.TaskProgress{TaskId: task.ID, Message: "early"}); err == nil {
+		t.Error("expected progress of a task that was not accepted to be rejected")
+	}
+	if err := s.handleTaskAccepted(ctx, sess, &pb.TaskAccepted{TaskId: task.ID}); err != nil {
+		t.Fatal(err)
+	}
+	expectTaskStatus(t, s, task.ID, store.TaskRunning)
+	if err := s.handleTaskAccepted(ctx, sess, &pb.TaskAccepted{TaskId: task.ID}); err != nil {
+		t.Errorf("expected a duplicate accept to be ignored, got %v", err)
+	}
+	if err := s.handleTaskProgress(ctx, sess, &pb.TaskProgress{TaskId: task.ID, Message: "installing"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := expectTaskStatus(t, s, task.ID, store.TaskRunning); got.Message != "installing" {
+		t.Errorf("expected the progress message to be recorded, got %q", got.Message)
+	}
+
+	other := &session{agentID: sess.agentID + 1}
+	if err := s.handleTaskAccepted(ctx, other, &pb.TaskAccepted{TaskId: task.ID}); !errors.Is(err, store.ErrTaskNotFound) {
+		t.Errorf("expected %v accepting the task of another agent, got %v", store.ErrTaskNotFound, err)
+	}
+	if err := s.handleTaskProgress(ctx, other, &pb.TaskProgress{TaskId: task.ID}); !errors.Is(err, store.ErrTaskNotFound) {
+		t.Errorf("expected %v reporting the task of another agent, got %v", store.ErrTaskNotFound, err)
+	}
+	if err := s.handleTaskAccepted(ctx, sess, &pb.TaskAccepted{TaskId: "missing"}); !errors.Is(err, store.ErrTaskNotFound) {
+		t.Errorf("expected %v accepting a missing task, got %v", store.ErrTaskNotFound, err)
+	}
+
+	if _, err := s.store.UpdateTask(ctx, task.ID, nil, store.TaskUpdate{Status: store.TaskCanceled}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.handleTaskAccepted(ctx, sess, &pb.TaskAccepted{TaskId: task.ID}); err == nil {
+		t.Error("expected accepting a canceled task to be rejected")
+	}
+	if err := s.handleTaskProgress(ctx, sess, &pb.TaskProgress{TaskId: task.ID, Message: "late"}); err == nil {
+		t.Error("expected progress of a canceled task to be rejected")
+	}
+}
+
+func TestHandleTaskResult(t *testing.T) {
+	ctx := context.Background()
+	s, sess, task := newTaskTestServer(t)
+
+	if err := s.handleTaskResult(ctx, sess, &pb.TaskResult{TaskId: task.ID}); err == nil {
+		t.Error("expected a result without a final status to be rejected")
+	}
+	res := &pb.TaskResult{
+		TaskId:    task.ID,
+		Status:    pb.TaskStatus_TASK_STATUS_FAILED,
+		ErrorCode: pb.TaskErrorCode_TASK_ERROR_CODE_INSTALL,
+		Message:   "permission denied",
+		Artifacts: []*pb.TaskArtifact{{Name: "key", Path: "/etc/ssl/host.key"}},
+	}
+	if err := s.handleTaskResult(ctx, sess, res); err != nil {
+		t.Fatal(err)
+	}
+	got := expectTaskStatus(t, s, task.ID, store.TaskFailed)
+	if got.ErrorCode != errorCode(res.ErrorCode) || got.FinishedAt == nil || len(got.Artifacts) != 1 {
+		t.Errorf("expected a finished task with the error code and artifact, got %+v", got)
+	}
+	// A duplicate result neither changes the task nor is audited again.
+	res.Status = pb.TaskStatus_TASK_STATUS_SUCCEEDED
+	if err := s.handleTaskResult(ctx, sess, res); err != nil {
+		t.Errorf("expected a duplicate result to be ignored, got %v", err)
+	}
+	expectTaskStatus(t, s, task.ID, store.TaskFailed)
+
+	events, err := s.store.ListAuditEvents(ctx, store.AuditFilter{Action: AuditTaskFinish})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Target != TaskTarget(task.ID) || events[0].Result != store.AuditFailure {
+		t.Errorf("expected one failed task audit event, got %v", events)
+	}
+
+	// Results are accepted after the task timed out, not after it was canceled.
+	now := time.Now()
+	for id, status := range map[string]string{"timed-out": store.TaskTimedOut, "canceled": store.TaskCanceled} {
+		if err := s.store.CreateTask(ctx, &store.Task{ID: id, AgentID: sess.agentID, Type: TaskTypeIssueCertificate,
+			Spec: []byte("{}"), CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.store.UpdateTask(ctx, id, nil, store.TaskUpdate{Status: status}, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.handleTaskResult(ctx, sess, &pb.TaskResult{TaskId: id, Status: pb.TaskStatus_TASK_STATUS_SUCCEEDED}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectTaskStatus(t, s, "timed-out", store.TaskSucceeded)
+	expectTaskStatus(t, s, "canceled", store.TaskCanceled)
+
+	other := &session{agentID: sess.agentID + 1}
+	if err := s.handleTaskResult(ctx, other, res); !errors.Is(err, store.ErrTaskNotFound) {
+		t.Errorf("expected %v reporting the task of another agent, got %v", store.ErrTaskNotFound, err)
+	}
+}
+
+// recordingStream passes the responses sent to the agent to the test.
+type recordingStream struct {
+	pb.AgentService_PollServer
+	sent chan *pb.AgentResponse
+}
+
+func newRecordingStream() *recordingStream {
+	return &recordingStream{sent: make(chan *pb.AgentResponse, 16)}
+}
+
+func (s *recordingStream) Send(res *pb.AgentResponse) error {
+	s.sent <- res
+	return nil
+}
+
+// next returns the next response sent to the agent.
+func (s *recordingStream) next(t *testing.T) *pb.AgentResponse {
+	t.Helper()
+	select {
+	case res := <-s.sent:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was sent to the agent")
+		return nil
+	}
+}
+
+func TestHandleCSRIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	s, sess, _ := newTaskTestServer(t)
+	s.issuer = &caIssuer{ca: newTestCA(t)}
+	s.metrics = newMetrics(s)
+	stream := newRecordingStream()
+	sess.stream = stream
+
+	spec, err := protojson.Marshal(&pb.IssueCertificate{Subject: &pb.Subject{CommonName: "web"}, DnsNames: []string{"web.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &store.Task{ID: "issue", AgentID: sess.agentID, Type: TaskTypeIssueCertificate, Spec: spec, CreatedAt: time.Now()}
+	if err := s.store.CreateTask(ctx, task); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.DispatchTask(ctx, task.ID, unfinishedTaskStatuses, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	csr := func() []byte {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: "web"},
+			DNSNames: []string{"web.example.com"},
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+	}
+
+	// The agent sends its CSR again when the task is redelivered.
+	first := csr()
+	for range 2 {
+		s.handleCSR(ctx, sess, &pb.CertificateSigningRequest{TaskId: task.ID, Csr: first})
+	}
+	issued, resent := stream.next(t).GetIssuedCertificate(), stream.next(t).GetIssuedCertificate()
+	if issued.Error != "" || len(issued.CertificateChain) == 0 {
+		t.Fatalf("expected a certificate to be issued, got %v", issued)
+	}
+	if !bytes.Equal(resent.CertificateChain, issued.CertificateChain) || !bytes.Equal(resent.Ca, issued.Ca) {
+		t.Error("expected the duplicate csr to be answered with the certificate already issued")
+	}
+	events, err := s.store.ListAuditEvents(ctx, store.AuditFilter{Action: AuditCertificateIssue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 {
+		t.Errorf("expected one certificate to be issued, got %d audit events", len(events))
+	}
+
+	s.handleCSR(ctx, sess, &pb.CertificateSigningRequest{TaskId: task.ID, Csr: csr()})
+	if rejected := stream.next(t).GetIssuedCertificate(); rejected.Error == "" || len(rejected.CertificateChain) != 0 {
+		t.Errorf("expected a csr for another key to be rejected, got %v", rejected)
+	}
+	expectTaskStatus(t, s, task.ID, store.TaskFailed)
+}
+
+func TestDispatchTasksAtLeastOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, sess, _ := newTaskTestServer(t)
+	s.taskAckTimeout = 100 * time.Millisecond
+	s.taskMaxAttempts = 3
+	go s.expireTasks(ctx, time.Hour)
+
+	// The task dispatched before the agent connected is delivered again right away, another one
+	// is queued for the agent.
+	if err := s.store.CreateTask(ctx, &store.Task{ID: "unacked", AgentID: sess.agentID, Type: TaskTypeIssueCertificate,
+		Spec: []byte("{}"), CreatedAt: time.Now().Add(time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	connect := func() (*recordingStream, context.CancelFunc) {
+		streamCtx, drop := context.WithCancel(ctx)
+		stream := newRecordingStream()
+		conn := &session{agentID: sess.agentID, stream: stream, wake: make(chan struct{}, 1)}
+		go s.dispatchTasks(streamCtx, conn)
+		return stream, drop
+	}
+	expectDelivery := func(stream *recordingStream, id string, attempt uint32) {
+		t.Helper()
+		task := stream.next(t).GetServerTask()
+		if task.GetTaskId() != id || task.GetAttempt() != attempt {
+			t.Fatalf("expected attempt %d of task %s to be delivered, got %v", attempt, id, task)
+		}
+	}
+
+	stream, drop := connect()
+	expectDelivery(stream, "task", 2)
+	expectDelivery(stream, "unacked", 1)
+	if err := s.handleTaskAccepted(ctx, sess, &pb.TaskAccepted{TaskId: "task"}); err != nil {
+		t.Fatal(err)
+	}
+	// The unaccepted task is redelivered after the ack timeout until it runs out of attempts.
+	expectDelivery(stream, "unacked", 2)
+	expectDelivery(stream, "unacked", 3)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		got, err := s.store.GetTask(ctx, "unacked")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status == store.TaskTimedOut {
+			if got.Attempts != 3 {
+				t.Errorf("expected the task to time out after 3 attempts, got %d", got.Attempts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the task to time out, got %s", got.Status)
+		}
+	}
+	// The timeout is audited right after the task is updated.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(20 * time.Millisecond) {
+		events, err := s.store.ListAuditEvents(ctx, store.AuditFilter{Action: AuditTaskTimeOut})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) == 1 && events[0].Target == TaskTarget("unacked") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the timeout of the task to be audited, got %v", events)
+		}
+	}
+	select {
+	case res := <-stream.sent:
+		t.Errorf("expected a timed out task not to be delivered, got %v", res)
+	case <-time.After(3 * s.taskAckTimeout):
+	}
+
+	// The running task is delivered again when the agent reconnects, as it may have lost it.
+	drop()
+	stream, drop = connect()
+	defer drop()
+	expectDelivery(stream, "task", 3)
+	got := expectTaskStatus(t, s, "task", store.TaskDispatched)
+	if got.Attempts != 3 {
+		t.Errorf("expected the task to be delivered 3 times, got %d", got.Attempts)
+	}
+	expectTaskStatus(t, s, "unacked", store.TaskTimedOut)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// serverTLSConfig builds the tls config for the grpc listener from the options. The serving
+// certificate and client CAs are taken from material on every handshake so they can be swapped
+// without restarting the listener. Established connections keep the material they were
+// handshaked with, so Poll streams survive a rotation. verify is called with the verified
+// chains of client certificates, e.g. to reject revoked ones.
+func serverTLSConfig(options Options, material func() *tlsMaterial, verify func([][]byte, [][]*x509.Certificate) error) (*tls.Config, error) {
+	minVersion, ok := tlsVersions[options.MinTLSVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported minimum tls version %q", options.MinTLSVersion)
+	}
+	cipherSuites, err := cipherSuiteIDs(options.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	// Client certificates are optional so agents without one can Enroll, Poll requires it.
+	base := &tls.Config{
+		ClientAuth:            tls.VerifyClientCertIfGiven,
+		MinVersion:            minVersion,
+		CipherSuites:          cipherSuites,
+		VerifyPeerCertificate: verify,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &material().serving, nil
+		},
+	}
+	return &tls.Config{
+		MinVersion: minVersion,
+		// The client CA pool can only be swapped by handing out a new config per handshake.
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			cfg.ClientCAs = material().clientCAs
+			return cfg, nil
+		},
+	}, nil
+}
+
+// cipherSuiteIDs maps cipher suite names to their ids. Only suites considered secure by
+// crypto/tls are accepted, an empty list keeps the crypto/tls defaults.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
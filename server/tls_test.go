@@ -0,0 +1,23 @@
+package server
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestCipherSuiteIDs(t *testing.T) {
+	ids, err := cipherSuiteIDs([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("unexpected ids %v", ids)
+	}
+
+	if _, err := cipherSuiteIDs([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Error("expected insecure cipher suite to be rejected")
+	}
+	if ids, err := cipherSuiteIDs(nil); err != nil || ids != nil {
+		t.Errorf("expected defaults, got %v %v", ids, err)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/salzr/acert/store"
+)
+
+const (
+	DefaultTokenTTL = 24 * time.Hour
+	tokenBytes      = 32
+)
+
+// CreateToken generates a single use join token valid for ttl and records its hash as a ticket.
+// The plain token is only returned here and cannot be recovered from the store.
+func CreateToken(ctx context.Context, st store.Repository, ttl time.Duration) (string, *store.Ticket, error) {
+	if ttl <= 0 {
+		return "", nil, fmt.Errorf("token ttl must be positive, got %s", ttl)
+	}
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	ticket, err := st.CreateTicket(ctx, hashToken(token), now, now.Add(ttl))
+	if err != nil {
+		return "", nil, err
+	}
+	return token, ticket, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
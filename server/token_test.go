@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	pb "github.com/salzr/acert/proto/agentservice/v1"
+	"github.com/salzr/acert/store"
+)
+
+func TestCreateToken(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.Open(ctx, filepath.Join(t.TempDir(), "acert.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	if _, _, err := CreateToken(ctx, st, 0); err == nil {
+		t.Error("expected a token without ttl to be rejected")
+	}
+
+	token, ticket, err := CreateToken(ctx, st, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Token == token || ticket.Token != hashToken(token) {
+		t.Error("expected only the hash of the token to be stored")
+	}
+	if d := ticket.ExpiresAt.Sub(ticket.CreatedAt); d != time.Hour {
+		t.Errorf("expected the ticket to expire after 1h, got %s", d)
+	}
+
+	// Tokens are redeemed by enrolling, which tears the ticket of their hash.
+	s := &server{logger: zap.NewNop(), store: st, agentCertTTL: time.Hour}
+	s.material.Store(&tlsMaterial{agentCA: newTestCA(t)})
+	if _, err := s.Enroll(ctx, &pb.EnrollRequest{Token: token, Hostname: "host", Csr: newTestCSR(t)}); err != nil {
+		t.Fatal(err)
+	}
+	enroll := func(token string) error {
+		_, err := st.EnrollAgent(ctx, hashToken(token), &store.Agent{Hostname: "host", CreatedAt: time.Now()}, time.Now(),
+			func(a *store.Agent) (*store.AgentCertificate, error) {
+				return &store.AgentCertificate{Serial: "1", AgentID: a.ID, NotAfter: time.Now().Add(time.Hour)}, nil
+			})
+		return err
+	}
+	if err := enroll(token); !errors.Is(err, store.ErrTicketTorn) {
+		t.Errorf("expected %v enrolling with a token twice, got %v", store.ErrTicketTorn, err)
+	}
+	if err := enroll("unknown"); !errors.Is(err, store.ErrTicketNotFound) {
+		t.Errorf("expected %v for an unknown token, got %v", store.ErrTicketNotFound, err)
+	}
+
+	expired, _, err := CreateToken(ctx, st, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := enroll(expired); !errors.Is(err, store.ErrTicketExpired) {
+		t.Errorf("expected %v for an expired token, got %v", store.ErrTicketExpired, err)
+	}
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrAgentNotFound = errors.New("agent not found")
+
+// Agent is an enrolled agent. Token holds the hash of the join token it enrolled with.
+type Agent struct {
+	ID          int64
+	Hostname    string
+	IP          string
+	Token       string
+	Description string
+	CreatedAt   time.Time
+	Revoked     *time.Time
+	// Version and LastSeen are updated from the agent's heartbeats.
+	Version  string
+	LastSeen *time.Time
+}
+
+// CreateAgent stores a new agent and sets its ID.
+func (s *Store) CreateAgent(ctx context.Context, a *Agent) error {
+	return createAgent(ctx, s.db, a)
+}
+
+// EnrollAgent redeems the ticket for the token hash like TearTicket and stores the agent
+// enrolled with it together with the certificate sign issues to it, in one transaction. sign
+// is called once the agent has its ID. If it or storing fails, nothing is stored and the
+// ticket can still be redeemed.
+func (s *Store) EnrollAgent(ctx context.Context, token string, a *Agent, now time.Time,
+	sign func(a *Agent) (*AgentCertificate, error)) (*Ticket, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := tearTicket(ctx, tx, token, now)
+	if err != nil {
+		return nil, err
+	}
+	a.Token = t.Token
+	if err := createAgent(ctx, tx, a); err != nil {
+		return nil, err
+	}
+	c, err := sign(a)
+	if err != nil {
+		return nil, err
+	}
+	if err := createAgentCertificate(ctx, tx, c); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit enrollment: %w", err)
+	}
+	return t, nil
+}
+
+func createAgent(ctx context.Context, q querier, a *Agent) error {
+	a.CreatedAt = a.CreatedAt.UTC()
+	if err := q.QueryRowContext(ctx,
+		"INSERT INTO agent (hostname, ip, token, description, created_at) VALUES (?, ?, ?, ?, ?) RETURNING id",
+		a.Hostname, a.IP, a.Token, a.Description, a.CreatedAt).Scan(&a.ID); err != nil {
+		return fmt.Errorf("failed to insert agent: %w", err)
+	}
+	return nil
+}
+
+// GetAgent returns the agent with the given id.
+func (s *Store) GetAgent(ctx context.Context, id int64) (*Agent, error) {
+	a, err := scanAgent(s.db.QueryRowContext(ctx, "SELECT "+agentColumns+" FROM agent WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAgentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agent: %w", err)
+	}
+	return a, nil
+}
+
+// ListAgents returns every agent ordered by id.
+func (s *Store) ListAgents(ctx context.Context) ([]*Agent, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+agentColumns+" FROM agent ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agents: %w", err)
+	}
+	defer rows.Close()
+
+	var agents []*Agent
+	for rows.Next() {
+		a, err := scanAgent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan agent: %w", err)
+		}
+		agents = append(agents, a)
+	}
+	return agents, rows.Err()
+}
+
+// UpdateAgentPresence records the version an agent runs and when it was last seen.
+func (s *Store) UpdateAgentPresence(ctx context.Context, id int64, version string, lastSeen time.Time) error {
+	if _, err := s.db.ExecContext(ctx, "UPDATE agent SET version = ?, last_seen = ? WHERE id = ?",
+		nullString(version), lastSeen.UTC(), id); err != nil {
+		return fmt.Errorf("failed to update agent presence: %w", err)
+	}
+	return nil
+}
+
+const agentColumns = "id, hostname, ip, token, description, created_at, revoked, version, last_seen"
+
+func scanAgent(row interface{ Scan(...any) error }) (*Agent, error) {
+	a := &Agent{}
+	var description, version sql.NullString
+	var revoked, lastSeen sql.NullTime
+	if err := row.Scan(&a.ID, &a.Hostname, &a.IP, &a.Token, &description, &a.CreatedAt, &revoked, &version,
+		&lastSeen); err != nil {
+		return nil, err
+	}
+	a.Description = description.String
+	a.Version = version.String
+	if revoked.Valid {
+		a.Revoked = &revoked.Time
+	}
+	if lastSeen.Valid {
+		a.LastSeen = &lastSeen.Time
+	}
+	return a, nil
+}
+
+// RevokeAgent marks the agent and every certificate issued to it as revoked. Agents and
+// certificates that are already revoked keep their revocation time.
+func (s *Store) RevokeAgent(ctx context.Context, id int64, now time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	res, err := tx.ExecContext(ctx, "UPDATE agent SET revoked = COALESCE(revoked, ?) WHERE id = ?", now, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke agent: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to read revoked agents: %w", err)
+	} else if n == 0 {
+		return ErrAgentNotFound
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE agent_certificate SET revoked = COALESCE(revoked, ?) WHERE agent_id = ?", now, id); err != nil {
+		return fmt.Errorf("failed to revoke agent certificates: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
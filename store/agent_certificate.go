@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrAgentCertificateNotFound = errors.New("agent certificate not found")
+
+// AgentCertificate is a client certificate issued to an agent, Serial is its hex encoded serial
+// number.
+type AgentCertificate struct {
+	Serial    string
+	AgentID   int64
+	NotBefore time.Time
+	NotAfter  time.Time
+	CreatedAt time.Time
+	Revoked   *time.Time
+}
+
+// CreateAgentCertificate records a certificate issued to an agent.
+func (s *Store) CreateAgentCertificate(ctx context.Context, c *AgentCertificate) error {
+	return createAgentCertificate(ctx, s.db, c)
+}
+
+func createAgentCertificate(ctx context.Context, q querier, c *AgentCertificate) error {
+	c.CreatedAt = c.CreatedAt.UTC()
+	_, err := q.ExecContext(ctx,
+		"INSERT INTO agent_certificate (serial, agent_id, not_before, not_after, created_at) VALUES (?, ?, ?, ?, ?)",
+		c.Serial, c.AgentID, c.NotBefore.UTC(), c.NotAfter.UTC(), c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert agent certificate: %w", err)
+	}
+	return nil
+}
+
+// GetAgentCertificate returns the agent certificate with the given serial.
+func (s *Store) GetAgentCertificate(ctx context.Context, serial string) (*AgentCertificate, error) {
+	c, err := scanAgentCertificate(s.db.QueryRowContext(ctx,
+		"SELECT "+agentCertificateColumns+" FROM agent_certificate WHERE serial = ?", serial))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAgentCertificateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agent certificate: %w", err)
+	}
+	return c, nil
+}
+
+// ListRevokedAgentCertificates returns the revoked agent certificates that have not expired at
+// now, ordered by revocation time.
+func (s *Store) ListRevokedAgentCertificates(ctx context.Context, now time.Time) ([]*AgentCertificate, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+agentCertificateColumns+" FROM agent_certificate WHERE revoked IS NOT NULL AND not_after > ? ORDER BY revoked, serial",
+		now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query agent certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certs []*AgentCertificate
+	for rows.Next() {
+		c, err := scanAgentCertificate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan agent certificate: %w", err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, rows.Err()
+}
+
+const agentCertificateColumns = "serial, agent_id, not_before, not_after, created_at, revoked"
+
+func scanAgentCertificate(row interface{ Scan(...any) error }) (*AgentCertificate, error) {
+	c := &AgentCertificate{}
+	var revoked sql.NullTime
+	if err := row.Scan(&c.Serial, &c.AgentID, &c.NotBefore, &c.NotAfter, &c.CreatedAt, &revoked); err != nil {
+		return nil, err
+	}
+	if revoked.Valid {
+		c.Revoked = &revoked.Time
+	}
+	return c, nil
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Results of audited actions.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEvent is an entry of the audit log. Events are numbered from 1 without gaps and each
+// carries the hash of the previous one, so modified, removed or reordered events break the
+// chain. Events removed from the end can only be noticed by comparing with an earlier export.
+type AuditEvent struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	// Actor is who performed the action, e.g. agent:42 or management:1f2e3d4c5b6a.
+	Actor string `json:"actor"`
+	// AgentID and AgentSerial identify the agent by the mTLS client certificate it called with,
+	// they are empty for other actors.
+	AgentID     int64  `json:"agentId,omitempty"`
+	AgentSerial string `json:"agentSerial,omitempty"`
+	Action      string `json:"action"`
+	Target      string `json:"target"`
+	Result      string `json:"result"`
+	Detail      string `json:"detail,omitempty"`
+	PrevHash    string `json:"prevHash"`
+	Hash        string `json:"hash"`
+}
+
+// AuditFilter selects audit events, zero fields match every event.
+type AuditFilter struct {
+	// AfterID matches the events after it, to page through the log.
+	AfterID int64
+	Since   time.Time
+	Until   time.Time
+	Actor   string
+	Action  string
+	AgentID int64
+	Limit   int
+}
+
+// ComputeHash returns the hash of the event, the SHA-256 of its fields and the hash of the
+// previous event.
+func (e *AuditEvent) ComputeHash() string {
+	b, _ := json.Marshal([]any{e.ID, e.Time.UTC().Format(time.RFC3339Nano), e.Actor, e.AgentID, e.AgentSerial,
+		e.Action, e.Target, e.Result, e.Detail, e.PrevHash})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Chain sets the id, previous hash and hash of e to follow the event prevID with hash prevHash,
+// as implementations of AppendAuditEvent do. The time is kept at the microsecond precision
+// every storage preserves.
+func (e *AuditEvent) Chain(prevID int64, prevHash string) {
+	e.ID = prevID + 1
+	e.Time = e.Time.UTC().Truncate(time.Microsecond)
+	e.PrevHash = prevHash
+	e.Hash = e.ComputeHash()
+}
+
+// AuditHead identifies an audit event by its id and hash. A head recorded outside the store
+// anchors verification: a log rewritten from the first event chains correctly, but no longer
+// contains it.
+type AuditHead struct {
+	ID   int64
+	Hash string
+}
+
+// ParseAuditHead parses a head formatted as <id>:<hash>.
+func ParseAuditHead(s string) (AuditHead, error) {
+	id, hash, ok := strings.Cut(s, ":")
+	if !ok || hash == "" {
+		return AuditHead{}, fmt.Errorf("invalid audit head %q, expected <id>:<hash>", s)
+	}
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || n < 1 {
+		return AuditHead{}, fmt.Errorf("invalid audit head %q, expected <id>:<hash>", s)
+	}
+	return AuditHead{ID: n, Hash: hash}, nil
+}
+
+func (h AuditHead) String() string {
+	return strconv.FormatInt(h.ID, 10) + ":" + h.Hash
+}
+
+// AuditChain verifies audit events read in order from the first one.
+type AuditChain struct {
+	// Anchor is a trusted head the log must contain, the zero value trusts any log.
+	Anchor AuditHead
+
+	last int64
+	hash string
+}
+
+// Verify checks that e follows the events verified so far and was not modified.
+func (c *AuditChain) Verify(e *AuditEvent) error {
+	if e.ID != c.last+1 {
+		return fmt.Errorf("audit event %d is missing, found %d", c.last+1, e.ID)
+	}
+	if e.PrevHash != c.hash {
+		return fmt.Errorf("audit event %d does not chain to event %d", e.ID, c.last)
+	}
+	if e.ComputeHash() != e.Hash {
+		return fmt.Errorf("audit event %d was modified", e.ID)
+	}
+	if e.ID == c.Anchor.ID && e.Hash != c.Anchor.Hash {
+		return fmt.Errorf("audit event %d does not match the trusted head", e.ID)
+	}
+	c.last, c.hash = e.ID, e.Hash
+	return nil
+}
+
+// Head returns the last verified event.
+func (c *AuditChain) Head() AuditHead {
+	return AuditHead{ID: c.last, Hash: c.hash}
+}
+
+// Complete checks that the verified events reached the anchor, a truncated log does not.
+func (c *AuditChain) Complete() error {
+	if c.last < c.Anchor.ID {
+		return fmt.Errorf("audit log ends at event %d before the trusted head %d", c.last, c.Anchor.ID)
+	}
+	return nil
+}
+
+// AppendAuditEvent appends e to the audit log and sets its id and hashes. Appends are
+// serialized by the database, by the write lock of sqlite and a table lock on PostgreSQL.
+func (s *Store) AppendAuditEvent(ctx context.Context, e *AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if lock := s.db.dialect.auditLock; lock != "" {
+		if _, err := tx.ExecContext(ctx, lock); err != nil {
+			return fmt.Errorf("failed to lock audit log: %w", err)
+		}
+	}
+	var prevID int64
+	var prevHash string
+	err = tx.QueryRowContext(ctx, "SELECT id, hash FROM audit_event ORDER BY id DESC LIMIT 1").Scan(&prevID, &prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read last audit event: %w", err)
+	}
+	e.Chain(prevID, prevHash)
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO audit_event (id, time, actor, agent_id, agent_serial, action, target, result, detail, prev_hash, hash)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.Time, e.Actor, e.AgentID, e.AgentSerial, e.Action, e.Target, e.Result, e.Detail, e.PrevHash, e.Hash); err != nil {
+		return fmt.Errorf("failed to insert audit event: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit audit event: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEvents returns the audit events matching filter in order.
+func (s *Store) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	query := `SELECT id, time, actor, agent_id, agent_serial, action, target, result, detail, prev_hash, hash
+		FROM audit_event WHERE id > ?`
+	args := []any{filter.AfterID}
+	if !filter.Since.IsZero() {
+		query += " AND time >= ?"
+		args = append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		query += " AND time < ?"
+		args = append(args, filter.Until.UTC())
+	}
+	if filter.Actor != "" {
+		query += " AND actor = ?"
+		args = append(args, filter.Actor)
+	}
+	if filter.Action != "" {
+		query += " AND action = ?"
+		args = append(args, filter.Action)
+	}
+	if filter.AgentID != 0 {
+		query += " AND agent_id = ?"
+		args = append(args, filter.AgentID)
+	}
+	query += " ORDER BY id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		e := &AuditEvent{}
+		if err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.AgentID, &e.AgentSerial, &e.Action, &e.Target, &e.Result,
+			&e.Detail, &e.PrevHash, &e.Hash); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		e.Time = e.Time.UTC()
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
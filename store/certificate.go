@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Certificate is a certificate found on an agent host.
+type Certificate struct {
+	ID           int64
+	AgentID      int64
+	Path         string
+	Subject      string
+	SANs         []string
+	Issuer       string
+	Serial       string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	KeyAlgorithm string
+	Fingerprint  string
+	ReportedAt   time.Time
+}
+
+// ReplaceCertificates replaces the certificate inventory of an agent.
+func (s *Store) ReplaceCertificates(ctx context.Context, agentID int64, certs []*Certificate, reportedAt time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM certificate WHERE agent_id = ?", agentID); err != nil {
+		return fmt.Errorf("failed to delete certificates: %w", err)
+	}
+	reportedAt = reportedAt.UTC()
+	for _, c := range certs {
+		if c.SANs == nil {
+			c.SANs = []string{}
+		}
+		sans, err := json.Marshal(c.SANs)
+		if err != nil {
+			return err
+		}
+		if err := tx.QueryRowContext(ctx,
+			`INSERT INTO certificate (agent_id, path, subject, sans, issuer, serial, not_before, not_after,
+				key_algorithm, fingerprint, reported_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+			agentID, c.Path, c.Subject, string(sans), c.Issuer, c.Serial, c.NotBefore.UTC(), c.NotAfter.UTC(),
+			c.KeyAlgorithm, c.Fingerprint, reportedAt).Scan(&c.ID); err != nil {
+			return fmt.Errorf("failed to insert certificate %s: %w", c.Path, err)
+		}
+		c.AgentID = agentID
+		c.ReportedAt = reportedAt
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit certificates: %w", err)
+	}
+	return nil
+}
+
+// CertificateFilter selects certificates of the agent inventories, zero fields match every
+// certificate.
+type CertificateFilter struct {
+	AgentID int64
+	// ExpiresBefore matches the certificates expiring before it.
+	ExpiresBefore time.Time
+}
+
+// ListCertificates returns the certificate inventory of an agent ordered by expiry.
+func (s *Store) ListCertificates(ctx context.Context, agentID int64) ([]*Certificate, error) {
+	return s.ListAllCertificates(ctx, CertificateFilter{AgentID: agentID})
+}
+
+// ListAllCertificates returns the certificates of every agent inventory matching filter ordered
+// by expiry.
+func (s *Store) ListAllCertificates(ctx context.Context, filter CertificateFilter) ([]*Certificate, error) {
+	query := `SELECT id, agent_id, path, subject, sans, issuer, serial, not_before, not_after, key_algorithm,
+		fingerprint, reported_at FROM certificate WHERE 1 = 1`
+	var args []any
+	if filter.AgentID != 0 {
+		query += " AND agent_id = ?"
+		args = append(args, filter.AgentID)
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		query += " AND not_after < ?"
+		args = append(args, filter.ExpiresBefore.UTC())
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY not_after, id", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var certs []*Certificate
+	for rows.Next() {
+		c := &Certificate{}
+		var sans string
+		if err := rows.Scan(&c.ID, &c.AgentID, &c.Path, &c.Subject, &sans, &c.Issuer, &c.Serial, &c.NotBefore,
+			&c.NotAfter, &c.KeyAlgorithm, &c.Fingerprint, &c.ReportedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate: %w", err)
+		}
+		if err := json.Unmarshal([]byte(sans), &c.SANs); err != nil {
+			return nil, fmt.Errorf("failed to parse sans of certificate %d: %w", c.ID, err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, rows.Err()
+}
+
+// ListCertificateExpiries returns when each certificate in the inventory of every agent expires.
+func (s *Store) ListCertificateExpiries(ctx context.Context) ([]time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT not_after FROM certificate")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query certificates: %w", err)
+	}
+	defer rows.Close()
+
+	var expiries []time.Time
+	for rows.Next() {
+		var notAfter time.Time
+		if err := rows.Scan(&notAfter); err != nil {
+			return nil, fmt.Errorf("failed to scan certificate: %w", err)
+		}
+		expiries = append(expiries, notAfter)
+	}
+	return expiries, rows.Err()
+}
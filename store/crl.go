@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrCRLNotFound = errors.New("crl not found")
+
+// CRL is the latest CRL of the agent CA. It is signed by the server running the singleton jobs
+// and served by every server sharing the store.
+type CRL struct {
+	Number     int64
+	DER        []byte
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// GetCRL returns the latest published CRL.
+func (s *Store) GetCRL(ctx context.Context) (*CRL, error) {
+	c := &CRL{}
+	err := s.db.QueryRowContext(ctx, "SELECT number, der, this_update, next_update FROM crl WHERE id = 1").
+		Scan(&c.Number, &c.DER, &c.ThisUpdate, &c.NextUpdate)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCRLNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crl: %w", err)
+	}
+	return c, nil
+}
+
+// PutCRL replaces the published CRL.
+func (s *Store) PutCRL(ctx context.Context, c *CRL) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO crl (id, number, der, this_update, next_update) VALUES (1, ?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET number = excluded.number, der = excluded.der,
+			this_update = excluded.this_update, next_update = excluded.next_update`,
+		c.Number, c.DER, c.ThisUpdate.UTC(), c.NextUpdate.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to store crl: %w", err)
+	}
+	return nil
+}
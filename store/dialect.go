@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/salzr/acert/migrations"
+)
+
+// dialect is what differs between the databases the store runs on. The queries of the store are
+// written for both with ? placeholders.
+type dialect struct {
+	name      string
+	changelog string
+	// migrationTable creates the schema_migration table if it is missing.
+	migrationTable string
+	// liquibaseTable counts the tables Liquibase records its changesets in.
+	liquibaseTable string
+	// numbered uses $1, $2, ... placeholders.
+	numbered bool
+	// notifications can be sent to the other processes connected with LISTEN and NOTIFY.
+	notifications bool
+	// lock and unlock serialize the migrations of processes sharing the database, empty when
+	// the transactions of the database already do.
+	lock, unlock string
+	// auditLock serializes the appends to the audit log within a transaction, empty when the
+	// transactions of the database already do.
+	auditLock string
+	// timeLayout formats the times bound to queries when the database has no time type. The
+	// layout is in UTC with a fixed width, so the stored text sorts in time order.
+	timeLayout string
+}
+
+var sqlite = &dialect{
+	name:      "sqlite",
+	changelog: migrations.Changelog,
+	migrationTable: `CREATE TABLE IF NOT EXISTS schema_migration (
+    version INTEGER PRIMARY KEY NOT NULL,
+    changeset TEXT NOT NULL,
+    applied_at DATETIME NOT NULL
+)`,
+	liquibaseTable: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'DATABASECHANGELOG' COLLATE NOCASE",
+	// The driver stores RFC 3339 with trailing zeros trimmed from the fraction otherwise, which
+	// does not sort within a second. Microseconds are what PostgreSQL keeps.
+	timeLayout: "2006-01-02T15:04:05.000000Z",
+}
+
+// migrationLockID is the key of the advisory lock held while migrating a PostgreSQL database.
+const migrationLockID = 0x61636572
+
+var postgres = &dialect{
+	name:      "postgres",
+	changelog: migrations.PostgresChangelog,
+	migrationTable: `CREATE TABLE IF NOT EXISTS schema_migration (
+    version INTEGER PRIMARY KEY NOT NULL,
+    changeset TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL
+)`,
+	liquibaseTable: "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'databasechangelog'",
+	numbered:       true,
+	notifications:  true,
+	lock:           "SELECT pg_advisory_lock(" + strconv.Itoa(migrationLockID) + ")",
+	unlock:         "SELECT pg_advisory_unlock(" + strconv.Itoa(migrationLockID) + ")",
+	auditLock:      "LOCK TABLE audit_event IN EXCLUSIVE MODE",
+}
+
+// rebind rewrites the ? placeholders of query for the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// bind returns the arguments of a query with the times formatted for the dialect.
+func (d *dialect) bind(args []any) []any {
+	if d.timeLayout == "" {
+		return args
+	}
+	bound := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			arg = v.UTC().Format(d.timeLayout)
+		case *time.Time:
+			if v != nil {
+				arg = v.UTC().Format(d.timeLayout)
+			}
+		case sql.NullTime:
+			if v.Valid {
+				arg = v.Time.UTC().Format(d.timeLayout)
+			}
+		}
+		bound[i] = arg
+	}
+	return bound
+}
+
+// db runs the queries of the store on the database of its dialect.
+type db struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (d *db) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.DB.ExecContext(ctx, d.dialect.rebind(query), d.dialect.bind(args)...)
+}
+
+func (d *db) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return d.DB.QueryContext(ctx, d.dialect.rebind(query), d.dialect.bind(args)...)
+}
+
+func (d *db) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return d.DB.QueryRowContext(ctx, d.dialect.rebind(query), d.dialect.bind(args)...)
+}
+
+func (d *db) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tx, error) {
+	t, err := d.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{Tx: t, dialect: d.dialect}, nil
+}
+
+// querier runs the queries of the store on a db or within a tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// tx is a transaction of a db.
+type tx struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (t *tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return t.Tx.ExecContext(ctx, t.dialect.rebind(query), t.dialect.bind(args)...)
+}
+
+func (t *tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.QueryContext(ctx, t.dialect.rebind(query), t.dialect.bind(args)...)
+}
+
+func (t *tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return t.Tx.QueryRowContext(ctx, t.dialect.rebind(query), t.dialect.bind(args)...)
+}
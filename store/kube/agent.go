@@ -0,0 +1,179 @@
+package kube
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/store"
+)
+
+// CreateAgent stores a new Agent and sets its ID.
+func (s *Store) CreateAgent(ctx context.Context, a *store.Agent) error {
+	id, err := s.createWithID(ctx, "agent", s.maxAgentID, func(id int64) client.Object {
+		return &acertv1.Agent{
+			Spec: acertv1.AgentSpec{
+				ID:          id,
+				Hostname:    a.Hostname,
+				IP:          a.IP,
+				TokenHash:   a.Token,
+				Description: a.Description,
+				CreatedAt:   metaTime(a.CreatedAt),
+			},
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create agent: %w", err)
+	}
+	a.ID = id
+	a.CreatedAt = a.CreatedAt.UTC()
+	return nil
+}
+
+// EnrollAgent redeems the JoinToken for the token hash and stores the Agent enrolled with it
+// together with the certificate sign issues to it. Resources cannot be changed in one
+// transaction, so when a later step fails the Agent is deleted again and the JoinToken
+// restored to unused, so the host can retry with it.
+func (s *Store) EnrollAgent(ctx context.Context, token string, a *store.Agent, now time.Time,
+	sign func(a *store.Agent) (*store.AgentCertificate, error)) (*store.Ticket, error) {
+	t, err := s.TearTicket(ctx, token, now)
+	if err != nil {
+		return nil, err
+	}
+	a.Token = t.Token
+	err = s.CreateAgent(ctx, a)
+	if err == nil {
+		var c *store.AgentCertificate
+		if c, err = sign(a); err == nil {
+			err = s.CreateAgentCertificate(ctx, c)
+		}
+		if err != nil {
+			err = errors.Join(err, s.deleteAgent(ctx, a.ID))
+		}
+	}
+	if err != nil {
+		return nil, errors.Join(err, s.restoreTicket(ctx, t.ID))
+	}
+	return t, nil
+}
+
+func (s *Store) deleteAgent(ctx context.Context, id int64) error {
+	a := &acertv1.Agent{}
+	a.Namespace, a.Name = s.namespace, agentName(id)
+	if err := s.client.Delete(ctx, a); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete agent: %w", err)
+	}
+	return nil
+}
+
+// GetAgent returns the agent with the given id.
+func (s *Store) GetAgent(ctx context.Context, id int64) (*store.Agent, error) {
+	a := &acertv1.Agent{}
+	err := s.client.Get(ctx, s.key(agentName(id)), a)
+	if apierrors.IsNotFound(err) {
+		return nil, store.ErrAgentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	return agent(a), nil
+}
+
+// ListAgents returns every agent ordered by id.
+func (s *Store) ListAgents(ctx context.Context) ([]*store.Agent, error) {
+	items, err := s.listAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	agents := make([]*store.Agent, 0, len(items))
+	for i := range items {
+		agents = append(agents, agent(&items[i]))
+	}
+	return agents, nil
+}
+
+// UpdateAgentPresence records the version an agent runs and when it was last seen.
+func (s *Store) UpdateAgentPresence(ctx context.Context, id int64, version string, lastSeen time.Time) error {
+	a := &acertv1.Agent{}
+	err := s.update(ctx, agentName(id), a, func() (bool, error) {
+		t := metaTime(lastSeen)
+		a.Status.Version, a.Status.LastSeen = version, &t
+		return true, nil
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to update agent presence: %w", err)
+	}
+	return nil
+}
+
+// RevokeAgent marks the agent and every certificate issued to it as revoked and labels the
+// Agent, so the revoked certificates are listed without reading every Agent. Agents and
+// certificates that are already revoked keep their revocation time.
+func (s *Store) RevokeAgent(ctx context.Context, id int64, now time.Time) error {
+	a := &acertv1.Agent{}
+	err := s.update(ctx, agentName(id), a, func() (bool, error) {
+		revoked := metaTime(now)
+		changed := false
+		if a.Spec.Revoked == nil {
+			a.Spec.Revoked, changed = &revoked, true
+		}
+		if a.Labels[acertv1.LabelRevoked] != "true" {
+			if a.Labels == nil {
+				a.Labels = map[string]string{}
+			}
+			a.Labels[acertv1.LabelRevoked], changed = "true", true
+		}
+		for i := range a.Status.ClientCertificates {
+			if c := &a.Status.ClientCertificates[i]; c.Revoked == nil {
+				c.Revoked, changed = &revoked, true
+			}
+		}
+		return changed, nil
+	})
+	if apierrors.IsNotFound(err) {
+		return store.ErrAgentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke agent: %w", err)
+	}
+	return nil
+}
+
+// listAgents returns the Agents matching opts ordered by id.
+func (s *Store) listAgents(ctx context.Context, opts ...client.ListOption) ([]acertv1.Agent, error) {
+	list := &acertv1.AgentList{}
+	if err := s.client.List(ctx, list, append(opts, client.InNamespace(s.namespace))...); err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+	slices.SortFunc(list.Items, func(a, b acertv1.Agent) int { return cmp.Compare(a.Spec.ID, b.Spec.ID) })
+	return list.Items, nil
+}
+
+func (s *Store) maxAgentID(ctx context.Context) (int64, error) {
+	items, err := s.listAgents(ctx)
+	if err != nil || len(items) == 0 {
+		return 0, err
+	}
+	return items[len(items)-1].Spec.ID, nil
+}
+
+func agent(a *acertv1.Agent) *store.Agent {
+	return &store.Agent{
+		ID:          a.Spec.ID,
+		Hostname:    a.Spec.Hostname,
+		IP:          a.Spec.IP,
+		Token:       a.Spec.TokenHash,
+		Description: a.Spec.Description,
+		CreatedAt:   a.Spec.CreatedAt.UTC(),
+		Revoked:     timePtr(a.Spec.Revoked),
+		Version:     a.Status.Version,
+		LastSeen:    timePtr(a.Status.LastSeen),
+	}
+}
@@ -0,0 +1,160 @@
+package kube
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/store"
+)
+
+// auditHead is the last audit event known to the store.
+type auditHead struct {
+	id   int64
+	hash string
+}
+
+// AppendAuditEvent creates the AuditEvent after the last one and sets the id and hashes of e.
+// Names are unique, so when another server appended first the event it created becomes the
+// head and e is chained to it instead. The head is remembered, the events are only listed
+// when the store appends its first one.
+func (s *Store) AppendAuditEvent(ctx context.Context, e *store.AuditEvent) error {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	if s.auditHead == nil {
+		head, err := s.lastAuditEvent(ctx)
+		if err != nil {
+			return err
+		}
+		s.auditHead = head
+	}
+	for {
+		e.Chain(s.auditHead.id, s.auditHead.hash)
+		err := s.client.Create(ctx, &acertv1.AuditEvent{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: s.namespace,
+				Name:      auditEventName(e.ID),
+				Labels:    map[string]string{acertv1.LabelAuditID: strconv.FormatInt(e.ID, 10)},
+			},
+			Spec: acertv1.AuditEventSpec{
+				ID:          e.ID,
+				Time:        metav1.NewMicroTime(e.Time),
+				Actor:       e.Actor,
+				AgentID:     e.AgentID,
+				AgentSerial: e.AgentSerial,
+				Action:      e.Action,
+				Target:      e.Target,
+				Result:      e.Result,
+				Detail:      e.Detail,
+				PrevHash:    e.PrevHash,
+				Hash:        e.Hash,
+			},
+		})
+		if err == nil {
+			s.auditHead = &auditHead{id: e.ID, hash: e.Hash}
+			return nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create audit event: %w", err)
+		}
+		taken := &acertv1.AuditEvent{}
+		if err := s.client.Get(ctx, s.key(auditEventName(e.ID)), taken); err != nil {
+			return fmt.Errorf("failed to get audit event: %w", err)
+		}
+		s.auditHead = &auditHead{id: taken.Spec.ID, hash: taken.Spec.Hash}
+	}
+}
+
+// ListAuditEvents returns the audit events matching filter in order. The events after AfterID
+// are selected by their id label, and only the ids of the page when no other filter is set, as
+// ids have no gaps.
+func (s *Store) ListAuditEvents(ctx context.Context, filter store.AuditFilter) ([]*store.AuditEvent, error) {
+	selector := labels.NewSelector()
+	if filter.AfterID > 0 {
+		r, err := labels.NewRequirement(acertv1.LabelAuditID, selection.GreaterThan, []string{strconv.FormatInt(filter.AfterID, 10)})
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	if filter.Limit > 0 && filter.Since.IsZero() && filter.Until.IsZero() && filter.Actor == "" && filter.Action == "" &&
+		filter.AgentID == 0 {
+		r, err := labels.NewRequirement(acertv1.LabelAuditID, selection.LessThan,
+			[]string{strconv.FormatInt(filter.AfterID+int64(filter.Limit)+1, 10)})
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	items, err := s.listAuditEvents(ctx, client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return nil, err
+	}
+	var events []*store.AuditEvent
+	for i := range items {
+		e := auditEvent(&items[i])
+		switch {
+		case e.ID <= filter.AfterID,
+			!filter.Since.IsZero() && e.Time.Before(filter.Since),
+			!filter.Until.IsZero() && !e.Time.Before(filter.Until),
+			filter.Actor != "" && e.Actor != filter.Actor,
+			filter.Action != "" && e.Action != filter.Action,
+			filter.AgentID != 0 && e.AgentID != filter.AgentID:
+			continue
+		}
+		events = append(events, e)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// listAuditEvents returns the AuditEvents matching opts ordered by id.
+func (s *Store) listAuditEvents(ctx context.Context, opts ...client.ListOption) ([]acertv1.AuditEvent, error) {
+	list := &acertv1.AuditEventList{}
+	if err := s.client.List(ctx, list, append(opts, client.InNamespace(s.namespace))...); err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+	slices.SortFunc(list.Items, func(a, b acertv1.AuditEvent) int { return cmp.Compare(a.Spec.ID, b.Spec.ID) })
+	return list.Items, nil
+}
+
+func (s *Store) lastAuditEvent(ctx context.Context) (*auditHead, error) {
+	items, err := s.listAuditEvents(ctx)
+	if err != nil || len(items) == 0 {
+		return &auditHead{}, err
+	}
+	last := items[len(items)-1]
+	return &auditHead{id: last.Spec.ID, hash: last.Spec.Hash}, nil
+}
+
+func auditEventName(id int64) string {
+	return "audit-" + strconv.FormatInt(id, 10)
+}
+
+func auditEvent(ae *acertv1.AuditEvent) *store.AuditEvent {
+	return &store.AuditEvent{
+		ID:          ae.Spec.ID,
+		Time:        ae.Spec.Time.UTC(),
+		Actor:       ae.Spec.Actor,
+		AgentID:     ae.Spec.AgentID,
+		AgentSerial: ae.Spec.AgentSerial,
+		Action:      ae.Spec.Action,
+		Target:      ae.Spec.Target,
+		Result:      ae.Spec.Result,
+		Detail:      ae.Spec.Detail,
+		PrevHash:    ae.Spec.PrevHash,
+		Hash:        ae.Spec.Hash,
+	}
+}
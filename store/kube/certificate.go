@@ -0,0 +1,319 @@
+package kube
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/store"
+)
+
+// CreateAgentCertificate records a certificate issued to an agent in the status of its Agent
+// and labels the Agent with its serial.
+func (s *Store) CreateAgentCertificate(ctx context.Context, c *store.AgentCertificate) error {
+	c.CreatedAt = c.CreatedAt.UTC()
+	a := &acertv1.Agent{}
+	err := s.update(ctx, agentName(c.AgentID), a, func() (bool, error) {
+		if a.Labels == nil {
+			a.Labels = map[string]string{}
+		}
+		a.Labels[serialLabel(c.Serial)] = "true"
+		a.Status.ClientCertificates = append(a.Status.ClientCertificates, acertv1.ClientCertificate{
+			Serial:    c.Serial,
+			NotBefore: metaTime(c.NotBefore),
+			NotAfter:  metaTime(c.NotAfter),
+			CreatedAt: metaTime(c.CreatedAt),
+		})
+		return true, nil
+	})
+	if apierrors.IsNotFound(err) {
+		return store.ErrAgentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record agent certificate: %w", err)
+	}
+	return nil
+}
+
+// GetAgentCertificate returns the agent certificate with the given serial. Only the Agents
+// labeled with the serial are read.
+func (s *Store) GetAgentCertificate(ctx context.Context, serial string) (*store.AgentCertificate, error) {
+	agents, err := s.listAgents(ctx, client.HasLabels{serialLabel(serial)})
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range agents {
+		for _, c := range a.Status.ClientCertificates {
+			if c.Serial == serial {
+				return agentCertificate(a.Spec.ID, c), nil
+			}
+		}
+	}
+	return nil, store.ErrAgentCertificateNotFound
+}
+
+// ListRevokedAgentCertificates returns the revoked agent certificates that have not expired at
+// now, ordered by revocation time. Only the Agents labeled as revoked are read.
+func (s *Store) ListRevokedAgentCertificates(ctx context.Context, now time.Time) ([]*store.AgentCertificate, error) {
+	agents, err := s.listAgents(ctx, client.MatchingLabels{acertv1.LabelRevoked: "true"})
+	if err != nil {
+		return nil, err
+	}
+	var certs []*store.AgentCertificate
+	for _, a := range agents {
+		for _, c := range a.Status.ClientCertificates {
+			if c.Revoked != nil && c.NotAfter.After(now) {
+				certs = append(certs, agentCertificate(a.Spec.ID, c))
+			}
+		}
+	}
+	slices.SortFunc(certs, func(a, b *store.AgentCertificate) int {
+		return cmp.Or(a.Revoked.Compare(*b.Revoked), strings.Compare(a.Serial, b.Serial))
+	})
+	return certs, nil
+}
+
+// ReplaceCertificates stores the certificate inventory of an agent as a new generation of
+// InventoryCertificates and makes it current in the status of the Agent. Certificates are
+// numbered by their position in the inventory. Generations that are no longer current are
+// deleted, those of a report that failed before it became current when the next one is made.
+func (s *Store) ReplaceCertificates(ctx context.Context, agentID int64, certs []*store.Certificate, reportedAt time.Time) error {
+	reportedAt = reportedAt.UTC()
+	a := &acertv1.Agent{}
+	err := s.client.Get(ctx, s.key(agentName(agentID)), a)
+	if apierrors.IsNotFound(err) {
+		return store.ErrAgentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get agent: %w", err)
+	}
+	current := a.Status.InventoryGeneration
+	if err := s.deleteInventory(ctx, agentID, current); err != nil {
+		return err
+	}
+	generation := current + 1
+	for i, c := range certs {
+		index := int64(i + 1)
+		err := s.client.Create(ctx, &acertv1.InventoryCertificate{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: s.namespace,
+				Name:      inventoryCertificateName(agentID, generation, index),
+				Labels: map[string]string{
+					acertv1.LabelAgentID:             strconv.FormatInt(agentID, 10),
+					acertv1.LabelInventoryGeneration: strconv.FormatInt(generation, 10),
+				},
+			},
+			Spec: acertv1.InventoryCertificateSpec{
+				AgentID:      agentID,
+				Generation:   generation,
+				Index:        index,
+				ReportedAt:   metaTime(reportedAt),
+				Path:         c.Path,
+				Subject:      c.Subject,
+				SANs:         c.SANs,
+				Issuer:       c.Issuer,
+				Serial:       c.Serial,
+				NotBefore:    metaTime(c.NotBefore),
+				NotAfter:     metaTime(c.NotAfter),
+				KeyAlgorithm: c.KeyAlgorithm,
+				Fingerprint:  c.Fingerprint,
+			},
+		})
+		if apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create inventory certificate: another report of agent %d is in progress", agentID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create inventory certificate: %w", err)
+		}
+	}
+	err = s.update(ctx, agentName(agentID), a, func() (bool, error) {
+		if a.Status.InventoryGeneration != current {
+			return false, fmt.Errorf("another report of agent %d became current", agentID)
+		}
+		t := metaTime(reportedAt)
+		a.Status.InventoryGeneration, a.Status.InventoryReportedAt = generation, &t
+		return true, nil
+	})
+	if apierrors.IsNotFound(err) {
+		return store.ErrAgentNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to replace certificates: %w", err)
+	}
+	if err := s.deleteInventory(ctx, agentID, generation); err != nil {
+		return err
+	}
+	for i, c := range certs {
+		if c.SANs == nil {
+			c.SANs = []string{}
+		}
+		c.ID, c.AgentID, c.ReportedAt = int64(i+1), agentID, reportedAt
+	}
+	return nil
+}
+
+// deleteInventory deletes the InventoryCertificates of an agent except those of generation.
+func (s *Store) deleteInventory(ctx context.Context, agentID, generation int64) error {
+	agent, err := labels.NewRequirement(acertv1.LabelAgentID, selection.Equals, []string{strconv.FormatInt(agentID, 10)})
+	if err != nil {
+		return err
+	}
+	other, err := labels.NewRequirement(acertv1.LabelInventoryGeneration, selection.NotEquals, []string{strconv.FormatInt(generation, 10)})
+	if err != nil {
+		return err
+	}
+	if err := s.client.DeleteAllOf(ctx, &acertv1.InventoryCertificate{}, client.InNamespace(s.namespace),
+		client.MatchingLabelsSelector{Selector: labels.NewSelector().Add(*agent, *other)}); err != nil {
+		return fmt.Errorf("failed to delete inventory certificates: %w", err)
+	}
+	return nil
+}
+
+// ListCertificates returns the certificate inventory of an agent ordered by expiry.
+func (s *Store) ListCertificates(ctx context.Context, agentID int64) ([]*store.Certificate, error) {
+	a := &acertv1.Agent{}
+	err := s.client.Get(ctx, s.key(agentName(agentID)), a)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	if a.Status.InventoryGeneration == 0 {
+		return []*store.Certificate{}, nil
+	}
+	items, err := s.listInventory(ctx, client.MatchingLabels{
+		acertv1.LabelAgentID:             strconv.FormatInt(agentID, 10),
+		acertv1.LabelInventoryGeneration: strconv.FormatInt(a.Status.InventoryGeneration, 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inventory(items, nil), nil
+}
+
+// ListAllCertificates returns the certificates of every agent inventory matching filter ordered
+// by expiry.
+func (s *Store) ListAllCertificates(ctx context.Context, filter store.CertificateFilter) ([]*store.Certificate, error) {
+	if filter.AgentID != 0 {
+		certs, err := s.ListCertificates(ctx, filter.AgentID)
+		if err != nil {
+			return nil, err
+		}
+		return expiringBefore(certs, filter.ExpiresBefore), nil
+	}
+	current, err := s.inventoryGenerations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.listInventory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return expiringBefore(inventory(items, current), filter.ExpiresBefore), nil
+}
+
+// ListCertificateExpiries returns when each certificate in the inventory of every agent expires.
+func (s *Store) ListCertificateExpiries(ctx context.Context) ([]time.Time, error) {
+	current, err := s.inventoryGenerations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.listInventory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var expiries []time.Time
+	for _, c := range inventory(items, current) {
+		expiries = append(expiries, c.NotAfter)
+	}
+	return expiries, nil
+}
+
+// inventoryGenerations returns the current inventory generation of every agent by id.
+func (s *Store) inventoryGenerations(ctx context.Context) (map[int64]int64, error) {
+	agents, err := s.listAgents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[int64]int64, len(agents))
+	for _, a := range agents {
+		current[a.Spec.ID] = a.Status.InventoryGeneration
+	}
+	return current, nil
+}
+
+func (s *Store) listInventory(ctx context.Context, opts ...client.ListOption) ([]acertv1.InventoryCertificate, error) {
+	list := &acertv1.InventoryCertificateList{}
+	if err := s.client.List(ctx, list, append(opts, client.InNamespace(s.namespace))...); err != nil {
+		return nil, fmt.Errorf("failed to list inventory certificates: %w", err)
+	}
+	return list.Items, nil
+}
+
+// inventory returns the InventoryCertificates ordered by expiry, only those of the current
+// generation of their agent unless current is nil.
+func inventory(items []acertv1.InventoryCertificate, current map[int64]int64) []*store.Certificate {
+	certs := make([]*store.Certificate, 0, len(items))
+	for _, c := range items {
+		if current != nil && c.Spec.Generation != current[c.Spec.AgentID] {
+			continue
+		}
+		sans := c.Spec.SANs
+		if sans == nil {
+			sans = []string{}
+		}
+		certs = append(certs, &store.Certificate{
+			ID:           c.Spec.Index,
+			AgentID:      c.Spec.AgentID,
+			Path:         c.Spec.Path,
+			Subject:      c.Spec.Subject,
+			SANs:         sans,
+			Issuer:       c.Spec.Issuer,
+			Serial:       c.Spec.Serial,
+			NotBefore:    c.Spec.NotBefore.UTC(),
+			NotAfter:     c.Spec.NotAfter.UTC(),
+			KeyAlgorithm: c.Spec.KeyAlgorithm,
+			Fingerprint:  c.Spec.Fingerprint,
+			ReportedAt:   c.Spec.ReportedAt.UTC(),
+		})
+	}
+	slices.SortFunc(certs, func(a, b *store.Certificate) int {
+		return cmp.Or(a.NotAfter.Compare(b.NotAfter), cmp.Compare(a.AgentID, b.AgentID), cmp.Compare(a.ID, b.ID))
+	})
+	return certs
+}
+
+// expiringBefore returns the certificates expiring before t, all of them when t is zero.
+func expiringBefore(certs []*store.Certificate, t time.Time) []*store.Certificate {
+	if t.IsZero() {
+		return certs
+	}
+	return slices.DeleteFunc(certs, func(c *store.Certificate) bool { return !c.NotAfter.Before(t) })
+}
+
+func inventoryCertificateName(agentID, generation, index int64) string {
+	return fmt.Sprintf("%s-%d-%d", agentName(agentID), generation, index)
+}
+
+func agentCertificate(agentID int64, c acertv1.ClientCertificate) *store.AgentCertificate {
+	return &store.AgentCertificate{
+		Serial:    c.Serial,
+		AgentID:   agentID,
+		NotBefore: c.NotBefore.UTC(),
+		NotAfter:  c.NotAfter.UTC(),
+		CreatedAt: c.CreatedAt.UTC(),
+		Revoked:   timePtr(c.Revoked),
+	}
+}
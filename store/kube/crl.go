@@ -0,0 +1,73 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/salzr/acert/store"
+)
+
+// The CRL is kept in a ConfigMap, it is public and can be fetched with kubectl as well.
+const (
+	crlConfigMap     = "acert-crl"
+	crlKey           = "crl.der"
+	crlNumberKey     = "number"
+	crlThisUpdateKey = "thisUpdate"
+	crlNextUpdateKey = "nextUpdate"
+)
+
+// GetCRL returns the CRL in the acert-crl ConfigMap.
+func (s *Store) GetCRL(ctx context.Context) (*store.CRL, error) {
+	cm := &corev1.ConfigMap{}
+	err := s.client.Get(ctx, s.key(crlConfigMap), cm)
+	if apierrors.IsNotFound(err) {
+		return nil, store.ErrCRLNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get crl: %w", err)
+	}
+	c := &store.CRL{DER: cm.BinaryData[crlKey]}
+	if c.Number, err = strconv.ParseInt(cm.Data[crlNumberKey], 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid crl number: %w", err)
+	}
+	if c.ThisUpdate, err = time.Parse(time.RFC3339, cm.Data[crlThisUpdateKey]); err != nil {
+		return nil, fmt.Errorf("invalid crl this update: %w", err)
+	}
+	if c.NextUpdate, err = time.Parse(time.RFC3339, cm.Data[crlNextUpdateKey]); err != nil {
+		return nil, fmt.Errorf("invalid crl next update: %w", err)
+	}
+	return c, nil
+}
+
+// PutCRL replaces the CRL in the acert-crl ConfigMap, creating it if needed.
+func (s *Store) PutCRL(ctx context.Context, c *store.CRL) error {
+	data := map[string]string{
+		crlNumberKey:     strconv.FormatInt(c.Number, 10),
+		crlThisUpdateKey: c.ThisUpdate.UTC().Format(time.RFC3339),
+		crlNextUpdateKey: c.NextUpdate.UTC().Format(time.RFC3339),
+	}
+	binaryData := map[string][]byte{crlKey: c.DER}
+
+	cm := &corev1.ConfigMap{}
+	err := s.update(ctx, crlConfigMap, cm, func() (bool, error) {
+		cm.Data, cm.BinaryData = data, binaryData
+		return true, nil
+	})
+	if apierrors.IsNotFound(err) {
+		err = s.client.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: crlConfigMap},
+			Data:       data,
+			BinaryData: binaryData,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store crl: %w", err)
+	}
+	return nil
+}
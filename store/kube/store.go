@@ -0,0 +1,145 @@
+// Package kube implements the store.Repository on the acert.salzr.io custom resources, so the
+// server state lives in the cluster and can be inspected with kubectl.
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/store"
+)
+
+// maxCreateAttempts bounds the retries of creating an object under the next free id when other
+// servers take the same id concurrently.
+const maxCreateAttempts = 10
+
+// Store keeps agents, join tokens and tasks as Agent, JoinToken and AgentTask resources in a
+// namespace. Agent certificates are kept in the status of their Agent, the certificates an agent
+// reports in InventoryCertificates. Updates use the resource version of the object read,
+// conflicting updates are read and applied again.
+type Store struct {
+	client    client.WithWatch
+	namespace string
+
+	// auditMu serializes the appends of this store, auditHead is the last event it knows of.
+	auditMu   sync.Mutex
+	auditHead *auditHead
+}
+
+var _ store.Repository = (*Store)(nil)
+
+// Open returns a store in namespace. The client must have the acert and core schemes registered
+// and the CRDs in config/crd must be installed.
+func Open(ctx context.Context, c client.WithWatch, namespace string) (*Store, error) {
+	s := &Store{client: c, namespace: namespace}
+	if err := s.Ping(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Ping checks that the API server is reachable and serves the acert resources.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.client.List(ctx, &acertv1.AgentList{}, client.InNamespace(s.namespace), client.Limit(1)); err != nil {
+		return fmt.Errorf("failed to list agents: %w", err)
+	}
+	return nil
+}
+
+// Close does nothing, the client is owned by the caller.
+func (s *Store) Close() error {
+	return nil
+}
+
+func (s *Store) key(name string) client.ObjectKey {
+	return client.ObjectKey{Namespace: s.namespace, Name: name}
+}
+
+// createWithID creates the object returned by build for the id after the highest id in use,
+// named prefix-<id>. Names are unique, so servers allocating the same id concurrently retry
+// with the next one.
+func (s *Store) createWithID(ctx context.Context, prefix string, maxID func(context.Context) (int64, error),
+	build func(id int64) client.Object) (int64, error) {
+	for range maxCreateAttempts {
+		id, err := maxID(ctx)
+		if err != nil {
+			return 0, err
+		}
+		id++
+		obj := build(id)
+		obj.SetNamespace(s.namespace)
+		obj.SetName(prefix + "-" + strconv.FormatInt(id, 10))
+		err = s.client.Create(ctx, obj)
+		if apierrors.IsAlreadyExists(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+	return 0, fmt.Errorf("failed to allocate a %s id after %d attempts", prefix, maxCreateAttempts)
+}
+
+// update reads the object named name into obj, applies mutate and writes it back, reading it
+// again when another update won. mutate returns false to leave the object unchanged.
+func (s *Store) update(ctx context.Context, name string, obj client.Object, mutate func() (bool, error)) error {
+	for {
+		if err := s.client.Get(ctx, s.key(name), obj); err != nil {
+			return err
+		}
+		changed, err := mutate()
+		if err != nil || !changed {
+			return err
+		}
+		err = s.client.Update(ctx, obj)
+		if apierrors.IsConflict(err) {
+			continue
+		}
+		return err
+	}
+}
+
+func agentName(id int64) string {
+	return "agent-" + strconv.FormatInt(id, 10)
+}
+
+// tokenHashLabel is the token hash as a label value, which is at most 63 characters long.
+func tokenHashLabel(hash string) string {
+	return hash[:min(len(hash), 63)]
+}
+
+// serialLabel is the label key of a certificate serial, whose name is at most 63 characters
+// long.
+func serialLabel(serial string) string {
+	return acertv1.LabelSerialPrefix + serial[:min(len(serial), 63)]
+}
+
+// metaTime returns t at the second precision resources store times in.
+func metaTime(t time.Time) metav1.Time {
+	return metav1.NewTime(t.UTC().Truncate(time.Second))
+}
+
+func metaTimePtr(t *time.Time) *metav1.Time {
+	if t == nil {
+		return nil
+	}
+	mt := metaTime(*t)
+	return &mt
+}
+
+func timePtr(t *metav1.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := t.UTC()
+	return &v
+}
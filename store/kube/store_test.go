@@ -0,0 +1,437 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/store"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := acertv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	st, err := Open(context.Background(), fake.NewClientBuilder().WithScheme(scheme).Build(), "acert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+func TestTearTicketOnce(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now()
+	if _, err := st.CreateTicket(ctx, "token", now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for range cap(errs) {
+		wg.Go(func() {
+			_, err := st.TearTicket(ctx, "token", now)
+			errs <- err
+		})
+	}
+	wg.Wait()
+	close(errs)
+
+	redeemed := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			redeemed++
+		case !errors.Is(err, store.ErrTicketTorn):
+			t.Errorf("expected redemption to fail with %v, got %v", store.ErrTicketTorn, err)
+		}
+	}
+	if redeemed != 1 {
+		t.Errorf("expected the ticket to be redeemed once, got %d", redeemed)
+	}
+	if _, err := st.TearTicket(ctx, "other", now); !errors.Is(err, store.ErrTicketNotFound) {
+		t.Errorf("expected %v, got %v", store.ErrTicketNotFound, err)
+	}
+}
+
+func TestRevokeTicket(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now().Truncate(time.Second)
+	revoked, err := st.CreateTicket(ctx, "revoked", now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	used, err := st.CreateTicket(ctx, "used", now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.TearTicket(ctx, "used", now); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.RevokeTicket(ctx, revoked.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.TearTicket(ctx, "revoked", now); !errors.Is(err, store.ErrTicketRevoked) {
+		t.Errorf("expected %v redeeming a revoked ticket, got %v", store.ErrTicketRevoked, err)
+	}
+	if _, err := st.RevokeTicket(ctx, used.ID, now); !errors.Is(err, store.ErrTicketTorn) {
+		t.Errorf("expected %v revoking a used ticket, got %v", store.ErrTicketTorn, err)
+	}
+	if _, err := st.RevokeTicket(ctx, used.ID+1, now); !errors.Is(err, store.ErrTicketNotFound) {
+		t.Errorf("expected %v revoking a missing ticket, got %v", store.ErrTicketNotFound, err)
+	}
+
+	tickets, err := st.ListTickets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tickets) != 2 || tickets[0].Revoked == nil || tickets[1].Torn == nil {
+		t.Errorf("expected a revoked and a used ticket, got %+v", tickets)
+	}
+}
+
+func TestEnrollAgent(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now().Truncate(time.Second)
+	if _, err := st.CreateTicket(ctx, "token", now, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	sign := func(a *store.Agent) (*store.AgentCertificate, error) {
+		return &store.AgentCertificate{Serial: "1a", AgentID: a.ID, NotBefore: now, NotAfter: now.Add(time.Hour), CreatedAt: now}, nil
+	}
+
+	failed := errors.New("sign failed")
+	_, err := st.EnrollAgent(ctx, "token", &store.Agent{Hostname: "host", CreatedAt: now}, now,
+		func(*store.Agent) (*store.AgentCertificate, error) { return nil, failed })
+	if !errors.Is(err, failed) {
+		t.Errorf("expected %v, got %v", failed, err)
+	}
+	if agents, err := st.ListAgents(ctx); err != nil || len(agents) != 0 {
+		t.Errorf("expected no agent after a failed enrollment, got %v, %v", agents, err)
+	}
+
+	a := &store.Agent{Hostname: "host", CreatedAt: now}
+	ticket, err := st.EnrollAgent(ctx, "token", a, now, sign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ticket.Torn == nil || a.ID == 0 || a.Token != "token" {
+		t.Errorf("expected the ticket to be torn for agent %+v, got %+v", a, ticket)
+	}
+	if c, err := st.GetAgentCertificate(ctx, "1a"); err != nil || c.AgentID != a.ID {
+		t.Errorf("expected certificate 1a of agent %d, got %v, %v", a.ID, c, err)
+	}
+	if _, err := st.GetAgentCertificate(ctx, "1"); !errors.Is(err, store.ErrAgentCertificateNotFound) {
+		t.Errorf("expected %v for an unknown serial, got %v", store.ErrAgentCertificateNotFound, err)
+	}
+	agent := &acertv1.Agent{}
+	if err := st.client.Get(ctx, st.key(agentName(a.ID)), agent); err != nil || agent.Labels[serialLabel("1a")] != "true" {
+		t.Errorf("expected agent %d to be labeled with serial 1a, got %v, %v", a.ID, agent.Labels, err)
+	}
+	if _, err := st.EnrollAgent(ctx, "token", &store.Agent{Hostname: "host", CreatedAt: now}, now, sign); !errors.Is(err, store.ErrTicketTorn) {
+		t.Errorf("expected %v enrolling twice, got %v", store.ErrTicketTorn, err)
+	}
+}
+
+func TestAgentLifecycle(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now().Truncate(time.Second)
+
+	for range 2 {
+		if err := st.CreateAgent(ctx, &store.Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	agents, err := st.ListAgents(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(agents) != 2 || agents[0].ID != 1 || agents[1].ID != 2 {
+		t.Fatalf("expected agents 1 and 2, got %v", agents)
+	}
+	if err := st.CreateAgentCertificate(ctx, &store.AgentCertificate{Serial: "1a", AgentID: 1,
+		NotBefore: now, NotAfter: now.Add(time.Hour), CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RevokeAgent(ctx, 1, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RevokeAgent(ctx, 3, now); !errors.Is(err, store.ErrAgentNotFound) {
+		t.Errorf("expected %v revoking a missing agent, got %v", store.ErrAgentNotFound, err)
+	}
+
+	got, err := st.GetAgent(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Revoked == nil || !got.Revoked.Equal(now) {
+		t.Errorf("expected the agent to be revoked at %v, got %v", now, got.Revoked)
+	}
+	revoked, err := st.ListRevokedAgentCertificates(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0].Serial != "1a" {
+		t.Errorf("expected certificate 1a to be revoked, got %v", revoked)
+	}
+}
+
+func TestReplaceCertificates(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now().Truncate(time.Second)
+	if err := st.CreateAgent(ctx, &store.Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	report := func(paths ...string) {
+		t.Helper()
+		var certs []*store.Certificate
+		for i, path := range paths {
+			certs = append(certs, &store.Certificate{Path: path, Subject: "CN=" + path, NotBefore: now,
+				NotAfter: now.Add(time.Duration(len(paths)-i) * time.Hour)})
+		}
+		if err := st.ReplaceCertificates(ctx, 1, certs, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// expect checks the current inventory and that no other generation is left.
+	expect := func(paths ...string) {
+		t.Helper()
+		certs, err := st.ListAllCertificates(ctx, store.CertificateFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, c := range certs {
+			got = append(got, c.Path)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(paths) {
+			t.Errorf("expected certificates %v, got %v", paths, got)
+		}
+		list := &acertv1.InventoryCertificateList{}
+		if err := st.client.List(ctx, list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Items) != len(paths) {
+			t.Errorf("expected %d inventory certificates, got %d", len(paths), len(list.Items))
+		}
+	}
+
+	report("/a", "/b")
+	expect("/b", "/a")
+	report("/c")
+	expect("/c")
+
+	// The certificates of a report that failed before it became current are not listed and
+	// deleted by the next report.
+	if err := st.client.Create(ctx, &acertv1.InventoryCertificate{
+		ObjectMeta: metav1.ObjectMeta{Namespace: st.namespace, Name: inventoryCertificateName(1, 3, 1),
+			Labels: map[string]string{acertv1.LabelAgentID: "1", acertv1.LabelInventoryGeneration: "3"}},
+		Spec: acertv1.InventoryCertificateSpec{AgentID: 1, Generation: 3, Index: 1, Path: "/failed"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if certs, err := st.ListCertificates(ctx, 1); err != nil {
+		t.Fatal(err)
+	} else if len(certs) != 1 || certs[0].Path != "/c" {
+		t.Errorf("expected only the current certificate /c, got %v", certs)
+	}
+	report("/d")
+	expect("/d")
+
+	if err := st.ReplaceCertificates(ctx, 2, nil, now); !errors.Is(err, store.ErrAgentNotFound) {
+		t.Errorf("expected %v for a missing agent, got %v", store.ErrAgentNotFound, err)
+	}
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	now := time.Now().Truncate(time.Second)
+
+	for _, id := range []string{"b", "a"} {
+		if err := st.CreateTask(ctx, &store.Task{ID: id, AgentID: 1, Type: "issue", Spec: []byte("{}"), CreatedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err := st.ListTasks(ctx, store.TaskFilter{AgentID: 1, Statuses: []string{store.TaskPending}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].ID != "a" {
+		t.Fatalf("expected tasks a and b pending, got %v", pending)
+	}
+
+	ok, err := st.DispatchTask(ctx, "a", []string{store.TaskPending}, now)
+	if err != nil || !ok {
+		t.Fatalf("expected task a to be dispatched, got %v, %v", ok, err)
+	}
+	if ok, _ := st.DispatchTask(ctx, "a", []string{store.TaskPending}, now); ok {
+		t.Error("expected a dispatched task not to be dispatched again")
+	}
+	for i, want := range []bool{true, false} {
+		ok, err := st.SetTaskCertificate(ctx, "a", []byte(fmt.Sprint("chain", i)), []byte("ca"), now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("expected recording certificate %d of task a to be %v, got %v", i, want, ok)
+		}
+	}
+	if ok, _ := st.SetTaskCertificate(ctx, "b", []byte("chain"), []byte("ca"), now); ok {
+		t.Error("expected a pending task not to get a certificate")
+	}
+	ok, err = st.UpdateTask(ctx, "a", []string{store.TaskDispatched}, store.TaskUpdate{Status: store.TaskSucceeded,
+		Artifacts: []store.TaskArtifact{{Name: "cert", Path: "/etc/cert.pem"}}}, now)
+	if err != nil || !ok {
+		t.Fatalf("expected task a to succeed, got %v, %v", ok, err)
+	}
+	got, err := st.GetTask(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != store.TaskSucceeded || got.Attempts != 1 || got.FinishedAt == nil || len(got.Artifacts) != 1 ||
+		string(got.CertificateChain) != "chain0" {
+		t.Errorf("expected a finished task with one artifact, got %+v", got)
+	}
+
+	if _, err := st.DispatchTask(ctx, "b", []string{store.TaskPending}, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := st.TimeOutTasks(ctx, now.Add(-time.Minute), now, 3, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "b" {
+		t.Errorf("expected task b to time out, got %v", ids)
+	}
+	counts, err := st.CountTasks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts[store.TaskSucceeded] != 1 || counts[store.TaskTimedOut] != 1 {
+		t.Errorf("expected a succeeded and a timed out task, got %v", counts)
+	}
+	if _, err := st.GetTask(ctx, "c"); !errors.Is(err, store.ErrTaskNotFound) {
+		t.Errorf("expected %v, got %v", store.ErrTaskNotFound, err)
+	}
+}
+
+func TestWatchTasks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	st := newTestStore(t)
+
+	agents := make(chan int64, 1)
+	done := make(chan error, 1)
+	go func() { done <- st.WatchTasks(ctx, func(agentID int64) { agents <- agentID }) }()
+
+	// The watch may not be established yet, tasks are created until one is notified.
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		if err := st.CreateTask(ctx, &store.Task{ID: fmt.Sprint(i), AgentID: 7, Type: "test", CreatedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case id := <-agents:
+			if id != 7 {
+				t.Errorf("expected agent 7 to be notified, got %d", id)
+			}
+		case <-ticker.C:
+			continue
+		case <-time.After(5 * time.Second):
+			t.Fatal("no task was notified")
+		}
+		break
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("expected the watch to end without error, got %v", err)
+	}
+}
+
+func TestCRL(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	if _, err := st.GetCRL(ctx); !errors.Is(err, store.ErrCRLNotFound) {
+		t.Errorf("expected %v, got %v", store.ErrCRLNotFound, err)
+	}
+	now := time.Now().Truncate(time.Second)
+	for n := range int64(2) {
+		if err := st.PutCRL(ctx, &store.CRL{Number: n, DER: []byte{byte(n)}, ThisUpdate: now, NextUpdate: now.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	crl, err := st.GetCRL(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crl.Number != 1 || len(crl.DER) != 1 || crl.DER[0] != 1 || !crl.ThisUpdate.Equal(now) {
+		t.Errorf("expected the second crl, got %+v", crl)
+	}
+}
+
+func TestAuditEvents(t *testing.T) {
+	ctx := context.Background()
+	st := newTestStore(t)
+	// other shares the objects of st like a second server would.
+	other := &Store{client: st.client, namespace: st.namespace}
+	for i := range 6 {
+		s := st
+		if i%2 == 1 {
+			s = other
+		}
+		e := &store.AuditEvent{Time: time.Now(), Actor: fmt.Sprintf("test:%d", i%2), Action: "test.append",
+			Target: fmt.Sprintf("n:%d", i), Result: store.AuditSuccess}
+		if err := s.AppendAuditEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+		if e.ID != int64(i+1) {
+			t.Errorf("expected event %d, got %d", i+1, e.ID)
+		}
+	}
+
+	events, err := st.ListAuditEvents(ctx, store.AuditFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("expected 6 events, got %d", len(events))
+	}
+	var chain store.AuditChain
+	for _, e := range events {
+		if err := chain.Verify(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if actor, err := st.ListAuditEvents(ctx, store.AuditFilter{Actor: "test:1"}); err != nil {
+		t.Fatal(err)
+	} else if len(actor) != 3 {
+		t.Errorf("expected 3 events of test:1, got %d", len(actor))
+	}
+	if page, err := st.ListAuditEvents(ctx, store.AuditFilter{AfterID: 2, Limit: 3}); err != nil {
+		t.Fatal(err)
+	} else if len(page) != 3 || page[0].ID != 3 || page[2].ID != 5 {
+		t.Errorf("expected events 3 to 5, got %v", page)
+	}
+}
@@ -0,0 +1,335 @@
+package kube
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/store"
+)
+
+// CreateTask stores a new pending AgentTask.
+func (s *Store) CreateTask(ctx context.Context, t *store.Task) error {
+	t.Status = store.TaskPending
+	t.CreatedAt = metaTime(t.CreatedAt).Time
+	t.UpdatedAt = t.CreatedAt
+	at := &acertv1.AgentTask{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.namespace,
+			Name:      t.ID,
+			Labels: map[string]string{
+				acertv1.LabelAgentID:    strconv.FormatInt(t.AgentID, 10),
+				acertv1.LabelTaskStatus: t.Status,
+			},
+		},
+		Spec: acertv1.AgentTaskSpec{
+			AgentID:   t.AgentID,
+			Type:      t.Type,
+			Payload:   string(t.Spec),
+			CreatedAt: metaTime(t.CreatedAt),
+		},
+		Status: acertv1.AgentTaskStatus{
+			Status:    t.Status,
+			UpdatedAt: metaTime(t.UpdatedAt),
+		},
+	}
+	if err := s.client.Create(ctx, at); err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+	return nil
+}
+
+// GetTask returns the task with the given id.
+func (s *Store) GetTask(ctx context.Context, id string) (*store.Task, error) {
+	at := &acertv1.AgentTask{}
+	err := s.client.Get(ctx, s.key(id), at)
+	if apierrors.IsNotFound(err) {
+		return nil, store.ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task: %w", err)
+	}
+	return task(at), nil
+}
+
+// ListTasks returns the tasks matching filter, oldest first. The agent and statuses are
+// selected by label.
+func (s *Store) ListTasks(ctx context.Context, filter store.TaskFilter) ([]*store.Task, error) {
+	selector := labels.NewSelector()
+	if filter.AgentID != 0 {
+		r, err := labels.NewRequirement(acertv1.LabelAgentID, selection.Equals, []string{strconv.FormatInt(filter.AgentID, 10)})
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	if len(filter.Statuses) > 0 {
+		r, err := labels.NewRequirement(acertv1.LabelTaskStatus, selection.In, filter.Statuses)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	list := &acertv1.AgentTaskList{}
+	if err := s.client.List(ctx, list, client.InNamespace(s.namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	var tasks []*store.Task
+	for i := range list.Items {
+		t := task(&list.Items[i])
+		if !filter.DispatchedBefore.IsZero() && (t.LastDispatchedAt == nil || !t.LastDispatchedAt.Before(filter.DispatchedBefore)) {
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	slices.SortFunc(tasks, func(a, b *store.Task) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	if filter.Limit > 0 && len(tasks) > filter.Limit {
+		tasks = tasks[:filter.Limit]
+	}
+	return tasks, nil
+}
+
+// DispatchTask records a delivery of a task in one of from and moves it to dispatched, it
+// returns false when the task was in another status.
+func (s *Store) DispatchTask(ctx context.Context, id string, from []string, now time.Time) (bool, error) {
+	at := &acertv1.AgentTask{}
+	dispatched := false
+	err := s.update(ctx, id, at, func() (bool, error) {
+		if dispatched = slices.Contains(from, at.Status.Status); !dispatched {
+			return false, nil
+		}
+		t := metaTime(now)
+		setStatus(at, store.TaskDispatched, t)
+		at.Status.Attempts++
+		at.Status.LastDispatchedAt = &t
+		if at.Status.DispatchedAt == nil {
+			at.Status.DispatchedAt = &t
+		}
+		return true, nil
+	})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to dispatch task: %w", err)
+	}
+	return dispatched, nil
+}
+
+// UpdateTask moves a task to the update if it is currently in one of from, it returns false when
+// the task was in another status. FinishedAt is set when the task finishes.
+func (s *Store) UpdateTask(ctx context.Context, id string, from []string, u store.TaskUpdate, now time.Time) (bool, error) {
+	at := &acertv1.AgentTask{}
+	updated := false
+	err := s.update(ctx, id, at, func() (bool, error) {
+		if updated = len(from) == 0 || slices.Contains(from, at.Status.Status); !updated {
+			return false, nil
+		}
+		t := metaTime(now)
+		setStatus(at, u.Status, t)
+		at.Status.Message = u.Message
+		at.Status.ErrorCode = u.ErrorCode
+		at.Status.Artifacts = nil
+		for _, a := range u.Artifacts {
+			at.Status.Artifacts = append(at.Status.Artifacts, acertv1.TaskArtifact{Name: a.Name, Path: a.Path, SHA256: a.SHA256})
+		}
+		at.Status.FinishedAt = nil
+		if store.TaskFinished(u.Status) {
+			at.Status.FinishedAt = &t
+		}
+		return true, nil
+	})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update task: %w", err)
+	}
+	return updated, nil
+}
+
+// SetTaskCertificate records the certificate issued for a dispatched or running task, it returns
+// false when the task is in another status or already has a certificate.
+func (s *Store) SetTaskCertificate(ctx context.Context, id string, chain, ca []byte, now time.Time) (bool, error) {
+	at := &acertv1.AgentTask{}
+	set := false
+	err := s.update(ctx, id, at, func() (bool, error) {
+		status := at.Status.Status
+		if set = (status == store.TaskDispatched || status == store.TaskRunning) && at.Status.CertificateChain == ""; !set {
+			return false, nil
+		}
+		at.Status.CertificateChain = string(chain)
+		at.Status.CA = string(ca)
+		at.Status.UpdatedAt = metaTime(now)
+		return true, nil
+	})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to set task certificate: %w", err)
+	}
+	return set, nil
+}
+
+// TimeOutTasks marks as timed out the dispatched and running tasks first dispatched before
+// deadline, and the tasks still unacknowledged after maxAttempts deliveries, the last one
+// before ackDeadline. It returns the ids of the tasks that timed out.
+func (s *Store) TimeOutTasks(ctx context.Context, deadline, ackDeadline time.Time, maxAttempts int, now time.Time) ([]string, error) {
+	expired := func(st *acertv1.AgentTaskStatus) bool {
+		switch st.Status {
+		case store.TaskDispatched:
+			if st.Attempts >= maxAttempts && st.LastDispatchedAt != nil && st.LastDispatchedAt.Time.Before(ackDeadline) {
+				return true
+			}
+			fallthrough
+		case store.TaskRunning:
+			return st.DispatchedAt != nil && st.DispatchedAt.Time.Before(deadline)
+		}
+		return false
+	}
+
+	list := &acertv1.AgentTaskList{}
+	if err := s.client.List(ctx, list, client.InNamespace(s.namespace),
+		client.MatchingLabelsSelector{Selector: statusSelector(store.TaskDispatched, store.TaskRunning)}); err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	var ids []string
+	for _, item := range list.Items {
+		if !expired(&item.Status) {
+			continue
+		}
+		at := &acertv1.AgentTask{}
+		timedOut := false
+		err := s.update(ctx, item.Name, at, func() (bool, error) {
+			if timedOut = expired(&at.Status); !timedOut {
+				return false, nil
+			}
+			t := metaTime(now)
+			setStatus(at, store.TaskTimedOut, t)
+			at.Status.Message = "task did not finish in time"
+			at.Status.FinishedAt = &t
+			return true, nil
+		})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return ids, fmt.Errorf("failed to time out task: %w", err)
+		}
+		if timedOut {
+			ids = append(ids, item.Name)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// rewatchDelay is how long WatchTasks waits before watching again after a watch was closed.
+const rewatchDelay = 5 * time.Second
+
+// WatchTasks calls notify with the agent of every pending AgentTask until ctx is done. The
+// watch is established again when the API server closes it, which announces the tasks still
+// pending once more.
+func (s *Store) WatchTasks(ctx context.Context, notify func(agentID int64)) error {
+	for ctx.Err() == nil {
+		w, err := s.client.Watch(ctx, &acertv1.AgentTaskList{}, client.InNamespace(s.namespace),
+			client.MatchingLabels{acertv1.LabelTaskStatus: store.TaskPending})
+		if err != nil {
+			return fmt.Errorf("failed to watch tasks: %w", err)
+		}
+		s.forwardTasks(ctx, w, notify)
+		w.Stop()
+		select {
+		case <-ctx.Done():
+		case <-time.After(rewatchDelay):
+		}
+	}
+	return nil
+}
+
+// forwardTasks notifies the agents of the tasks added until the watch is closed or ctx is done.
+func (s *Store) forwardTasks(ctx context.Context, w watch.Interface, notify func(agentID int64)) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			if at, ok := ev.Object.(*acertv1.AgentTask); ok && ev.Type == watch.Added {
+				notify(at.Spec.AgentID)
+			}
+		}
+	}
+}
+
+// CountTasks returns the number of tasks by status, statuses without tasks are missing.
+func (s *Store) CountTasks(ctx context.Context) (map[string]int, error) {
+	list := &acertv1.AgentTaskList{}
+	if err := s.client.List(ctx, list, client.InNamespace(s.namespace)); err != nil {
+		return nil, fmt.Errorf("failed to count tasks: %w", err)
+	}
+	counts := map[string]int{}
+	for _, at := range list.Items {
+		counts[at.Status.Status]++
+	}
+	return counts, nil
+}
+
+// setStatus moves the task to status, keeping the status label in sync.
+func setStatus(at *acertv1.AgentTask, status string, now metav1.Time) {
+	at.Status.Status = status
+	at.Status.UpdatedAt = now
+	if at.Labels == nil {
+		at.Labels = map[string]string{}
+	}
+	at.Labels[acertv1.LabelTaskStatus] = status
+}
+
+func statusSelector(statuses ...string) labels.Selector {
+	r, _ := labels.NewRequirement(acertv1.LabelTaskStatus, selection.In, statuses)
+	return labels.NewSelector().Add(*r)
+}
+
+func task(at *acertv1.AgentTask) *store.Task {
+	t := &store.Task{
+		ID:               at.Name,
+		AgentID:          at.Spec.AgentID,
+		Type:             at.Spec.Type,
+		Spec:             []byte(at.Spec.Payload),
+		Status:           at.Status.Status,
+		Message:          at.Status.Message,
+		ErrorCode:        at.Status.ErrorCode,
+		CreatedAt:        at.Spec.CreatedAt.UTC(),
+		UpdatedAt:        at.Status.UpdatedAt.UTC(),
+		DispatchedAt:     timePtr(at.Status.DispatchedAt),
+		FinishedAt:       timePtr(at.Status.FinishedAt),
+		Attempts:         at.Status.Attempts,
+		LastDispatchedAt: timePtr(at.Status.LastDispatchedAt),
+	}
+	if at.Status.CertificateChain != "" {
+		t.CertificateChain = []byte(at.Status.CertificateChain)
+		t.CA = []byte(at.Status.CA)
+	}
+	for _, a := range at.Status.Artifacts {
+		t.Artifacts = append(t.Artifacts, store.TaskArtifact{Name: a.Name, Path: a.Path, SHA256: a.SHA256})
+	}
+	return t
+}
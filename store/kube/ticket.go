@@ -0,0 +1,160 @@
+package kube
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	acertv1 "github.com/salzr/acert/api/v1"
+	"github.com/salzr/acert/store"
+)
+
+// CreateTicket stores a new JoinToken for the given token hash.
+func (s *Store) CreateTicket(ctx context.Context, token string, createdAt, expiresAt time.Time) (*store.Ticket, error) {
+	var jt *acertv1.JoinToken
+	_, err := s.createWithID(ctx, "jointoken", s.maxTicketID, func(id int64) client.Object {
+		jt = &acertv1.JoinToken{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{acertv1.LabelTokenHash: tokenHashLabel(token)}},
+			Spec: acertv1.JoinTokenSpec{
+				ID:        id,
+				TokenHash: token,
+				CreatedAt: metaTime(createdAt),
+				ExpiresAt: metaTime(expiresAt),
+			},
+		}
+		return jt
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create join token: %w", err)
+	}
+	return ticket(jt), nil
+}
+
+// TearTicket marks the JoinToken for the given token hash as used. It fails if the token does
+// not exist, has already been torn, has been revoked or has expired. Of concurrent redemptions only the first
+// update succeeds, the others read the torn token again.
+func (s *Store) TearTicket(ctx context.Context, token string, now time.Time) (*store.Ticket, error) {
+	list := &acertv1.JoinTokenList{}
+	if err := s.client.List(ctx, list, client.InNamespace(s.namespace),
+		client.MatchingLabels{acertv1.LabelTokenHash: tokenHashLabel(token)}); err != nil {
+		return nil, fmt.Errorf("failed to list join tokens: %w", err)
+	}
+	var name string
+	for _, jt := range list.Items {
+		if jt.Spec.TokenHash == token {
+			name = jt.Name
+		}
+	}
+	if name == "" {
+		return nil, store.ErrTicketNotFound
+	}
+
+	jt := &acertv1.JoinToken{}
+	err := s.update(ctx, name, jt, func() (bool, error) {
+		if jt.Status.Torn != nil {
+			return false, store.ErrTicketTorn
+		}
+		if jt.Status.Revoked != nil {
+			return false, store.ErrTicketRevoked
+		}
+		if !now.Before(jt.Spec.ExpiresAt.Time) {
+			return false, store.ErrTicketExpired
+		}
+		torn := metaTime(now)
+		jt.Status.Torn = &torn
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ticket(jt), nil
+}
+
+// ListTickets returns every JoinToken, oldest first.
+func (s *Store) ListTickets(ctx context.Context) ([]*store.Ticket, error) {
+	list := &acertv1.JoinTokenList{}
+	if err := s.client.List(ctx, list, client.InNamespace(s.namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list join tokens: %w", err)
+	}
+	tickets := make([]*store.Ticket, 0, len(list.Items))
+	for i := range list.Items {
+		tickets = append(tickets, ticket(&list.Items[i]))
+	}
+	slices.SortFunc(tickets, func(a, b *store.Ticket) int { return cmp.Compare(a.ID, b.ID) })
+	return tickets, nil
+}
+
+// RevokeTicket revokes an unused JoinToken so it can no longer be redeemed. It fails with
+// ErrTicketTorn if the token was already used, revoking it again keeps the first revocation.
+// The update conflicts with a concurrent redemption, so only one of them succeeds.
+func (s *Store) RevokeTicket(ctx context.Context, id int64, now time.Time) (*store.Ticket, error) {
+	jt := &acertv1.JoinToken{}
+	err := s.update(ctx, jointokenName(id), jt, func() (bool, error) {
+		if jt.Status.Revoked != nil {
+			return false, nil
+		}
+		if jt.Status.Torn != nil {
+			return false, store.ErrTicketTorn
+		}
+		revoked := metaTime(now)
+		jt.Status.Revoked = &revoked
+		return true, nil
+	})
+	if apierrors.IsNotFound(err) {
+		return nil, store.ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ticket(jt), nil
+}
+
+// restoreTicket marks the JoinToken as unused again after an enrollment with it failed.
+func (s *Store) restoreTicket(ctx context.Context, id int64) error {
+	jt := &acertv1.JoinToken{}
+	err := s.update(ctx, jointokenName(id), jt, func() (bool, error) {
+		if jt.Status.Torn == nil {
+			return false, nil
+		}
+		jt.Status.Torn = nil
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to restore join token: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) maxTicketID(ctx context.Context) (int64, error) {
+	list := &acertv1.JoinTokenList{}
+	if err := s.client.List(ctx, list, client.InNamespace(s.namespace)); err != nil {
+		return 0, fmt.Errorf("failed to list join tokens: %w", err)
+	}
+	var id int64
+	for _, jt := range list.Items {
+		id = max(id, jt.Spec.ID)
+	}
+	return id, nil
+}
+
+func jointokenName(id int64) string {
+	return "jointoken-" + strconv.FormatInt(id, 10)
+}
+
+func ticket(jt *acertv1.JoinToken) *store.Ticket {
+	return &store.Ticket{
+		ID:        jt.Spec.ID,
+		Token:     jt.Spec.TokenHash,
+		CreatedAt: jt.Spec.CreatedAt.UTC(),
+		ExpiresAt: jt.Spec.ExpiresAt.UTC(),
+		Torn:      timePtr(jt.Status.Torn),
+		Revoked:   timePtr(jt.Status.Revoked),
+	}
+}
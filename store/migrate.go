@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// changeset is a changeset of a Liquibase formatted SQL changelog.
+type changeset struct {
+	version int
+	// id is the author:id of the changeset.
+	id  string
+	sql string
+}
+
+// parseChangelog splits a Liquibase formatted SQL changelog into its changesets. Comments,
+// including the rollback statements, are dropped.
+func parseChangelog(src string) ([]changeset, error) {
+	var changesets []changeset
+	for line := range strings.Lines(src) {
+		trimmed := strings.TrimSpace(line)
+		if header, ok := strings.CutPrefix(trimmed, "--changeset "); ok {
+			fields := strings.Fields(header)
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("changeset %d has no id", len(changesets)+1)
+			}
+			changesets = append(changesets, changeset{version: len(changesets) + 1, id: fields[0]})
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		if len(changesets) == 0 {
+			return nil, errors.New("statement outside of a changeset")
+		}
+		changesets[len(changesets)-1].sql += line
+	}
+	return changesets, nil
+}
+
+// Version returns the number of changesets applied to the database.
+func (s *Store) Version(ctx context.Context) (int, error) {
+	var version int
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migration").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate applies the changesets of the changelog that are missing from the database, each in
+// its own transaction. The schema_migration table records the applied changesets by version,
+// the position of the changeset in the changelog. Changesets recorded by Liquibase in
+// DATABASECHANGELOG are taken as applied, databases managed by hand so far are adopted without
+// running them again.
+func (s *Store) migrate(ctx context.Context) error {
+	d := s.db.dialect
+	changesets, err := parseChangelog(d.changelog)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s changelog: %w", d.name, err)
+	}
+	if d.lock != "" {
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, d.lock); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), d.unlock)
+	}
+	if _, err := s.db.ExecContext(ctx, d.migrationTable); err != nil {
+		return fmt.Errorf("failed to create migration table: %w", err)
+	}
+	liquibase, err := s.liquibaseChangesets(ctx)
+	if err != nil {
+		return err
+	}
+	for _, cs := range changesets {
+		if err := s.applyChangeset(ctx, cs, liquibase[cs.id]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) applyChangeset(ctx context.Context, cs changeset, applied bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, "SELECT changeset FROM schema_migration WHERE version = ?", cs.version).Scan(&id)
+	switch {
+	case err == nil && id != cs.id:
+		return fmt.Errorf("schema version %d is changeset %s in the database but %s in the changelog", cs.version, id, cs.id)
+	case err == nil:
+		return nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if !applied {
+		if _, err := tx.ExecContext(ctx, cs.sql); err != nil {
+			return fmt.Errorf("failed to apply changeset %s: %w", cs.id, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO schema_migration (version, changeset, applied_at) VALUES (?, ?, ?)",
+		cs.version, cs.id, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record changeset %s: %w", cs.id, err)
+	}
+	return tx.Commit()
+}
+
+// liquibaseChangesets returns the author:id of the changesets Liquibase applied to the
+// database, none when it never ran.
+func (s *Store) liquibaseChangesets(ctx context.Context) (map[string]bool, error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx, s.db.dialect.liquibaseTable).Scan(&n); err != nil {
+		return nil, fmt.Errorf("failed to look up liquibase changelog: %w", err)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	rows, err := s.db.QueryContext(ctx, "SELECT author, id FROM DATABASECHANGELOG")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read liquibase changelog: %w", err)
+	}
+	defer rows.Close()
+	applied := map[string]bool{}
+	for rows.Next() {
+		var author, id string
+		if err := rows.Scan(&author, &id); err != nil {
+			return nil, fmt.Errorf("failed to read liquibase changelog: %w", err)
+		}
+		applied[author+":"+id] = true
+	}
+	return applied, rows.Err()
+}
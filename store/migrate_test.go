@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/salzr/acert/migrations"
+)
+
+func TestParseChangelog(t *testing.T) {
+	changesets, err := parseChangelog(`--liquibase formatted sql
+
+--changeset alice:1 runOnChange:true
+CREATE TABLE a (id INTEGER);
+--rollback DROP TABLE a;
+
+--changeset bob:2
+ALTER TABLE a ADD COLUMN b TEXT;
+ALTER TABLE a ADD COLUMN c TEXT;
+--rollback ALTER TABLE a DROP COLUMN c;
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []changeset{
+		{version: 1, id: "alice:1", sql: "CREATE TABLE a (id INTEGER);\n"},
+		{version: 2, id: "bob:2", sql: "ALTER TABLE a ADD COLUMN b TEXT;\nALTER TABLE a ADD COLUMN c TEXT;\n"},
+	}
+	if len(changesets) != len(want) {
+		t.Fatalf("expected %d changesets, got %d", len(want), len(changesets))
+	}
+	for i := range want {
+		if changesets[i] != want[i] {
+			t.Errorf("changeset %d: expected %+v, got %+v", i, want[i], changesets[i])
+		}
+	}
+
+	if _, err := parseChangelog("CREATE TABLE a (id INTEGER);"); err == nil {
+		t.Error("expected statements outside of a changeset to fail")
+	}
+}
+
+func TestOpenMigrates(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			// Opening again must not apply the changesets twice.
+			for range 2 {
+				st := db.open(t)
+				changesets, err := parseChangelog(st.db.dialect.changelog)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if v, err := st.Version(ctx); err != nil {
+					t.Fatal(err)
+				} else if v != len(changesets) {
+					t.Errorf("expected version %d, got %d", len(changesets), v)
+				}
+				if _, err := st.CreateTicket(ctx, time.Now().String(), time.Now(), time.Now().Add(time.Hour)); err != nil {
+					t.Errorf("expected the migrated schema to store tickets: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestChangelogsMatch(t *testing.T) {
+	sqliteChangesets, err := parseChangelog(migrations.Changelog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postgresChangesets, err := parseChangelog(migrations.PostgresChangelog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqliteChangesets) != len(postgresChangesets) {
+		t.Fatalf("expected the changelogs to have the same changesets, got %d and %d", len(sqliteChangesets), len(postgresChangesets))
+	}
+	for i := range sqliteChangesets {
+		if sqliteChangesets[i].id != postgresChangesets[i].id {
+			t.Errorf("expected changeset %d to be %s in both changelogs, got %s", i+1, sqliteChangesets[i].id, postgresChangesets[i].id)
+		}
+	}
+}
+
+func TestOpenAdoptsLiquibaseDatabase(t *testing.T) {
+	ctx := context.Background()
+	changesets, err := parseChangelog(migrations.Changelog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "acert.db")
+	db, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE DATABASECHANGELOG (ID TEXT NOT NULL, AUTHOR TEXT NOT NULL, FILENAME TEXT NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	// Liquibase applied the first changesets, the rest are left to Open.
+	for _, cs := range changesets[:3] {
+		if _, err := db.Exec(cs.sql); err != nil {
+			t.Fatal(err)
+		}
+		author, id, _ := strings.Cut(cs.id, ":")
+		if _, err := db.Exec("INSERT INTO DATABASECHANGELOG VALUES (?, ?, 'changelog.sql')", id, author); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	st, err := Open(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	if v, err := st.Version(ctx); err != nil {
+		t.Fatal(err)
+	} else if v != len(changesets) {
+		t.Errorf("expected version %d, got %d", len(changesets), v)
+	}
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// taskChannel is the PostgreSQL channel tasks are announced on, the payload is the agent id.
+const taskChannel = "acert_task"
+
+// taskWatchers are the watchers of a store whose database cannot notify other processes, they
+// only learn about the tasks created through the same store.
+type taskWatchers struct {
+	mu       sync.Mutex
+	next     int
+	watchers map[int]func(agentID int64)
+}
+
+func (w *taskWatchers) add(notify func(agentID int64)) (remove func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.watchers == nil {
+		w.watchers = map[int]func(int64){}
+	}
+	id := w.next
+	w.next++
+	w.watchers[id] = notify
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.watchers, id)
+	}
+}
+
+func (w *taskWatchers) notify(agentID int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, notify := range w.watchers {
+		notify(agentID)
+	}
+}
+
+// notifyTask announces a task created for the agent. A lost notification only delays the
+// delivery until the next poll, so errors are ignored.
+func (s *Store) notifyTask(ctx context.Context, agentID int64) {
+	if !s.db.dialect.notifications {
+		s.watchers.notify(agentID)
+		return
+	}
+	s.db.ExecContext(ctx, "SELECT pg_notify(?, ?)", taskChannel, strconv.FormatInt(agentID, 10))
+}
+
+// WatchTasks calls notify with the agent of every task created until ctx is done. On
+// PostgreSQL the tasks created by every server sharing the database are announced, one
+// connection of the pool listens for them. The connection is discarded afterwards rather than
+// returned to the pool still listening.
+func (s *Store) WatchTasks(ctx context.Context, notify func(agentID int64)) error {
+	if !s.db.dialect.notifications {
+		defer s.watchers.add(notify)()
+		<-ctx.Done()
+		return nil
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+	err = conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(ctx, "LISTEN "+taskChannel); err != nil {
+			return errors.Join(err, driver.ErrBadConn)
+		}
+		for {
+			n, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return errors.Join(err, driver.ErrBadConn)
+			}
+			if agentID, err := strconv.ParseInt(n.Payload, 10, 64); err == nil {
+				notify(agentID)
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return fmt.Errorf("failed to listen for tasks: %w", err)
+}
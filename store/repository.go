@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Repository is the storage of the server state. Implementations return the Err* errors of
+// this package so callers do not depend on the database behind it.
+type Repository interface {
+	Tickets
+	Agents
+	AgentCertificates
+	Certificates
+	Tasks
+	CRLs
+	Audit
+
+	// Ping checks that the storage is reachable.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Tickets stores the join tokens agents enroll with.
+type Tickets interface {
+	CreateTicket(ctx context.Context, token string, createdAt, expiresAt time.Time) (*Ticket, error)
+	TearTicket(ctx context.Context, token string, now time.Time) (*Ticket, error)
+	ListTickets(ctx context.Context) ([]*Ticket, error)
+	RevokeTicket(ctx context.Context, id int64, now time.Time) (*Ticket, error)
+}
+
+// Agents stores the enrolled agents.
+type Agents interface {
+	CreateAgent(ctx context.Context, a *Agent) error
+	// EnrollAgent redeems the ticket for token and stores the agent with the certificate sign
+	// issues to it. When sign or storing fails, no agent is left behind and the ticket can be
+	// redeemed again.
+	EnrollAgent(ctx context.Context, token string, a *Agent, now time.Time, sign func(a *Agent) (*AgentCertificate, error)) (*Ticket, error)
+	GetAgent(ctx context.Context, id int64) (*Agent, error)
+	ListAgents(ctx context.Context) ([]*Agent, error)
+	UpdateAgentPresence(ctx context.Context, id int64, version string, lastSeen time.Time) error
+	RevokeAgent(ctx context.Context, id int64, now time.Time) error
+}
+
+// AgentCertificates stores the client certificates issued to agents.
+type AgentCertificates interface {
+	CreateAgentCertificate(ctx context.Context, c *AgentCertificate) error
+	GetAgentCertificate(ctx context.Context, serial string) (*AgentCertificate, error)
+	ListRevokedAgentCertificates(ctx context.Context, now time.Time) ([]*AgentCertificate, error)
+}
+
+// Certificates stores the certificate inventories reported by agents.
+type Certificates interface {
+	ReplaceCertificates(ctx context.Context, agentID int64, certs []*Certificate, reportedAt time.Time) error
+	ListCertificates(ctx context.Context, agentID int64) ([]*Certificate, error)
+	ListAllCertificates(ctx context.Context, filter CertificateFilter) ([]*Certificate, error)
+	ListCertificateExpiries(ctx context.Context) ([]time.Time, error)
+}
+
+// Tasks stores the tasks queued for agents.
+type Tasks interface {
+	CreateTask(ctx context.Context, t *Task) error
+	GetTask(ctx context.Context, id string) (*Task, error)
+	ListTasks(ctx context.Context, filter TaskFilter) ([]*Task, error)
+	DispatchTask(ctx context.Context, id string, from []string, now time.Time) (bool, error)
+	UpdateTask(ctx context.Context, id string, from []string, u TaskUpdate, now time.Time) (bool, error)
+	SetTaskCertificate(ctx context.Context, id string, chain, ca []byte, now time.Time) (bool, error)
+	TimeOutTasks(ctx context.Context, deadline, ackDeadline time.Time, maxAttempts int, now time.Time) ([]string, error)
+	CountTasks(ctx context.Context) (map[string]int, error)
+	// WatchTasks calls notify with the agent of every task created by a server sharing the
+	// storage until ctx is done. Notifications may be lost or repeated, they only spare the
+	// server holding the agent's stream waiting for its next poll.
+	WatchTasks(ctx context.Context, notify func(agentID int64)) error
+}
+
+// CRLs stores the CRL of the agent CA.
+type CRLs interface {
+	GetCRL(ctx context.Context) (*CRL, error)
+	PutCRL(ctx context.Context, c *CRL) error
+}
+
+// Audit stores the hash chained audit log.
+type Audit interface {
+	AppendAuditEvent(ctx context.Context, e *AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+}
+
+var _ Repository = (*Store)(nil)
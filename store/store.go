@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/ncruces/go-sqlite3/driver"
+	_ "github.com/ncruces/go-sqlite3/embed"
+)
+
+// Connection pool of PostgreSQL databases. Servers share the database, so each keeps a few
+// connections rather than one per concurrent stream.
+const (
+	postgresMaxOpenConns    = 20
+	postgresMaxIdleConns    = 5
+	postgresConnMaxIdleTime = 5 * time.Minute
+	postgresConnMaxLifetime = time.Hour
+)
+
+// Store implements the Repository on a sqlite or PostgreSQL database with the schema of the
+// changelogs in migrations.
+type Store struct {
+	db       *db
+	watchers taskWatchers
+}
+
+// Open opens the sqlite database at path, creating it if needed, and applies the changesets
+// of the changelog it is missing.
+func Open(ctx context.Context, path string) (*Store, error) {
+	conn, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_txlock=immediate", path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return open(ctx, conn, sqlite)
+}
+
+// OpenPostgres connects to the PostgreSQL database at the postgres:// url and applies the
+// changesets of its changelog it is missing. Several servers can share the database.
+func OpenPostgres(ctx context.Context, url string) (*Store, error) {
+	conn, err := sql.Open("pgx", url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	conn.SetMaxOpenConns(postgresMaxOpenConns)
+	conn.SetMaxIdleConns(postgresMaxIdleConns)
+	conn.SetConnMaxIdleTime(postgresConnMaxIdleTime)
+	conn.SetConnMaxLifetime(postgresConnMaxLifetime)
+	return open(ctx, conn, postgres)
+}
+
+// OpenURL opens the database at rawURL, either a postgres:// or postgresql:// url or a
+// sqlite: url with the path of the database, e.g. sqlite:db/acert.db.
+func OpenURL(ctx context.Context, rawURL string) (*Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database url: %w", err)
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		return OpenPostgres(ctx, rawURL)
+	case "sqlite":
+		path := u.Opaque
+		if path == "" {
+			path = u.Host + u.Path
+		}
+		return Open(ctx, path)
+	default:
+		return nil, fmt.Errorf("unsupported database url scheme %q", u.Scheme)
+	}
+}
+
+func open(ctx context.Context, conn *sql.DB, d *dialect) (*Store, error) {
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	s := &Store{db: &db{DB: conn, dialect: d}}
+	if err := s.migrate(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,683 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+)
+
+// postgresURL is the PostgreSQL server the store tests run against, empty to test sqlite only.
+var postgresURL string
+
+// TestMain starts an embedded PostgreSQL server for the tests unless ACERT_TEST_DATABASE_URL
+// names a server to use instead, or is "off" to test sqlite only.
+func TestMain(m *testing.M) {
+	switch url := os.Getenv("ACERT_TEST_DATABASE_URL"); url {
+	case "":
+	case "off":
+		os.Exit(m.Run())
+	default:
+		postgresURL = url
+		os.Exit(m.Run())
+	}
+
+	dir, err := os.MkdirTemp("", "acert-postgres")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to create postgres directory:", err)
+		os.Exit(1)
+	}
+	port, err := freePort()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to find a postgres port:", err)
+		os.Exit(1)
+	}
+	config := embeddedpostgres.DefaultConfig().
+		Port(port).
+		RuntimePath(filepath.Join(dir, "runtime")).
+		DataPath(filepath.Join(dir, "data")).
+		Logger(io.Discard)
+	pg := embeddedpostgres.NewDatabase(config)
+	if err := pg.Start(); err != nil {
+		os.RemoveAll(dir)
+		fmt.Fprintln(os.Stderr, "failed to start embedded postgres, set ACERT_TEST_DATABASE_URL to a server or to off:", err)
+		os.Exit(1)
+	}
+	postgresURL = config.GetConnectionURL() + "?sslmode=disable"
+
+	code := m.Run()
+	if err := pg.Stop(); err != nil {
+		fmt.Fprintln(os.Stderr, "failed to stop embedded postgres:", err)
+	}
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// freePort returns a local TCP port nothing listens on.
+func freePort() (uint32, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return uint32(l.Addr().(*net.TCPAddr).Port), nil
+}
+
+// testDatabase is an empty database, open can be called several times to connect to it like
+// servers sharing it would.
+type testDatabase struct {
+	name string
+	open func(t *testing.T) *Store
+}
+
+// testDatabases returns a sqlite database and, unless postgres testing is off, a PostgreSQL
+// database in a schema of its own on the test server.
+func testDatabases(t *testing.T) []testDatabase {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acert.db")
+	dbs := []testDatabase{{name: "sqlite", open: func(t *testing.T) *Store {
+		t.Helper()
+		st, err := Open(context.Background(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		return st
+	}}}
+
+	url := postgresURL
+	if url == "" {
+		return dbs
+	}
+	admin, err := sql.Open("pgx", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("acert_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		admin.Close()
+	})
+	sep := "?"
+	if strings.Contains(url, "?") {
+		sep = "&"
+	}
+	schemaURL := url + sep + "search_path=" + schema
+	return append(dbs, testDatabase{name: "postgres", open: func(t *testing.T) *Store {
+		t.Helper()
+		st, err := OpenPostgres(context.Background(), schemaURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { st.Close() })
+		return st
+	}})
+}
+
+func TestRebind(t *testing.T) {
+	query := "UPDATE task SET status = ? WHERE id = ? AND status IN (?, ?)"
+	if got := sqlite.rebind(query); got != query {
+		t.Errorf("expected sqlite queries to be unchanged, got %q", got)
+	}
+	want := "UPDATE task SET status = $1 WHERE id = $2 AND status IN ($3, $4)"
+	if got := postgres.rebind(query); got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestTearTicketOnce(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			// Two handles on the database stand in for two servers.
+			stores := []*Store{db.open(t), db.open(t)}
+			now := time.Now()
+			if _, err := stores[0].CreateTicket(ctx, "token", now, now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 8)
+			for i := range cap(errs) {
+				wg.Go(func() {
+					_, err := stores[i%len(stores)].TearTicket(ctx, "token", now)
+					errs <- err
+				})
+			}
+			wg.Wait()
+			close(errs)
+
+			redeemed := 0
+			for err := range errs {
+				switch {
+				case err == nil:
+					redeemed++
+				case !errors.Is(err, ErrTicketTorn):
+					t.Errorf("expected redemption to fail with %v, got %v", ErrTicketTorn, err)
+				}
+			}
+			if redeemed != 1 {
+				t.Errorf("expected the ticket to be redeemed once, got %d", redeemed)
+			}
+		})
+	}
+}
+
+func TestRevokeTicket(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := db.open(t)
+			now := time.Now().Truncate(time.Second)
+			revoked, err := st.CreateTicket(ctx, "revoked", now, now.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			used, err := st.CreateTicket(ctx, "used", now, now.Add(time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := st.TearTicket(ctx, "used", now); err != nil {
+				t.Fatal(err)
+			}
+
+			for range 2 {
+				got, err := st.RevokeTicket(ctx, revoked.ID, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Revoked == nil || !got.Revoked.Equal(now) {
+					t.Errorf("expected the ticket to be revoked at %v, got %v", now, got.Revoked)
+				}
+			}
+			if _, err := st.TearTicket(ctx, "revoked", now); !errors.Is(err, ErrTicketRevoked) {
+				t.Errorf("expected %v redeeming a revoked ticket, got %v", ErrTicketRevoked, err)
+			}
+			if _, err := st.RevokeTicket(ctx, used.ID, now); !errors.Is(err, ErrTicketTorn) {
+				t.Errorf("expected %v revoking a used ticket, got %v", ErrTicketTorn, err)
+			}
+			if _, err := st.RevokeTicket(ctx, used.ID+1, now); !errors.Is(err, ErrTicketNotFound) {
+				t.Errorf("expected %v revoking a missing ticket, got %v", ErrTicketNotFound, err)
+			}
+
+			tickets, err := st.ListTickets(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tickets) != 2 || tickets[0].Revoked == nil || tickets[1].Torn == nil {
+				t.Errorf("expected a revoked and a used ticket, got %+v", tickets)
+			}
+		})
+	}
+}
+
+func TestEnrollAgent(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := db.open(t)
+			now := time.Now().Truncate(time.Second)
+			if _, err := st.CreateTicket(ctx, "token", now, now.Add(time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			sign := func(a *Agent) (*AgentCertificate, error) {
+				return &AgentCertificate{Serial: "1a", AgentID: a.ID, NotBefore: now, NotAfter: now.Add(time.Hour), CreatedAt: now}, nil
+			}
+
+			failed := errors.New("sign failed")
+			_, err := st.EnrollAgent(ctx, "token", &Agent{Hostname: "host", CreatedAt: now}, now,
+				func(*Agent) (*AgentCertificate, error) { return nil, failed })
+			if !errors.Is(err, failed) {
+				t.Errorf("expected %v, got %v", failed, err)
+			}
+			if agents, err := st.ListAgents(ctx); err != nil || len(agents) != 0 {
+				t.Errorf("expected no agent after a failed enrollment, got %v, %v", agents, err)
+			}
+
+			a := &Agent{Hostname: "host", CreatedAt: now}
+			ticket, err := st.EnrollAgent(ctx, "token", a, now, sign)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ticket.Torn == nil || a.ID == 0 || a.Token != "token" {
+				t.Errorf("expected the ticket to be torn for agent %+v, got %+v", a, ticket)
+			}
+			if c, err := st.GetAgentCertificate(ctx, "1a"); err != nil || c.AgentID != a.ID {
+				t.Errorf("expected certificate 1a of agent %d, got %v, %v", a.ID, c, err)
+			}
+			if _, err := st.EnrollAgent(ctx, "token", &Agent{Hostname: "host", CreatedAt: now}, now, sign); !errors.Is(err, ErrTicketTorn) {
+				t.Errorf("expected %v enrolling twice, got %v", ErrTicketTorn, err)
+			}
+		})
+	}
+}
+
+func TestAgentLifecycle(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := db.open(t)
+			now := time.Now().Truncate(time.Second)
+
+			a := &Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: now}
+			if err := st.CreateAgent(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.CreateAgentCertificate(ctx, &AgentCertificate{Serial: "1a", AgentID: a.ID,
+				NotBefore: now, NotAfter: now.Add(time.Hour), CreatedAt: now}); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.RevokeAgent(ctx, a.ID, now); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.RevokeAgent(ctx, a.ID+1, now); !errors.Is(err, ErrAgentNotFound) {
+				t.Errorf("expected %v revoking a missing agent, got %v", ErrAgentNotFound, err)
+			}
+
+			got, err := st.GetAgent(ctx, a.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Revoked == nil || !got.Revoked.Equal(now) {
+				t.Errorf("expected the agent to be revoked at %v, got %v", now, got.Revoked)
+			}
+			revoked, err := st.ListRevokedAgentCertificates(ctx, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(revoked) != 1 || revoked[0].Serial != "1a" {
+				t.Errorf("expected certificate 1a to be revoked, got %v", revoked)
+			}
+		})
+	}
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := db.open(t)
+			now := time.Now().Truncate(time.Second)
+
+			a := &Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: now}
+			if err := st.CreateAgent(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+			for _, id := range []string{"a", "b", "c", "d"} {
+				if err := st.CreateTask(ctx, &Task{ID: id, AgentID: a.ID, Type: "issue", Spec: []byte("{}"),
+					CreatedAt: now}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			unfinished := []string{TaskPending, TaskDispatched, TaskRunning}
+			transition := func(id string, from []string, u TaskUpdate, want bool) {
+				t.Helper()
+				ok, err := st.UpdateTask(ctx, id, from, u, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != want {
+					t.Errorf("expected moving task %s to %s to be %v, got %v", id, u.Status, want, ok)
+				}
+			}
+			expect := func(id, status string, attempts int, finished bool) *Task {
+				t.Helper()
+				got, err := st.GetTask(ctx, id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Status != status || got.Attempts != attempts || (got.FinishedAt != nil) != finished {
+					t.Errorf("expected task %s to be %s after %d attempts, finished %v, got %+v", id, status,
+						attempts, finished, got)
+				}
+				return got
+			}
+
+			// a is accepted, reports progress and succeeds.
+			for i := 0; i < 2; i++ {
+				if ok, err := st.DispatchTask(ctx, "a", unfinished, now); err != nil || !ok {
+					t.Fatalf("expected task a to be dispatched, got %v, %v", ok, err)
+				}
+			}
+			got := expect("a", TaskDispatched, 2, false)
+			if got.DispatchedAt == nil || !got.DispatchedAt.Equal(now) {
+				t.Errorf("expected task a to be first dispatched at %v, got %v", now, got.DispatchedAt)
+			}
+			transition("a", []string{TaskPending}, TaskUpdate{Status: TaskRunning}, false)
+			transition("a", []string{TaskDispatched}, TaskUpdate{Status: TaskRunning}, true)
+			transition("a", []string{TaskRunning}, TaskUpdate{Status: TaskRunning, Message: "installing"}, true)
+			expect("a", TaskRunning, 2, false)
+			for i, want := range []bool{true, false} {
+				ok, err := st.SetTaskCertificate(ctx, "a", []byte(fmt.Sprint("chain", i)), []byte("ca"), now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != want {
+					t.Errorf("expected recording certificate %d of task a to be %v, got %v", i, want, ok)
+				}
+			}
+			if got := expect("a", TaskRunning, 2, false); string(got.CertificateChain) != "chain0" || string(got.CA) != "ca" {
+				t.Errorf("expected the first certificate to be kept, got %q %q", got.CertificateChain, got.CA)
+			}
+			if ok, _ := st.SetTaskCertificate(ctx, "c", []byte("chain"), []byte("ca"), now); ok {
+				t.Error("expected a pending task not to get a certificate")
+			}
+			if ok, _ := st.DispatchTask(ctx, "a", []string{TaskPending, TaskDispatched}, now); ok {
+				t.Error("expected a running task not to be dispatched again")
+			}
+			transition("a", []string{TaskDispatched, TaskRunning, TaskTimedOut}, TaskUpdate{Status: TaskSucceeded,
+				Artifacts: []TaskArtifact{{Name: "cert", Path: "/etc/cert.pem"}}}, true)
+			got = expect("a", TaskSucceeded, 2, true)
+			if len(got.Artifacts) != 1 || got.Artifacts[0].Path != "/etc/cert.pem" {
+				t.Errorf("expected the artifact of task a, got %v", got.Artifacts)
+			}
+			// A finished task is neither accepted, dispatched nor finished again.
+			transition("a", []string{TaskDispatched}, TaskUpdate{Status: TaskRunning}, false)
+			transition("a", []string{TaskDispatched, TaskRunning, TaskTimedOut}, TaskUpdate{Status: TaskFailed}, false)
+			if ok, _ := st.DispatchTask(ctx, "a", unfinished, now); ok {
+				t.Error("expected a succeeded task not to be dispatched again")
+			}
+
+			// b is canceled, the late result of the agent is rejected.
+			if _, err := st.DispatchTask(ctx, "b", unfinished, now); err != nil {
+				t.Fatal(err)
+			}
+			transition("b", unfinished, TaskUpdate{Status: TaskCanceled, Message: "canceled"}, true)
+			transition("b", []string{TaskDispatched, TaskRunning, TaskTimedOut}, TaskUpdate{Status: TaskSucceeded}, false)
+			transition("b", unfinished, TaskUpdate{Status: TaskCanceled}, false)
+			got = expect("b", TaskCanceled, 1, true)
+			if got.Message != "canceled" {
+				t.Errorf("expected the cancel message to be kept, got %q", got.Message)
+			}
+
+			// c does not finish within the deadline, d is never accepted after three deliveries.
+			if _, err := st.DispatchTask(ctx, "c", unfinished, now.Add(-time.Hour)); err != nil {
+				t.Fatal(err)
+			}
+			transition("c", []string{TaskDispatched}, TaskUpdate{Status: TaskRunning}, true)
+			for i := 0; i < 3; i++ {
+				if _, err := st.DispatchTask(ctx, "d", unfinished, now.Add(-time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ids, err := st.TimeOutTasks(ctx, now.Add(-time.Hour), now, 4, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != 0 {
+				t.Errorf("expected no task to time out yet, got %v", ids)
+			}
+			ids, err = st.TimeOutTasks(ctx, now.Add(-time.Minute), now, 3, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(ids, []string{"c", "d"}) {
+				t.Errorf("expected tasks c and d to time out, got %v", ids)
+			}
+			expect("c", TaskTimedOut, 1, true)
+			expect("d", TaskTimedOut, 3, true)
+			// The agent may still report a timed out task, but not accept it.
+			transition("c", []string{TaskDispatched}, TaskUpdate{Status: TaskRunning}, false)
+			transition("c", []string{TaskDispatched, TaskRunning, TaskTimedOut}, TaskUpdate{Status: TaskFailed,
+				ErrorCode: "install"}, true)
+			got = expect("c", TaskFailed, 1, true)
+			if got.ErrorCode != "install" {
+				t.Errorf("expected the error code of task c, got %q", got.ErrorCode)
+			}
+
+			counts, err := st.CountTasks(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]int{TaskSucceeded: 1, TaskCanceled: 1, TaskFailed: 1, TaskTimedOut: 1}
+			for status, n := range want {
+				if counts[status] != n {
+					t.Errorf("expected %d %s tasks, got %v", n, status, counts)
+				}
+			}
+			if _, err := st.GetTask(ctx, "e"); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("expected %v, got %v", ErrTaskNotFound, err)
+			}
+			if ok, err := st.UpdateTask(ctx, "e", unfinished, TaskUpdate{Status: TaskRunning}, now); err != nil || ok {
+				t.Errorf("expected a missing task not to be updated, got %v, %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestWatchTasks(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			watcher := db.open(t)
+			// sqlite only notifies the watchers of the same store, PostgreSQL every server.
+			creator := watcher
+			if db.name != "sqlite" {
+				creator = db.open(t)
+			}
+
+			agents := make(chan int64, 1)
+			done := make(chan error, 1)
+			go func() { done <- watcher.WatchTasks(ctx, func(agentID int64) { agents <- agentID }) }()
+
+			a := &Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: time.Now()}
+			if err := creator.CreateAgent(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+			// The watch may not be established yet, tasks are created until one is notified.
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+			for i := 0; ; i++ {
+				if err := creator.CreateTask(ctx, &Task{ID: fmt.Sprint(i), AgentID: a.ID, Type: "test", Spec: []byte("{}"),
+					CreatedAt: time.Now()}); err != nil {
+					t.Fatal(err)
+				}
+				select {
+				case id := <-agents:
+					if id != a.ID {
+						t.Errorf("expected agent %d to be notified, got %d", a.ID, id)
+					}
+				case <-ticker.C:
+					continue
+				case <-time.After(5 * time.Second):
+					t.Fatal("no task was notified")
+				}
+				break
+			}
+			cancel()
+			if err := <-done; err != nil {
+				t.Errorf("expected the watch to end without error, got %v", err)
+			}
+			if db.name == "sqlite" {
+				return
+			}
+			// The listening connection must not be handed out again by the pool, every idle
+			// connection is held open until the end of the test to check them all.
+			ctx = context.Background()
+			for range watcher.db.Stats().Idle {
+				conn, err := watcher.db.Conn(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				var channels int
+				if err := conn.QueryRowContext(ctx, "SELECT count(*) FROM pg_listening_channels()").Scan(&channels); err != nil {
+					t.Fatal(err)
+				}
+				if channels != 0 {
+					t.Error("expected the listening connection to be discarded")
+				}
+			}
+		})
+	}
+}
+
+func TestCRL(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := db.open(t)
+			if _, err := st.GetCRL(ctx); !errors.Is(err, ErrCRLNotFound) {
+				t.Errorf("expected %v, got %v", ErrCRLNotFound, err)
+			}
+			now := time.Now().Truncate(time.Second)
+			for n := range int64(2) {
+				if err := st.PutCRL(ctx, &CRL{Number: n, DER: []byte{byte(n)}, ThisUpdate: now, NextUpdate: now.Add(time.Hour)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			crl, err := st.GetCRL(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if crl.Number != 1 || len(crl.DER) != 1 || crl.DER[0] != 1 || !crl.ThisUpdate.Equal(now) {
+				t.Errorf("expected the second crl, got %+v", crl)
+			}
+		})
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			// Two handles append concurrently like servers sharing the database.
+			stores := []*Store{db.open(t), db.open(t)}
+			var wg sync.WaitGroup
+			for i, st := range stores {
+				wg.Go(func() {
+					for n := range 10 {
+						e := &AuditEvent{Time: time.Now(), Actor: fmt.Sprintf("test:%d", i), Action: "test.append",
+							Target: fmt.Sprintf("n:%d", n), Result: AuditSuccess}
+						if err := st.AppendAuditEvent(ctx, e); err != nil {
+							t.Error(err)
+						}
+					}
+				})
+			}
+			wg.Wait()
+
+			st := stores[0]
+			events, err := st.ListAuditEvents(ctx, AuditFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 20 {
+				t.Fatalf("expected 20 events, got %d", len(events))
+			}
+			var chain AuditChain
+			for _, e := range events {
+				if err := chain.Verify(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if page, err := st.ListAuditEvents(ctx, AuditFilter{AfterID: 5, Actor: "test:1", Limit: 3}); err != nil {
+				t.Fatal(err)
+			} else if len(page) != 3 || page[0].ID <= 5 || page[0].Actor != "test:1" {
+				t.Errorf("expected 3 events of test:1 after 5, got %+v", page)
+			}
+
+			// A trusted head must be contained unchanged in the log.
+			head, err := ParseAuditHead(chain.Head().String())
+			if err != nil || head != (AuditHead{ID: 20, Hash: events[19].Hash}) {
+				t.Fatalf("expected head 20 to round trip, got %v, %v", head, err)
+			}
+			anchored := func(anchor AuditHead) error {
+				chain := AuditChain{Anchor: anchor}
+				for _, e := range events {
+					if err := chain.Verify(e); err != nil {
+						return err
+					}
+				}
+				return chain.Complete()
+			}
+			if err := anchored(AuditHead{ID: 10, Hash: events[9].Hash}); err != nil {
+				t.Errorf("expected the log to contain head 10, got %v", err)
+			}
+			if err := anchored(AuditHead{ID: 10, Hash: events[10].Hash}); err == nil {
+				t.Error("expected a rewritten head to be reported")
+			}
+			if err := anchored(AuditHead{ID: 21, Hash: head.Hash}); err == nil {
+				t.Error("expected a log truncated before the head to be reported")
+			}
+
+			if _, err := st.db.ExecContext(ctx, "UPDATE audit_event SET result = ? WHERE id = ?", AuditFailure, 7); err != nil {
+				t.Fatal(err)
+			}
+			events, err = st.ListAuditEvents(ctx, AuditFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain = AuditChain{}
+			for _, e := range events {
+				if err = chain.Verify(e); err != nil {
+					break
+				}
+			}
+			if err == nil || !strings.Contains(err.Error(), "event 7 was modified") {
+				t.Errorf("expected event 7 to be reported as modified, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTimeOrder(t *testing.T) {
+	for _, db := range testDatabases(t) {
+		t.Run(db.name, func(t *testing.T) {
+			ctx := context.Background()
+			st := db.open(t)
+			a := &Agent{Hostname: "host", IP: "10.0.0.1", Token: "hash", CreatedAt: time.Now()}
+			if err := st.CreateAgent(ctx, a); err != nil {
+				t.Fatal(err)
+			}
+			// Times within a second must compare in time order however many digits their fraction has.
+			base := time.Now().Truncate(time.Second)
+			for id, offset := range map[string]time.Duration{"a": 510 * time.Millisecond, "b": 0, "c": 500 * time.Millisecond,
+				"d": 123 * time.Millisecond} {
+				if err := st.CreateTask(ctx, &Task{ID: id, AgentID: a.ID, Type: "test", Spec: []byte("{}"),
+					CreatedAt: base.Add(offset)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			tasks, err := st.ListTasks(ctx, TaskFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, task := range tasks {
+				ids = append(ids, task.ID)
+			}
+			if fmt.Sprint(ids) != "[b d c a]" {
+				t.Errorf("expected the tasks in creation order [b d c a], got %v", ids)
+			}
+
+			for id, at := range map[string]time.Time{"a": base, "b": base.Add(510 * time.Millisecond)} {
+				if _, err := st.DispatchTask(ctx, id, []string{TaskPending}, at); err != nil {
+					t.Fatal(err)
+				}
+			}
+			due, err := st.ListTasks(ctx, TaskFilter{DispatchedBefore: base.Add(500 * time.Millisecond)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(due) != 1 || due[0].ID != "a" {
+				t.Errorf("expected only task a to be dispatched before %v, got %v", base.Add(500*time.Millisecond), due)
+			}
+		})
+	}
+}
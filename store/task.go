@@ -0,0 +1,321 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrTaskNotFound = errors.New("task not found")
+
+// Task statuses. A task moves from pending to dispatched when it is sent to its agent, to
+// running once the agent accepts it and ends as succeeded, failed or timed out. Operators can
+// cancel tasks that have not finished.
+const (
+	TaskPending    = "pending"
+	TaskDispatched = "dispatched"
+	TaskRunning    = "running"
+	TaskSucceeded  = "succeeded"
+	TaskFailed     = "failed"
+	TaskTimedOut   = "timed_out"
+	TaskCanceled   = "canceled"
+)
+
+// TaskStatuses lists every task status in lifecycle order.
+var TaskStatuses = []string{TaskPending, TaskDispatched, TaskRunning, TaskSucceeded, TaskFailed, TaskTimedOut, TaskCanceled}
+
+// TaskFinished reports whether status is final.
+func TaskFinished(status string) bool {
+	return status == TaskSucceeded || status == TaskFailed || status == TaskTimedOut || status == TaskCanceled
+}
+
+// Task is work queued for an agent. Spec is the task payload encoded by the server.
+type Task struct {
+	ID           string
+	AgentID      int64
+	Type         string
+	Spec         []byte
+	Status       string
+	Message      string
+	ErrorCode    string
+	Artifacts    []TaskArtifact
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DispatchedAt *time.Time
+	FinishedAt   *time.Time
+	// Attempts counts the deliveries of the task, LastDispatchedAt is the time of the latest one.
+	Attempts         int
+	LastDispatchedAt *time.Time
+	// CertificateChain and CA are the PEM encoded certificate issued for the task and its CA, a
+	// redelivered CSR is answered with them instead of issuing another certificate.
+	CertificateChain []byte
+	CA               []byte
+}
+
+// TaskArtifact is a file produced by a task.
+type TaskArtifact struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// TaskUpdate is the new state of a task, Message, ErrorCode and Artifacts replace the stored ones.
+type TaskUpdate struct {
+	Status    string
+	Message   string
+	ErrorCode string
+	Artifacts []TaskArtifact
+}
+
+// TaskFilter selects tasks, zero fields match every task.
+type TaskFilter struct {
+	AgentID  int64
+	Statuses []string
+	// DispatchedBefore matches the tasks last dispatched before it.
+	DispatchedBefore time.Time
+	Limit            int
+}
+
+// CreateTask stores a new pending task.
+func (s *Store) CreateTask(ctx context.Context, t *Task) error {
+	t.Status = TaskPending
+	t.CreatedAt = t.CreatedAt.UTC()
+	t.UpdatedAt = t.CreatedAt
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO task (id, agent_id, type, spec, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		t.ID, t.AgentID, t.Type, string(t.Spec), t.Status, t.CreatedAt, t.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert task: %w", err)
+	}
+	s.notifyTask(ctx, t.AgentID)
+	return nil
+}
+
+// GetTask returns the task with the given id.
+func (s *Store) GetTask(ctx context.Context, id string) (*Task, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+taskColumns+" FROM task WHERE id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query task: %w", err)
+	}
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, ErrTaskNotFound
+	}
+	return tasks[0], nil
+}
+
+// ListTasks returns the tasks matching filter, oldest first.
+func (s *Store) ListTasks(ctx context.Context, filter TaskFilter) ([]*Task, error) {
+	query := "SELECT " + taskColumns + " FROM task WHERE 1 = 1"
+	var args []any
+	if filter.AgentID != 0 {
+		query += " AND agent_id = ?"
+		args = append(args, filter.AgentID)
+	}
+	if len(filter.Statuses) > 0 {
+		query += " AND status " + in(len(filter.Statuses))
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if !filter.DispatchedBefore.IsZero() {
+		query += " AND last_dispatched_at < ?"
+		args = append(args, filter.DispatchedBefore.UTC())
+	}
+	query += " ORDER BY created_at, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tasks: %w", err)
+	}
+	return scanTasks(rows)
+}
+
+// DispatchTask records a delivery of a task in one of from and moves it to dispatched, it
+// returns false when the task was in another status.
+func (s *Store) DispatchTask(ctx context.Context, id string, from []string, now time.Time) (bool, error) {
+	now = now.UTC()
+	query := `UPDATE task SET status = ?, attempts = attempts + 1, updated_at = ?, last_dispatched_at = ?,
+		dispatched_at = COALESCE(dispatched_at, ?) WHERE id = ? AND status ` + in(len(from))
+	args := []any{TaskDispatched, now, now, now, id}
+	for _, f := range from {
+		args = append(args, f)
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to dispatch task: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// UpdateTask moves a task to the update if it is currently in one of from, it returns false when
+// the task was in another status. finished_at is set when the task finishes.
+func (s *Store) UpdateTask(ctx context.Context, id string, from []string, u TaskUpdate, now time.Time) (bool, error) {
+	now = now.UTC()
+	var artifacts *string
+	if len(u.Artifacts) > 0 {
+		b, err := json.Marshal(u.Artifacts)
+		if err != nil {
+			return false, err
+		}
+		artifacts = new(string)
+		*artifacts = string(b)
+	}
+	var finishedAt *time.Time
+	if TaskFinished(u.Status) {
+		finishedAt = &now
+	}
+
+	query := `UPDATE task SET status = ?, message = ?, error_code = ?, artifacts = ?, updated_at = ?,
+		finished_at = ? WHERE id = ?`
+	args := []any{u.Status, u.Message, nullString(u.ErrorCode), artifacts, now, finishedAt, id}
+	if len(from) > 0 {
+		query += " AND status " + in(len(from))
+		for _, f := range from {
+			args = append(args, f)
+		}
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update task: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// SetTaskCertificate records the certificate issued for a dispatched or running task, it returns
+// false when the task is in another status or already has a certificate.
+func (s *Store) SetTaskCertificate(ctx context.Context, id string, chain, ca []byte, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE task SET certificate_chain = ?, ca = ?, updated_at = ?
+			WHERE id = ? AND status IN (?, ?) AND certificate_chain IS NULL`,
+		string(chain), string(ca), now.UTC(), id, TaskDispatched, TaskRunning)
+	if err != nil {
+		return false, fmt.Errorf("failed to set task certificate: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// TimeOutTasks marks as timed out the dispatched and running tasks first dispatched before
+// deadline, and the tasks still unacknowledged after maxAttempts deliveries, the last one
+// before ackDeadline. It returns the ids of the tasks that timed out.
+func (s *Store) TimeOutTasks(ctx context.Context, deadline, ackDeadline time.Time, maxAttempts int, now time.Time) ([]string, error) {
+	now = now.UTC()
+	rows, err := s.db.QueryContext(ctx,
+		`UPDATE task SET status = ?, message = ?, updated_at = ?, finished_at = ?
+			WHERE (status IN (?, ?) AND dispatched_at < ?)
+			OR (status = ? AND attempts >= ? AND last_dispatched_at < ?) RETURNING id`,
+		TaskTimedOut, "task did not finish in time", now, now, TaskDispatched, TaskRunning, deadline.UTC(),
+		TaskDispatched, maxAttempts, ackDeadline.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to time out tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan task id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to time out tasks: %w", err)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+const taskColumns = "id, agent_id, type, spec, status, message, error_code, artifacts, created_at, updated_at, " +
+	"dispatched_at, finished_at, attempts, last_dispatched_at, certificate_chain, ca"
+
+func scanTasks(rows *sql.Rows) ([]*Task, error) {
+	defer rows.Close()
+
+	var tasks []*Task
+	for rows.Next() {
+		t := &Task{}
+		var spec string
+		var message, errorCode, artifacts, chain, ca sql.NullString
+		var dispatchedAt, finishedAt, lastDispatchedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.AgentID, &t.Type, &spec, &t.Status, &message, &errorCode, &artifacts,
+			&t.CreatedAt, &t.UpdatedAt, &dispatchedAt, &finishedAt, &t.Attempts, &lastDispatchedAt, &chain, &ca); err != nil {
+			return nil, fmt.Errorf("failed to scan task: %w", err)
+		}
+		t.Spec = []byte(spec)
+		t.Message = message.String
+		t.ErrorCode = errorCode.String
+		if artifacts.Valid {
+			if err := json.Unmarshal([]byte(artifacts.String), &t.Artifacts); err != nil {
+				return nil, fmt.Errorf("failed to parse artifacts of task %s: %w", t.ID, err)
+			}
+		}
+		if dispatchedAt.Valid {
+			t.DispatchedAt = &dispatchedAt.Time
+		}
+		if finishedAt.Valid {
+			t.FinishedAt = &finishedAt.Time
+		}
+		if lastDispatchedAt.Valid {
+			t.LastDispatchedAt = &lastDispatchedAt.Time
+		}
+		if chain.Valid {
+			t.CertificateChain = []byte(chain.String)
+			t.CA = []byte(ca.String)
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// in returns an IN clause with n placeholders.
+func in(n int) string {
+	return "IN (?" + strings.Repeat(", ?", n-1) + ")"
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// CountTasks returns the number of tasks by status, statuses without tasks are missing.
+func (s *Store) CountTasks(ctx context.Context) (map[string]int, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT status, COUNT(*) FROM task GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tasks: %w", err)
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan task count: %w", err)
+		}
+		counts[status] = n
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,181 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTicketNotFound = errors.New("ticket not found")
+	ErrTicketTorn     = errors.New("ticket already used")
+	ErrTicketExpired  = errors.New("ticket expired")
+	ErrTicketRevoked  = errors.New("ticket revoked")
+)
+
+// Ticket is a single use join token. Only the hash of the token is stored.
+type Ticket struct {
+	ID        int64
+	Token     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Torn      *time.Time
+	// Revoked is when the ticket was revoked before it was used.
+	Revoked *time.Time
+}
+
+// CreateTicket stores a new ticket for the given token hash.
+func (s *Store) CreateTicket(ctx context.Context, token string, createdAt, expiresAt time.Time) (*Ticket, error) {
+	t := &Ticket{
+		Token:     token,
+		CreatedAt: createdAt.UTC(),
+		ExpiresAt: expiresAt.UTC(),
+	}
+	if err := s.db.QueryRowContext(ctx,
+		"INSERT INTO ticket (token, created_at, expires_at) VALUES (?, ?, ?) RETURNING id",
+		t.Token, t.CreatedAt, t.ExpiresAt).Scan(&t.ID); err != nil {
+		return nil, fmt.Errorf("failed to insert ticket: %w", err)
+	}
+	return t, nil
+}
+
+// TearTicket marks the ticket for the given token hash as used. It fails if the ticket
+// does not exist, has already been torn, has been revoked or has expired.
+func (s *Store) TearTicket(ctx context.Context, token string, now time.Time) (*Ticket, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := tearTicket(ctx, tx, token, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit ticket: %w", err)
+	}
+	return t, nil
+}
+
+// tearTicket marks the ticket as used within tx.
+func tearTicket(ctx context.Context, tx *tx, token string, now time.Time) (*Ticket, error) {
+	t := &Ticket{}
+	var expiresAt sql.NullTime
+	var torn, revoked sql.NullTime
+	err := tx.QueryRowContext(ctx,
+		"SELECT id, token, created_at, expires_at, torn, revoked FROM ticket WHERE token = ?", token).
+		Scan(&t.ID, &t.Token, &t.CreatedAt, &expiresAt, &torn, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTicketNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ticket: %w", err)
+	}
+	t.ExpiresAt = expiresAt.Time
+	if torn.Valid {
+		return nil, ErrTicketTorn
+	}
+	if revoked.Valid {
+		return nil, ErrTicketRevoked
+	}
+	if !expiresAt.Valid || !now.Before(expiresAt.Time) {
+		return nil, ErrTicketExpired
+	}
+
+	// The torn guard makes all but one of concurrent redemptions fail, also when servers share
+	// a PostgreSQL database: the update waits for the row lock of the first and then matches no row.
+	now = now.UTC()
+	res, err := tx.ExecContext(ctx, "UPDATE ticket SET torn = ? WHERE id = ? AND torn IS NULL AND revoked IS NULL", now, t.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update ticket: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to update ticket: %w", err)
+	} else if n != 1 {
+		return nil, ErrTicketTorn
+	}
+	t.Torn = &now
+	return t, nil
+}
+
+// ListTickets returns every ticket, oldest first.
+func (s *Store) ListTickets(ctx context.Context) ([]*Ticket, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+ticketColumns+" FROM ticket ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tickets: %w", err)
+	}
+	return scanTickets(rows)
+}
+
+// RevokeTicket revokes an unused ticket so it can no longer be redeemed. It fails with
+// ErrTicketTorn if the ticket was already used, revoking it again keeps the first revocation.
+func (s *Store) RevokeTicket(ctx context.Context, id int64, now time.Time) (*Ticket, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT "+ticketColumns+" FROM ticket WHERE id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ticket: %w", err)
+	}
+	tickets, err := scanTickets(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tickets) == 0 {
+		return nil, ErrTicketNotFound
+	}
+	t := tickets[0]
+	if t.Revoked != nil {
+		return t, nil
+	}
+	if t.Torn != nil {
+		return nil, ErrTicketTorn
+	}
+
+	// Like in TearTicket the guard keeps a concurrent redemption from also succeeding.
+	now = now.UTC()
+	res, err := tx.ExecContext(ctx, "UPDATE ticket SET revoked = ? WHERE id = ? AND torn IS NULL", now, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update ticket: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to update ticket: %w", err)
+	} else if n != 1 {
+		return nil, ErrTicketTorn
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit ticket: %w", err)
+	}
+	t.Revoked = &now
+	return t, nil
+}
+
+const ticketColumns = "id, token, created_at, expires_at, torn, revoked"
+
+func scanTickets(rows *sql.Rows) ([]*Ticket, error) {
+	defer rows.Close()
+
+	var tickets []*Ticket
+	for rows.Next() {
+		t := &Ticket{}
+		var expiresAt, torn, revoked sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Token, &t.CreatedAt, &expiresAt, &torn, &revoked); err != nil {
+			return nil, fmt.Errorf("failed to scan ticket: %w", err)
+		}
+		t.ExpiresAt = expiresAt.Time
+		if torn.Valid {
+			t.Torn = &torn.Time
+		}
+		if revoked.Valid {
+			t.Revoked = &revoked.Time
+		}
+		tickets = append(tickets, t)
+	}
+	return tickets, rows.Err()
+}
@@ -0,0 +1,18 @@
+// Package version reports the version acert was built as.
+package version
+
+import "runtime/debug"
+
+// Version is set at build time with -ldflags "-X github.com/salzr/acert/version.Version=v1.2.3".
+var Version string
+
+// Get returns Version, or the module version when acert was built with go install, or "dev".
+func Get() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}